@@ -39,7 +39,10 @@ import (
 
 // MkdirAll mimics os.MkdirAll
 func (fs *Share) MkdirAll(path string, perm os.FileMode) error {
-	path = normPath(path)
+	path, err := cleanPath("mkdir", path)
+	if err != nil {
+		return err
+	}
 
 	// Fast path: if we can tell whether path is a directory or file, stop with success or error.
 	dir, err := fs.Stat(path)
@@ -88,10 +91,13 @@ func (fs *Share) MkdirAll(path string, perm os.FileMode) error {
 // it encounters. If the path does not exist, RemoveAll
 // returns nil (no error).
 func (fs *Share) RemoveAll(path string) error {
-	path = normPath(path)
+	path, err := cleanPath("remove", path)
+	if err != nil {
+		return err
+	}
 
 	// Simple case: if Remove works, we're done.
-	err := fs.Remove(path)
+	err = fs.Remove(path)
 	if err == nil || os.IsNotExist(err) {
 		return nil
 	}
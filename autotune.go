@@ -0,0 +1,185 @@
+package smb2
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// maxAutoTuneDepth caps the number of operations in flight chosen by automatic tuning.
+const maxAutoTuneDepth = 64
+
+// Thresholds on the estimated number of operations waiting in queues (on the link or at the server).
+// Below autoTuneAlpha, the link isn't saturated and the depth is increased.
+// Above autoTuneBeta, operations only add latency and the depth is decreased.
+const (
+	autoTuneAlpha = 1
+	autoTuneBeta  = 3
+)
+
+// AutoTuneStats is a snapshot of the automatic tuning of parallel transfers of a connection.
+// See Dialer.AutoTune.
+type AutoTuneStats struct {
+	Depth       int           // number of operations in flight chosen for the latest transfer
+	MaxDepth    int           // highest depth chosen so far
+	BaseRTT     time.Duration // lowest latency of an operation observed by the latest transfer
+	RTT         time.Duration // smoothed latency of an operation observed by the latest transfer
+	Adjustments uint64        // number of times the depth has been changed
+}
+
+// tuneStats holds the counters behind AutoTuneStats.
+type tuneStats struct {
+	// keep 64-bit values first for alignment of atomic operations.
+	adjustments uint64
+	baseRTT     int64
+	rtt         int64
+	depth       int32
+	maxDepth    int32
+}
+
+func (s *tuneStats) snapshot() AutoTuneStats {
+	return AutoTuneStats{
+		Depth:       int(atomic.LoadInt32(&s.depth)),
+		MaxDepth:    int(atomic.LoadInt32(&s.maxDepth)),
+		BaseRTT:     time.Duration(atomic.LoadInt64(&s.baseRTT)),
+		RTT:         time.Duration(atomic.LoadInt64(&s.rtt)),
+		Adjustments: atomic.LoadUint64(&s.adjustments),
+	}
+}
+
+func (s *tuneStats) record(t *tuner, depth int, adjusted bool) {
+	atomic.StoreInt32(&s.depth, int32(depth))
+	for {
+		max := atomic.LoadInt32(&s.maxDepth)
+		if int32(depth) <= max || atomic.CompareAndSwapInt32(&s.maxDepth, max, int32(depth)) {
+			break
+		}
+	}
+	atomic.StoreInt64(&s.baseRTT, int64(t.baseRTT))
+	atomic.StoreInt64(&s.rtt, int64(t.rtt))
+	if adjusted {
+		atomic.AddUint64(&s.adjustments, 1)
+	}
+}
+
+// creditBudget is the number of credits an operation of a transfer consumes on a connection.
+type creditBudget struct {
+	conn   *conn
+	charge int
+}
+
+// tuner adjusts the depth of a transfer from the latency of its operations, in the manner of TCP Vegas.
+// While the latency stays close to the lowest one observed, more operations would use idle bandwidth,
+// so the depth is increased. Once operations start waiting in queues, the depth is decreased.
+// The depth never exceeds what the credit windows of the connections involved allow.
+// A tuner isn't safe for concurrent use; limiter serializes calls of observe.
+type tuner struct {
+	stats   *tuneStats
+	budgets []creditBudget
+	window  int // highest depth allowed besides the credits, maxAutoTuneDepth if zero
+
+	baseRTT time.Duration
+	rtt     time.Duration
+	samples int
+}
+
+// newTuner returns a tuner for a transfer whose operations consume the given credits,
+// or nil if automatic tuning is disabled on the first connection.
+func newTuner(budgets ...creditBudget) *tuner {
+	if len(budgets) == 0 || budgets[0].conn.autoTune == nil {
+		return nil
+	}
+	return &tuner{
+		stats:   budgets[0].conn.autoTune,
+		budgets: budgets,
+	}
+}
+
+// newWindowTuner returns a tuner for a pipelined read or write on conn, made of chunks of size bytes
+// with at most window of them in flight, or nil if automatic tuning is disabled.
+// (See Dialer.ReadAheadWindow and Dialer.WriteBehindWindow)
+func (conn *conn) newWindowTuner(size, window int) *tuner {
+	t := newTuner(creditBudget{conn: conn, charge: conn.transferCharge(size)})
+	if t != nil {
+		t.window = window
+	}
+	return t
+}
+
+// resume returns the depth chosen by the latest transfer on the connection, or n if there was none,
+// so that a series of short transfers, like the reads of io.Copy, keeps adapting rather than starting over.
+func (t *tuner) resume(n int) int {
+	if depth := int(atomic.LoadInt32(&t.stats.depth)); depth > 0 {
+		return depth
+	}
+	return n
+}
+
+// observe records the latency of a completed operation and returns the depth to use from now on.
+func (t *tuner) observe(rtt time.Duration, depth int) int {
+	if t.baseRTT == 0 || rtt < t.baseRTT {
+		t.baseRTT = rtt
+	}
+	if t.rtt == 0 {
+		t.rtt = rtt
+	} else {
+		t.rtt += (rtt - t.rtt) / 8
+	}
+
+	// adjust once per window, so that the effect of the previous adjustment is observable.
+	t.samples++
+	if t.samples < depth {
+		return depth
+	}
+	t.samples = 0
+
+	next := depth
+
+	var queued float64
+	if t.rtt > t.baseRTT {
+		queued = float64(depth) * float64(t.rtt-t.baseRTT) / float64(t.rtt)
+	}
+
+	switch {
+	case queued < autoTuneAlpha:
+		next++
+	case queued > autoTuneBeta:
+		next--
+	}
+
+	if max := t.maxDepth(depth); next > max {
+		next = max
+	}
+	if next < 1 {
+		next = 1
+	}
+
+	t.stats.record(t, next, next != depth)
+
+	return next
+}
+
+// maxDepth returns the highest depth the credit windows allow,
+// counting the credits held by the depth operations in flight as part of the window.
+func (t *tuner) maxDepth(depth int) int {
+	max := maxAutoTuneDepth
+	if t.window > 0 && t.window < max {
+		max = t.window
+	}
+	for _, b := range t.budgets {
+		n := len(b.conn.account.balance)/b.charge + depth
+		if n < max {
+			max = n
+		}
+	}
+	return max
+}
+
+// transferCharge returns the credit charge of a read or write of size bytes.
+func (conn *conn) transferCharge(size int) int {
+	if conn.capabilities&SMB2_GLOBAL_CAP_LARGE_MTU == 0 {
+		return 1
+	}
+	return (size-1)/(64*1024) + 1
+}
@@ -0,0 +1,147 @@
+package smb2
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTuner(t *testing.T) {
+	a := openAccount(128)
+	a.grant(127, 0)
+
+	c := &conn{account: a, autoTune: new(tuneStats)}
+
+	tu := newTuner(creditBudget{conn: c, charge: 4})
+	if tu == nil {
+		t.Fatal("expected tuner")
+	}
+
+	l := newTunedLimiter(4, tu)
+
+	// operations in flight hold their credits.
+	observe := func(rtt time.Duration, depth int) int {
+		for len(a.balance) > 128-depth*4 {
+			<-a.balance
+		}
+		for len(a.balance) < 128-depth*4 {
+			a.balance <- struct{}{}
+		}
+		return tu.observe(rtt, depth)
+	}
+
+	// constant latency: the link isn't saturated, so the depth grows up to the credit window.
+	depth := l.limit
+	for i := 0; i < 1000; i++ {
+		depth = observe(10*time.Millisecond, depth)
+	}
+	if depth != 128/4 {
+		t.Errorf("expected depth %d, got %d", 128/4, depth)
+	}
+
+	// latency proportional to the depth: operations are queueing, so the depth shrinks.
+	for i := 0; i < 1000; i++ {
+		depth = observe(time.Duration(depth)*10*time.Millisecond, depth)
+	}
+	if depth > 4 {
+		t.Errorf("expected depth to decrease, got %d", depth)
+	}
+
+	stats := c.autoTune.snapshot()
+	if stats.Depth != depth {
+		t.Errorf("unexpected depth stats: %d", stats.Depth)
+	}
+	if stats.MaxDepth != 128/4 {
+		t.Errorf("unexpected max depth stats: %d", stats.MaxDepth)
+	}
+	if stats.BaseRTT != 10*time.Millisecond {
+		t.Errorf("unexpected base rtt stats: %v", stats.BaseRTT)
+	}
+	if stats.Adjustments == 0 {
+		t.Error("expected adjustments")
+	}
+
+	c.autoTune = nil
+
+	if newTuner(creditBudget{conn: c, charge: 4}) != nil {
+		t.Error("expected no tuner when auto tuning is disabled")
+	}
+}
+
+func TestWindowTuner(t *testing.T) {
+	a := openAccount(128)
+	a.grant(127, 0)
+
+	c := &conn{account: a, autoTune: new(tuneStats)}
+
+	tu := c.newWindowTuner(1000, 4)
+
+	// the window bounds the depth even though the credits would allow more.
+	l := newTunedLimiter(8, tu)
+	if l.limit != 4 {
+		t.Errorf("expected the limit to be capped to the window, got %d", l.limit)
+	}
+
+	depth := l.limit
+	for i := 0; i < 100; i++ {
+		depth = tu.observe(10*time.Millisecond, depth)
+	}
+	if depth != 4 {
+		t.Errorf("expected depth 4, got %d", depth)
+	}
+
+	// the next transfer starts from the depth chosen by this one.
+	atomic.StoreInt32(&c.autoTune.depth, 2)
+
+	if depth := c.newWindowTuner(1000, 4).resume(4); depth != 2 {
+		t.Errorf("expected to resume from depth 2, got %d", depth)
+	}
+}
+
+func TestAutoTuneWindow(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	f, srv := newTestFile(4, -1)
+	defer srv.conn.Close()
+
+	f.fs.conn.autoTune = new(tuneStats)
+
+	n, err := f.WriteAt(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(srv.data, data) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+	if srv.maxInflight > 4 {
+		t.Errorf("expected up to 4 writes in flight, got %d", srv.maxInflight)
+	}
+
+	stats := f.fs.conn.autoTune.snapshot()
+	if stats.Depth < 1 || stats.MaxDepth > 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// reads are tuned as well.
+	f.fs.conn.maxReadSize = 1000
+	f.fs.conn.readAheadWindow = 4
+	srv.asyncReads = true
+	srv.maxInflight = 0
+
+	b := make([]byte, len(data))
+
+	n, err = f.ReadAt(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || !bytes.Equal(b, data) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+	if srv.maxInflight > 4 {
+		t.Errorf("expected up to 4 reads in flight, got %d", srv.maxInflight)
+	}
+}
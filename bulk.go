@@ -0,0 +1,205 @@
+package smb2
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// maxDefaultConcurrency caps the default number of parallel operations of bulk helpers.
+const maxDefaultConcurrency = 16
+
+// concurrency returns n if it's positive.
+// Otherwise, it returns a value derived from the credits currently available,
+// so that bulk helpers neither starve nor flood the server.
+func (fs *Share) concurrency(n int) int {
+	if n > 0 {
+		return n
+	}
+	// each operation (create, read/write, close) consumes a few credits.
+	n = len(fs.account.balance) / 4
+	if n < 1 {
+		return 1
+	}
+	if n > maxDefaultConcurrency {
+		return maxDefaultConcurrency
+	}
+	return n
+}
+
+// limiter bounds the number of operations in flight and remembers the first error.
+// If it has a tuner, the bound is adjusted as operations complete.
+type limiter struct {
+	m      sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	err    error
+
+	t *tuner
+}
+
+func newLimiter(n int) *limiter {
+	l := &limiter{limit: n}
+	l.cond = sync.NewCond(&l.m)
+	return l
+}
+
+// newTunedLimiter is like newLimiter but lets t adjust the bound, starting from n.
+// If t is nil, the bound is fixed.
+func newTunedLimiter(n int, t *tuner) *limiter {
+	l := newLimiter(n)
+	if t != nil {
+		if max := t.maxDepth(0); n > max {
+			l.limit = max
+		}
+		if l.limit < 1 {
+			l.limit = 1
+		}
+		t.stats.record(t, l.limit, false)
+		l.t = t
+	}
+	return l
+}
+
+// do runs f in a new goroutine once a slot is available.
+// It doesn't start f if some operation has already failed.
+func (l *limiter) do(wg *sync.WaitGroup, f func() error) {
+	l.m.Lock()
+	for l.active >= l.limit && l.err == nil {
+		l.cond.Wait()
+	}
+	if l.err != nil {
+		l.m.Unlock()
+		return
+	}
+	l.active++
+	l.m.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		start := time.Now()
+
+		err := f()
+
+		l.m.Lock()
+		l.active--
+		if err != nil {
+			if l.err == nil {
+				l.err = err
+			}
+		} else if l.t != nil {
+			l.limit = l.t.observe(time.Since(start), l.limit)
+		}
+		l.cond.Broadcast()
+		l.m.Unlock()
+	}()
+}
+
+func (l *limiter) fail(err error) {
+	l.m.Lock()
+	if l.err == nil {
+		l.err = err
+	}
+	l.cond.Broadcast()
+	l.m.Unlock()
+}
+
+func (l *limiter) failed() bool {
+	return l.error() != nil
+}
+
+func (l *limiter) error() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.err
+}
+
+// RemoveAllOptions contains optional parameters for Share.RemoveAllWith.
+type RemoveAllOptions struct {
+	// Concurrency limits the number of files removed in parallel.
+	// If it's zero, a value derived from the credit window is used.
+	Concurrency int
+}
+
+// RemoveAllWith is like RemoveAll but removes files in parallel.
+// Unlike RemoveAll, it stops at the first error.
+// If opts is nil, default options are used.
+func (fs *Share) RemoveAllWith(path string, opts *RemoveAllOptions) error {
+	if opts == nil {
+		opts = &RemoveAllOptions{}
+	}
+
+	path, err := cleanPath("remove", path)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fs.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		err = fs.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	l := newLimiter(fs.concurrency(opts.Concurrency))
+
+	fs.removeDir(path, l)
+
+	return l.error()
+}
+
+func (fs *Share) removeDir(path string, l *limiter) {
+	fis, err := fs.ReadDir(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.fail(err)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, fi := range fis {
+		if l.failed() {
+			break
+		}
+
+		name := path + string(PathSeparator) + fi.Name()
+
+		if fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 {
+			fs.removeDir(name, l)
+			continue
+		}
+
+		l.do(&wg, func() error {
+			err := fs.Remove(name)
+			if err == nil || os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		})
+	}
+
+	wg.Wait()
+
+	if l.failed() {
+		return
+	}
+
+	err = fs.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		l.fail(err)
+	}
+}
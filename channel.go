@@ -0,0 +1,116 @@
+package smb2
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// bindChannel negotiates tcpConn and binds it to s as an additional channel. See Session.AddChannel.
+func (s *session) bindChannel(tcpConn net.Conn, ctx context.Context) (err error) {
+	if s.dialect < SMB300 || s.capabilities&SMB2_GLOBAL_CAP_MULTI_CHANNEL == 0 || s.sessionKey == nil {
+		return ErrNotSupported
+	}
+
+	// the server only binds connections of the same client using the same dialect, cipher and signing algorithm.
+	n := s.dialer.Negotiator
+	n.ClientGuid = s.clientGuid
+	n.SpecifiedDialect = s.dialect
+	if s.cipherId != 0 {
+		n.Ciphers = []uint16{s.cipherId}
+	}
+	if s.signingAlgorithmId != 0 {
+		n.SigningAlgorithms = []uint16{s.signingAlgorithmId}
+	}
+
+	conn, err := s.dialer.negotiate(n, tcpConn, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			conn.close()
+		}
+	}()
+
+	if conn.capabilities&SMB2_GLOBAL_CAP_MULTI_CHANNEL == 0 {
+		return ErrNotSupported
+	}
+	if conn.cipherId != s.cipherId {
+		return &InvalidResponseError{"server selected another cipher for the channel"}
+	}
+	if conn.signingAlgorithmId != s.signingAlgorithmId {
+		return &InvalidResponseError{"server selected another signing algorithm for the channel"}
+	}
+
+	ch, err := sessionSetup(conn, s.initiator, s, ctx)
+	if err != nil {
+		return err
+	}
+
+	conn.retryPolicy = s.retryPolicy
+	conn.requireEncryption = s.requireEncryption
+	conn.autoTune = s.autoTune
+
+	s.channelsMu.Lock()
+	s.channels = append(s.channels, ch)
+	s.channelsMu.Unlock()
+
+	return nil
+}
+
+// channel returns the channel of s with the fewest outstanding requests, s itself included.
+// Ties are broken in turn, so that sequential requests are spread across the channels as well.
+// Channels whose connection is closed are skipped.
+func (s *session) channel() *session {
+	s.channelsMu.Lock()
+	channels := s.channels
+	s.channelsMu.Unlock()
+
+	if len(channels) == 0 {
+		return s
+	}
+
+	all := len(channels) + 1
+	start := int(atomic.AddUint32(&s.channelNext, 1) % uint32(all))
+
+	best := s
+	min := -1
+
+	for i := 0; i < all; i++ {
+		ch := s
+		if j := (start + i) % all; j != 0 {
+			ch = channels[j-1]
+		}
+
+		if ch.isClosed() {
+			continue
+		}
+
+		if n := ch.outstandingRequests.len(); min < 0 || n < min {
+			best, min = ch, n
+		}
+	}
+
+	return best
+}
+
+// channel returns the tree connection on the channel of the session with the fewest outstanding requests.
+// Tree ids and file ids are valid on every channel of a session.
+func (tc *treeConn) channel() *treeConn {
+	ch := tc.session.channel()
+	if ch == tc.session {
+		return tc
+	}
+	return &treeConn{
+		session:           ch,
+		treeId:            tc.treeId,
+		shareFlags:        tc.shareFlags,
+		shareType:         tc.shareType,
+		shareCapabilities: tc.shareCapabilities,
+		forceEncryption:   atomic.LoadInt32(&tc.forceEncryption),
+	}
+}
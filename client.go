@@ -1,9 +1,11 @@
 package smb2
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"os"
@@ -11,6 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/hirochachacha/go-smb2/internal/erref"
@@ -24,10 +27,166 @@ type Dialer struct {
 	MaxCreditBalance uint16 // if it's zero, clientMaxCreditBalance is used. (See feature.go for more details)
 	Negotiator       Negotiator
 	Initiator        Initiator
+
+	// InitialCreditRequest is the number of credits requested by NEGOTIATE and SESSION_SETUP,
+	// for servers that grant few credits unless they are asked for more.
+	// If it's zero, NEGOTIATE requests a single credit and SESSION_SETUP requests enough credits
+	// to fill the balance up to MaxCreditBalance. Credits beyond MaxCreditBalance are not kept.
+	InitialCreditRequest uint16
+
+	// RetryPolicy specifies how requests rejected by a busy server are retried.
+	// If it's nil, such requests fail immediately.
+	RetryPolicy *RetryPolicy
+
+	// ClientName is the name of the client machine presented to the server.
+	// It's sent as the workstation name of the NTLM AUTHENTICATE message unless
+	// NTLMInitiator.Workstation is set explicitly, and as the calling name of the NetBIOS session.
+	// If it's empty, no workstation name is sent, and the NetBIOS session uses a generic calling name,
+	// so that the host name isn't disclosed to the server. See UseHostName.
+	ClientName string
+
+	// UseHostName makes the host name up to the first dot the default of ClientName.
+	UseHostName bool
+
+	// ReadTimeout is the maximum time to wait for data from the server while synchronous requests are outstanding.
+	// Asynchronous requests, like the CHANGE_NOTIFY of Watch or a blocking LockWait, and abandoned requests
+	// waiting for their CANCEL don't count, since they may legitimately go unanswered for long.
+	// The timer is reset each time data is received. When it expires, the connection is closed and
+	// all pending requests fail with a TransportError. If it's zero, there is no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum time a single write of a request to the connection may take.
+	// When it expires, the request fails with a TransportError. If it's zero, there is no timeout.
+	WriteTimeout time.Duration
+
+	// OperationTimeout is the maximum time to wait for the response of a request whose context has no deadline.
+	// When it expires, CANCEL is sent for the request and it fails with a ContextError satisfying os.IsTimeout.
+	// Requests that wait for an event by design, like blocking locks and File.Watch, are not limited.
+	// If it's zero, requests wait until their context is done.
+	OperationTimeout time.Duration
+
+	// RejectGuest fails Dial with ErrGuestSession when the server logs the client on as guest,
+	// which some servers do silently when the credentials are wrong. See Session.IsGuest.
+	RejectGuest bool
+
+	// RequireEncryption fails Dial with ErrEncryptionNotSupported unless the session can be encrypted,
+	// i.e. SMB 3 with encryption supported by the server and a session that isn't guest nor anonymous.
+	// All requests of the session are then encrypted, even on shares that don't require it,
+	// and unencrypted responses are rejected.
+	// Without it, requests are only encrypted where the server asks for it, with SMB2_SESSION_FLAG_ENCRYPT_DATA
+	// for the session or SMB2_SHAREFLAG_ENCRYPT_DATA for a share. Note that earlier versions encrypted
+	// every SMB 3.1.1 session regardless.
+	// Together with Negotiator.RequireMessageSigning, Negotiator.MinDialect and RejectGuest,
+	// it enforces a minimum security posture: Dial fails rather than falling back to a weaker session.
+	RequireEncryption bool
+
+	// KeepAlive is the interval of the ECHO requests sent to detect dead connections,
+	// e.g. idle connections silently dropped by a NAT or a firewall.
+	// If the server doesn't answer an ECHO within the interval, the connection is closed and
+	// the outstanding and following requests fail with a TransportError wrapping ErrKeepAliveTimeout.
+	// If it's zero, no ECHO is sent.
+	KeepAlive time.Duration
+
+	// ReadAheadWindow is the maximum number of READ requests in flight for a single read larger than
+	// the max read size of the server, e.g. File.ReadAt with a large buffer, or io.Copy from a File.
+	// Keeping several requests in flight fills high-latency links that a single request at a time can't,
+	// at the cost of credits; requests wait for credits when the server doesn't grant enough.
+	// The data is returned in order and a short or failed read stops at the first chunk affected, as if read serially.
+	// If AutoTune is set, the number of requests in flight is adjusted within the window.
+	// If it's zero or one, large reads are split and sent one request at a time.
+	ReadAheadWindow int
+
+	// WriteBehindWindow is the maximum number of WRITE requests in flight for a single write larger than
+	// the max write size of the server, e.g. File.WriteAt with a large buffer, or io.Copy to a File.
+	// No more requests are sent once one of them fails, and the write returns the number of bytes written
+	// contiguously from its offset, though some of the data following them may have been written as well.
+	// File.ReadFrom fills the window with a single write only if the source returns its data without waiting,
+	// like *os.File on a regular file or *bytes.Reader; data from streaming sources is written as it arrives.
+	// If AutoTune is set, the number of requests in flight is adjusted within the window.
+	// If it's zero or one, large writes are split and sent one request at a time.
+	WriteBehindWindow int
+
+	// RecvBufferSize is the size of the buffers the receiver carves incoming packets from.
+	// Packets smaller than the remaining space share a buffer, so small responses don't need
+	// an allocation each. Larger packets get a buffer of their own.
+	// Setting it slightly above the max read size of the server (typically 8 MiB, plus 1 KiB for headers)
+	// lets read-heavy workloads share buffers across large responses at the cost of memory,
+	// since a buffer is kept alive while any packet carved from it is referenced.
+	// If it's zero, clientRecvBufferSize is used. (See feature.go for more details)
+	// The default isn't derived from the max read size the server negotiates: responses larger than the buffer
+	// are placed into the buffer of the caller of READ or get a pooled buffer of their own anyway, while
+	// a buffer of several MiB would be kept alive by any small response still referenced.
+	RecvBufferSize int
+
+	// Authenticate, if set, supplies initiators on demand, e.g. by prompting the user for credentials.
+	// It's called during Dial if Initiator is nil, and again each time session setup fails
+	// with STATUS_LOGON_FAILURE or STATUS_WRONG_PASSWORD. The negotiated connection is reused.
+	// Returning a non-nil error aborts Dial with that error.
+	Authenticate func(ctx context.Context, challenge *AuthChallenge) (Initiator, error)
+
+	// AutoTune enables automatic tuning of the number of operations in flight of parallel transfers:
+	// CrossServerCopy, and large reads and writes within ReadAheadWindow and WriteBehindWindow.
+	// The depth is adjusted from the observed latency of operations, increased while it stays close
+	// to the lowest one and decreased once operations start queueing, and never exceeds what the granted
+	// credits allow. Reads and writes start from the depth chosen by the previous transfer, so that a series of
+	// them, like those of io.Copy, keeps adapting. Session.AutoTuneStats reports the chosen depth.
+	// If it's false, the depth of CrossServerCopy is fixed at the start from the credits available,
+	// and the one of reads and writes is the window.
+	AutoTune bool
+
+	// EnableCompression advertises SMB 3.1.1 compression (LZ77, LZ77+Huffman and Pattern_V1).
+	// If the server agrees, READ responses are requested compressed and WRITE requests are compressed
+	// with LZ77 when it makes them smaller. It benefits large transfers over slow links
+	// at the cost of CPU time on both sides. It has no effect on older dialects.
+	EnableCompression bool
+
+	// DialDFS connects to the servers DFS paths are redirected to, other than the one of the session.
+	// Sessions are set up on the returned connections with the options of this Dialer, and logged off with the session.
+	// If it's nil, a TCP connection is made to port 445 of the server.
+	DialDFS func(ctx context.Context, server string) (net.Conn, error)
+
+	// RequestLeases advertises leasing on SMB 2.1 and later, and requests a read, write and handle caching lease
+	// on the files opened by OpenFile, so that their data can be cached by the application.
+	// Durable opens use a batch oplock instead. See File.LeaseState and File.LeaseBreaks.
+	RequestLeases bool
+
+	// ServerName is the name of the server in the UNC paths sent to it, e.g. by Session.Mount for share names
+	// without a server name. If it's empty, the remote address of the connection is used, which is not
+	// the address of the server for connections through a proxy or a tunnel.
+	ServerName string
+
+	// NetBIOS makes Dial request a NetBIOS session on the connection before negotiation, as legacy servers
+	// listening on port 139 only accept the NetBIOS session service (RFC 1002) rather than direct TCP.
+	// The session is also requested if the remote port of the connection is 139.
+	// If the server refuses the session, Dial fails with a *NetBIOSSessionError.
+	// If the context of DialContext has a deadline or is done during the session request, the deadline
+	// of the connection is cleared afterwards, since the one set before by the caller can't be read back.
+	NetBIOS bool
+
+	// NetBIOSName is the NetBIOS name of the server the session is requested for.
+	// If it's empty, "*SMBSERVER" is used, which most servers accept. The name of the client
+	// is the one of ClientName, or "SMB2CLIENT" if there is none.
+	NetBIOSName string
+
+	// Logger receives the diagnostic messages of the connection, e.g. about unexpected packets
+	// or failed oplock break acknowledgements. A *slog.Logger can be used.
+	// If it's nil, the messages are discarded unless the DEBUG environment variable is set.
+	Logger Logger
+}
+
+// AuthChallenge describes the state of authentication passed to Dialer.Authenticate.
+type AuthChallenge struct {
+	Addr    string // remote address of the server
+	Attempt int    // number of failed attempts so far
+	Err     error  // error of the last attempt, or nil for the first one
 }
 
 // Dial performs negotiation and authentication.
-// It returns a session. It doesn't support NetBIOS transport.
+// It returns a session. See Dialer.NetBIOS for NetBIOS transport.
+// tcpConn can be any net.Conn carrying the byte stream of the server, e.g. a connection through a SOCKS5 proxy
+// or an SSH tunnel, a TLS connection, a stream of an SMB over QUIC connection, or one end of net.Pipe in tests.
+// See also Dialer.ServerName.
+// If Dial fails after the handshake has started, tcpConn is closed.
 // This implementation doesn't support multi-session on the same TCP connection.
 // If you want to use another session, you need to prepare another TCP connection at first.
 func (d *Dialer) Dial(tcpConn net.Conn) (*Session, error) {
@@ -35,41 +194,217 @@ func (d *Dialer) Dial(tcpConn net.Conn) (*Session, error) {
 }
 
 // DialContext performs negotiation and authentication using the provided context.
+// If ctx is done before the session is established, DialContext fails with a *ContextError.
+// If DialContext fails after the handshake has started, tcpConn is closed.
 // Note that returned session doesn't inherit context.
 // If you want to use the same context, call Session.WithContext manually.
 // This implementation doesn't support multi-session on the same TCP connection.
 // If you want to use another session, you need to prepare another TCP connection at first.
-func (d *Dialer) DialContext(ctx context.Context, tcpConn net.Conn) (*Session, error) {
+func (d *Dialer) DialContext(ctx context.Context, tcpConn net.Conn) (_ *Session, err error) {
 	if ctx == nil {
 		panic("nil context")
 	}
-	if d.Initiator == nil {
+	if d.Initiator == nil && d.Authenticate == nil {
 		return nil, &InternalError{"Initiator is empty"}
 	}
-	if i, ok := d.Initiator.(*NTLMInitiator); ok {
-		if i.User == "" {
-			return nil, &InternalError{"Anonymous account is not supported yet. Use guest account instead"}
+	if d.Initiator != nil {
+		if _, err := d.prepareInitiator(d.Initiator); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := d.negotiate(d.Negotiator, tcpConn, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			conn.close()
+		}
+	}()
+
+	addr := tcpConn.RemoteAddr().String()
+
+	server := d.ServerName
+	if server == "" {
+		server = addr
+	}
+
+	s, err := d.authenticate(conn, addr, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.RejectGuest && s.sessionFlags&SMB2_SESSION_FLAG_IS_GUEST != 0 {
+		s.logoff(ctx)
+		return nil, ErrGuestSession
+	}
+
+	if d.RequireEncryption {
+		if !s.supportsEncryption() {
+			s.logoff(ctx)
+			return nil, ErrEncryptionNotSupported
+		}
+
+		// encrypt all requests, even if the server doesn't ask for it.
+		s.sessionFlags |= SMB2_SESSION_FLAG_ENCRYPT_DATA
+		conn.requireEncryption = true
+	}
+
+	switch conn.dialect {
+	case SMB300, SMB302:
+		if !d.Negotiator.SkipValidateNegotiate && s.sessionFlags&(SMB2_SESSION_FLAG_IS_GUEST|SMB2_SESSION_FLAG_IS_NULL) == 0 {
+			err = s.validateNegotiateInfo(server, ctx)
+			if err != nil {
+				s.logoff(ctx)
+				return nil, err
+			}
 		}
 	}
 
+	conn.retryPolicy = d.RetryPolicy
+
+	if d.AutoTune {
+		conn.autoTune = new(tuneStats)
+	}
+
+	dc := *d
+	s.dialer = &dc
+
+	if d.KeepAlive > 0 {
+		go s.keepAlive(d.KeepAlive)
+	}
+
+	return &Session{s: s, ctx: context.Background(), addr: server}, nil
+}
+
+// negotiate performs negotiation on tcpConn with n and the connection options of d.
+func (d *Dialer) negotiate(n Negotiator, tcpConn net.Conn, ctx context.Context) (*conn, error) {
 	maxCreditBalance := d.MaxCreditBalance
 	if maxCreditBalance == 0 {
 		maxCreditBalance = clientMaxCreditBalance
 	}
 
 	a := openAccount(maxCreditBalance)
+	a.initialRequest = d.InitialCreditRequest
 
-	conn, err := d.Negotiator.negotiate(direct(tcpConn), a, ctx)
-	if err != nil {
-		return nil, err
+	recvBufferSize := d.RecvBufferSize
+	if recvBufferSize <= 0 {
+		recvBufferSize = clientRecvBufferSize
+	}
+
+	n.compression = d.EnableCompression
+	n.leasing = d.RequestLeases
+	n.logger = d.Logger
+
+	var t transport
+
+	if d.useNetBIOS(tcpConn) {
+		called, calling := d.netBIOSNames()
+
+		if err := requestNetBIOSSession(tcpConn, called, calling, ctx); err != nil {
+			tcpConn.Close()
+			return nil, err
+		}
+
+		t = netBIOSSession(newDeadlineConn(tcpConn, d.ReadTimeout, d.WriteTimeout))
+	} else {
+		t = direct(newDeadlineConn(tcpConn, d.ReadTimeout, d.WriteTimeout))
 	}
 
-	s, err := sessionSetup(conn, d.Initiator, ctx)
+	conn, err := n.negotiate(t, a, recvBufferSize, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Session{s: s, ctx: context.Background(), addr: tcpConn.RemoteAddr().String()}, nil
+	conn.operationTimeout = d.OperationTimeout
+	conn.readAheadWindow = d.ReadAheadWindow
+	conn.writeBehindWindow = d.WriteBehindWindow
+
+	return conn, nil
+}
+
+// authenticate performs session setup with d.Initiator or the initiators returned by d.Authenticate.
+func (d *Dialer) authenticate(conn *conn, addr string, ctx context.Context) (*session, error) {
+	initiator := d.Initiator
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if initiator == nil {
+			initiator, err = d.Authenticate(ctx, &AuthChallenge{Addr: addr, Attempt: attempt, Err: err})
+			if err != nil {
+				return nil, err
+			}
+			if initiator == nil {
+				return nil, &InternalError{"Initiator is empty"}
+			}
+		}
+
+		initiator, err = d.prepareInitiator(initiator)
+		if err != nil {
+			return nil, err
+		}
+
+		var s *session
+
+		s, err = sessionSetup(conn, initiator, nil, ctx)
+		if err == nil {
+			return s, nil
+		}
+
+		if d.Authenticate == nil || !isLogonFailure(err) {
+			return nil, err
+		}
+
+		initiator = nil
+	}
+}
+
+func (d *Dialer) prepareInitiator(initiator Initiator) (Initiator, error) {
+	name := d.clientName()
+
+	if i, ok := initiator.(*NTLMInitiator); ok {
+		if i.User == "" {
+			return nil, &InternalError{"NTLMInitiator requires a user. Use AnonymousInitiator for anonymous sessions"}
+		}
+		if i.Workstation == "" && name != "" {
+			ni := *i
+			ni.Workstation = name
+			initiator = &ni
+		}
+	}
+	if i, ok := initiator.(*AnonymousInitiator); ok {
+		if i.Workstation == "" && name != "" {
+			ni := *i
+			ni.Workstation = name
+			initiator = &ni
+		}
+	}
+	return initiator, nil
+}
+
+// clientName returns ClientName, or the host name up to the first dot if it's empty and UseHostName is set.
+func (d *Dialer) clientName() string {
+	if d.ClientName != "" || !d.UseHostName {
+		return d.ClientName
+	}
+	name, _ := os.Hostname()
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func isLogonFailure(err error) bool {
+	if rerr, ok := err.(*ResponseError); ok {
+		switch NtStatus(rerr.Code) {
+		case STATUS_LOGON_FAILURE, STATUS_WRONG_PASSWORD:
+			return true
+		}
+	}
+	return false
 }
 
 // Session represents a SMB session.
@@ -86,15 +421,121 @@ func (c *Session) WithContext(ctx context.Context) *Session {
 	return &Session{s: c.s, ctx: ctx, addr: c.addr}
 }
 
-// Logoff invalidates the current SMB session.
+// ServerTime returns the system time of the server reported at negotiation.
+// If the server didn't report it, it returns the zero time.
+func (c *Session) ServerTime() time.Time {
+	return c.s.serverTime
+}
+
+// ClockSkew returns the difference between the server's clock and the local clock
+// measured at negotiation. A positive value means the server's clock is ahead.
+// Kerberos authentication typically fails if it exceeds 5 minutes.
+func (c *Session) ClockSkew() time.Duration {
+	return c.s.clockSkew
+}
+
+// IsGuest reports whether the server logged the client on as guest rather than as the user of the credentials,
+// e.g. because they are wrong and the server maps failed logons to guest.
+// Guest sessions are neither signed nor encrypted. See Dialer.RejectGuest.
+func (c *Session) IsGuest() bool {
+	return c.s.sessionFlags&SMB2_SESSION_FLAG_IS_GUEST != 0
+}
+
+// NegotiatedDialect returns the SMB dialect negotiated with the server, e.g. DialectSMB311.
+func (c *Session) NegotiatedDialect() uint16 {
+	return c.s.dialect
+}
+
+// Capabilities returns the capabilities advertised by the server at negotiation,
+// a combination of the Capability flags (e.g. CapabilityEncryption).
+// Note that the server only advertises the capabilities supported by the negotiated dialect.
+func (c *Session) Capabilities() uint32 {
+	return c.s.capabilities
+}
+
+// MaxReadSize returns the maximum size of a read request accepted by the server.
+// Reads are split into requests of at most this size, further capped at 1 MiB,
+// or at 64 KiB if the server doesn't support CapabilityLargeMTU.
+func (c *Session) MaxReadSize() int {
+	return int(c.s.maxReadSize)
+}
+
+// MaxWriteSize returns the maximum size of a write request accepted by the server.
+// Writes are split like reads. (See MaxReadSize)
+func (c *Session) MaxWriteSize() int {
+	return int(c.s.maxWriteSize)
+}
+
+// MaxTransactSize returns the maximum size of the buffers of query directory, query info,
+// set info and ioctl requests accepted by the server.
+func (c *Session) MaxTransactSize() int {
+	return int(c.s.maxTransactSize)
+}
+
+// CreditStats returns the credit statistics of the underlying connection.
+// A growing Blocked counter means that throughput is limited by the credit window
+// rather than by the bandwidth. If PeakAvailable stays well below MaxBalance,
+// the server doesn't grant more credits; see Dialer.InitialCreditRequest.
+// If Available stays at zero while Outstanding doesn't go down, the requests are stuck on the server.
+func (c *Session) CreditStats() CreditStats {
+	stats := c.s.account.snapshot()
+	stats.Outstanding = c.s.outstandingRequests.len()
+	return stats
+}
+
+// AutoTuneStats returns the statistics of automatic tuning of parallel transfers.
+// If Dialer.AutoTune wasn't set, it returns the zero value.
+func (c *Session) AutoTuneStats() AutoTuneStats {
+	if c.s.autoTune == nil {
+		return AutoTuneStats{}
+	}
+	return c.s.autoTune.snapshot()
+}
+
+// AddChannel binds tcpConn to the session as an additional channel, so that transfers can use
+// several connections, e.g. over multiple network interfaces. ([MS-SMB2] 3.2.4.1.7)
+// Each chunk of File.ReadAt and File.WriteAt, and of the methods built on them, is sent on the channel
+// with the fewest outstanding requests. Other requests use the connection the session was established on.
+//
+// tcpConn must be connected to the same server. It's negotiated with the options of the Dialer
+// which created the session, and authenticated again with the same initiator.
+// If the session isn't on SMB 3.x, the server doesn't advertise CapabilityMultiChannel,
+// or the session is a guest or anonymous one, it returns ErrNotSupported.
+// Like Dial, if AddChannel fails after the handshake has started, tcpConn is closed.
+// AddChannel must not be called concurrently. Logoff closes every channel.
+func (c *Session) AddChannel(tcpConn net.Conn) error {
+	return c.s.bindChannel(tcpConn, c.ctx)
+}
+
+// NumChannels returns the number of connections of the session, including the one it was established on.
+func (c *Session) NumChannels() int {
+	c.s.channelsMu.Lock()
+	defer c.s.channelsMu.Unlock()
+
+	return len(c.s.channels) + 1
+}
+
+// Logoff invalidates the current SMB session with LOGOFF and closes the connection,
+// so that the server frees the session right away.
+// It waits for the requests in flight first, and cancels the ones waiting for an asynchronous
+// operation, like watching a directory. The wait is bounded by the context of the session,
+// see WithContext, and by a default timeout after which the requests still in flight are cancelled,
+// then failed. (See feature.go for more details) Calling Logoff again after it succeeded does nothing.
 func (c *Session) Logoff() error {
 	return c.s.logoff(c.ctx)
 }
 
+// Echo sends ECHO to the server and waits for its answer, to check that the connection is alive.
+func (c *Session) Echo(ctx context.Context) error {
+	return c.s.echo(ctx)
+}
+
 // Mount mounts the SMB share.
 // sharename must follow format like `<share>` or `\\<server>\<share>`.
 // Note that the mounted share doesn't inherit session's context.
 // If you want to use the same context, call Share.WithContext manually.
+// If the share doesn't exist, the error is an *os.PathError containing the share name, whose Err is ErrShareNotFound.
+// Other errors are returned as they are, e.g. os.ErrPermission if access to the share is denied.
 func (c *Session) Mount(sharename string) (*Share, error) {
 	sharename = normPath(sharename)
 
@@ -108,6 +549,9 @@ func (c *Session) Mount(sharename string) (*Share, error) {
 
 	tc, err := treeConnect(c.s, sharename, 0, c.ctx)
 	if err != nil {
+		if err == ErrShareNotFound {
+			return nil, &os.PathError{Op: "mount", Path: sharename, Err: err}
+		}
 		return nil, err
 	}
 
@@ -237,16 +681,39 @@ func (fs *Share) WithContext(ctx context.Context) *Share {
 	}
 }
 
-// Umount disconects the current SMB tree.
+// Umount disconnects the current SMB tree with TREE_DISCONNECT.
+// Like Session.Logoff, it waits for the requests in flight on the share first, bounded by the context
+// of the share. Calling Umount again after it succeeded does nothing.
 func (fs *Share) Umount() error {
 	return fs.treeConn.disconnect(fs.ctx)
 }
 
+// SetEncryption forces the encryption of all requests on the share, even if the server doesn't require it
+// for the share nor for the session. It's meant for sensitive shares on an untrusted network path,
+// without paying for encryption on the other shares of the session.
+// It fails with ErrEncryptionNotSupported unless the session can be encrypted, i.e. SMB 3 with encryption
+// supported by the server and a session that isn't guest nor anonymous. (See Dialer.RequireEncryption)
+// SetEncryption(false) stops forcing encryption, but shares and sessions the server encrypts stay encrypted.
+func (fs *Share) SetEncryption(enable bool) error {
+	if !enable {
+		atomic.StoreInt32(&fs.forceEncryption, 0)
+		return nil
+	}
+
+	if !fs.supportsEncryption() {
+		return ErrEncryptionNotSupported
+	}
+
+	atomic.StoreInt32(&fs.forceEncryption, 1)
+
+	return nil
+}
+
 func (fs *Share) Create(name string) (*File, error) {
 	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
-func (fs *Share) newFile(r CreateResponseDecoder, name string) *File {
+func (fs *Share) newFile(r CreateResponseDecoder, name string, req *CreateRequest) *File {
 	fd := r.FileId().Decode()
 
 	fileStat := &FileStat{
@@ -260,7 +727,20 @@ func (fs *Share) newFile(r CreateResponseDecoder, name string) *File {
 		FileName:       base(name),
 	}
 
-	f := &File{fs: fs, fd: fd, name: name, fileStat: fileStat}
+	reopen := reopenRequest(req)
+
+	f := &File{fs: fs, fd: fd, name: name, fileStat: fileStat, reopen: &reopen, durable: grantDurable(r, req), lease: grantLease(r, req)}
+
+	if mayWrite(req.DesiredAccess) {
+		fs.trackHandle(fd, name, req.DesiredAccess)
+	}
+
+	switch {
+	case f.lease != nil:
+		fs.oplocks.setLease(f.lease.key, f)
+	case r.OplockLevel() != SMB2_OPLOCK_LEVEL_NONE:
+		fs.oplocks.set(fd, f)
+	}
 
 	runtime.SetFinalizer(f, (*File).close)
 
@@ -271,13 +751,206 @@ func (fs *Share) Open(name string) (*File, error) {
 	return fs.OpenFile(name, os.O_RDONLY, 0)
 }
 
+// OpenOptions contains optional parameters for Share.OpenFileWith.
+// The zero value opens any kind of file like Share.OpenFile.
+type OpenOptions struct {
+	// Directory asserts that the file is a directory.
+	// If it isn't, the open fails with ErrNotDirectory.
+	Directory bool
+
+	// NonDirectory asserts that the file is not a directory.
+	// If it is, the open fails with ErrIsDirectory.
+	NonDirectory bool
+
+	// Unbuffered requests the server to bypass its cache when reading (SMB2_READFLAG_READ_UNBUFFERED).
+	// It's useful for verifying on-disk data and for large sequential reads that shouldn't pollute the server cache.
+	// It requires SMB 3.0.2 or later; otherwise the open fails with ErrNotSupported.
+	// Depending on the underlying file system, the server may require that read offsets and lengths
+	// are aligned to the sector size of the volume.
+	Unbuffered bool
+
+	// ImpersonationLevel is the impersonation level requested to the server.
+	// The zero value requests SecurityImpersonation.
+	ImpersonationLevel ImpersonationLevel
+
+	// Durable requests a durable handle, which the server keeps open for a while after the connection is lost,
+	// so that it can be reclaimed by File.Reconnect on a new session.
+	// Servers only grant durability with a batch oplock, which is requested as well. When another client
+	// opens the file, the oplock is broken and acknowledged automatically, and the handle isn't durable anymore.
+	// If the server doesn't grant durability, the file is opened anyway and File.IsDurable reports false.
+	// On continuously available shares, the handle is requested persistent, see File.IsPersistent.
+	Durable bool
+
+	// DurableTimeout is the time the server should keep a durable handle after a disconnect on SMB 3.x.
+	// If it's zero, the server chooses, typically 60 seconds.
+	DurableTimeout time.Duration
+
+	// AppInstanceID, if it's not zero, identifies the application instance that opens a durable handle
+	// on SMB 3.x (SMB2_CREATE_APP_INSTANCE_ID). When the file is opened again with the same id,
+	// e.g. by the application restarted on another node of a cluster after a failover, the server closes
+	// the handle left by the old instance instead of failing the open with a sharing violation.
+	// This fences handles which can't be reclaimed by File.Reconnect, since the new instance
+	// doesn't know them; handles that are reconnected keep their id and don't need it.
+	// It's ignored unless Durable is set.
+	AppInstanceID [16]byte
+
+	// AppInstanceVersionHigh and AppInstanceVersionLow, if they aren't zero, version the handle of
+	// AppInstanceID on SMB 3.1.1 (SMB2_CREATE_APP_INSTANCE_VERSION). An open with the same id only closes
+	// the old handle if its version is higher, so that a stale instance can't fence a newer one.
+	AppInstanceVersionHigh uint64
+	AppInstanceVersionLow  uint64
+
+	// NoFollow opens a symbolic link or another reparse point itself rather than its target
+	// (FILE_OPEN_REPARSE_POINT), e.g. to read it with File.Readlink or File.ReadReparsePoint.
+	NoFollow bool
+
+	// Snapshot, if it's not zero, opens the file as of the snapshot of the volume taken at that time,
+	// which must be one of those returned by Share.Snapshots. Snapshots are read-only.
+	// If there is no such snapshot, the open fails with an error satisfying os.IsNotExist.
+	Snapshot time.Time
+
+	// DeleteAccess requests DELETE access in addition to the access implied by the flags,
+	// which File.Rename requires.
+	DeleteAccess bool
+
+	// AllocationSize, if it's positive, reserves that many bytes of disk space for the file
+	// when it's created or overwritten, without changing its size (SMB2_CREATE_ALLOCATION_SIZE).
+	// It's ignored when an existing file is opened; use File.SetAllocationSize then.
+	AllocationSize int64
+
+	// DeleteOnClose deletes the file once all of its handles are closed, even if the connection is lost before
+	// (FILE_DELETE_ON_CLOSE), which suits temporary files. It implies DeleteAccess.
+	// Unlike File.SetDeleteOnClose, it can't be undone.
+	DeleteOnClose bool
+
+	// DirectoryInfo is the information returned for the entries of a directory by Readdir.
+	// If it's zero, file ids are returned if the server supports them, see DirectoryInfoClass.
+	DirectoryInfo DirectoryInfoClass
+}
+
+// ImpersonationLevel represents how much the server may act on behalf of the client
+// when it accesses resources to serve a request. ([MS-SMB2] 2.2.13)
+type ImpersonationLevel int
+
+const (
+	// SecurityAnonymous prevents the server from obtaining the identity of the client.
+	SecurityAnonymous ImpersonationLevel = iota + 1
+
+	// SecurityIdentification allows the server to identify the client but not to impersonate it.
+	SecurityIdentification
+
+	// SecurityImpersonation allows the server to impersonate the client on the local system.
+	SecurityImpersonation
+
+	// SecurityDelegation allows the server to impersonate the client on remote systems too,
+	// which is needed when the server relays access to another server (double-hop).
+	SecurityDelegation
+)
+
+func (level ImpersonationLevel) value() (uint32, bool) {
+	switch level {
+	case 0:
+		return Impersonation, true
+	case SecurityAnonymous:
+		return Anonymous, true
+	case SecurityIdentification:
+		return Identification, true
+	case SecurityImpersonation:
+		return Impersonation, true
+	case SecurityDelegation:
+		return Delegate, true
+	}
+	return 0, false
+}
+
+// DirectoryInfoClass represents the information returned for the entries of a directory by Readdir.
+// The classes other than DirectoryInfo fill the additional fields of FileStat. ([MS-FSCC] 2.4)
+//
+// By default, entries are listed with IdFullDirectoryInfo, so that the IndexNumber of the FileStat
+// returned by Sys identifies files across listings and tells hard links apart, or with DirectoryInfo
+// if the server doesn't support file ids.
+type DirectoryInfoClass int
+
+const (
+	// DirectoryInfo returns the times, the sizes and the attributes of the entries.
+	DirectoryInfo DirectoryInfoClass = iota + 1
+
+	// FullDirectoryInfo returns the size of the extended attributes as well (EaSize).
+	FullDirectoryInfo
+
+	// BothDirectoryInfo returns the 8.3 short names as well (EaSize, ShortName).
+	BothDirectoryInfo
+
+	// IdFullDirectoryInfo returns the file ids as well (EaSize, IndexNumber).
+	IdFullDirectoryInfo
+
+	// IdBothDirectoryInfo returns the short names and the file ids as well (EaSize, ShortName, IndexNumber).
+	IdBothDirectoryInfo
+)
+
+func (class DirectoryInfoClass) value() (uint8, bool) {
+	switch class {
+	case 0:
+		return 0, true // chosen by File.readdirn
+	case DirectoryInfo:
+		return FileDirectoryInformation, true
+	case FullDirectoryInfo:
+		return FileFullDirectoryInformation, true
+	case BothDirectoryInfo:
+		return FileBothDirectoryInformation, true
+	case IdFullDirectoryInfo:
+		return FileIdFullDirectoryInformation, true
+	case IdBothDirectoryInfo:
+		return FileIdBothDirectoryInformation, true
+	}
+	return 0, false
+}
+
 func (fs *Share) OpenFile(name string, flag int, perm os.FileMode) (*File, error) {
-	name = normPath(name)
+	return fs.OpenFileWith(name, flag, perm, nil)
+}
 
-	if err := validatePath("open", name, false); err != nil {
+// OpenFileWith is like OpenFile but accepts additional options.
+// If opts is nil, it behaves like OpenFile.
+func (fs *Share) OpenFileWith(name string, flag int, perm os.FileMode, opts *OpenOptions) (*File, error) {
+	name, err := cleanPath("open", name)
+	if err != nil {
 		return nil, err
 	}
 
+	var createoptions uint32 = FILE_SYNCHRONOUS_IO_NONALERT
+	var impersonation uint32 = Impersonation
+	var dirInfoClass uint8
+	if opts != nil {
+		if opts.Directory && opts.NonDirectory {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+		if opts.Directory {
+			createoptions |= FILE_DIRECTORY_FILE
+		}
+		if opts.NonDirectory {
+			createoptions |= FILE_NON_DIRECTORY_FILE
+		}
+		if opts.NoFollow {
+			createoptions |= FILE_OPEN_REPARSE_POINT
+		}
+		if opts.DeleteOnClose {
+			createoptions |= FILE_DELETE_ON_CLOSE
+		}
+		if opts.Unbuffered && fs.dialect < SMB302 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+		}
+		var ok bool
+		impersonation, ok = opts.ImpersonationLevel.value()
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+		dirInfoClass, ok = opts.DirectoryInfo.value()
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+	}
+
 	var access uint32
 	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
 	case os.O_RDONLY:
@@ -294,6 +967,9 @@ func (fs *Share) OpenFile(name string, flag int, perm os.FileMode) (*File, error
 		access &^= GENERIC_WRITE
 		access |= FILE_APPEND_DATA
 	}
+	if opts != nil && (opts.DeleteAccess || opts.DeleteOnClose) {
+		access |= DELETE
+	}
 
 	sharemode := uint32(FILE_SHARE_READ | FILE_SHARE_WRITE)
 
@@ -319,19 +995,46 @@ func (fs *Share) OpenFile(name string, flag int, perm os.FileMode) (*File, error
 	req := &CreateRequest{
 		SecurityFlags:        0,
 		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
-		ImpersonationLevel:   Impersonation,
+		ImpersonationLevel:   impersonation,
 		SmbCreateFlags:       0,
 		DesiredAccess:        access,
 		FileAttributes:       attrs,
 		ShareAccess:          sharemode,
 		CreateDisposition:    createmode,
-		CreateOptions:        FILE_SYNCHRONOUS_IO_NONALERT,
+		CreateOptions:        createoptions,
+	}
+
+	if opts != nil && opts.Durable {
+		err = fs.requestDurable(req, opts.DurableTimeout)
+		if err == nil {
+			fs.requestAppInstance(req, opts.AppInstanceID, opts.AppInstanceVersionHigh, opts.AppInstanceVersionLow)
+		}
+	} else {
+		err = fs.requestLease(req)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if opts != nil && !opts.Snapshot.IsZero() {
+		requestSnapshot(req, opts.Snapshot)
+	}
+
+	if opts != nil && opts.AllocationSize > 0 {
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_ALLOCATION_SIZE,
+			Data: &FileAllocationInformationEncoder{AllocationSize: opts.AllocationSize},
+		})
 	}
 
-	f, err := fs.createFile(name, req, true)
+	f, err := fs.createFile(name, req, opts == nil || !opts.NoFollow)
 	if err != nil {
 		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
+	if opts != nil && opts.Unbuffered {
+		f.readFlags = SMB2_READFLAG_READ_UNBUFFERED
+	}
+	f.dirInfoClass = dirInfoClass
 	if flag&os.O_APPEND != 0 {
 		f.seek(0, io.SeekEnd)
 	}
@@ -339,9 +1042,8 @@ func (fs *Share) OpenFile(name string, flag int, perm os.FileMode) (*File, error
 }
 
 func (fs *Share) Mkdir(name string, perm os.FileMode) error {
-	name = normPath(name)
-
-	if err := validatePath("mkdir", name, false); err != nil {
+	name, err := cleanPath("mkdir", name)
+	if err != nil {
 		return err
 	}
 
@@ -369,10 +1071,10 @@ func (fs *Share) Mkdir(name string, perm os.FileMode) error {
 	return nil
 }
 
+// Readlink returns the target of the symbolic link or the mount point name. See File.Readlink.
 func (fs *Share) Readlink(name string) (string, error) {
-	name = normPath(name)
-
-	if err := validatePath("readlink", name, false); err != nil {
+	name, err := cleanPath("readlink", name)
+	if err != nil {
 		return "", err
 	}
 
@@ -393,17 +1095,7 @@ func (fs *Share) Readlink(name string) (string, error) {
 		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
 	}
 
-	req := &IoctlRequest{
-		CtlCode:           FSCTL_GET_REPARSE_POINT,
-		OutputOffset:      0,
-		OutputCount:       0,
-		MaxInputResponse:  0,
-		MaxOutputResponse: uint32(f.maxTransactSize()),
-		Flags:             SMB2_0_IOCTL_IS_FSCTL,
-		Input:             nil,
-	}
-
-	output, err := f.ioctl(req)
+	target, err := f.readlink()
 	if e := f.close(); err == nil {
 		err = e
 	}
@@ -411,20 +1103,6 @@ func (fs *Share) Readlink(name string) (string, error) {
 		return "", &os.PathError{Op: "readlink", Path: f.name, Err: err}
 	}
 
-	r := SymbolicLinkReparseDataBufferDecoder(output)
-	if r.IsInvalid() {
-		return "", &os.PathError{Op: "readlink", Path: f.name, Err: &InvalidResponseError{"broken symbolic link response data buffer format"}}
-	}
-
-	target := r.SubstituteName()
-
-	switch {
-	case strings.HasPrefix(target, `\??\UNC\`):
-		target = `\\` + target[8:]
-	case strings.HasPrefix(target, `\??\`):
-		target = target[4:]
-	}
-
 	return target, nil
 }
 
@@ -440,9 +1118,8 @@ func (fs *Share) Remove(name string) error {
 }
 
 func (fs *Share) remove(name string) error {
-	name = normPath(name)
-
-	if err := validatePath("remove", name, false); err != nil {
+	name, err := cleanPath("remove", name)
+	if err != nil {
 		return err
 	}
 
@@ -477,14 +1154,13 @@ func (fs *Share) remove(name string) error {
 }
 
 func (fs *Share) Rename(oldpath, newpath string) error {
-	oldpath = normPath(oldpath)
-	newpath = normPath(newpath)
-
-	if err := validatePath("rename from", oldpath, false); err != nil {
+	oldpath, err := cleanPath("rename from", oldpath)
+	if err != nil {
 		return err
 	}
 
-	if err := validatePath("rename to", newpath, false); err != nil {
+	newpath, err = cleanPath("rename to", newpath)
+	if err != nil {
 		return err
 	}
 
@@ -505,13 +1181,54 @@ func (fs *Share) Rename(oldpath, newpath string) error {
 		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
 	}
 
+	err = f.rename(newpath, false)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+// Link creates newname as a hard link to the oldname file, like os.Link.
+// If newname exists, Link fails with an error satisfying os.IsExist.
+// Both names are relative to the share root, and must be on the same volume.
+func (fs *Share) Link(oldname, newname string) error {
+	oldname, err := cleanPath("link", oldname)
+	if err != nil {
+		return err
+	}
+
+	newname, err = cleanPath("link", newname)
+	if err != nil {
+		return err
+	}
+
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_WRITE_ATTRIBUTES,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_OPEN_REPARSE_POINT,
+	}
+
+	f, err := fs.createFile(oldname, create, false)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+
 	info := &SetInfoRequest{
-		FileInfoClass:         FileRenameInformation,
+		FileInfoClass:         FileLinkInformation,
 		AdditionalInformation: 0,
-		Input: &FileRenameInformationType2Encoder{
+		Input: &FileLinkInformationType2Encoder{
 			ReplaceIfExists: 0,
 			RootDirectory:   0,
-			FileName:        newpath,
+			FileName:        newname,
 		},
 	}
 
@@ -520,7 +1237,7 @@ func (fs *Share) Rename(oldpath, newpath string) error {
 		err = e
 	}
 	if err != nil {
-		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
 	}
 	return nil
 }
@@ -534,15 +1251,16 @@ func (fs *Share) Rename(oldpath, newpath string) error {
 // This implementation always assumes that format is absolute path.
 // So, if you know the target server is Windows, you should avoid that format.
 // If you want to use an absolute target path on windows, you can use // `C:\dir\name` format instead.
+// UNC targets (e.g. `\\server\share\name`) are absolute.
 func (fs *Share) Symlink(target, linkpath string) error {
 	target = normPath(target)
-	linkpath = normPath(linkpath)
 
 	if err := validatePath("symlink target", target, true); err != nil {
 		return err
 	}
 
-	if err := validatePath("symlink linkpath", linkpath, false); err != nil {
+	linkpath, err := cleanPath("symlink linkpath", linkpath)
+	if err != nil {
 		return err
 	}
 
@@ -558,6 +1276,9 @@ func (fs *Share) Symlink(target, linkpath string) error {
 		}
 		rdbuf.SubstituteName = `\??\` + target
 		rdbuf.PrintName = rdbuf.SubstituteName[4:]
+	} else if strings.HasPrefix(target, `\\`) {
+		rdbuf.SubstituteName = `\??\UNC\` + target[2:]
+		rdbuf.PrintName = target
 	} else {
 		if target[0] != '\\' {
 			rdbuf.Flags = SYMLINK_FLAG_RELATIVE // It's not true on window server.
@@ -610,9 +1331,8 @@ func (fs *Share) Symlink(target, linkpath string) error {
 }
 
 func (fs *Share) Lstat(name string) (os.FileInfo, error) {
-	name = normPath(name)
-
-	if err := validatePath("lstat", name, false); err != nil {
+	name, err := cleanPath("lstat", name)
+	if err != nil {
 		return nil, err
 	}
 
@@ -644,9 +1364,8 @@ func (fs *Share) Lstat(name string) (os.FileInfo, error) {
 }
 
 func (fs *Share) Stat(name string) (os.FileInfo, error) {
-	name = normPath(name)
-
-	if err := validatePath("stat", name, false); err != nil {
+	name, err := cleanPath("stat", name)
+	if err != nil {
 		return nil, err
 	}
 
@@ -677,10 +1396,34 @@ func (fs *Share) Stat(name string) (os.FileInfo, error) {
 	return fi, nil
 }
 
-func (fs *Share) Truncate(name string, size int64) error {
-	name = normPath(name)
+// Exists reports whether the named file or directory exists.
+// Symbolic links are followed, so a dangling link is reported as non-existent.
+// It returns false and a nil error if the server reports the file or a path component as not found,
+// and a non-nil error for other failures such as permission denial.
+func (fs *Share) Exists(name string) (bool, error) {
+	_, err := fs.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// IsDir reports whether the named file exists and is a directory.
+// Symbolic links are followed. Unlike Exists, it returns an error if the file doesn't exist.
+func (fs *Share) IsDir(name string) (bool, error) {
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
 
-	if err := validatePath("truncate", name, false); err != nil {
+func (fs *Share) Truncate(name string, size int64) error {
+	name, err := cleanPath("truncate", name)
+	if err != nil {
 		return err
 	}
 
@@ -716,9 +1459,8 @@ func (fs *Share) Truncate(name string, size int64) error {
 }
 
 func (fs *Share) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normPath(name)
-
-	if err := validatePath("chtimes", name, false); err != nil {
+	name, err := cleanPath("chtimes", name)
+	if err != nil {
 		return err
 	}
 
@@ -758,10 +1500,10 @@ func (fs *Share) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return nil
 }
 
+// Chmod changes the mode of the named file to mode. See File.Chmod.
 func (fs *Share) Chmod(name string, mode os.FileMode) error {
-	name = normPath(name)
-
-	if err := validatePath("chmod", name, false); err != nil {
+	name, err := cleanPath("chmod", name)
+	if err != nil {
 		return err
 	}
 
@@ -792,6 +1534,9 @@ func (fs *Share) Chmod(name string, mode os.FileMode) error {
 	return nil
 }
 
+// ReadDir reads the directory named by dirname and returns a list of directory entries sorted by filename.
+// If an error occurs while reading the directory, ReadDir returns the entries it was able to read
+// before the error, along with the error.
 func (fs *Share) ReadDir(dirname string) ([]os.FileInfo, error) {
 	f, err := fs.Open(dirname)
 	if err != nil {
@@ -800,13 +1545,10 @@ func (fs *Share) ReadDir(dirname string) ([]os.FileInfo, error) {
 	defer f.Close()
 
 	fis, err := f.Readdir(-1)
-	if err != nil {
-		return nil, err
-	}
 
 	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
 
-	return fis, nil
+	return fis, err
 }
 
 const (
@@ -871,9 +1613,8 @@ func (fs *Share) WriteFile(filename string, data []byte, perm os.FileMode) error
 }
 
 func (fs *Share) Statfs(name string) (FileFsInfo, error) {
-	name = normPath(name)
-
-	if err := validatePath("statfs", name, false); err != nil {
+	name, err := cleanPath("statfs", name)
+	if err != nil {
 		return nil, err
 	}
 
@@ -904,7 +1645,24 @@ func (fs *Share) Statfs(name string) (FileFsInfo, error) {
 	return fi, nil
 }
 
+// createFile opens name, following the DFS referrals of the server to the share it lives on.
 func (fs *Share) createFile(name string, req *CreateRequest, followSymlinks bool) (f *File, err error) {
+	for i := 0; i < clientMaxDFSReferralDepth; i++ {
+		f, err = fs.createFileOnce(name, req, followSymlinks)
+		if !isPathNotCovered(err) {
+			return f, err
+		}
+
+		fs, name, err = fs.redirectDFS(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, &InternalError{"Too many levels of DFS referrals"}
+}
+
+func (fs *Share) createFileOnce(name string, req *CreateRequest, followSymlinks bool) (f *File, err error) {
 	if followSymlinks {
 		return fs.createFileRec(name, req)
 	}
@@ -919,7 +1677,7 @@ func (fs *Share) createFile(name string, req *CreateRequest, followSymlinks bool
 		return nil, err
 	}
 
-	req.Name = name
+	fs.createName(req, name)
 
 	res, err := fs.sendRecv(SMB2_CREATE, req)
 	if err != nil {
@@ -931,7 +1689,7 @@ func (fs *Share) createFile(name string, req *CreateRequest, followSymlinks bool
 		return nil, &InvalidResponseError{"broken create response format"}
 	}
 
-	f = fs.newFile(r, name)
+	f = fs.newFile(r, name, req)
 
 	return f, nil
 }
@@ -948,13 +1706,13 @@ func (fs *Share) createFileRec(name string, req *CreateRequest) (f *File, err er
 			return nil, err
 		}
 
-		req.Name = name
+		fs.createName(req, name)
 
 		res, err := fs.sendRecv(SMB2_CREATE, req)
 		if err != nil {
 			if rerr, ok := err.(*ResponseError); ok && NtStatus(rerr.Code) == STATUS_STOPPED_ON_SYMLINK {
 				if len(rerr.data) > 0 {
-					name, err = evalSymlinkError(req.Name, rerr.data[0])
+					name, err = evalSymlinkError(name, rerr.data[0])
 					if err != nil {
 						return nil, err
 					}
@@ -969,7 +1727,7 @@ func (fs *Share) createFileRec(name string, req *CreateRequest) (f *File, err er
 			return nil, &InvalidResponseError{"broken create response format"}
 		}
 
-		f = fs.newFile(r, name)
+		f = fs.newFile(r, name, req)
 
 		return f, nil
 	}
@@ -988,14 +1746,7 @@ func evalSymlinkError(name string, errData []byte) (string, error) {
 		return "", &InvalidResponseError{"broken symbolic link error response format"}
 	}
 
-	target := d.SubstituteName()
-
-	switch {
-	case strings.HasPrefix(target, `\??\UNC\`):
-		target = `\\` + target[8:]
-	case strings.HasPrefix(target, `\??\`):
-		target = target[4:]
-	}
+	target := substituteNameToPath(d.SubstituteName())
 
 	if d.Flags()&SYMLINK_FLAG_RELATIVE == 0 {
 		return target + u, nil
@@ -1005,33 +1756,105 @@ func evalSymlinkError(name string, errData []byte) (string, error) {
 }
 
 func (fs *Share) sendRecv(cmd uint16, req Packet) (res []byte, err error) {
-	rr, err := fs.send(req, fs.ctx)
-	if err != nil {
-		return nil, err
-	}
+	res, _, err = fs.sendRecvInto(cmd, req, nil)
+	return res, err
+}
 
-	pkt, err := fs.recv(rr)
-	if err != nil {
-		return nil, err
-	}
+// sendRecvInto is like sendRecv, but the data of the response of READ may be placed directly into dst,
+// in which case the direct field of the returned request is set. Its release method returns
+// the buffer of the response to the pool once res isn't used anymore.
+func (fs *Share) sendRecvInto(cmd uint16, req Packet, dst []byte) (res []byte, rr *requestResponse, err error) {
+	policy := fs.retryPolicy
+	hdr := req.Header()
+	creditRequest := hdr.CreditRequestResponse
+
+	var backoff time.Duration
+
+	for retries := 0; ; retries++ {
+		rr, err := fs.sendInto(req, dst, fs.ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pkt, err := fs.recv(rr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		res, err = accept(cmd, pkt)
+		if policy == nil || retries >= policy.MaxRetries || !isResourceShortage(err) {
+			return res, rr, err
+		}
 
-	return accept(cmd, pkt)
+		backoff = policy.nextBackoff(backoff)
+
+		if err := sleep(backoff, fs.ctx); err != nil {
+			return nil, nil, err
+		}
+
+		// the credits of the previous attempt were given back with the response.
+		// acquire them again and reset the header for the next attempt.
+		if err := fs.account.loanAll(hdr.CreditCharge, fs.ctx); err != nil {
+			return nil, nil, err
+		}
+		hdr.CreditRequestResponse = creditRequest
+	}
 }
 
 func (fs *Share) loanCredit(payloadSize int) (creditCharge uint16, grantedPayloadSize int, err error) {
 	return fs.session.conn.loanCredit(payloadSize, fs.ctx)
 }
 
+// channel returns the share on the channel of the session with the fewest outstanding requests.
+// Requests sent through it must loan their credits from it as well.
+func (fs *Share) channel() *Share {
+	tc := fs.treeConn.channel()
+	if tc == fs.treeConn {
+		return fs
+	}
+	return &Share{treeConn: tc, ctx: fs.ctx}
+}
+
+// File represents an open handle on the server.
+//
+// Each call of Share.Open, Share.OpenFile or Share.Create opens a new handle, even if the same path
+// is already open. Handles have their own file offset, so Read, Write and Seek on one handle
+// don't affect the others. Sharing violations are avoided by opening with FILE_SHARE_READ and FILE_SHARE_WRITE,
+// but the data written through one handle is visible to the others immediately.
+//
+// A File is safe for concurrent use by multiple goroutines.
+// ReadAt and WriteAt don't use the offset and may run in parallel.
+// Read, Write, Seek and Readdir are serialized, but concurrent calls of them on the same File
+// observe each other's offset updates in an unspecified order. Goroutines that need their own offset
+// should open their own handles or use ReadAt and WriteAt.
 type File struct {
 	fs          *Share
 	fd          *FileId
 	name        string
 	fileStat    *FileStat
 	dirents     []os.FileInfo
+	dirPattern  string // pattern of the enumeration of the directory, or "" before the first request
 	noMoreFiles bool
 
 	offset int64
 
+	// resiliencyTimeout is the timeout granted by RequestResiliency.
+	// If it's non-zero, the handle survives a disconnect for that duration.
+	resiliencyTimeout time.Duration
+
+	// reopen is the request that opened the handle, reissued by Reconnect to reclaim a resilient handle.
+	reopen *CreateRequest
+
+	// durable is the state of a durable handle, or nil. See OpenOptions.Durable.
+	durable *durableHandle
+
+	// lease is the state of the lease of the file, or nil. See Dialer.RequestLeases.
+	lease *lease
+
+	readFlags uint8 // flags of READ requests
+
+	dirInfoClass uint8 // FileInformationClass of QUERY_DIRECTORY requests, or 0 before the default one is known. See OpenOptions.DirectoryInfo
+
 	m sync.Mutex
 }
 
@@ -1060,6 +1883,10 @@ func (f *File) close() error {
 
 	req.FileId = f.fd
 
+	// the handle is given up even if CLOSE fails, like os.File.Close does, e.g. when it's called
+	// by the finalizer on a dead connection. Otherwise, Share.Sync would keep flushing it.
+	defer f.release()
+
 	res, err := f.sendRecv(SMB2_CLOSE, req)
 	if err != nil {
 		return err
@@ -1070,19 +1897,59 @@ func (f *File) close() error {
 		return &InvalidResponseError{"broken close response format"}
 	}
 
+	return nil
+}
+
+// release forgets the handle of f on the share.
+func (f *File) release() {
+	f.fs.untrackHandle(f.fd)
+	f.fs.oplocks.delete(f.fd)
+	if f.lease != nil {
+		f.fs.oplocks.deleteLease(f.lease.key)
+	}
+
 	f.fd = nil
 
 	runtime.SetFinalizer(f, nil)
+}
 
-	return nil
+// mayWrite reports whether a handle opened with access may be written through, i.e. whether Share.Sync flushes it.
+// MAXIMUM_ALLOWED grants whatever the server allows, which may include writing.
+func mayWrite(access uint32) bool {
+	return requestsWrite(access) || access&MAXIMUM_ALLOWED != 0
+}
+
+// requestsWrite reports whether access includes write access explicitly.
+func requestsWrite(access uint32) bool {
+	return access&(GENERIC_ALL|GENERIC_WRITE|FILE_WRITE_DATA|FILE_APPEND_DATA) != 0
 }
 
 func (f *File) remove() error {
+	return f.setDeleteOnClose(true)
+}
+
+// SetDeleteOnClose marks the file to be deleted once all of its handles are closed, or unmarks it if del is false.
+// The server deletes the file even if the connection is lost before. The file must have been opened with DELETE access,
+// see OpenOptions.DeleteAccess. It doesn't unmark files opened with OpenOptions.DeleteOnClose.
+func (f *File) SetDeleteOnClose(del bool) error {
+	err := f.setDeleteOnClose(del)
+	if err != nil {
+		return &os.PathError{Op: "setdeleteonclose", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) setDeleteOnClose(del bool) error {
+	var pending uint8
+	if del {
+		pending = 1
+	}
+
 	info := &SetInfoRequest{
 		FileInfoClass:         FileDispositionInformation,
 		AdditionalInformation: 0,
 		Input: &FileDispositionInformationEncoder{
-			DeletePending: 1,
+			DeletePending: pending,
 		},
 	}
 
@@ -1123,9 +1990,10 @@ func (f *File) Read(b []byte) (n int, err error) {
 }
 
 // ReadAt implements io.ReaderAt.
+// As required by io.ReaderAt, it returns io.EOF if fewer than len(b) bytes are read because EOF is reached.
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	if off < 0 {
-		return -1, os.ErrInvalid
+		return 0, os.ErrInvalid
 	}
 
 	n, err = f.readAt(b, off)
@@ -1135,9 +2003,39 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 		}
 		return n, &os.PathError{Op: "read", Path: f.name, Err: err}
 	}
+	if n < len(b) {
+		return n, io.EOF
+	}
 	return n, nil
 }
 
+// OpenSection opens the named file for reading and returns a reader of the n bytes starting at off.
+// Reading stops at EOF of the section or of the file, whichever comes first.
+// The returned reader also implements io.Seeker and io.ReaderAt relative to the section,
+// as *io.SectionReader does, so it can be used with http.ServeContent.
+// Closing it closes the file.
+func (fs *Share) OpenSection(name string, off, n int64) (io.ReadCloser, error) {
+	if off < 0 || n < 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sectionReadCloser{SectionReader: io.NewSectionReader(f, off, n), f: f}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	f *File
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.f.Close()
+}
+
 const winMaxPayloadSize = 1024 * 1024 // windows system don't accept more than 1M bytes request even though they tell us maxXXXSize > 1M
 const singleCreditMaxPayloadSize = 64 * 1024
 
@@ -1187,12 +2085,95 @@ func (f *File) readAt(b []byte, off int64) (n int, err error) {
 
 	maxReadSize := f.maxReadSize()
 
+	if window := f.fs.readAheadWindow; window > 1 && len(b) > maxReadSize {
+		return f.readAtPipelined(b, off, maxReadSize, window)
+	}
+
+	return f.readAtSerial(b, off, maxReadSize)
+}
+
+// readAtPipelined reads b in chunks of maxReadSize with up to window chunks in flight.
+// The chunks may complete in any order, but the result is the one of reading them one after another:
+// b is filled up to the first chunk that reached EOF, and an error of a chunk before it fails the read.
+// If Dialer.AutoTune is set, the number of chunks in flight is adjusted within the window.
+func (f *File) readAtPipelined(b []byte, off int64, maxReadSize, window int) (n int, err error) {
+	type chunk struct {
+		n   int
+		err error
+	}
+
+	chunks := make([]chunk, (len(b)+maxReadSize-1)/maxReadSize)
+
+	t := f.fs.conn.newWindowTuner(maxReadSize, window)
+	if t != nil {
+		window = t.resume(window)
+	}
+
+	l := newTunedLimiter(window, t)
+
+	var wg sync.WaitGroup
+
+	// stop is set once a chunk reached EOF or failed, so that no more chunks are sent.
+	// The chunks following it aren't looked at.
+	var stop int32
+
+	for i := range chunks {
+		if atomic.LoadInt32(&stop) != 0 {
+			break
+		}
+
+		i := i
+
+		l.do(&wg, func() error {
+			if atomic.LoadInt32(&stop) != 0 {
+				return nil
+			}
+
+			start := i * maxReadSize
+			end := start + maxReadSize
+			if end > len(b) {
+				end = len(b)
+			}
+
+			c := &chunks[i]
+
+			c.n, c.err = f.readAtSerial(b[start:end], off+int64(start), maxReadSize)
+			if c.err != nil || c.n < end-start {
+				atomic.StoreInt32(&stop, 1)
+			}
+
+			return c.err
+		})
+	}
+
+	wg.Wait()
+
+	for _, c := range chunks {
+		if c.err != nil {
+			if err, ok := c.err.(*ResponseError); ok && NtStatus(err.Code) == STATUS_END_OF_FILE && n != 0 {
+				return n, nil
+			}
+			return 0, c.err
+		}
+
+		n += c.n
+
+		if c.n < maxReadSize {
+			return n, nil
+		}
+	}
+
+	return n, nil
+}
+
+// readAtSerial reads b in chunks of maxReadSize, one at a time.
+func (f *File) readAtSerial(b []byte, off int64, maxReadSize int) (n int, err error) {
 	for {
 		switch {
 		case len(b)-n == 0:
 			return n, nil
 		case len(b)-n <= maxReadSize:
-			bs, isEOF, err := f.readAtChunk(len(b)-n, int64(n)+off)
+			m, isEOF, err := f.readAtChunk(b[n:], int64(n)+off)
 			if err != nil {
 				if err, ok := err.(*ResponseError); ok && NtStatus(err.Code) == STATUS_END_OF_FILE && n != 0 {
 					return n, nil
@@ -1200,13 +2181,13 @@ func (f *File) readAt(b []byte, off int64) (n int, err error) {
 				return 0, err
 			}
 
-			n += copy(b[n:], bs)
+			n += m
 
 			if isEOF {
 				return n, nil
 			}
 		default:
-			bs, isEOF, err := f.readAtChunk(maxReadSize, int64(n)+off)
+			m, isEOF, err := f.readAtChunk(b[n:n+maxReadSize], int64(n)+off)
 			if err != nil {
 				if err, ok := err.(*ResponseError); ok && NtStatus(err.Code) == STATUS_END_OF_FILE && n != 0 {
 					return n, nil
@@ -1214,7 +2195,7 @@ func (f *File) readAt(b []byte, off int64) (n int, err error) {
 				return 0, err
 			}
 
-			n += copy(b[n:], bs)
+			n += m
 
 			if isEOF {
 				return n, nil
@@ -1223,20 +2204,30 @@ func (f *File) readAt(b []byte, off int64) (n int, err error) {
 	}
 }
 
-func (f *File) readAtChunk(n int, off int64) (bs []byte, isEOF bool, err error) {
-	creditCharge, m, err := f.fs.loanCredit(n)
+// readAtChunk reads into b from off with a single READ request, which may return fewer bytes than len(b).
+// The data is placed directly into b by the receiver if possible. Otherwise, it's copied out of the response
+// so that its buffer is returned to the pool.
+func (f *File) readAtChunk(b []byte, off int64) (n int, isEOF bool, err error) {
+	fs := f.fs.channel()
+
+	creditCharge, m, err := fs.loanCredit(len(b))
 	defer func() {
 		if err != nil {
-			f.fs.chargeCredit(creditCharge)
+			fs.chargeCredit(creditCharge)
 		}
 	}()
 	if err != nil {
-		return nil, false, err
+		return 0, false, err
+	}
+
+	flags := f.readFlags
+	if len(fs.compressionIds) != 0 {
+		flags |= SMB2_READFLAG_REQUEST_COMPRESSED
 	}
 
 	req := &ReadRequest{
 		Padding:         0,
-		Flags:           0,
+		Flags:           flags,
 		Length:          uint32(m),
 		Offset:          uint64(off),
 		MinimumCount:    1, // for returning EOF
@@ -1249,41 +2240,110 @@ func (f *File) readAtChunk(n int, off int64) (bs []byte, isEOF bool, err error)
 
 	req.CreditCharge = creditCharge
 
-	res, err := f.sendRecv(SMB2_READ, req)
+	res, rr, err := fs.sendRecvInto(SMB2_READ, req, b[:m])
 	if err != nil {
-		return nil, false, err
+		return 0, false, err
 	}
+	defer rr.release()
 
 	r := ReadResponseDecoder(res)
+
+	if rr.direct {
+		n = int(r.DataLength())
+
+		return n, n < m, nil
+	}
+
 	if r.IsInvalid() {
-		return nil, false, &InvalidResponseError{"broken read response format"}
+		return 0, false, &InvalidResponseError{"broken read response format"}
 	}
 
-	bs = r.Data()
+	n = copy(b, r.Data())
 
-	return bs, len(bs) < m, nil
+	return n, n < m, nil
 }
 
+// Readdir reads the contents of the directory and returns a slice of up to n FileInfo values,
+// like os.File.Readdir.
+//
+// If an error occurs in the middle of enumeration, Readdir returns the entries gathered
+// before the error together with it, so that callers can process partial results.
+// The returned entries are consumed; calling Readdir again resumes the enumeration
+// from the request that failed.
 func (f *File) Readdir(n int) (fi []os.FileInfo, err error) {
 	f.m.Lock()
 	defer f.m.Unlock()
 
+	fi, err = f.readdirn("*", n)
+	if err != nil && err != io.EOF {
+		return fi, &os.PathError{Op: "readdir", Path: f.name, Err: err}
+	}
+	return fi, err
+}
+
+// ReaddirPattern is like Readdir but only returns the entries whose names match pattern,
+// which is evaluated by the server with the wildcards '*' and '?' ([MS-FSA] 2.1.4.4).
+// Entries are fetched in batches of about n entries, so that huge directories can be read
+// without buffering them, and io.EOF is returned at the end of the directory.
+// Calling ReaddirPattern or Readdir with another pattern restarts the enumeration.
+func (f *File) ReaddirPattern(pattern string, n int) (fi []os.FileInfo, err error) {
+	if pattern == "" {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	fi, err = f.readdirn(pattern, n)
+	if err != nil && err != io.EOF {
+		return fi, &os.PathError{Op: "readdir", Path: f.name, Err: err}
+	}
+	return fi, err
+}
+
+func (f *File) readdirn(pattern string, n int) (fi []os.FileInfo, err error) {
+	var flags uint8
+
+	// the pattern of an enumeration is fixed by its first request, it can only be changed by restarting it.
+	if f.dirPattern != "" && f.dirPattern != pattern {
+		f.dirents = nil
+		f.noMoreFiles = false
+		flags = RESTART_SCANS
+	}
+
 	if !f.noMoreFiles {
 		if f.dirents == nil {
 			f.dirents = []os.FileInfo{}
 		}
 		for n <= 0 || n > len(f.dirents) {
-			dirents, err := f.readdir("*")
+			dirents, err := f.readdir(pattern, flags, n-len(f.dirents))
 			if len(dirents) > 0 {
 				f.dirents = append(f.dirents, dirents...)
 			}
 			if err != nil {
-				if err, ok := err.(*ResponseError); ok && NtStatus(err.Code) == STATUS_NO_MORE_FILES {
-					f.noMoreFiles = true
+				if err, ok := err.(*ResponseError); ok {
+					switch NtStatus(err.Code) {
+					case STATUS_NO_MORE_FILES, STATUS_NO_SUCH_FILE: // nothing matches pattern
+						f.dirPattern = pattern
+						f.noMoreFiles = true
+					case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED:
+						// file ids aren't supported, e.g. by FAT volumes.
+						if f.dirInfoClass == 0 {
+							f.dirInfoClass = FileDirectoryInformation
+							continue
+						}
+					}
+				}
+				if f.noMoreFiles {
 					break
 				}
-				return nil, &os.PathError{Op: "readdir", Path: f.name, Err: err}
+				// return the entries gathered so far, like os.File.Readdir on a short read.
+				fi = f.dirents
+				f.dirents = []os.FileInfo{}
+				return fi, err
 			}
+			f.dirPattern = pattern
+			flags = 0
 		}
 	}
 
@@ -1311,9 +2371,6 @@ func (f *File) Readdir(n int) (fi []os.FileInfo, err error) {
 
 func (f *File) Readdirnames(n int) (names []string, err error) {
 	fi, err := f.Readdir(n)
-	if err != nil {
-		return nil, err
-	}
 
 	names = make([]string, len(fi))
 
@@ -1321,7 +2378,7 @@ func (f *File) Readdirnames(n int) (names []string, err error) {
 		names[i] = st.Name()
 	}
 
-	return names, nil
+	return names, err
 }
 
 // Seek implements io.Seeker.
@@ -1378,24 +2435,11 @@ func (f *File) Stat() (os.FileInfo, error) {
 }
 
 func (f *File) stat() (os.FileInfo, error) {
-	req := &QueryInfoRequest{
-		InfoType:              SMB2_0_INFO_FILE,
-		FileInfoClass:         FileAllInformation,
-		AdditionalInformation: 0,
-		Flags:                 0,
-		OutputBufferLength:    uint32(f.maxTransactSize()),
-	}
-
-	infoBytes, err := f.queryInfo(req)
+	info, err := f.queryAllInformation()
 	if err != nil {
 		return nil, err
 	}
 
-	info := FileAllInformationDecoder(infoBytes)
-	if info.IsInvalid() {
-		return nil, &InvalidResponseError{"broken query info response format"}
-	}
-
 	basic := info.BasicInformation()
 	std := info.StandardInformation()
 
@@ -1408,84 +2452,802 @@ func (f *File) stat() (os.FileInfo, error) {
 		AllocationSize: std.AllocationSize(),
 		FileAttributes: basic.FileAttributes(),
 		FileName:       base(f.name),
+		NumberOfLinks:  std.NumberOfLinks(),
+		DeletePending:  std.DeletePending() != 0,
+		Directory:      std.Directory() != 0,
+		IndexNumber:    info.InternalInformation().IndexNumber(),
 	}, nil
 }
 
-func (f *File) Statfs() (FileFsInfo, error) {
-	fi, err := f.statfs()
+// StatAll returns all the metadata of the file FileAllInformation provides in a single request.
+func (f *File) StatAll() (*FileAllInfo, error) {
+	info, err := f.queryAllInformation()
 	if err != nil {
-		return nil, &os.PathError{Op: "statfs", Path: f.name, Err: err}
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: err}
 	}
+
+	fi, err := newFileAllInfo(info)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+
 	return fi, nil
 }
 
-type FileFsInfo interface {
-	BlockSize() uint64
-	FragmentSize() uint64
-	TotalBlockCount() uint64
-	FreeBlockCount() uint64
-	AvailableBlockCount() uint64
-}
+func (f *File) queryAllInformation() (FileAllInformationDecoder, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileAllInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    uint32(f.maxTransactSize()),
+	}
 
-type fileFsFullSizeInformation struct {
-	TotalAllocationUnits           int64
-	CallerAvailableAllocationUnits int64
-	ActualAvailableAllocationUnits int64
-	SectorsPerAllocationUnit       uint32
-	BytesPerSector                 uint32
-}
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		return nil, err
+	}
 
-func (fi *fileFsFullSizeInformation) BlockSize() uint64 {
-	return uint64(fi.BytesPerSector)
-}
+	info := FileAllInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
 
-func (fi *fileFsFullSizeInformation) FragmentSize() uint64 {
-	return uint64(fi.SectorsPerAllocationUnit)
+	return info, nil
 }
 
-func (fi *fileFsFullSizeInformation) TotalBlockCount() uint64 {
-	return uint64(fi.TotalAllocationUnits)
+// FileAllInfo is the metadata of a file returned by File.StatAll, as the information classes
+// FileAllInformation is made of. ([MS-FSCC] 2.4.2)
+type FileAllInfo struct {
+	// FileBasicInformation
+	CreationTime   time.Time
+	LastAccessTime time.Time
+	LastWriteTime  time.Time
+	ChangeTime     time.Time
+	FileAttributes uint32
+
+	// FileStandardInformation
+	AllocationSize int64
+	EndOfFile      int64
+	NumberOfLinks  uint32
+	DeletePending  bool
+	Directory      bool
+
+	IndexNumber          int64  // FileInternalInformation: file system specific file id, unique in the volume
+	EaSize               uint32 // FileEaInformation: size of the extended attributes
+	AccessFlags          uint32 // FileAccessInformation: access granted to the handle
+	CurrentByteOffset    int64  // FilePositionInformation: position of the handle, unrelated to the offset of File
+	Mode                 uint32 // FileModeInformation: FILE_WRITE_THROUGH, FILE_SEQUENTIAL_ONLY, etc.
+	AlignmentRequirement uint32 // FileAlignmentInformation: buffer alignment required by the device
+	FileName             string // FileNameInformation: path from the root of the volume, e.g. `\share\dir\file.txt`
 }
 
-func (fi *fileFsFullSizeInformation) FreeBlockCount() uint64 {
-	return uint64(fi.ActualAvailableAllocationUnits)
+func newFileAllInfo(info FileAllInformationDecoder) (*FileAllInfo, error) {
+	name := info.NameInformation()
+	if name.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	basic := info.BasicInformation()
+	std := info.StandardInformation()
+
+	return &FileAllInfo{
+		CreationTime:         time.Unix(0, basic.CreationTime().Nanoseconds()),
+		LastAccessTime:       time.Unix(0, basic.LastAccessTime().Nanoseconds()),
+		LastWriteTime:        time.Unix(0, basic.LastWriteTime().Nanoseconds()),
+		ChangeTime:           time.Unix(0, basic.ChangeTime().Nanoseconds()),
+		FileAttributes:       basic.FileAttributes(),
+		AllocationSize:       std.AllocationSize(),
+		EndOfFile:            std.EndOfFile(),
+		NumberOfLinks:        std.NumberOfLinks(),
+		DeletePending:        std.DeletePending() != 0,
+		Directory:            std.Directory() != 0,
+		IndexNumber:          info.InternalInformation().IndexNumber(),
+		EaSize:               info.EaInformation().EaSize(),
+		AccessFlags:          info.AccessInformation().AccessFlags(),
+		CurrentByteOffset:    info.PositionInformation().CurrentByteOffset(),
+		Mode:                 info.ModeInformation().Mode(),
+		AlignmentRequirement: info.AlignmentInformation().AlignmentRequirement(),
+		FileName:             name.FileName(),
+	}, nil
 }
 
-func (fi *fileFsFullSizeInformation) AvailableBlockCount() uint64 {
-	return uint64(fi.CallerAvailableAllocationUnits)
+// NormalizedName returns the server's canonical form of the file path relative to the share root.
+// It's useful to get the actual case of a path opened with different case.
+// If the server doesn't support FileNormalizedNameInformation, it returns ErrNotSupported.
+func (f *File) NormalizedName() (string, error) {
+	name, err := f.normalizedName()
+	if err != nil {
+		return "", &os.PathError{Op: "normalizedname", Path: f.name, Err: err}
+	}
+	return name, nil
 }
 
-func (f *File) statfs() (FileFsInfo, error) {
+func (f *File) normalizedName() (string, error) {
+	if f.fs.dialect < SMB311 {
+		return "", ErrNotSupported
+	}
+
 	req := &QueryInfoRequest{
-		InfoType:              SMB2_0_INFO_FILESYSTEM,
-		FileInfoClass:         FileFsFullSizeInformation,
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileNormalizedNameInformation,
 		AdditionalInformation: 0,
 		Flags:                 0,
-		OutputBufferLength:    32,
+		OutputBufferLength:    uint32(f.maxTransactSize()),
 	}
 
 	infoBytes, err := f.queryInfo(req)
 	if err != nil {
-		return nil, err
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				return "", ErrNotSupported
+			}
+		}
+		return "", err
 	}
 
-	info := FileFsFullSizeInformationDecoder(infoBytes)
+	info := FileNameInformationDecoder(infoBytes)
 	if info.IsInvalid() {
-		return nil, &InvalidResponseError{"broken query info response format"}
+		return "", &InvalidResponseError{"broken query info response format"}
 	}
 
-	return &fileFsFullSizeInformation{
-		TotalAllocationUnits:           info.TotalAllocationUnits(),
-		CallerAvailableAllocationUnits: info.CallerAvailableAllocationUnits(),
-		ActualAvailableAllocationUnits: info.ActualAvailableAllocationUnits(),
-		SectorsPerAllocationUnit:       info.SectorsPerAllocationUnit(),
-		BytesPerSector:                 info.BytesPerSector(),
-	}, nil
+	return info.FileName(), nil
 }
 
-func (f *File) Sync() (err error) {
-	req := new(FlushRequest)
-	req.FileId = f.fd
+// GrantedAccess returns the access mask granted by the server for this handle,
+// using FileAccessInformation. The bits are defined in [MS-SMB2] 2.2.13.1
+// (e.g. FILE_READ_DATA 0x1, FILE_WRITE_DATA 0x2, DELETE 0x10000).
+func (f *File) GrantedAccess() (uint32, error) {
+	access, err := f.grantedAccess()
+	if err != nil {
+		return 0, &os.PathError{Op: "grantedaccess", Path: f.name, Err: err}
+	}
+	return access, nil
+}
+
+func (f *File) grantedAccess() (uint32, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileAccessInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    4,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		return 0, err
+	}
+
+	info := FileAccessInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return 0, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return info.AccessFlags(), nil
+}
+
+// AlignmentInfo returns the buffer alignment requirement of the device backing the file,
+// using FileAlignmentInformation. The value is a mask, one less than the alignment in bytes
+// (e.g. 0 for byte alignment, 511 for 512-byte alignment): the offsets and lengths of
+// unbuffered I/O (see OpenOptions.Unbuffered) must have none of its bits set.
+// If the server doesn't support the query, it returns ErrNotSupported.
+func (f *File) AlignmentInfo() (uint32, error) {
+	mask, err := f.alignmentInfo()
+	if err != nil {
+		return 0, &os.PathError{Op: "alignmentinfo", Path: f.name, Err: err}
+	}
+	return mask, nil
+}
+
+func (f *File) alignmentInfo() (uint32, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileAlignmentInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    4,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				return 0, ErrNotSupported
+			}
+		}
+		return 0, err
+	}
+
+	info := FileAlignmentInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return 0, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return info.AlignmentRequirement(), nil
+}
+
+// ListHardlinks returns the paths of all hard links to the file, including the one used to open it,
+// using FileHardLinkInformation. The paths are relative to the root of the share.
+// Resolving the parent directories of the links requires FILE_OPEN_BY_FILE_ID.
+// If the server or the underlying file system doesn't support either, it returns ErrNotSupported.
+func (f *File) ListHardlinks() ([]string, error) {
+	names, err := f.listHardlinks()
+	if err != nil {
+		return nil, &os.PathError{Op: "listhardlinks", Path: f.name, Err: err}
+	}
+	return names, nil
+}
+
+func (f *File) listHardlinks() ([]string, error) {
+	infoBytes, err := f.queryInfoGrow(SMB2_0_INFO_FILE, FileHardLinkInformation, 0, 4096)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				return nil, ErrNotSupported
+			}
+		}
+		return nil, err
+	}
+
+	info := FileLinksInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	parents := make(map[string]string)
+
+	var names []string
+
+	entries := info.Entries()
+
+	for i := uint32(0); i < info.EntriesReturned(); i++ {
+		entry := FileLinkEntryInformationDecoder(entries)
+		if entry.IsInvalid() {
+			return nil, &InvalidResponseError{"broken file link entry information format"}
+		}
+
+		id := string(entry.ParentFileId())
+
+		parent, ok := parents[id]
+		if !ok {
+			parent, err = f.fs.pathByFileId(entry.ParentFileId())
+			if err != nil {
+				return nil, err
+			}
+			parents[id] = parent
+		}
+
+		if parent == "" {
+			names = append(names, entry.FileName())
+		} else {
+			names = append(names, parent+string(PathSeparator)+entry.FileName())
+		}
+
+		next := entry.NextEntryOffset()
+		if next == 0 {
+			break
+		}
+		if int(next) > len(entries) {
+			return nil, &InvalidResponseError{"broken file link entry information format"}
+		}
+
+		entries = entries[next:]
+	}
+
+	return names, nil
+}
+
+// queryInfoGrow queries the information of the type and class with a buffer of the initial size,
+// doubling it while the server reports that it's too small.
+func (f *File) queryInfoGrow(infoType, class uint8, additional uint32, size int) ([]byte, error) {
+	for {
+		if size > f.maxTransactSize() {
+			size = f.maxTransactSize()
+		}
+
+		req := &QueryInfoRequest{
+			InfoType:              infoType,
+			FileInfoClass:         class,
+			AdditionalInformation: additional,
+			Flags:                 0,
+			OutputBufferLength:    uint32(size),
+		}
+
+		infoBytes, err := f.queryInfo(req)
+		if err != nil {
+			if rerr, ok := err.(*ResponseError); ok {
+				switch NtStatus(rerr.Code) {
+				case STATUS_BUFFER_OVERFLOW, STATUS_BUFFER_TOO_SMALL, STATUS_INFO_LENGTH_MISMATCH:
+					if size < f.maxTransactSize() {
+						size *= 2
+						continue
+					}
+				}
+			}
+			return nil, err
+		}
+
+		return infoBytes, nil
+	}
+}
+
+// pathByFileId returns the path of the file identified by the file id (index number), relative to the root of the share.
+func (fs *Share) pathByFileId(id []byte) (string, error) {
+	req := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_READ_ATTRIBUTES,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_OPEN_BY_FILE_ID,
+		FileIdName:           id,
+	}
+
+	f, err := fs.createFile("", req, false)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				return "", ErrNotSupported
+			}
+		}
+		return "", err
+	}
+
+	req2 := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileAllInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    uint32(f.maxTransactSize()),
+	}
+
+	infoBytes, err := f.queryInfo(req2)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return "", err
+	}
+
+	info := FileAllInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return "", &InvalidResponseError{"broken query info response format"}
+	}
+
+	name := info.NameInformation()
+	if name.IsInvalid() {
+		return "", &InvalidResponseError{"broken query info response format"}
+	}
+
+	return strings.Trim(name.FileName(), `\`), nil
+}
+
+func (f *File) Statfs() (FileFsInfo, error) {
+	fi, err := f.statfs()
+	if err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: f.name, Err: err}
+	}
+	return fi, nil
+}
+
+// FileFsInfo describes the size and the free space of a volume, in the manner of statfs(2).
+// Blocks are allocation units of FragmentSize sectors of BlockSize bytes each, so the free space in bytes is
+// FreeBlockCount() * FragmentSize() * BlockSize(). AvailableBlockCount is limited by the quota of the user.
+type FileFsInfo interface {
+	BlockSize() uint64
+	FragmentSize() uint64
+	TotalBlockCount() uint64
+	FreeBlockCount() uint64
+	AvailableBlockCount() uint64
+}
+
+type fileFsFullSizeInformation struct {
+	TotalAllocationUnits           int64
+	CallerAvailableAllocationUnits int64
+	ActualAvailableAllocationUnits int64
+	SectorsPerAllocationUnit       uint32
+	BytesPerSector                 uint32
+}
+
+func (fi *fileFsFullSizeInformation) BlockSize() uint64 {
+	return uint64(fi.BytesPerSector)
+}
+
+func (fi *fileFsFullSizeInformation) FragmentSize() uint64 {
+	return uint64(fi.SectorsPerAllocationUnit)
+}
+
+func (fi *fileFsFullSizeInformation) TotalBlockCount() uint64 {
+	return uint64(fi.TotalAllocationUnits)
+}
+
+func (fi *fileFsFullSizeInformation) FreeBlockCount() uint64 {
+	return uint64(fi.ActualAvailableAllocationUnits)
+}
+
+func (fi *fileFsFullSizeInformation) AvailableBlockCount() uint64 {
+	return uint64(fi.CallerAvailableAllocationUnits)
+}
+
+func (f *File) statfs() (FileFsInfo, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILESYSTEM,
+		FileInfoClass:         FileFsFullSizeInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    32,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				// FileFsFullSizeInformation is optional, FileFsSizeInformation isn't.
+				return f.statfsSize()
+			}
+		}
+		return nil, err
+	}
+
+	info := FileFsFullSizeInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return &fileFsFullSizeInformation{
+		TotalAllocationUnits:           info.TotalAllocationUnits(),
+		CallerAvailableAllocationUnits: info.CallerAvailableAllocationUnits(),
+		ActualAvailableAllocationUnits: info.ActualAvailableAllocationUnits(),
+		SectorsPerAllocationUnit:       info.SectorsPerAllocationUnit(),
+		BytesPerSector:                 info.BytesPerSector(),
+	}, nil
+}
+
+func (f *File) statfsSize() (FileFsInfo, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILESYSTEM,
+		FileInfoClass:         FileFsSizeInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    24,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	info := FileFsSizeInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	// the quota of the caller isn't known, so everything is reported available.
+	return &fileFsFullSizeInformation{
+		TotalAllocationUnits:           info.TotalAllocationUnits(),
+		CallerAvailableAllocationUnits: info.AvailableAllocationUnits(),
+		ActualAvailableAllocationUnits: info.AvailableAllocationUnits(),
+		SectorsPerAllocationUnit:       info.SectorsPerAllocationUnit(),
+		BytesPerSector:                 info.BytesPerSector(),
+	}, nil
+}
+
+// SectorSizeInformation describes the sector sizes of the volume backing a share.
+// See [MS-FSCC] 2.5.8 for the meaning of the fields and flags.
+type SectorSizeInformation struct {
+	LogicalBytesPerSector                                 uint32
+	PhysicalBytesPerSectorForAtomicity                    uint32
+	PhysicalBytesPerSectorForPerformance                  uint32
+	FileSystemEffectivePhysicalBytesPerSectorForAtomicity uint32
+	Flags                                                 uint32
+	ByteOffsetForSectorAlignment                          uint32
+	ByteOffsetForPartitionAlignment                       uint32
+}
+
+// SectorSizeInfo returns the sector sizes of the volume backing the share, using FileFsSectorSizeInformation.
+// Reads and writes aligned to PhysicalBytesPerSectorForPerformance avoid read-modify-write cycles
+// on 4K-native volumes, which matters most for unbuffered I/O (see OpenOptions.Unbuffered).
+// If the server doesn't support the information class, it returns ErrNotSupported.
+func (fs *Share) SectorSizeInfo() (*SectorSizeInformation, error) {
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_READ_ATTRIBUTES,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_DIRECTORY_FILE,
+	}
+
+	f, err := fs.createFile("", create, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "sectorsizeinfo", Path: "", Err: err}
+	}
+
+	info, err := f.sectorSizeInfo()
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "sectorsizeinfo", Path: "", Err: err}
+	}
+	return info, nil
+}
+
+func (f *File) sectorSizeInfo() (*SectorSizeInformation, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILESYSTEM,
+		FileInfoClass:         FileFsSectorSizeInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    28,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_INVALID_INFO_CLASS, STATUS_NOT_SUPPORTED, STATUS_INVALID_PARAMETER:
+				return nil, ErrNotSupported
+			}
+		}
+		return nil, err
+	}
+
+	info := FileFsSectorSizeInformationDecoder(infoBytes)
+	if info.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return &SectorSizeInformation{
+		LogicalBytesPerSector:                                 info.LogicalBytesPerSector(),
+		PhysicalBytesPerSectorForAtomicity:                    info.PhysicalBytesPerSectorForAtomicity(),
+		PhysicalBytesPerSectorForPerformance:                  info.PhysicalBytesPerSectorForPerformance(),
+		FileSystemEffectivePhysicalBytesPerSectorForAtomicity: info.FileSystemEffectivePhysicalBytesPerSectorForAtomicity(),
+		Flags:                           info.Flags(),
+		ByteOffsetForSectorAlignment:    info.ByteOffsetForSectorAlignment(),
+		ByteOffsetForPartitionAlignment: info.ByteOffsetForPartitionAlignment(),
+	}, nil
+}
+
+// VolumeInformation describes the volume backing a share and its file system.
+type VolumeInformation struct {
+	Label        string // may be empty
+	SerialNumber uint32
+	CreationTime time.Time
+
+	FileSystemName       string // e.g. "NTFS"
+	FileSystemAttributes uint32 // FILE_* flags of [MS-FSCC] 2.5.1, e.g. 0x1 for FILE_CASE_SENSITIVE_SEARCH
+	MaxComponentLength   int    // maximum length of a file name, in UTF-16 code units
+}
+
+// VolumeInfo returns the label and serial number of the volume backing the share, using FileFsVolumeInformation,
+// and the name and capabilities of its file system, using FileFsAttributeInformation.
+// Use Statfs for the size and the free space of the volume.
+func (fs *Share) VolumeInfo() (*VolumeInformation, error) {
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_READ_ATTRIBUTES,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_DIRECTORY_FILE,
+	}
+
+	f, err := fs.createFile("", create, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "volumeinfo", Path: "", Err: err}
+	}
+
+	info, err := f.volumeInfo()
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "volumeinfo", Path: "", Err: err}
+	}
+	return info, nil
+}
+
+func (f *File) volumeInfo() (*VolumeInformation, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILESYSTEM,
+		FileInfoClass:         FileFsVolumeInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    18 + 2*32, // labels are up to 32 characters long
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	vol := FileFsVolumeInformationDecoder(infoBytes)
+	if vol.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	req = &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILESYSTEM,
+		FileInfoClass:         FileFsAttributeInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    12 + 2*256,
+	}
+
+	infoBytes, err = f.queryInfo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	attr := FileFsAttributeInformationDecoder(infoBytes)
+	if attr.IsInvalid() {
+		return nil, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return &VolumeInformation{
+		Label:                vol.VolumeLabel(),
+		SerialNumber:         vol.VolumeSerialNumber(),
+		CreationTime:         time.Unix(0, vol.VolumeCreationTime().Nanoseconds()),
+		FileSystemName:       attr.FileSystemName(),
+		FileSystemAttributes: attr.FileSystemAttributes(),
+		MaxComponentLength:   int(attr.MaximumComponentNameLength()),
+	}, nil
+}
+
+func (f *File) Sync() error {
+	err := f.fs.flush(f.fd)
+	if err != nil {
+		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (fs *Share) flush(fd *FileId) (err error) {
+	req := new(FlushRequest)
+	req.FileId = fd
+
+	req.CreditCharge, _, err = fs.loanCredit(0)
+	defer func() {
+		if err != nil {
+			fs.chargeCredit(req.CreditCharge)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	res, err := fs.sendRecv(SMB2_FLUSH, req)
+	if err != nil {
+		return err
+	}
+
+	r := FlushResponseDecoder(res)
+	if r.IsInvalid() {
+		return &InvalidResponseError{"broken flush response format"}
+	}
+
+	return nil
+}
+
+// Sync flushes all handles opened for writing on the share, like calling File.Sync for each of them.
+// Handles opened through any Share derived from the same Mount (e.g. by WithContext) are included,
+// as well as the ones opened with AccessMaximumAllowed, unless the server turns out not to grant them write access.
+// A flush denied on a handle opened with write access is reported like any other error.
+// It tries all handles even if some fail, and returns the first error.
+func (fs *Share) Sync() error {
+	var err error
+
+	for fd, h := range fs.writableHandles() {
+		e := fs.flush(fd)
+		if e != nil {
+			if rerr, ok := e.(*ResponseError); ok && NtStatus(rerr.Code) == STATUS_FILE_CLOSED {
+				// closed concurrently
+				continue
+			}
+			if e == os.ErrPermission && h.maximumAllowed {
+				// opened with MAXIMUM_ALLOWED, but not writable; there is nothing to flush.
+				continue
+			}
+			if err == nil {
+				err = &os.PathError{Op: "sync", Path: h.name, Err: e}
+			}
+		}
+	}
+
+	return err
+}
+
+// Lock places a byte-range lock on length bytes of the file starting at offset.
+// If exclusive is false, a shared lock is placed.
+// If the range conflicts with a lock held by another handle, it fails immediately with ErrLockNotGranted.
+func (f *File) Lock(offset, length uint64, exclusive bool) error {
+	l := &LockElement{
+		Offset: offset,
+		Length: length,
+		Flags:  lockFlags(exclusive) | SMB2_LOCKFLAG_FAIL_IMMEDIATELY,
+	}
+
+	err := f.lock(l, nil)
+	if err != nil {
+		return &os.PathError{Op: "lock", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// LockWait is like Lock but waits up to timeout for conflicting locks to be released.
+// If the lock isn't granted in time, the pending request is cancelled and ErrLockTimeout is returned.
+func (f *File) LockWait(offset, length uint64, exclusive bool, timeout time.Duration) error {
+	l := &LockElement{
+		Offset: offset,
+		Length: length,
+		Flags:  lockFlags(exclusive),
+	}
+
+	var cancel chan struct{}
+
+	if timeout <= 0 {
+		l.Flags |= SMB2_LOCKFLAG_FAIL_IMMEDIATELY
+	} else {
+		cancel = make(chan struct{})
+
+		t := time.AfterFunc(timeout, func() { close(cancel) })
+		defer t.Stop()
+	}
+
+	err := f.lock(l, cancel)
+	if err == ErrLockNotGranted && timeout <= 0 {
+		err = ErrLockTimeout
+	}
+	if err != nil {
+		return &os.PathError{Op: "lock", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// Unlock releases the byte-range lock placed on exactly the same range by Lock or LockWait.
+func (f *File) Unlock(offset, length uint64) error {
+	l := &LockElement{
+		Offset: offset,
+		Length: length,
+		Flags:  SMB2_LOCKFLAG_UNLOCK,
+	}
+
+	err := f.lock(l, nil)
+	if err != nil {
+		return &os.PathError{Op: "unlock", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func lockFlags(exclusive bool) uint32 {
+	if exclusive {
+		return SMB2_LOCKFLAG_EXCLUSIVE_LOCK
+	}
+	return SMB2_LOCKFLAG_SHARED_LOCK
+}
+
+func (f *File) lock(l *LockElement, cancel <-chan struct{}) (err error) {
+	req := &LockRequest{
+		Locks: []*LockElement{l},
+	}
+
+	req.FileId = f.fd
 
 	req.CreditCharge, _, err = f.fs.loanCredit(0)
 	defer func() {
@@ -1494,22 +3256,75 @@ func (f *File) Sync() (err error) {
 		}
 	}()
 	if err != nil {
-		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+		return err
 	}
 
-	res, err := f.sendRecv(SMB2_FLUSH, req)
+	rr, err := f.fs.send(req, f.fs.ctx)
 	if err != nil {
-		return &os.PathError{Op: "sync", Path: f.name, Err: err}
+		return err
 	}
 
-	r := FlushResponseDecoder(res)
+	pkt, err := f.fs.recvWithCancel(rr, cancel)
+	if err != nil {
+		return err
+	}
+
+	res, err := accept(SMB2_LOCK, pkt)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_LOCK_NOT_GRANTED, STATUS_FILE_LOCK_CONFLICT:
+				return ErrLockNotGranted
+			case STATUS_CANCELLED:
+				return ErrLockTimeout
+			}
+		}
+		return err
+	}
+
+	r := LockResponseDecoder(res)
 	if r.IsInvalid() {
-		return &os.PathError{Op: "sync", Path: f.name, Err: &InvalidResponseError{"broken flush response format"}}
+		return &InvalidResponseError{"broken lock response format"}
 	}
 
 	return nil
 }
 
+// Rename renames the file to newpath, which is relative to the share root, so that the file
+// can be moved to another directory of the share. If newpath exists, it's replaced if replaceIfExists is set,
+// as done to save a file atomically by writing a temporary file first. Otherwise Rename fails with an error
+// satisfying os.IsExist. The file must have been opened with DELETE access, see OpenOptions.DeleteAccess.
+// Name keeps returning the name the file was opened with.
+func (f *File) Rename(newpath string, replaceIfExists bool) error {
+	newpath, err := cleanPath("rename to", newpath)
+	if err != nil {
+		return err
+	}
+
+	err = f.rename(newpath, replaceIfExists)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: f.name, New: newpath, Err: err}
+	}
+	return nil
+}
+
+func (f *File) rename(newpath string, replaceIfExists bool) error {
+	info := &SetInfoRequest{
+		FileInfoClass:         FileRenameInformation,
+		AdditionalInformation: 0,
+		Input: &FileRenameInformationType2Encoder{
+			RootDirectory: 0,
+			FileName:      newpath,
+		},
+	}
+
+	if replaceIfExists {
+		info.Input.(*FileRenameInformationType2Encoder).ReplaceIfExists = 1
+	}
+
+	return f.setInfo(info)
+}
+
 func (f *File) Truncate(size int64) error {
 	if size < 0 {
 		return os.ErrInvalid
@@ -1538,6 +3353,72 @@ func (f *File) truncate(size int64) error {
 	return nil
 }
 
+// SetAllocationSize sets the disk space reserved for the file, rounded up to the cluster size by the server,
+// without changing its size. It preallocates space for files that are going to grow,
+// which avoids fragmentation. An allocation size below the size of the file truncates the file.
+func (f *File) SetAllocationSize(size int64) error {
+	if size < 0 {
+		return os.ErrInvalid
+	}
+
+	err := f.setAllocationSize(size)
+	if err != nil {
+		return &os.PathError{Op: "setallocationsize", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) setAllocationSize(size int64) error {
+	info := &SetInfoRequest{
+		FileInfoClass:         FileAllocationInformation,
+		AdditionalInformation: 0,
+		Input: &FileAllocationInformationEncoder{
+			AllocationSize: size,
+		},
+	}
+
+	return f.setInfo(info)
+}
+
+// SetTimes sets the creation, last access and last write times of the file.
+// Zero times are left unchanged, so that a single time can be set, e.g. to replicate the creation time,
+// which Share.Chtimes can't set. The change time is updated by the server.
+// Once a time is set, the server stops updating it for the writes through the same handle.
+func (f *File) SetTimes(creation, lastAccess, lastWrite time.Time) error {
+	err := f.setTimes(creation, lastAccess, lastWrite)
+	if err != nil {
+		return &os.PathError{Op: "settimes", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) setTimes(creation, lastAccess, lastWrite time.Time) error {
+	info := &SetInfoRequest{
+		FileInfoClass:         FileBasicInformation,
+		AdditionalInformation: 0,
+		Input: &FileBasicInformationEncoder{ // nil times are encoded as 0, which leaves them unchanged.
+			CreationTime:   filetimeOrNil(creation),
+			LastAccessTime: filetimeOrNil(lastAccess),
+			LastWriteTime:  filetimeOrNil(lastWrite),
+		},
+	}
+
+	return f.setInfo(info)
+}
+
+func filetimeOrNil(t time.Time) *Filetime {
+	if t.IsZero() {
+		return nil
+	}
+	return NsecToFiletime(t.UnixNano())
+}
+
+// Chmod changes the mode of the file to mode, like os.Chmod does on Windows:
+// only the owner write bit (0200) is used, and it clears or sets AttributeReadonly.
+// The other permission bits and the file mode bits are ignored, since SMB doesn't carry POSIX permissions
+// unless the SMB3 POSIX extensions are negotiated, which this package doesn't do.
+// Permissions can be changed with File.SetSecurityDescriptor instead.
+// FileStat.Mode maps the attribute back to 0444 or 0666.
 func (f *File) Chmod(mode os.FileMode) error {
 	err := f.chmod(mode)
 	if err != nil {
@@ -1573,6 +3454,60 @@ func (f *File) chmod(mode os.FileMode) error {
 		attrs |= FILE_ATTRIBUTE_READONLY
 	}
 
+	return f.setAttributes(attrs)
+}
+
+// Attributes returns the attributes of the file, like AttributeReadonly or AttributeHidden.
+func (f *File) Attributes() (FileAttributes, error) {
+	attrs, err := f.attributes()
+	if err != nil {
+		return 0, &os.PathError{Op: "attributes", Path: f.name, Err: err}
+	}
+	return attrs, nil
+}
+
+func (f *File) attributes() (FileAttributes, error) {
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileBasicInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    40,
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		return 0, err
+	}
+
+	base := FileBasicInformationDecoder(infoBytes)
+	if base.IsInvalid() {
+		return 0, &InvalidResponseError{"broken query info response format"}
+	}
+
+	return FileAttributes(base.FileAttributes()), nil
+}
+
+// SetAttributes replaces the attributes of the file by attrs, e.g. to hide a file or to make it read-only
+// on a Windows share. Zero clears all the attributes that can be set, like AttributeNormal does.
+// The attributes which can't be set by SET_INFO, like AttributeSparseFile, are ignored by the server,
+// except AttributeDirectory, which fails on files.
+func (f *File) SetAttributes(attrs FileAttributes) error {
+	err := f.setAttributes(uint32(attrs))
+	if err != nil {
+		return &os.PathError{Op: "setattributes", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) setAttributes(attrs uint32) error {
+	// zero leaves the attributes unchanged, no attribute is FILE_ATTRIBUTE_NORMAL, which is only valid alone.
+	if attrs&^FILE_ATTRIBUTE_NORMAL == 0 {
+		attrs = FILE_ATTRIBUTE_NORMAL
+	} else {
+		attrs &^= FILE_ATTRIBUTE_NORMAL
+	}
+
 	info := &SetInfoRequest{
 		FileInfoClass:         FileBasicInformation,
 		AdditionalInformation: 0,
@@ -1581,11 +3516,7 @@ func (f *File) chmod(mode os.FileMode) error {
 		},
 	}
 
-	err = f.setInfo(info)
-	if err != nil {
-		return err
-	}
-	return nil
+	return f.setInfo(info)
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
@@ -1630,34 +3561,102 @@ func (f *File) writeAt(b []byte, off int64) (n int, err error) {
 
 	maxWriteSize := f.maxWriteSize()
 
-	for {
-		switch {
-		case len(b)-n == 0:
-			return n, nil
-		case len(b)-n <= maxWriteSize:
-			m, err := f.writeAtChunk(b[n:], int64(n)+off)
-			if err != nil {
-				return -1, err
-			}
+	if window := f.fs.writeBehindWindow; window > 1 && len(b) > maxWriteSize {
+		return f.writeAtPipelined(b, off, maxWriteSize, window)
+	}
 
-			n += m
-		default:
-			m, err := f.writeAtChunk(b[n:n+maxWriteSize], int64(n)+off)
-			if err != nil {
-				return -1, err
+	return f.writeAtSerial(b, off, maxWriteSize)
+}
+
+// writeAtPipelined writes b in chunks of maxWriteSize with up to window chunks in flight.
+// No more chunks are sent once one of them fails. On error, n is the number of bytes written contiguously
+// from off, as with writeAtSerial, though some of the chunks following them may have been written as well.
+// If Dialer.AutoTune is set, the number of chunks in flight is adjusted within the window.
+func (f *File) writeAtPipelined(b []byte, off int64, maxWriteSize, window int) (n int, err error) {
+	type chunk struct {
+		n   int
+		err error
+	}
+
+	chunks := make([]chunk, (len(b)+maxWriteSize-1)/maxWriteSize)
+
+	t := f.fs.conn.newWindowTuner(maxWriteSize, window)
+	if t != nil {
+		window = t.resume(window)
+	}
+
+	// the limiter stops sending chunks once one of them failed.
+	l := newTunedLimiter(window, t)
+
+	var wg sync.WaitGroup
+
+	for i := range chunks {
+		if l.failed() {
+			break
+		}
+
+		i := i
+
+		l.do(&wg, func() error {
+			start := i * maxWriteSize
+			end := start + maxWriteSize
+			if end > len(b) {
+				end = len(b)
 			}
 
-			n += m
+			c := &chunks[i]
+
+			c.n, c.err = f.writeAtSerial(b[start:end], off+int64(start), maxWriteSize)
+
+			return c.err
+		})
+	}
+
+	wg.Wait()
+
+	for _, c := range chunks {
+		n += c.n
+
+		if c.err != nil {
+			return n, c.err
 		}
 	}
+
+	return n, nil
+}
+
+// writeAtSerial writes b in chunks of maxWriteSize, one at a time.
+func (f *File) writeAtSerial(b []byte, off int64, maxWriteSize int) (n int, err error) {
+	// Chunks are written one by one at explicit offsets, so that they are applied in order.
+	// On error, n is the number of bytes written contiguously from off.
+	for len(b)-n > 0 {
+		chunk := b[n:]
+		if len(chunk) > maxWriteSize {
+			chunk = chunk[:maxWriteSize]
+		}
+
+		m, err := f.writeAtChunk(chunk, int64(n)+off)
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+
+		n += m
+	}
+
+	return n, nil
 }
 
 // writeAt allows partial write
 func (f *File) writeAtChunk(b []byte, off int64) (n int, err error) {
-	creditCharge, m, err := f.fs.loanCredit(len(b))
+	fs := f.fs.channel()
+
+	creditCharge, m, err := fs.loanCredit(len(b))
 	defer func() {
 		if err != nil {
-			f.fs.chargeCredit(creditCharge)
+			fs.chargeCredit(creditCharge)
 		}
 	}()
 	if err != nil {
@@ -1677,7 +3676,7 @@ func (f *File) writeAtChunk(b []byte, off int64) (n int, err error) {
 
 	req.CreditCharge = creditCharge
 
-	res, err := f.sendRecv(SMB2_WRITE, req)
+	res, err := fs.sendRecv(SMB2_WRITE, req)
 	if err != nil {
 		return 0, err
 	}
@@ -1717,124 +3716,131 @@ func copyBuffer(r io.Reader, w io.Writer, buf []byte) (n int64, err error) {
 	return
 }
 
-func (f *File) copyTo(wf *File) (supported bool, n int64, err error) {
-	f.m.Lock()
-	defer f.m.Unlock()
+// RequestResiliency asks the server to keep the handle open for timeout after a disconnect,
+// so that it can be reclaimed by a reconnected session.
+// It's the alternative to durable handles for SMB 2.1 and later servers; the handle is reclaimed by Reconnect as well.
+// A timeout under a millisecond is rounded up to one millisecond.
+// If the server doesn't support resilient handles, it returns ErrNotSupported.
+func (f *File) RequestResiliency(timeout time.Duration) error {
+	err := f.requestResiliency(timeout)
+	if err != nil {
+		return &os.PathError{Op: "resiliency", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) requestResiliency(timeout time.Duration) error {
+	if f.fs.dialect < SMB210 {
+		return ErrNotSupported
+	}
+
+	ms, err := resiliencyTimeoutMs(timeout)
+	if err != nil {
+		return err
+	}
 
 	req := &IoctlRequest{
-		CtlCode:           FSCTL_SRV_REQUEST_RESUME_KEY,
+		CtlCode:           FSCTL_LMR_REQUEST_RESILIENCY,
 		OutputOffset:      0,
 		OutputCount:       0,
 		MaxInputResponse:  0,
-		MaxOutputResponse: 32,
+		MaxOutputResponse: 0,
 		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input: &NetworkResiliencyRequest{
+			Timeout: ms,
+		},
 	}
 
-	output, err := f.ioctl(req)
+	_, err = f.ioctl(req)
 	if err != nil {
-		if rerr, ok := err.(*ResponseError); ok && NtStatus(rerr.Code) == STATUS_NOT_SUPPORTED {
-			return false, -1, nil
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST:
+				return ErrNotSupported
+			}
 		}
+		return err
+	}
 
-		return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
+	f.m.Lock()
+	f.resiliencyTimeout = timeout
+	f.m.Unlock()
 
-	}
+	return nil
+}
 
-	sr := SrvRequestResumeKeyResponseDecoder(output)
-	if sr.IsInvalid() {
-		return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: &InvalidResponseError{"broken srv request resume key response format"}}
+// resiliencyTimeoutMs converts timeout to the milliseconds of NETWORK_RESILIENCY_REQUEST.
+// A timeout under a millisecond is rounded up, since zero asks for the default timeout of the server.
+func resiliencyTimeoutMs(timeout time.Duration) (uint32, error) {
+	ms := timeout / time.Millisecond
+	if timeout < 0 || ms > math.MaxUint32 {
+		return 0, os.ErrInvalid
 	}
-
-	off, err := f.seek(0, io.SeekCurrent)
-	if err != nil {
-		return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
+	if ms == 0 && timeout > 0 {
+		ms = 1
 	}
+	return uint32(ms), nil
+}
 
-	end, err := f.seek(0, io.SeekEnd)
+// SetEncryption turns EFS encryption of the file on or off using FSCTL_SET_ENCRYPTION.
+// If the server or the underlying file system doesn't support EFS, it returns ErrNotSupported.
+func (f *File) SetEncryption(on bool) error {
+	err := f.setEncryption(on)
 	if err != nil {
-		return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
+		return &os.PathError{Op: "setencryption", Path: f.name, Err: err}
 	}
+	return nil
+}
 
-	woff, err := wf.seek(0, io.SeekCurrent)
-	if err != nil {
-		return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
+func (f *File) setEncryption(on bool) error {
+	op := uint32(FILE_CLEAR_ENCRYPTION)
+	if on {
+		op = FILE_SET_ENCRYPTION
 	}
 
-	var chunks []*SrvCopychunk
-
-	remains := end
-
-	for {
-		const maxChunkSize = 1024 * 1024
-		const maxTotalSize = 16 * 1024 * 1024
-		// https://msdn.microsoft.com/en-us/library/cc512134(v=vs.85).aspx
-
-		if remains < maxTotalSize {
-			nchunks := remains / maxChunkSize
-
-			chunks = make([]*SrvCopychunk, nchunks, nchunks+1)
-			for i := range chunks {
-				chunks[i] = &SrvCopychunk{
-					SourceOffset: off + int64(i)*maxChunkSize,
-					TargetOffset: woff + int64(i)*maxChunkSize,
-					Length:       maxChunkSize,
-				}
-			}
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_SET_ENCRYPTION,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: 1, // DECRYPTION_STATUS_BUFFER
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input: &EncryptionBuffer{
+			EncryptionOperation: op,
+		},
+	}
 
-			remains %= maxChunkSize
-			if remains != 0 {
-				chunks = append(chunks, &SrvCopychunk{
-					SourceOffset: off + int64(nchunks)*maxChunkSize,
-					TargetOffset: woff + int64(nchunks)*maxChunkSize,
-					Length:       uint32(remains),
-				})
-				remains = 0
-			}
-		} else {
-			chunks = make([]*SrvCopychunk, 16)
-			for i := range chunks {
-				chunks[i] = &SrvCopychunk{
-					SourceOffset: off + int64(i)*maxChunkSize,
-					TargetOffset: woff + int64(i)*maxChunkSize,
-					Length:       maxChunkSize,
-				}
+	_, err := f.ioctl(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST, STATUS_NO_EFS:
+				return ErrNotSupported
 			}
-
-			remains -= maxTotalSize
-		}
-
-		scc := &SrvCopychunkCopy{
-			Chunks: chunks,
-		}
-
-		copy(scc.SourceKey[:], sr.ResumeKey())
-
-		cReq := &IoctlRequest{
-			CtlCode:           FSCTL_SRV_COPYCHUNK,
-			OutputOffset:      0,
-			OutputCount:       0,
-			MaxInputResponse:  0,
-			MaxOutputResponse: 24,
-			Flags:             SMB2_0_IOCTL_IS_FSCTL,
-			Input:             scc,
 		}
+		return err
+	}
 
-		output, err = wf.ioctl(cReq)
-		if err != nil {
-			return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
-		}
+	return nil
+}
 
-		c := SrvCopychunkResponseDecoder(output)
-		if c.IsInvalid() {
-			return true, -1, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: &InvalidResponseError{"broken srv copy chunk response format"}}
-		}
+// IsResilient reports whether the handle has been made resilient by RequestResiliency.
+func (f *File) IsResilient() bool {
+	f.m.Lock()
+	defer f.m.Unlock()
 
-		n += int64(c.TotalBytesWritten())
+	return f.resiliencyTimeout != 0
+}
 
-		if remains == 0 {
-			return true, n, nil
+func (f *File) copyTo(wf *File) (supported bool, n int64, err error) {
+	n, err = f.serverSideCopy(wf, f.fs.ctx)
+	if err != nil {
+		if err == ErrNotSupported {
+			return false, -1, nil
 		}
+		return true, n, &os.LinkError{Op: "copy", Old: f.name, New: wf.name, Err: err}
 	}
+	return true, n, nil
 }
 
 // ReadFrom implements io.ReadFrom.
@@ -1854,9 +3860,40 @@ func (f *File) ReadFrom(r io.Reader) (n int64, err error) {
 		return copyBuffer(r, f, make([]byte, maxBufferSize))
 	}
 
+	if window := f.fs.writeBehindWindow; window > 1 && isBulkReader(r) {
+		// each write fills the write-behind window, unless r runs out of data.
+		return copyBuffer(&fullReader{r}, f, make([]byte, f.maxWriteSize()*window))
+	}
+
 	return copyBuffer(r, f, make([]byte, f.maxWriteSize()))
 }
 
+// isBulkReader reports whether r has its data at hand, so that reading until a buffer is full doesn't
+// hold back data that a streaming reader, like a pipe or a network connection, has already delivered.
+func isBulkReader(r io.Reader) bool {
+	switch r := r.(type) {
+	case *bytes.Reader, *strings.Reader, *bytes.Buffer, *io.SectionReader, *File:
+		return true
+	case *os.File:
+		fi, err := r.Stat()
+		return err == nil && fi.Mode().IsRegular()
+	}
+	return false
+}
+
+// fullReader reads until its buffer is full, so that a short read of r doesn't make a short write.
+type fullReader struct {
+	r io.Reader
+}
+
+func (r *fullReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(r.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
 // WriteTo implements io.WriteTo.
 // If w is *File on the same *Share as f, it invokes server-side copy.
 func (f *File) WriteTo(w io.Writer) (n int64, err error) {
@@ -1874,7 +3911,13 @@ func (f *File) WriteTo(w io.Writer) (n int64, err error) {
 		return copyBuffer(f, w, make([]byte, maxBufferSize))
 	}
 
-	return copyBuffer(f, w, make([]byte, f.maxReadSize()))
+	// a buffer spanning the read-ahead window lets each read keep the window full.
+	bufferSize := f.maxReadSize()
+	if window := f.fs.readAheadWindow; window > 1 {
+		bufferSize *= window
+	}
+
+	return copyBuffer(f, w, make([]byte, bufferSize))
 }
 
 func (f *File) WriteString(s string) (n int, err error) {
@@ -1927,12 +3970,29 @@ func (f *File) ioctl(req *IoctlRequest) (output []byte, err error) {
 	return r.Output(), nil
 }
 
-func (f *File) readdir(pattern string) (fi []os.FileInfo, err error) {
+// readdir sends a QUERY_DIRECTORY request for about n entries, or as many as fit in a request if n <= 0.
+func (f *File) readdir(pattern string, flags uint8, n int) (fi []os.FileInfo, err error) {
+	class := f.dirInfoClass
+	if class == 0 {
+		class = FileIdFullDirectoryInformation
+	}
+
+	bufferSize := f.maxTransactSize()
+	if n == 1 {
+		flags |= RETURN_SINGLE_ENTRY
+	}
+	if n > 0 && n*clientDirEntrySizeHint < bufferSize {
+		bufferSize = n * clientDirEntrySizeHint
+		if bufferSize < clientMinDirBufferSize {
+			bufferSize = clientMinDirBufferSize
+		}
+	}
+
 	req := &QueryDirectoryRequest{
-		FileInfoClass:      FileDirectoryInformation,
-		Flags:              0,
+		FileInfoClass:      class,
+		Flags:              flags,
 		FileIndex:          0,
-		OutputBufferLength: uint32(f.maxTransactSize()),
+		OutputBufferLength: uint32(bufferSize),
 		FileName:           pattern,
 	}
 
@@ -1964,33 +4024,75 @@ func (f *File) readdir(pattern string) (fi []os.FileInfo, err error) {
 		return nil, &InvalidResponseError{"broken query directory response format"}
 	}
 
-	output := r.OutputBuffer()
+	return parseDirectoryEntries(req.FileInfoClass, r.OutputBuffer())
+}
 
+// parseDirectoryEntries decodes the entries of a QUERY_DIRECTORY response of class, skipping "." and "..".
+func parseDirectoryEntries(class uint8, output []byte) (fi []os.FileInfo, err error) {
 	for {
 		info := FileDirectoryInformationDecoder(output)
 		if info.IsInvalid() {
 			return nil, &InvalidResponseError{"broken query directory response format"}
 		}
 
-		name := info.FileName()
+		st := &FileStat{
+			CreationTime:   time.Unix(0, info.CreationTime().Nanoseconds()),
+			LastAccessTime: time.Unix(0, info.LastAccessTime().Nanoseconds()),
+			LastWriteTime:  time.Unix(0, info.LastWriteTime().Nanoseconds()),
+			ChangeTime:     time.Unix(0, info.ChangeTime().Nanoseconds()),
+			EndOfFile:      info.EndOfFile(),
+			AllocationSize: info.AllocationSize(),
+			FileAttributes: info.FileAttributes(),
+		}
+
+		switch class {
+		case FileDirectoryInformation:
+			st.FileName = info.FileName()
+		case FileFullDirectoryInformation:
+			info := FileFullDirectoryInformationDecoder(output)
+			if info.IsInvalid() {
+				return nil, &InvalidResponseError{"broken query directory response format"}
+			}
+			st.FileName = info.FileName()
+			st.EaSize = info.EaSize()
+		case FileIdFullDirectoryInformation:
+			info := FileIdFullDirectoryInformationDecoder(output)
+			if info.IsInvalid() {
+				return nil, &InvalidResponseError{"broken query directory response format"}
+			}
+			st.FileName = info.FileName()
+			st.EaSize = info.EaSize()
+			st.IndexNumber = info.FileId()
+		case FileBothDirectoryInformation:
+			info := FileBothDirectoryInformationDecoder(output)
+			if info.IsInvalid() {
+				return nil, &InvalidResponseError{"broken query directory response format"}
+			}
+			st.FileName = info.FileName()
+			st.EaSize = info.EaSize()
+			st.ShortName = info.ShortName()
+		case FileIdBothDirectoryInformation:
+			info := FileIdBothDirectoryInformationDecoder(output)
+			if info.IsInvalid() {
+				return nil, &InvalidResponseError{"broken query directory response format"}
+			}
+			st.FileName = info.FileName()
+			st.EaSize = info.EaSize()
+			st.ShortName = info.ShortName()
+			st.IndexNumber = info.FileId()
+		}
 
-		if name != "." && name != ".." {
-			fi = append(fi, &FileStat{
-				CreationTime:   time.Unix(0, info.CreationTime().Nanoseconds()),
-				LastAccessTime: time.Unix(0, info.LastAccessTime().Nanoseconds()),
-				LastWriteTime:  time.Unix(0, info.LastWriteTime().Nanoseconds()),
-				ChangeTime:     time.Unix(0, info.ChangeTime().Nanoseconds()),
-				EndOfFile:      info.EndOfFile(),
-				AllocationSize: info.AllocationSize(),
-				FileAttributes: info.FileAttributes(),
-				FileName:       name,
-			})
+		if st.FileName != "." && st.FileName != ".." {
+			fi = append(fi, st)
 		}
 
 		next := info.NextEntryOffset()
 		if next == 0 {
 			return fi, nil
 		}
+		if int(next) > len(output) {
+			return nil, &InvalidResponseError{"broken query directory response format"}
+		}
 
 		output = output[next:]
 	}
@@ -2050,7 +4152,9 @@ func (f *File) setInfo(req *SetInfoRequest) (err error) {
 
 	req.FileId = f.fd
 
-	req.InfoType = SMB2_0_INFO_FILE
+	if req.InfoType == 0 {
+		req.InfoType = SMB2_0_INFO_FILE
+	}
 
 	res, err := f.sendRecv(SMB2_SET_INFO, req)
 	if err != nil {
@@ -2069,6 +4173,14 @@ func (f *File) sendRecv(cmd uint16, req Packet) (res []byte, err error) {
 	return f.fs.sendRecv(cmd, req)
 }
 
+// FileStat implements os.FileInfo. Its Sys method returns the *FileStat itself,
+// so the SMB specific metadata can be accessed by fi.Sys().(*smb2.FileStat).
+//
+// NumberOfLinks, DeletePending, Directory and IndexNumber are only filled by File.Stat,
+// which queries FileAllInformation. They are zero in the results of Share.Stat, Share.Lstat
+// and directory listings, except for IndexNumber, which directory listings fill
+// if the server supports file ids (see DirectoryInfoClass). EaSize and ShortName are only filled
+// by directory listings with the classes that return them.
 type FileStat struct {
 	CreationTime   time.Time
 	LastAccessTime time.Time
@@ -2078,6 +4190,13 @@ type FileStat struct {
 	AllocationSize int64
 	FileAttributes uint32
 	FileName       string
+
+	NumberOfLinks uint32 // number of hard links
+	DeletePending bool   // the file is going to be deleted when the last handle is closed
+	Directory     bool   // the file is a directory
+	IndexNumber   int64  // file system specific file id, unique in the volume
+	EaSize        uint32 // size of the extended attributes
+	ShortName     string // 8.3 short name, if the file has one
 }
 
 func (fs *FileStat) Name() string {
@@ -2108,6 +4227,12 @@ func (fs *FileStat) Mode() os.FileMode {
 	return m
 }
 
+// IsEncrypted reports whether the file is encrypted by EFS.
+// The content of such a file is returned in plaintext only to users who have the key.
+func (fs *FileStat) IsEncrypted() bool {
+	return fs.FileAttributes&FILE_ATTRIBUTE_ENCRYPTED != 0
+}
+
 func (fs *FileStat) ModTime() time.Time {
 	return fs.LastWriteTime
 }
@@ -4,6 +4,18 @@ package smb2
 
 import (
 	"io/fs"
+	"sort"
+	"strings"
+)
+
+var (
+	_ fs.FS         = (*wfs)(nil)
+	_ fs.StatFS     = (*wfs)(nil)
+	_ fs.ReadFileFS = (*wfs)(nil)
+	_ fs.ReadDirFS  = (*wfs)(nil)
+	_ fs.GlobFS     = (*wfs)(nil)
+
+	_ fs.ReadDirFile = (*wfile)(nil)
 )
 
 type wfs struct {
@@ -11,6 +23,10 @@ type wfs struct {
 	share *Share
 }
 
+// DirFS returns a file system for the tree of files rooted at the directory dirname of the share.
+// It implements fs.FS, fs.StatFS, fs.ReadFileFS, fs.ReadDirFS and fs.GlobFS.
+// Names follow the conventions of io/fs, i.e. they are slash-separated and unrooted, and "." is dirname itself.
+// Use DirFS("") to get the whole share.
 func (s *Share) DirFS(dirname string) fs.FS {
 	return &wfs{
 		root:  normPath(dirname),
@@ -18,58 +34,124 @@ func (s *Share) DirFS(dirname string) fs.FS {
 	}
 }
 
-func (fs *wfs) path(name string) string {
-	name = normPath(name)
+// FS returns the share as an fs.FS. It is the same as DirFS("").
+func (s *Share) FS() fs.FS {
+	return s.DirFS("")
+}
+
+// validPath reports whether name is a valid fs.FS name.
+// Backslashes are rejected since they'd be taken as separators by the server.
+func validPath(name string) bool {
+	return fs.ValidPath(name) && !strings.ContainsRune(name, '\\')
+}
 
-	if fs.root != "" {
+// path translates the valid fs.FS name to a path on the share.
+// Slashes are translated even if NORMALIZE_PATH is false.
+func (fsys *wfs) path(name string) string {
+	if name == "." {
+		name = ""
+	}
+
+	name = strings.Replace(name, "/", `\`, -1)
+
+	if fsys.root != "" {
 		if name != "" {
-			name = fs.root + "\\" + name
+			name = fsys.root + "\\" + name
 		} else {
-			name = fs.root
+			name = fsys.root
 		}
 	}
 
 	return name
 }
 
-func (fs *wfs) pattern(pattern string) string {
-	pattern = normPattern(pattern)
+func (fsys *wfs) pattern(pattern string) string {
+	pattern = strings.Replace(pattern, "/", `\`, -1)
 
-	if fs.root != "" {
-		pattern = fs.root + "\\" + pattern
+	if fsys.root != "" {
+		pattern = fsys.root + "\\" + pattern
 	}
 
 	return pattern
 }
 
-func (fs *wfs) Open(name string) (fs.File, error) {
-	file, err := fs.share.Open(fs.path(name))
+// pathError reports err for name rather than the path on the share.
+func pathError(op, name string, err error) error {
+	if perr, ok := err.(*fs.PathError); ok {
+		err = perr.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *wfs) Open(name string) (fs.File, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	file, err := fsys.share.Open(fsys.path(name))
 	if err != nil {
-		return nil, err
+		return nil, pathError("open", name, err)
 	}
 	return &wfile{file}, nil
 }
 
-func (fs *wfs) Stat(name string) (fs.FileInfo, error) {
-	return fs.share.Stat(fs.path(name))
+func (fsys *wfs) Stat(name string) (fs.FileInfo, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	fi, err := fsys.share.Stat(fsys.path(name))
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return fi, nil
 }
 
-func (fs *wfs) ReadFile(name string) ([]byte, error) {
-	return fs.share.ReadFile(fs.path(name))
+func (fsys *wfs) ReadFile(name string) ([]byte, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	bs, err := fsys.share.ReadFile(fsys.path(name))
+	if err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	return bs, nil
 }
 
-func (fs *wfs) Glob(pattern string) (matches []string, err error) {
-	matches, err = fs.share.Glob(fs.pattern(pattern))
+// ReadDir reads the directory name and returns its entries sorted by name.
+func (fsys *wfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	infos, err := fsys.share.ReadDir(fsys.path(name))
 	if err != nil {
-		return nil, err
+		return nil, pathError("readdir", name, err)
 	}
 
-	if fs.root == "" {
-		return matches, nil
+	dirents := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		dirents[i] = fileInfoToDirEntry(info)
+	}
+
+	sort.Slice(dirents, func(i, j int) bool { return dirents[i].Name() < dirents[j].Name() })
+
+	return dirents, nil
+}
+
+// Glob returns the names matching pattern, which are slash-separated like the pattern.
+func (fsys *wfs) Glob(pattern string) (matches []string, err error) {
+	matches, err = fsys.share.Glob(fsys.pattern(pattern))
+	if err != nil {
+		return nil, err
 	}
 
 	for i, match := range matches {
-		matches[i] = match[len(fs.root)+1:]
+		if fsys.root != "" {
+			match = match[len(fsys.root)+1:]
+		}
+		matches[i] = strings.Replace(match, `\`, "/", -1)
 	}
 
 	return matches, nil
@@ -109,12 +191,9 @@ type wfile struct {
 
 func (f *wfile) ReadDir(n int) (dirents []fs.DirEntry, err error) {
 	infos, err := f.Readdir(n)
-	if err != nil {
-		return nil, err
-	}
 	dirents = make([]fs.DirEntry, len(infos))
 	for i, info := range infos {
 		dirents[i] = fileInfoToDirEntry(info)
 	}
-	return dirents, nil
+	return dirents, err
 }
@@ -2,7 +2,22 @@ package smb2
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
 )
 
 type partialReader struct {
@@ -36,3 +51,946 @@ func TestCopyBufferPartialRead(t *testing.T) {
 		t.Fatal("data not equal")
 	}
 }
+
+// testFileServer serves a file over a connection. READ requests are answered in order, unless asyncReads is set.
+// WRITE requests are answered concurrently, so that their responses arrive out of order,
+// and writes at failOffset fail with STATUS_DISK_FULL. Reads at failOffset fail with STATUS_UNEXPECTED_IO_ERROR.
+// The responses are signed by signer, if any.
+type testFileServer struct {
+	conn       net.Conn
+	failOffset int64
+	signer     *session
+	asyncReads bool // answer READ requests like WRITE requests, later chunks first
+	stallReads bool // don't answer READ requests
+	busy       int  // number of READ requests failed with STATUS_INSUFF_SERVER_RESOURCES before the others are served
+
+	listing       [][]string // names returned by successive QUERY_DIRECTORY requests
+	listingStatus NtStatus   // status of the QUERY_DIRECTORY requests once listing is exhausted
+
+	flushStatus NtStatus // status of the FLUSH requests
+
+	m           sync.Mutex
+	data        []byte
+	inflight    int
+	maxInflight int
+	reads       []PacketHeader // headers of the READ requests received, in order
+	echoes      []PacketHeader // headers of the ECHO requests received, in order
+
+	wm  sync.Mutex // serializes the responses
+	out []byte     // buffer of the responses
+}
+
+func (srv *testFileServer) serve() {
+	var size [4]byte
+
+	for {
+		if _, err := io.ReadFull(srv.conn, size[:]); err != nil {
+			return
+		}
+		pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(srv.conn, pkt); err != nil {
+			return
+		}
+
+		switch PacketCodec(pkt).Command() {
+		case SMB2_READ:
+			srv.m.Lock()
+			stall := srv.stallReads
+			srv.m.Unlock()
+			if stall {
+				continue
+			}
+			if srv.asyncReads {
+				go srv.handleRead(pkt)
+			} else {
+				srv.handleRead(pkt)
+			}
+		case SMB2_WRITE:
+			go srv.handleWrite(pkt)
+		case SMB2_ECHO:
+			p := PacketCodec(pkt)
+			srv.m.Lock()
+			srv.echoes = append(srv.echoes, PacketHeader{CreditCharge: p.CreditCharge(), MessageId: p.MessageId()})
+			srv.m.Unlock()
+			srv.respond(pkt, new(EchoResponse))
+		case SMB2_LOGOFF:
+			srv.respond(pkt, new(LogoffResponse))
+		case SMB2_FLUSH:
+			if srv.flushStatus != STATUS_SUCCESS {
+				srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(srv.flushStatus)}})
+			} else {
+				srv.respond(pkt, new(FlushResponse))
+			}
+		case SMB2_QUERY_DIRECTORY:
+			srv.handleQueryDirectory(pkt)
+		case SMB2_CHANGE_NOTIFY:
+			// nothing changes; the request stays pending.
+			srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{
+				Status:  uint32(STATUS_PENDING),
+				Flags:   SMB2_FLAGS_ASYNC_COMMAND,
+				AsyncId: PacketCodec(pkt).MessageId() + 1,
+			}})
+		}
+	}
+}
+
+func (srv *testFileServer) respond(req []byte, res Packet) {
+	p := PacketCodec(req)
+
+	hdr := res.Header()
+	hdr.Command = p.Command()
+	hdr.CreditCharge = p.CreditCharge()
+	hdr.CreditRequestResponse = p.CreditRequest()
+	hdr.Flags |= SMB2_FLAGS_SERVER_TO_REDIR
+	hdr.MessageId = p.MessageId()
+	if hdr.Flags&SMB2_FLAGS_ASYNC_COMMAND == 0 {
+		hdr.TreeId = p.TreeId()
+	}
+	hdr.SessionId = p.SessionId()
+
+	srv.wm.Lock()
+	defer srv.wm.Unlock()
+
+	if len(srv.out) < 4+res.Size() {
+		srv.out = make([]byte, 4+res.Size())
+	}
+	out := srv.out[:4+res.Size()]
+	for i := range out {
+		out[i] = 0 // encoders expect zeroed buffers
+	}
+	binary.BigEndian.PutUint32(out[:4], uint32(res.Size()))
+	res.Encode(out[4:])
+
+	if srv.signer != nil {
+		srv.signer.sign(out[4:])
+	}
+
+	srv.conn.Write(out)
+}
+
+func (srv *testFileServer) handleRead(pkt []byte) {
+	r := ReadRequestDecoder(PacketCodec(pkt).Data())
+
+	off := int(r.Offset())
+
+	p := PacketCodec(pkt)
+
+	srv.m.Lock()
+	srv.reads = append(srv.reads, PacketHeader{CreditCharge: p.CreditCharge(), CreditRequestResponse: p.CreditRequest(), MessageId: p.MessageId()})
+	busy := srv.busy > 0
+	if busy {
+		srv.busy--
+	}
+	srv.m.Unlock()
+
+	if busy {
+		srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(STATUS_INSUFF_SERVER_RESOURCES)}})
+		return
+	}
+
+	if srv.asyncReads {
+		srv.m.Lock()
+		srv.inflight++
+		if srv.inflight > srv.maxInflight {
+			srv.maxInflight = srv.inflight
+		}
+		srv.m.Unlock()
+
+		time.Sleep(time.Duration(20000-off) * time.Microsecond / 10)
+
+		srv.m.Lock()
+		srv.inflight--
+		srv.m.Unlock()
+	}
+
+	if int64(off) == srv.failOffset {
+		srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(STATUS_UNEXPECTED_IO_ERROR)}})
+		return
+	}
+
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if off >= len(srv.data) {
+		srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(STATUS_END_OF_FILE)}})
+		return
+	}
+
+	end := off + int(r.Length())
+	if end > len(srv.data) {
+		end = len(srv.data)
+	}
+
+	srv.respond(pkt, &ReadResponse{Data: srv.data[off:end]})
+}
+
+func (srv *testFileServer) handleQueryDirectory(pkt []byte) {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if len(srv.listing) == 0 {
+		srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(srv.listingStatus)}})
+		return
+	}
+
+	names := srv.listing[0]
+	srv.listing = srv.listing[1:]
+
+	srv.respond(pkt, &QueryDirectoryResponse{Output: Bytes(encodeDirEntries(names))})
+}
+
+// encodeDirEntries returns a list of FILE_ID_FULL_DIR_INFORMATION of the given names.
+func encodeDirEntries(names []string) []byte {
+	le := binary.LittleEndian
+
+	var output []byte
+
+	for i, name := range names {
+		u := utf16le.EncodeStringToBytes(name)
+		entry := make([]byte, (80+len(u)+7)&^7)
+		if i < len(names)-1 {
+			le.PutUint32(entry[:4], uint32(len(entry))) // NextEntryOffset
+		}
+		le.PutUint32(entry[60:64], uint32(len(u)))
+		copy(entry[80:], u)
+		output = append(output, entry...)
+	}
+
+	return output
+}
+
+func (srv *testFileServer) handleWrite(pkt []byte) {
+	r := WriteRequestDecoder(PacketCodec(pkt).Data())
+
+	data := pkt[r.DataOffset() : int(r.DataOffset())+int(r.Length())]
+	off := int64(r.Offset())
+
+	srv.m.Lock()
+	srv.inflight++
+	if srv.inflight > srv.maxInflight {
+		srv.maxInflight = srv.inflight
+	}
+	srv.m.Unlock()
+
+	// later chunks are answered first.
+	time.Sleep(time.Duration(20000-off) * time.Microsecond / 10)
+
+	srv.m.Lock()
+	srv.inflight--
+	srv.m.Unlock()
+
+	if off == srv.failOffset {
+		srv.respond(pkt, &ErrorResponse{PacketHeader: PacketHeader{Status: uint32(STATUS_DISK_FULL)}})
+		return
+	}
+
+	srv.m.Lock()
+	if end := off + int64(len(data)); end > int64(len(srv.data)) {
+		srv.data = append(srv.data, make([]byte, int(end)-len(srv.data))...)
+	}
+	copy(srv.data[off:], data)
+	srv.m.Unlock()
+
+	srv.respond(pkt, &WriteResponse{Count: uint32(len(data))})
+}
+
+// newTestFile returns a file on a connection to a testFileServer. Closing the server closes the connection.
+func newTestFile(window int, failOffset int64) (*File, *testFileServer) {
+	return newTestFileWithTimeout(window, failOffset, 0)
+}
+
+// newTestFileWithTimeout is like newTestFile, but the connection has a read timeout. (See Dialer.ReadTimeout)
+func newTestFileWithTimeout(window int, failOffset int64, readTimeout time.Duration) (*File, *testFileServer) {
+	client, server := net.Pipe()
+
+	srv := &testFileServer{conn: server, failOffset: failOffset}
+	go srv.serve()
+
+	a := openAccount(16)
+	for len(a.balance) < cap(a.balance) {
+		a.balance <- struct{}{} // as granted by NEGOTIATE and SESSION_SETUP
+	}
+
+	c := &conn{
+		t:                   direct(newDeadlineConn(client, readTimeout, 0)),
+		outstandingRequests: newOutstandingRequests(),
+		account:             a,
+		recvBufferSize:      4096,
+		maxReadSize:         64 * 1024,
+		maxWriteSize:        1000,
+		writeBehindWindow:   window,
+		rdone:               make(chan struct{}, 1),
+		wdone:               make(chan struct{}, 1),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+	}
+
+	s := &session{
+		conn:         c,
+		sessionId:    1,
+		sessionFlags: SMB2_SESSION_FLAG_IS_GUEST,
+	}
+
+	c.session = s
+	c.enableSession()
+
+	go c.runSender()
+	go c.runReciever()
+
+	fs := &Share{treeConn: &treeConn{session: s, treeId: 1}, ctx: context.Background()}
+
+	return &File{fs: fs, fd: &FileId{}, name: "test"}, srv
+}
+
+func TestWriteBehindWindow(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	f, srv := newTestFile(4, -1)
+	defer srv.conn.Close()
+
+	n, err := f.WriteAt(data, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) {
+		t.Errorf("expected %d bytes written, got %d", len(data), n)
+	}
+	if !bytes.Equal(srv.data[500:], data) {
+		t.Error("unexpected content")
+	}
+	if srv.maxInflight < 2 || srv.maxInflight > 4 {
+		t.Errorf("expected up to 4 writes in flight, got %d", srv.maxInflight)
+	}
+
+	// ReadFrom fills the window with the data of a bulk reader.
+	f, srv = newTestFile(4, -1)
+	defer srv.conn.Close()
+
+	m, err := f.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != int64(len(data)) || !bytes.Equal(srv.data, data) {
+		t.Errorf("unexpected content: %d bytes", m)
+	}
+	if srv.maxInflight < 2 {
+		t.Errorf("expected writes in flight, got %d", srv.maxInflight)
+	}
+
+	// the data of a streaming reader is written as it arrives.
+	f, srv = newTestFile(4, -1)
+	defer srv.conn.Close()
+
+	pr, pw := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.ReadFrom(pr)
+		done <- err
+	}()
+
+	pw.Write(data[:100])
+
+	for i := 0; ; i++ {
+		srv.m.Lock()
+		written := len(srv.data)
+		srv.m.Unlock()
+
+		if written == 100 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the data read so far isn't written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pw.Write(data[100:])
+	pw.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(srv.data, data) {
+		t.Error("unexpected content")
+	}
+
+	// the error is reported with the bytes written contiguously before the failed chunk.
+	f, srv = newTestFile(4, 500+3000)
+	defer srv.conn.Close()
+
+	n, err = f.WriteAt(data, 500)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n != 3000 {
+		t.Errorf("expected 3000 bytes written, got %d", n)
+	}
+	if err, ok := err.(*os.PathError); !ok || !err.Err.(*ResponseError).Is(ErrDiskFull) {
+		t.Errorf("expected disk full, got %v", err)
+	}
+}
+
+func TestReadAtPipelined(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	newFile := func(failOffset int64) (*File, *testFileServer) {
+		f, srv := newTestFile(0, failOffset)
+		f.fs.conn.maxReadSize = 1000
+		f.fs.conn.readAheadWindow = 4
+		srv.asyncReads = true
+		srv.data = data
+		return f, srv
+	}
+
+	// the responses of the chunks arrive out of order.
+	f, srv := newFile(-1)
+	defer srv.conn.Close()
+
+	b := make([]byte, 5000)
+
+	n, err := f.ReadAt(b, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(b) || !bytes.Equal(b, data[500:5500]) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+	if srv.maxInflight < 2 || srv.maxInflight > 4 {
+		t.Errorf("expected up to 4 reads in flight, got %d", srv.maxInflight)
+	}
+
+	// the data ends in the middle of the window; the chunks after it aren't looked at.
+	b = make([]byte, 8000)
+
+	n, err = f.ReadAt(b, 7000)
+	if err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if n != len(data)-7000 || !bytes.Equal(b[:n], data[7000:]) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+
+	// a failed chunk in the middle of the window fails the read, as if read serially.
+	f, srv = newFile(500 + 2000)
+	defer srv.conn.Close()
+
+	b = make([]byte, 5000)
+
+	n, err = f.ReadAt(b, 500)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if n != 0 {
+		t.Errorf("expected 0 bytes read, got %d", n)
+	}
+	if err, ok := err.(*os.PathError); !ok || NtStatus(err.Err.(*ResponseError).Code) != STATUS_UNEXPECTED_IO_ERROR {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadAtOwnedResponses(t *testing.T) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	// the responses are larger than the receive buffer.
+	srv.data = make([]byte, 150*1024)
+	for i := range srv.data {
+		srv.data[i] = byte(i * 7)
+	}
+
+	for i := 0; i < 3; i++ {
+		b := make([]byte, 200*1024)
+
+		n, err := f.ReadAt(b, 1000)
+		if err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+		if n != len(srv.data)-1000 || !bytes.Equal(b[:n], srv.data[1000:]) {
+			t.Errorf("unexpected content: %d bytes", n)
+		}
+	}
+}
+
+func TestReadAtSignedResponses(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	// the data of the responses is placed into the buffer of the caller and verified there.
+	srv.signer = &session{signer: hmac.New(sha256.New, key)}
+
+	s := f.fs.session
+	s.sessionFlags = 0
+	s.signer = hmac.New(sha256.New, key)
+	s.verifier = hmac.New(sha256.New, key)
+	s.conn.requireSigning = true
+
+	srv.data = make([]byte, 150*1024)
+	for i := range srv.data {
+		srv.data[i] = byte(i * 7)
+	}
+
+	b := make([]byte, len(srv.data))
+
+	n, err := f.ReadAt(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(srv.data) || !bytes.Equal(b, srv.data) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+
+	s.verifier = hmac.New(sha256.New, []byte("fedcba9876543210"))
+
+	if _, err := f.ReadAt(b, 0); err == nil {
+		t.Error("expected the signature to be rejected")
+	}
+}
+
+func TestNewFileAllInfo(t *testing.T) {
+	le := binary.LittleEndian
+
+	name := utf16le.EncodeStringToBytes(`\dir\file.txt`)
+
+	info := make([]byte, 100+len(name))
+	le.PutUint64(info[16:24], 132223104000000000) // LastWriteTime: 2020-01-01
+	le.PutUint32(info[32:36], FILE_ATTRIBUTE_ARCHIVE)
+	le.PutUint64(info[40:48], 4096) // AllocationSize
+	le.PutUint64(info[48:56], 123)  // EndOfFile
+	le.PutUint32(info[56:60], 2)    // NumberOfLinks
+	le.PutUint64(info[64:72], 42)   // IndexNumber
+	le.PutUint32(info[76:80], FILE_READ_DATA|FILE_READ_ATTRIBUTES)
+	le.PutUint64(info[80:88], 7) // CurrentByteOffset
+	le.PutUint32(info[88:92], FILE_SEQUENTIAL_ONLY)
+	le.PutUint32(info[96:100], uint32(len(name)))
+	copy(info[100:], name)
+
+	fi, err := newFileAllInfo(FileAllInformationDecoder(info))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := FileAllInfo{
+		CreationTime:      time.Unix(0, FiletimeDecoder(info[:8]).Nanoseconds()),
+		LastAccessTime:    time.Unix(0, FiletimeDecoder(info[:8]).Nanoseconds()),
+		LastWriteTime:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ChangeTime:        time.Unix(0, FiletimeDecoder(info[:8]).Nanoseconds()),
+		FileAttributes:    FILE_ATTRIBUTE_ARCHIVE,
+		AllocationSize:    4096,
+		EndOfFile:         123,
+		NumberOfLinks:     2,
+		IndexNumber:       42,
+		AccessFlags:       FILE_READ_DATA | FILE_READ_ATTRIBUTES,
+		CurrentByteOffset: 7,
+		Mode:              FILE_SEQUENTIAL_ONLY,
+		FileName:          `\dir\file.txt`,
+	}
+	if !fi.LastWriteTime.Equal(expected.LastWriteTime) {
+		t.Errorf("expected %v, got %v", expected.LastWriteTime, fi.LastWriteTime)
+	}
+	fi.LastWriteTime = expected.LastWriteTime
+	if *fi != expected {
+		t.Errorf("expected %+v, got %+v", expected, *fi)
+	}
+
+	// the name is truncated.
+	if _, err := newFileAllInfo(FileAllInformationDecoder(info[:110])); err == nil {
+		t.Error("expected an error")
+	}
+
+	// the name length wraps around when added to the header length.
+	le.PutUint32(info[96:100], 0xfffffffe)
+	if _, err := newFileAllInfo(FileAllInformationDecoder(info)); err == nil {
+		t.Error("expected an error for a huge name length")
+	}
+}
+
+func TestParseDirectoryEntries(t *testing.T) {
+	le := binary.LittleEndian
+
+	entry := func(size int, name string) []byte {
+		u := utf16le.EncodeStringToBytes(name)
+		p := make([]byte, (size+len(u)+7)&^7)
+		le.PutUint64(p[40:48], 4)    // EndOfFile
+		le.PutUint32(p[56:60], 0x20) // FILE_ATTRIBUTE_ARCHIVE
+		le.PutUint32(p[60:64], uint32(len(u)))
+		le.PutUint32(p[64:68], 16) // EaSize
+		copy(p[size:], u)
+		return p
+	}
+
+	dot := entry(104, ".")
+	le.PutUint32(dot[:4], uint32(len(dot)))
+
+	file := entry(104, "a long file name.txt")
+	short := utf16le.EncodeStringToBytes("ALONGF~1.TXT")
+	file[68] = uint8(len(short))
+	copy(file[70:94], short)
+	le.PutUint64(file[96:104], 42) // FileId
+
+	fi, err := parseDirectoryEntries(FileIdBothDirectoryInformation, append(dot, file...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fi) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(fi))
+	}
+	st := fi[0].(*FileStat)
+	if st.FileName != "a long file name.txt" || st.ShortName != "ALONGF~1.TXT" || st.IndexNumber != 42 || st.EaSize != 16 || st.EndOfFile != 4 {
+		t.Errorf("unexpected entry: %+v", st)
+	}
+
+	fi, err = parseDirectoryEntries(FileIdFullDirectoryInformation, entry(80, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi[0].(*FileStat); st.FileName != "file.txt" || st.EaSize != 16 {
+		t.Errorf("unexpected entry: %+v", st)
+	}
+
+	// the name overflows the entry.
+	if _, err := parseDirectoryEntries(FileIdBothDirectoryInformation, entry(80, "file.txt")); err == nil {
+		t.Error("expected an error")
+	}
+
+	// the entry is shorter than the fixed header.
+	if _, err := parseDirectoryEntries(FileDirectoryInformation, make([]byte, 60)); err == nil {
+		t.Error("expected an error for a truncated entry")
+	}
+
+	// the next entry offset points past the end of the output.
+	next := entry(64, "file.txt")
+	le.PutUint32(next[:4], uint32(len(next)+8))
+	if _, err := parseDirectoryEntries(FileDirectoryInformation, next); err == nil {
+		t.Error("expected an error for a next entry offset past the end")
+	}
+
+	// the name length wraps around when added to the header length.
+	for _, class := range []uint8{FileDirectoryInformation, FileFullDirectoryInformation, FileIdFullDirectoryInformation, FileBothDirectoryInformation, FileIdBothDirectoryInformation} {
+		huge := entry(104, "file.txt")
+		le.PutUint32(huge[60:64], 0xfffffff0)
+		if _, err := parseDirectoryEntries(class, huge); err == nil {
+			t.Errorf("class %d: expected an error for a huge name length", class)
+		}
+	}
+}
+
+func BenchmarkReadAt(b *testing.B) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	srv.data = make([]byte, 64*1024)
+
+	buf := make([]byte, len(srv.data))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := f.ReadAt(buf, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadAtLarge reads 16MiB with 1MiB READ requests. Run it with -benchtime 200x to move over 3GB.
+func BenchmarkReadAtLarge(b *testing.B) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	f.fs.maxReadSize = 1024 * 1024
+
+	srv.data = make([]byte, 16*1024*1024)
+
+	buf := make([]byte, len(srv.data))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := f.ReadAt(buf, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPrepareInitiator(t *testing.T) {
+	hostname, _ := os.Hostname()
+	if i := strings.IndexByte(hostname, '.'); i >= 0 {
+		hostname = hostname[:i]
+	}
+
+	for _, tc := range []struct {
+		clientName  string
+		useHostName bool
+		workstation string
+		expected    string
+	}{
+		{"", false, "", ""},
+		{"", true, "", hostname},
+		{"client", false, "", "client"},
+		{"client", true, "", "client"},
+		{"client", false, "station", "station"},
+		{"", false, "station", "station"},
+	} {
+		d := &Dialer{ClientName: tc.clientName, UseHostName: tc.useHostName}
+
+		i := &NTLMInitiator{User: "user", Password: "password", Workstation: tc.workstation}
+
+		initiator, err := d.prepareInitiator(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := initiator.(*NTLMInitiator).Workstation; w != tc.expected {
+			t.Errorf("%+v: unexpected workstation: %q", tc, w)
+		}
+		if i.Workstation != tc.workstation {
+			t.Error("the initiator of the caller should not be modified")
+		}
+
+		initiator, err = d.prepareInitiator(&AnonymousInitiator{Workstation: tc.workstation})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := initiator.(*AnonymousInitiator).Workstation; w != tc.expected {
+			t.Errorf("%+v: unexpected anonymous workstation: %q", tc, w)
+		}
+
+		// the NetBIOS session presents the same name, regardless of the workstation name of the initiator.
+		expected := tc.clientName
+		if expected == "" {
+			expected = "SMB2CLIENT"
+			if tc.useHostName {
+				expected = hostname
+			}
+		}
+		if _, calling := d.netBIOSNames(); calling != expected {
+			t.Errorf("%+v: unexpected calling name: %q", tc, calling)
+		}
+	}
+
+	if _, err := new(Dialer).prepareInitiator(&NTLMInitiator{}); err == nil {
+		t.Error("expected an error without a user")
+	}
+}
+
+func TestCloseReleasesHandle(t *testing.T) {
+	f, srv := newTestFile(0, -1)
+
+	f.fs.handles = make(map[*FileId]trackedHandle)
+	f.fs.oplocks = newOplockTable()
+	f.fs.trackHandle(f.fd, f.name, FILE_WRITE_DATA)
+
+	// CLOSE fails, but the handle is given up all the same.
+	srv.conn.Close()
+
+	if err := f.Close(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if handles := f.fs.writableHandles(); len(handles) != 0 {
+		t.Errorf("expected no tracked handles, got %v", handles)
+	}
+	if err, ok := f.Close().(*os.PathError); !ok || err.Err != os.ErrInvalid {
+		t.Errorf("expected the file to be closed, got %v", err)
+	}
+}
+
+func TestSyncDenied(t *testing.T) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	srv.flushStatus = STATUS_ACCESS_DENIED
+
+	f.fs.handles = make(map[*FileId]trackedHandle)
+
+	if err, ok := f.Sync().(*os.PathError); !ok || err.Err != os.ErrPermission {
+		t.Errorf("expected %v, got %v", os.ErrPermission, err)
+	}
+
+	// a handle opened with MAXIMUM_ALLOWED may not be writable, and there is nothing to flush then.
+	f.fs.trackHandle(f.fd, f.name, MAXIMUM_ALLOWED)
+
+	if err := f.fs.Sync(); err != nil {
+		t.Error(err)
+	}
+
+	// a handle opened with write access is expected to be flushed.
+	f.fs.trackHandle(f.fd, f.name, MAXIMUM_ALLOWED|FILE_WRITE_DATA)
+
+	if err, ok := f.fs.Sync().(*os.PathError); !ok || err.Err != os.ErrPermission || err.Path != f.name {
+		t.Errorf("expected %v, got %v", os.ErrPermission, err)
+	}
+}
+
+func TestMayWrite(t *testing.T) {
+	for _, tc := range []struct {
+		access   uint32
+		expected bool
+	}{
+		{FILE_READ_DATA | FILE_READ_ATTRIBUTES, false},
+		{GENERIC_READ, false},
+		{FILE_WRITE_DATA, true},
+		{FILE_APPEND_DATA, true},
+		{GENERIC_WRITE, true},
+		{GENERIC_ALL, true},
+		{MAXIMUM_ALLOWED, true},
+	} {
+		if mayWrite(tc.access) != tc.expected {
+			t.Errorf("%#x: expected %v", tc.access, tc.expected)
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	f, srv := newTestFileWithTimeout(0, -1, 20*time.Millisecond)
+	defer srv.conn.Close()
+
+	srv.data = []byte("data")
+
+	f.fileStat = &FileStat{FileAttributes: FILE_ATTRIBUTE_DIRECTORY}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := f.Watch(ctx, false, NotifyFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending CHANGE_NOTIFY isn't expected to be answered, so the connection stays up.
+	time.Sleep(100 * time.Millisecond)
+
+	b := make([]byte, 4)
+
+	_, err = f.ReadAt(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// but a READ is.
+	srv.m.Lock()
+	srv.stallReads = true
+	srv.m.Unlock()
+
+	_, err = f.ReadAt(b, 0)
+	if e, ok := err.(*os.PathError); !ok {
+		t.Errorf("expected a transport error, got %v", err)
+	} else if e, ok := e.Err.(*TransportError); !ok || !isTimeout(e.Err) {
+		t.Errorf("expected a transport error, got %v", err)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	tr := direct(newDeadlineConn(client, 20*time.Millisecond, 0))
+
+	// nothing arrives; the timeout can be resumed from.
+	if _, err := tr.ReadSize(); !isTimeout(err) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	// half of the header arrives; the framing is lost.
+	go server.Write([]byte{0, 0})
+
+	if _, err := tr.ReadSize(); err == nil || isTimeout(err) {
+		t.Errorf("expected a fatal error, got %v", err)
+	}
+}
+
+func TestRecvBufferSize(t *testing.T) {
+	for _, tc := range []struct {
+		size     int
+		expected int
+	}{
+		{0, clientRecvBufferSize},
+		{-1, clientRecvBufferSize},
+		{1024*1024 + 1024, 1024*1024 + 1024},
+	} {
+		client, server := net.Pipe()
+
+		go func() {
+			srv := &testFileServer{conn: server}
+
+			var size [4]byte
+			if _, err := io.ReadFull(server, size[:]); err != nil {
+				return
+			}
+			pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(server, pkt); err != nil {
+				return
+			}
+
+			srv.respond(pkt, &NegotiateResponse{
+				DialectRevision: SMB210,
+				MaxTransactSize: 65536,
+				MaxReadSize:     8 * 1024 * 1024,
+				MaxWriteSize:    65536,
+				SystemTime:      &Filetime{},
+				ServerStartTime: &Filetime{},
+			})
+		}()
+
+		d := &Dialer{RecvBufferSize: tc.size}
+
+		c, err := d.negotiate(d.Negotiator, client, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.recvBufferSize != tc.expected {
+			t.Errorf("%d: expected a receive buffer of %d bytes, got %d", tc.size, tc.expected, c.recvBufferSize)
+		}
+
+		c.close()
+		server.Close()
+	}
+}
+
+func TestReaddirPartial(t *testing.T) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	f.fs.conn.maxTransactSize = 65536
+
+	// the listing fails after two batches.
+	srv.listing = [][]string{{".", "..", "a", "b"}, {"c"}}
+	srv.listingStatus = STATUS_UNEXPECTED_IO_ERROR
+
+	fi, err := f.Readdir(-1)
+	if e, ok := err.(*os.PathError); !ok || e.Op != "readdir" {
+		t.Fatalf("expected a readdir error, got %v", err)
+	} else if rerr, ok := e.Err.(*ResponseError); !ok || NtStatus(rerr.Code) != STATUS_UNEXPECTED_IO_ERROR {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, fi := range fi {
+		names = append(names, fi.Name())
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("expected the entries collected before the error, got %v", names)
+	}
+
+	// the entries aren't returned again.
+	srv.listing = [][]string{{"d"}}
+	srv.listingStatus = STATUS_NO_MORE_FILES
+
+	fi, err = f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fi) != 1 || fi[0].Name() != "d" {
+		t.Errorf("unexpected entries: %v", fi)
+	}
+}
@@ -0,0 +1,199 @@
+package smb2
+
+import (
+	"github.com/hirochachacha/go-smb2/internal/xpress"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// compressionThreshold is the minimum size of the data of a WRITE request worth compressing.
+const compressionThreshold = 4096
+
+// tryCompress compresses WRITE requests with LZ77 if it's been negotiated and it makes them smaller.
+// Other requests are returned as is.
+func (conn *conn) tryCompress(req Packet, pkt []byte) []byte {
+	w, ok := req.(*WriteRequest)
+	if !ok || len(w.Data) < compressionThreshold || !containsUint16(conn.compressionIds, SMB2_COMPRESSION_LZ77) {
+		return pkt
+	}
+
+	// the header and the fixed part of the request are sent uncompressed.
+	off := len(pkt) - len(w.Data)
+
+	var c []byte
+
+	if conn.compressionChained {
+		c = make([]byte, 8+8+off+8+4, 8+8+off+8+len(pkt))
+
+		t := CompressionTransformCodec(c)
+		t.SetProtocolId()
+		t.SetOriginalCompressedSegmentSize(uint32(len(pkt)))
+
+		h := CompressionPayloadHeaderCodec(c[8:])
+		h.SetCompressionAlgorithm(SMB2_COMPRESSION_NONE)
+		h.SetFlags(SMB2_COMPRESSION_FLAG_CHAINED)
+		h.SetLength(uint32(off))
+		copy(h[8:], pkt[:off])
+
+		h = CompressionPayloadHeaderCodec(c[8+8+off:])
+		h.SetCompressionAlgorithm(SMB2_COMPRESSION_LZ77)
+		h.SetFlags(0)
+		h.SetOriginalPayloadSize(uint32(len(w.Data)))
+
+		c = xpress.Compress(c, pkt[off:])
+
+		h = CompressionPayloadHeaderCodec(c[8+8+off:])
+		h.SetLength(uint32(len(h) - 8))
+	} else {
+		c = make([]byte, 16+off, 16+len(pkt))
+
+		t := CompressionTransformCodec(c)
+		t.SetProtocolId()
+		t.SetOriginalCompressedSegmentSize(uint32(len(w.Data)))
+		t.SetCompressionAlgorithm(SMB2_COMPRESSION_LZ77)
+		t.SetFlags(SMB2_COMPRESSION_FLAG_NONE)
+		t.SetOffset(uint32(off))
+		copy(c[16:], pkt[:off])
+
+		c = xpress.Compress(c, pkt[off:])
+	}
+
+	if len(c) >= len(pkt) {
+		return pkt
+	}
+
+	return c
+}
+
+// tryDecompress decompresses pkt if it starts with a compression transform header.
+// Other packets are returned as is.
+func (conn *conn) tryDecompress(pkt []byte) ([]byte, error) {
+	t := CompressionTransformCodec(pkt)
+	if t.IsInvalid() {
+		return pkt, nil
+	}
+
+	if len(conn.compressionIds) == 0 {
+		return nil, &InvalidResponseError{"compressed message without negotiated compression"}
+	}
+
+	size := int(t.OriginalCompressedSegmentSize())
+	if size > conn.maxDecompressedSize() {
+		return nil, &InvalidResponseError{"compressed message is too large"}
+	}
+
+	if t.Flags()&SMB2_COMPRESSION_FLAG_CHAINED != 0 {
+		return conn.decompressChained(t.Data(), size)
+	}
+
+	data := t.Data()
+
+	off := int(t.Offset())
+	if off > len(data) {
+		return nil, &InvalidResponseError{"broken compression transform header format"}
+	}
+
+	ret := make([]byte, off, off+size)
+	copy(ret, data[:off])
+
+	return conn.decompress(t.CompressionAlgorithm(), ret, data[off:], size)
+}
+
+func (conn *conn) decompressChained(data []byte, size int) ([]byte, error) {
+	ret := make([]byte, 0, size)
+
+	for len(data) > 0 {
+		h := CompressionPayloadHeaderCodec(data)
+		if h.IsInvalid() {
+			return nil, &InvalidResponseError{"broken compression payload header format"}
+		}
+
+		payload := h.Data()
+
+		switch alg := h.CompressionAlgorithm(); alg {
+		case SMB2_COMPRESSION_NONE:
+			if len(payload) > size-len(ret) {
+				return nil, &InvalidResponseError{"compressed message is larger than announced"}
+			}
+
+			ret = append(ret, payload...)
+		case SMB2_COMPRESSION_PATTERN_V1:
+			if !containsUint16(conn.compressionIds, alg) {
+				return nil, &InvalidResponseError{"unexpected compression algorithm"}
+			}
+
+			p := PatternPayloadV1Decoder(payload)
+			if p.IsInvalid() {
+				return nil, &InvalidResponseError{"broken pattern payload format"}
+			}
+
+			n := int(p.Repetitions())
+			if n > size-len(ret) {
+				return nil, &InvalidResponseError{"compressed message is larger than announced"}
+			}
+
+			for i := 0; i < n; i++ {
+				ret = append(ret, p.Pattern())
+			}
+		default:
+			if len(payload) < 4 {
+				return nil, &InvalidResponseError{"broken compression payload header format"}
+			}
+
+			n := int(h.OriginalPayloadSize())
+			if n > size-len(ret) {
+				return nil, &InvalidResponseError{"compressed message is larger than announced"}
+			}
+
+			var err error
+			ret, err = conn.decompress(alg, ret, payload[4:], n)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		data = data[8+h.Length():]
+	}
+
+	if len(ret) != size {
+		return nil, &InvalidResponseError{"compressed message is smaller than announced"}
+	}
+
+	return ret, nil
+}
+
+// decompress appends size bytes decompressed from src to dst.
+func (conn *conn) decompress(alg uint16, dst, src []byte, size int) ([]byte, error) {
+	if !containsUint16(conn.compressionIds, alg) {
+		return nil, &InvalidResponseError{"unexpected compression algorithm"}
+	}
+
+	var err error
+
+	switch alg {
+	case SMB2_COMPRESSION_LZ77:
+		dst, err = xpress.Decompress(dst, src, size)
+	case SMB2_COMPRESSION_LZ77_HUFFMAN:
+		dst, err = xpress.DecompressHuffman(dst, src, size)
+	default:
+		return nil, &InvalidResponseError{"unexpected compression algorithm"}
+	}
+	if err != nil {
+		return nil, &InvalidResponseError{err.Error()}
+	}
+
+	return dst, nil
+}
+
+// maxDecompressedSize bounds the size of decompressed messages, so that a broken header
+// can't make the receiver allocate an arbitrary amount of memory.
+func (conn *conn) maxDecompressedSize() int {
+	size := int(conn.maxReadSize)
+	if int(conn.maxTransactSize) > size {
+		size = int(conn.maxTransactSize)
+	}
+	if int(conn.maxWriteSize) > size {
+		size = int(conn.maxWriteSize)
+	}
+	return size + 64*1024 // headers
+}
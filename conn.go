@@ -3,6 +3,7 @@ package smb2
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha512"
 	"fmt"
 	"os"
@@ -14,11 +15,204 @@ import (
 	. "github.com/hirochachacha/go-smb2/internal/smb2"
 )
 
+// Encryption algorithms for Negotiator.Ciphers. ([MS-SMB2] 2.2.3.1.2)
+const (
+	CipherAES128CCM = AES128CCM
+	CipherAES128GCM = AES128GCM
+	CipherAES256CCM = AES256CCM
+	CipherAES256GCM = AES256GCM
+)
+
+// Signing algorithms for Negotiator.SigningAlgorithms. ([MS-SMB2] 2.2.3.1.7)
+const (
+	SigningAES128CMAC = AES128CMAC
+	SigningAES128GMAC = AES128GMAC
+)
+
+// Preauthentication integrity hash algorithms for Negotiator.HashAlgorithms. ([MS-SMB2] 2.2.3.1.1)
+const (
+	HashSHA512 = SHA512
+)
+
+// Dialects returned by Session.NegotiatedDialect and accepted by Negotiator.SpecifiedDialect. ([MS-SMB2] 2.2.3)
+const (
+	DialectSMB202 = SMB202
+	DialectSMB210 = SMB210
+	DialectSMB300 = SMB300
+	DialectSMB302 = SMB302
+	DialectSMB311 = SMB311
+)
+
+// Capability flags returned by Session.Capabilities. ([MS-SMB2] 2.2.4)
+const (
+	CapabilityDFS               = SMB2_GLOBAL_CAP_DFS
+	CapabilityLeasing           = SMB2_GLOBAL_CAP_LEASING
+	CapabilityLargeMTU          = SMB2_GLOBAL_CAP_LARGE_MTU
+	CapabilityMultiChannel      = SMB2_GLOBAL_CAP_MULTI_CHANNEL
+	CapabilityPersistentHandles = SMB2_GLOBAL_CAP_PERSISTENT_HANDLES
+	CapabilityDirectoryLeasing  = SMB2_GLOBAL_CAP_DIRECTORY_LEASING
+	CapabilityEncryption        = SMB2_GLOBAL_CAP_ENCRYPTION
+)
+
 // Negotiator contains options for func (*Dialer) Dial.
 type Negotiator struct {
 	RequireMessageSigning bool     // enforce signing?
 	ClientGuid            [16]byte // if it's zero, generated by crypto/rand.
 	SpecifiedDialect      uint16   // if it's zero, clientDialects is used. (See feature.go for more details)
+	NoDowngrade           bool     // if true, fail instead of using SMB 2.x dialects; only SMB 3.x connections are established.
+
+	// MinDialect, if it's not zero, is the lowest dialect accepted, e.g. DialectSMB300 to refuse the dialects
+	// without encryption and secure negotiation. All the dialects are still offered, so that a server selecting
+	// a lower one is refused with a DialectNotAllowedError rather than an obscure failure.
+	// NoDowngrade is the same as DialectSMB300.
+	MinDialect uint16
+
+	// StableClientGuid derives the client GUID from the host name and the process id
+	// instead of generating a random one, if ClientGuid is zero.
+	// Then all connections made by the process share the same client GUID, which is required
+	// for multichannel and for reclaiming durable handles after reconnection.
+	// Note that the server associates durable and resilient handles with the client GUID;
+	// a connection using a different GUID can't reclaim them.
+	StableClientGuid bool
+
+	// SkipValidateNegotiate disables FSCTL_VALIDATE_NEGOTIATE_INFO after authentication on SMB 3.0 and 3.0.2.
+	// The request detects downgrade attacks on the negotiate exchange, but some broken servers
+	// reject it or return unsigned responses.
+	// SMB 3.1.1 uses preauthentication integrity instead and is not affected.
+	SkipValidateNegotiate bool
+
+	// Ciphers lists the encryption algorithms advertised for SMB 3.1.1 in order of preference,
+	// e.g. []uint16{CipherAES256GCM} to require AES-256-GCM. The server can only select one of them.
+	// If it's empty, clientCiphers is used. (See feature.go for more details)
+	Ciphers []uint16
+
+	// SigningAlgorithms lists the signing algorithms advertised for SMB 3.1.1 in order of preference,
+	// e.g. []uint16{SigningAES128CMAC} for servers with a broken AES-GMAC implementation.
+	// Servers that don't support the negotiation sign with AES-CMAC.
+	// If it's empty, clientSigningAlgorithms is used. (See feature.go for more details)
+	SigningAlgorithms []uint16
+
+	// HashAlgorithms lists the preauthentication integrity hash algorithms advertised for SMB 3.1.1
+	// in order of preference. If it's empty, clientHashAlgorithms is used.
+	HashAlgorithms []uint16
+
+	// HashSalt is the salt sent with the preauthentication integrity capabilities.
+	// If it's empty, 32 random bytes are generated for each connection.
+	HashSalt []byte
+
+	compression bool   // see Dialer.EnableCompression
+	leasing     bool   // see Dialer.RequestLeases
+	logger      Logger // see Dialer.Logger
+}
+
+// minDialect returns the lowest dialect accepted, or 0 if any is.
+func (n *Negotiator) minDialect() uint16 {
+	if n.NoDowngrade && n.MinDialect < SMB300 {
+		return SMB300
+	}
+	return n.MinDialect
+}
+
+// capabilities returns the capabilities advertised by the client.
+func (n *Negotiator) capabilities() uint32 {
+	if n.leasing {
+		return clientCapabilities | SMB2_GLOBAL_CAP_LEASING
+	}
+	return clientCapabilities
+}
+
+// contexts returns the negotiate contexts for SMB 3.1.1.
+func (n *Negotiator) contexts() ([]Encoder, error) {
+	hashAlgorithms := n.HashAlgorithms
+	if len(hashAlgorithms) == 0 {
+		hashAlgorithms = clientHashAlgorithms
+	}
+	for _, alg := range hashAlgorithms {
+		if !containsUint16(clientHashAlgorithms, alg) {
+			return nil, &InternalError{fmt.Sprintf("unsupported hash algorithm specified: %#x", alg)}
+		}
+	}
+
+	ciphers := n.Ciphers
+	if len(ciphers) == 0 {
+		ciphers = clientCiphers
+	}
+	for _, ciph := range ciphers {
+		if !containsUint16(clientCiphers, ciph) {
+			return nil, &InternalError{fmt.Sprintf("unsupported cipher specified: %#x", ciph)}
+		}
+	}
+
+	signingAlgorithms := n.SigningAlgorithms
+	if len(signingAlgorithms) == 0 {
+		signingAlgorithms = clientSigningAlgorithms
+	}
+	for _, alg := range signingAlgorithms {
+		if !containsUint16(clientSigningAlgorithms, alg) {
+			return nil, &InternalError{fmt.Sprintf("unsupported signing algorithm specified: %#x", alg)}
+		}
+	}
+
+	hc := &HashContext{
+		HashAlgorithms: hashAlgorithms,
+		HashSalt:       n.HashSalt,
+	}
+	if len(hc.HashSalt) == 0 {
+		hc.HashSalt = make([]byte, 32)
+		if _, err := rand.Read(hc.HashSalt); err != nil {
+			return nil, &InternalError{err.Error()}
+		}
+	}
+	if len(hc.HashSalt) > 0xffff {
+		return nil, &InternalError{"hash salt is too long"}
+	}
+
+	cc := &CipherContext{
+		Ciphers: ciphers,
+	}
+
+	sc := &SigningContext{
+		SigningAlgorithms: signingAlgorithms,
+	}
+
+	if n.compression {
+		return []Encoder{hc, cc, sc, &CompressionContext{
+			Flags:                 SMB2_COMPRESSION_CAPABILITIES_FLAG_CHAINED,
+			CompressionAlgorithms: clientCompressionAlgorithms,
+		}}, nil
+	}
+
+	return []Encoder{hc, cc, sc}, nil
+}
+
+func containsUint16(list []uint16, v uint16) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	stableClientGuidOnce  sync.Once
+	stableClientGuidValue [16]byte
+)
+
+// stableClientGuid returns a name based (version 5) UUID derived from the host name and the process id.
+func stableClientGuid() [16]byte {
+	stableClientGuidOnce.Do(func() {
+		hostname, _ := os.Hostname()
+
+		h := sha1.New()
+		fmt.Fprintf(h, "go-smb2:%s:%d", hostname, os.Getpid())
+
+		copy(stableClientGuidValue[:], h.Sum(nil))
+
+		stableClientGuidValue[6] = stableClientGuidValue[6]&0x0f | 0x50 // version 5
+		stableClientGuidValue[8] = stableClientGuidValue[8]&0x3f | 0x80 // RFC 4122 variant
+	})
+	return stableClientGuidValue
 }
 
 func (n *Negotiator) makeRequest() (*NegotiateRequest, error) {
@@ -30,18 +224,25 @@ func (n *Negotiator) makeRequest() (*NegotiateRequest, error) {
 		req.SecurityMode = SMB2_NEGOTIATE_SIGNING_ENABLED
 	}
 
-	req.Capabilities = clientCapabilities
+	req.Capabilities = n.capabilities()
 
-	if n.ClientGuid == zero {
+	switch {
+	case n.ClientGuid != zero:
+		req.ClientGuid = n.ClientGuid
+	case n.StableClientGuid:
+		req.ClientGuid = stableClientGuid()
+	default:
 		_, err := rand.Read(req.ClientGuid[:])
 		if err != nil {
 			return nil, &InternalError{err.Error()}
 		}
-	} else {
-		req.ClientGuid = n.ClientGuid
 	}
 
 	if n.SpecifiedDialect != UnknownSMB {
+		if n.SpecifiedDialect < n.minDialect() {
+			return nil, &InternalError{"specified dialect is lower than the minimum dialect"}
+		}
+
 		req.Dialects = []uint16{n.SpecifiedDialect}
 
 		switch n.SpecifiedDialect {
@@ -50,46 +251,35 @@ func (n *Negotiator) makeRequest() (*NegotiateRequest, error) {
 		case SMB300:
 		case SMB302:
 		case SMB311:
-			hc := &HashContext{
-				HashAlgorithms: clientHashAlgorithms,
-				HashSalt:       make([]byte, 32),
-			}
-			if _, err := rand.Read(hc.HashSalt); err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-
-			cc := &CipherContext{
-				Ciphers: clientCiphers,
+			contexts, err := n.contexts()
+			if err != nil {
+				return nil, err
 			}
 
-			req.Contexts = append(req.Contexts, hc, cc)
+			req.Contexts = append(req.Contexts, contexts...)
 		default:
 			return nil, &InternalError{"unsupported dialect specified"}
 		}
 	} else {
 		req.Dialects = clientDialects
 
-		hc := &HashContext{
-			HashAlgorithms: clientHashAlgorithms,
-			HashSalt:       make([]byte, 32),
-		}
-		if _, err := rand.Read(hc.HashSalt); err != nil {
-			return nil, &InternalError{err.Error()}
-		}
-
-		cc := &CipherContext{
-			Ciphers: clientCiphers,
+		contexts, err := n.contexts()
+		if err != nil {
+			return nil, err
 		}
 
-		req.Contexts = append(req.Contexts, hc, cc)
+		req.Contexts = append(req.Contexts, contexts...)
 	}
 
 	return req, nil
 }
 
-func (n *Negotiator) negotiate(t transport, a *account, ctx context.Context) (*conn, error) {
+// negotiate starts a connection on t and performs negotiation. On failure, the connection is closed.
+func (n *Negotiator) negotiate(t transport, a *account, recvBufferSize int, ctx context.Context) (_ *conn, err error) {
 	conn := &conn{
 		t:                   t,
+		recvBufferSize:      recvBufferSize,
+		logger:              n.logger,
 		outstandingRequests: newOutstandingRequests(),
 		account:             a,
 		rdone:               make(chan struct{}, 1),
@@ -101,6 +291,12 @@ func (n *Negotiator) negotiate(t transport, a *account, ctx context.Context) (*c
 	go conn.runSender()
 	go conn.runReciever()
 
+	defer func() {
+		if err != nil {
+			conn.close()
+		}
+	}()
+
 retry:
 	req, err := n.makeRequest()
 	if err != nil {
@@ -108,6 +304,7 @@ retry:
 	}
 
 	req.CreditCharge = 1
+	req.CreditRequestResponse = a.initialRequest
 
 	rr, err := conn.send(req, ctx)
 	if err != nil {
@@ -119,6 +316,8 @@ retry:
 		return nil, err
 	}
 
+	now := time.Now()
+
 	res, err := accept(SMB2_NEGOTIATE, pkt)
 	if err != nil {
 		return nil, err
@@ -129,27 +328,45 @@ retry:
 		return nil, &InvalidResponseError{"broken negotiate response format"}
 	}
 
+	// the wildcard is lower than SMB 3.0 too, it would only lead to SMB 2.1.
+	if r.DialectRevision() < n.minDialect() {
+		return nil, &DialectNotAllowedError{Dialect: r.DialectRevision(), MinDialect: n.minDialect()}
+	}
+
 	if r.DialectRevision() == SMB2 {
 		n.SpecifiedDialect = SMB210
 
 		goto retry
 	}
 
-	if n.SpecifiedDialect != UnknownSMB && n.SpecifiedDialect != r.DialectRevision() {
-		return nil, &InvalidResponseError{"unexpected dialect returned"}
+	if !containsUint16(req.Dialects, r.DialectRevision()) {
+		return nil, &InvalidResponseError{fmt.Sprintf("server selected dialect %#x, which wasn't offered", r.DialectRevision())}
 	}
 
 	conn.requireSigning = n.RequireMessageSigning || r.SecurityMode()&SMB2_NEGOTIATE_SIGNING_REQUIRED != 0
-	conn.capabilities = clientCapabilities & r.Capabilities()
+	conn.capabilities = n.capabilities() & r.Capabilities()
 	conn.dialect = r.DialectRevision()
 	conn.maxTransactSize = r.MaxTransactSize()
 	conn.maxReadSize = r.MaxReadSize()
 	conn.maxWriteSize = r.MaxWriteSize()
 	conn.sequenceWindow = 1
 
+	if st := r.SystemTime(); st.LowDateTime() != 0 || st.HighDateTime() != 0 {
+		conn.serverTime = time.Unix(0, st.Nanoseconds())
+		conn.clockSkew = conn.serverTime.Sub(now)
+	}
+
 	// conn.gssNegotiateToken = r.SecurityBuffer()
-	// conn.clientGuid = n.ClientGuid
-	// copy(conn.serverGuid[:], r.ServerGuid())
+	conn.clientGuid = req.ClientGuid
+	copy(conn.serverGuid[:], r.ServerGuid())
+	conn.serverCapabilities = r.Capabilities()
+	conn.serverSecurityMode = r.SecurityMode()
+	conn.negotiateInfo = &ValidateNegotiateInfoRequest{
+		Capabilities: req.Capabilities,
+		Guid:         req.ClientGuid,
+		SecurityMode: req.SecurityMode,
+		Dialects:     req.Dialects,
+	}
 
 	if conn.dialect != SMB311 {
 		return conn, nil
@@ -206,12 +423,55 @@ retry:
 
 			conn.cipherId = ciphs[0]
 
+			if len(n.Ciphers) != 0 && !containsUint16(n.Ciphers, conn.cipherId) {
+				return nil, &InvalidResponseError{"server selected a cipher that wasn't offered"}
+			}
+
 			switch conn.cipherId {
-			case AES128CCM:
-			case AES128GCM:
+			case AES128CCM, AES128GCM, AES256CCM, AES256GCM:
 			default:
 				return nil, &InvalidResponseError{"unknown cipher algorithm"}
 			}
+		case SMB2_SIGNING_CAPABILITIES:
+			d := SigningContextDataDecoder(ctx.Data())
+			if d.IsInvalid() {
+				return nil, &InvalidResponseError{"broken signing context data format"}
+			}
+
+			algs := d.SigningAlgorithms()
+
+			if len(algs) != 1 {
+				return nil, &InvalidResponseError{"multiple signing algorithms"}
+			}
+
+			conn.signingAlgorithmId = algs[0]
+
+			if len(n.SigningAlgorithms) != 0 && !containsUint16(n.SigningAlgorithms, conn.signingAlgorithmId) {
+				return nil, &InvalidResponseError{"server selected a signing algorithm that wasn't offered"}
+			}
+
+			switch conn.signingAlgorithmId {
+			case AES128CMAC, AES128GMAC:
+			default:
+				return nil, &InvalidResponseError{"unknown signing algorithm"}
+			}
+		case SMB2_COMPRESSION_CAPABILITIES:
+			d := CompressionContextDataDecoder(ctx.Data())
+			if d.IsInvalid() {
+				return nil, &InvalidResponseError{"broken compression context data format"}
+			}
+
+			for _, alg := range d.CompressionAlgorithms() {
+				if alg == SMB2_COMPRESSION_NONE {
+					continue
+				}
+				if !n.compression || !containsUint16(clientCompressionAlgorithms, alg) {
+					return nil, &InvalidResponseError{"server selected a compression algorithm that wasn't offered"}
+				}
+				conn.compressionIds = append(conn.compressionIds, alg)
+			}
+
+			conn.compressionChained = len(conn.compressionIds) != 0 && d.Flags()&SMB2_COMPRESSION_CAPABILITIES_FLAG_CHAINED != 0
 		default:
 			// skip unsupported context
 		}
@@ -231,16 +491,39 @@ retry:
 type requestResponse struct {
 	msgId         uint64
 	asyncId       uint64
+	abandoned     int32 // set once the caller gave up waiting, see abandon
 	creditRequest uint16
-	pkt           []byte // request packet
+	pkt           []byte    // request packet, until it's sent unless it's hashed for preauth integrity
+	tc            *treeConn // tree of the request, if any
 	ctx           context.Context
 	recv          chan []byte
 	err           error
+
+	// buf is the pooled buffer of the response if it has one of its own, i.e. it's larger than
+	// the receive buffer and isn't part of a compound or encrypted response. See release.
+	buf []byte
+
+	// dst is the buffer of the caller of READ, into which the receiver may place the data of the response.
+	// If it does so, direct is set and the response is cut off after the READ response header. See readDirect.
+	dstMu  sync.Mutex
+	dst    []byte
+	direct bool
+}
+
+// release returns the buffer of the response to the pool.
+// It's called by the requests that copy large responses out, like READ, once they have done so.
+// The response must not be used afterwards.
+func (rr *requestResponse) release() {
+	if rr.buf != nil {
+		putBuffer(rr.buf)
+		rr.buf = nil
+	}
 }
 
 type outstandingRequests struct {
 	m        sync.Mutex
 	requests map[uint64]*requestResponse
+	changed  chan struct{} // closed when a request is answered, if someone is waiting for it
 }
 
 func newOutstandingRequests() *outstandingRequests {
@@ -260,9 +543,65 @@ func (r *outstandingRequests) pop(msgId uint64) (*requestResponse, bool) {
 
 	delete(r.requests, msgId)
 
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+
 	return rr, true
 }
 
+// interim records the async id of the request msgId, whose interim response has arrived.
+// Unlike pop, it leaves the request outstanding until its final response,
+// but it wakes up the waiters all the same, so that drain cancels it.
+func (r *outstandingRequests) interim(msgId, asyncId uint64) (*requestResponse, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rr, ok := r.requests[msgId]
+	if !ok {
+		return nil, false
+	}
+
+	atomic.StoreUint64(&rr.asyncId, asyncId)
+
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+
+	return rr, true
+}
+
+// abort fails rr with err, as if the connection was closed, unless it has been answered meanwhile.
+func (r *outstandingRequests) abort(rr *requestResponse, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.requests[rr.msgId] != rr {
+		return
+	}
+
+	delete(r.requests, rr.msgId)
+
+	rr.err = err
+	close(rr.recv)
+
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = nil
+	}
+}
+
+func (r *outstandingRequests) get(msgId uint64) (*requestResponse, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rr, ok := r.requests[msgId]
+
+	return rr, ok
+}
+
 func (r *outstandingRequests) set(msgId uint64, rr *requestResponse) {
 	r.m.Lock()
 	defer r.m.Unlock()
@@ -270,6 +609,51 @@ func (r *outstandingRequests) set(msgId uint64, rr *requestResponse) {
 	r.requests[msgId] = rr
 }
 
+// of returns the outstanding requests on the tree tc, or all of them if tc is nil,
+// and a channel that is closed when one of the outstanding requests is answered.
+func (r *outstandingRequests) of(tc *treeConn) ([]*requestResponse, <-chan struct{}) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var rrs []*requestResponse
+
+	for _, rr := range r.requests {
+		// the trees of the channels of a session share the tree id.
+		if tc == nil || (rr.tc != nil && rr.tc.treeId == tc.treeId) {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	if r.changed == nil {
+		r.changed = make(chan struct{})
+	}
+
+	return rrs, r.changed
+}
+
+// expectsData reports whether a response is due, i.e. whether a synchronous request is outstanding.
+// Asynchronous requests, like CHANGE_NOTIFY or a blocking LOCK, and abandoned requests waiting for
+// their CANCEL to take effect may legitimately go unanswered for long.
+func (r *outstandingRequests) expectsData() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, rr := range r.requests {
+		if atomic.LoadUint64(&rr.asyncId) == 0 && atomic.LoadInt32(&rr.abandoned) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *outstandingRequests) len() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return len(r.requests)
+}
+
 func (r *outstandingRequests) shutdown(err error) {
 	r.m.Lock()
 	defer r.m.Unlock()
@@ -291,13 +675,31 @@ type conn struct {
 	maxReadSize               uint32
 	maxWriteSize              uint32
 	requireSigning            bool
+	requireEncryption         bool
 	capabilities              uint32
 	preauthIntegrityHashId    uint16
 	preauthIntegrityHashValue [64]byte
 	cipherId                  uint16
+	signingAlgorithmId        uint16        // AES128CMAC or AES128GMAC on SMB 3.1.1, 0 if the server didn't negotiate it
+	compressionIds            []uint16      // compression algorithms negotiated, if any
+	compressionChained        bool          // chained compression negotiated?
+	serverTime                time.Time     // server's system time at negotiate
+	clockSkew                 time.Duration // serverTime - local time at negotiate
 
 	account *account
 
+	retryPolicy *RetryPolicy
+
+	recvBufferSize int // see Dialer.RecvBufferSize
+
+	operationTimeout  time.Duration // see Dialer.OperationTimeout
+	readAheadWindow   int           // see Dialer.ReadAheadWindow
+	writeBehindWindow int           // see Dialer.WriteBehindWindow
+
+	autoTune *tuneStats // nil unless Dialer.AutoTune is set
+
+	logger Logger // see Dialer.Logger
+
 	rdone chan struct{}
 	wdone chan struct{}
 	write chan []byte
@@ -308,8 +710,13 @@ type conn struct {
 	err error
 
 	// gssNegotiateToken []byte
-	// serverGuid        [16]byte
-	// clientGuid        [16]byte
+	serverGuid [16]byte
+	clientGuid [16]byte
+
+	// values exchanged at negotiate, used for FSCTL_VALIDATE_NEGOTIATE_INFO
+	serverCapabilities uint32
+	serverSecurityMode uint16
+	negotiateInfo      *ValidateNegotiateInfoRequest
 
 	_useSession int32 // receiver use session?
 }
@@ -322,8 +729,82 @@ func (conn *conn) enableSession() {
 	atomic.StoreInt32(&conn._useSession, 1)
 }
 
-func (conn *conn) newTimer() *time.Timer {
-	return time.NewTimer(5 * time.Second)
+// isClosed reports whether the receiver has stopped, after which every request fails.
+func (conn *conn) isClosed() bool {
+	select {
+	case <-conn.wdone:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain waits until the outstanding requests on the tree tc, or all of them if tc is nil, are answered.
+// The requests waiting for an asynchronous operation, like CHANGE_NOTIFY or a blocking LOCK, are cancelled,
+// since they might never be answered otherwise.
+// If requests are still outstanding after clientDrainTimeout, all of them are cancelled,
+// and the ones that aren't answered within another clientDrainTimeout are failed,
+// so that LOGOFF and TREE_DISCONNECT don't wait forever on an unresponsive server.
+func (conn *conn) drain(tc *treeConn, ctx context.Context) error {
+	return conn.drainFor(tc, ctx, clientDrainTimeout)
+}
+
+func (conn *conn) drainFor(tc *treeConn, ctx context.Context, timeout time.Duration) error {
+	cancelled := make(map[*requestResponse]bool)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	expired := false
+
+	for {
+		rrs, changed := conn.outstandingRequests.of(tc)
+		if len(rrs) == 0 {
+			return nil
+		}
+
+		for _, rr := range rrs {
+			// requests go async when their interim response arrives, which answers them for the time being.
+			if !cancelled[rr] && (expired || atomic.LoadUint64(&rr.asyncId) != 0) {
+				cancelled[rr] = true
+
+				if err := conn.sendCancel(conn.cancelRequest(rr), rr.tc); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			if expired {
+				// the server doesn't answer; the credits of the requests are lost.
+				for _, rr := range rrs {
+					conn.outstandingRequests.abort(rr, &ContextError{Err: context.DeadlineExceeded})
+				}
+				return nil
+			}
+			expired = true
+			timer.Reset(timeout)
+		case <-conn.wdone:
+			// the outstanding requests have failed.
+			return nil
+		case <-ctx.Done():
+			return &ContextError{Err: ctx.Err()}
+		}
+	}
+}
+
+// newTimer returns a timer expiring after the operation timeout for requests of ctx,
+// or nil if ctx has a deadline of its own or there is no operation timeout.
+func (conn *conn) newTimer(ctx context.Context) *time.Timer {
+	if conn.operationTimeout <= 0 {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return nil
+	}
+	return time.NewTimer(conn.operationTimeout)
 }
 
 func (conn *conn) sendRecv(cmd uint16, req Packet, ctx context.Context) (res []byte, err error) {
@@ -363,10 +844,11 @@ func (conn *conn) chargeCredit(creditCharge uint16) {
 }
 
 func (conn *conn) send(req Packet, ctx context.Context) (rr *requestResponse, err error) {
-	return conn.sendWith(req, nil, ctx)
+	return conn.sendWith(req, nil, nil, ctx)
 }
 
-func (conn *conn) sendWith(req Packet, tc *treeConn, ctx context.Context) (rr *requestResponse, err error) {
+// sendWith sends req on tc, if any. The data of the response of READ may be placed into dst, if any. (See readDirect)
+func (conn *conn) sendWith(req Packet, tc *treeConn, dst []byte, ctx context.Context) (rr *requestResponse, err error) {
 	conn.m.Lock()
 	defer conn.m.Unlock()
 
@@ -381,7 +863,7 @@ func (conn *conn) sendWith(req Packet, tc *treeConn, ctx context.Context) (rr *r
 		// do nothing
 	}
 
-	rr, err = conn.makeRequestResponse(req, tc, ctx)
+	rr, err = conn.makeRequestResponse(req, tc, dst, ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -395,6 +877,12 @@ func (conn *conn) sendWith(req Packet, tc *treeConn, ctx context.Context) (rr *r
 
 				return nil, &TransportError{err}
 			}
+
+			// the packets of NEGOTIATE and SESSION_SETUP are hashed with their responses for preauth integrity.
+			if cmd := req.Header().Command; cmd != SMB2_NEGOTIATE && cmd != SMB2_SESSION_SETUP {
+				putBuffer(rr.pkt)
+				rr.pkt = nil
+			}
 		case <-ctx.Done():
 			conn.outstandingRequests.pop(rr.msgId)
 
@@ -409,69 +897,127 @@ func (conn *conn) sendWith(req Packet, tc *treeConn, ctx context.Context) (rr *r
 	return rr, nil
 }
 
-func (conn *conn) makeRequestResponse(req Packet, tc *treeConn, ctx context.Context) (rr *requestResponse, err error) {
-	hdr := req.Header()
+// sendCancel sends a CANCEL request for the request identified by hdr.MessageId,
+// or by hdr.AsyncId if SMB2_FLAGS_ASYNC_COMMAND is set.
+// CANCEL doesn't consume credits and doesn't have a response.
+func (conn *conn) sendCancel(req *CancelRequest, tc *treeConn) error {
+	conn.m.Lock()
+	defer conn.m.Unlock()
 
-	var msgId uint64
+	if conn.err != nil {
+		return conn.err
+	}
 
-	if _, ok := req.(*CancelRequest); !ok {
-		msgId = conn.sequenceWindow
+	pkt, err := conn.encodePacket(req, tc)
+	if err != nil {
+		return err
+	}
 
-		creditCharge := hdr.CreditCharge
+	conn.write <- pkt
 
-		conn.sequenceWindow += uint64(creditCharge)
-		if hdr.CreditRequestResponse == 0 {
-			hdr.CreditRequestResponse = creditCharge
-		}
+	if err := <-conn.werr; err != nil {
+		return &TransportError{err}
+	}
+
+	putBuffer(pkt)
+
+	return nil
+}
 
-		hdr.CreditRequestResponse += conn.account.opening()
+func (conn *conn) makeRequestResponse(req Packet, tc *treeConn, dst []byte, ctx context.Context) (rr *requestResponse, err error) {
+	hdr := req.Header()
+
+	msgId := conn.sequenceWindow
+
+	creditCharge := hdr.CreditCharge
+
+	conn.sequenceWindow += uint64(creditCharge)
+	if hdr.CreditRequestResponse == 0 {
+		hdr.CreditRequestResponse = creditCharge
 	}
 
+	hdr.CreditRequestResponse += conn.account.opening()
+
 	hdr.MessageId = msgId
 
+	pkt, err := conn.encodePacket(req, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	rr = &requestResponse{
+		msgId:         msgId,
+		creditRequest: hdr.CreditRequestResponse,
+		pkt:           pkt,
+		tc:            tc,
+		ctx:           ctx,
+		recv:          make(chan []byte, 1),
+		dst:           dst,
+	}
+
+	conn.outstandingRequests.set(msgId, rr)
+
+	return rr, nil
+}
+
+// encodePacket fills the session and tree of the header, encodes req and signs or encrypts it.
+func (conn *conn) encodePacket(req Packet, tc *treeConn) (pkt []byte, err error) {
+	hdr := req.Header()
+
 	s := conn.session
 
 	if s != nil {
 		hdr.SessionId = s.sessionId
 
-		if tc != nil {
+		// TreeId and AsyncId share the same field.
+		if tc != nil && hdr.Flags&SMB2_FLAGS_ASYNC_COMMAND == 0 {
 			hdr.TreeId = tc.treeId
 		}
 	}
 
-	pkt := make([]byte, req.Size())
+	pkt = getBuffer(req.Size())
 
 	req.Encode(pkt)
 
+	// messages are signed before compression, and compressed before encryption.
 	if s != nil {
-		if _, ok := req.(*SessionSetupRequest); !ok {
-			if s.sessionFlags&SMB2_SESSION_FLAG_ENCRYPT_DATA != 0 || (tc != nil && tc.shareFlags&SMB2_SHAREFLAG_ENCRYPT_DATA != 0) {
-				pkt, err = s.encrypt(pkt)
+		if r, ok := req.(*SessionSetupRequest); ok {
+			if r.Flags&SMB2_SESSION_FLAG_BINDING != 0 {
+				pkt = s.sign(pkt)
+			}
+		} else {
+			if s.sessionFlags&SMB2_SESSION_FLAG_ENCRYPT_DATA != 0 || (tc != nil && tc.encryptData()) {
+				pkt = conn.tryCompress(req, pkt)
+
+				plain := pkt
+
+				pkt, err = s.encrypt(plain)
+				putBuffer(plain)
 				if err != nil {
-					return nil, &InternalError{err.Error()}
+					return nil, &EncryptionError{err.Error()}
 				}
 			} else {
 				if s.sessionFlags&(SMB2_SESSION_FLAG_IS_GUEST|SMB2_SESSION_FLAG_IS_NULL) == 0 {
 					pkt = s.sign(pkt)
 				}
+
+				pkt = conn.tryCompress(req, pkt)
 			}
 		}
 	}
 
-	rr = &requestResponse{
-		msgId:         msgId,
-		creditRequest: hdr.CreditRequestResponse,
-		pkt:           pkt,
-		ctx:           ctx,
-		recv:          make(chan []byte, 1),
-	}
-
-	conn.outstandingRequests.set(msgId, rr)
-
-	return rr, nil
+	return pkt, nil
 }
 
 func (conn *conn) recv(rr *requestResponse) ([]byte, error) {
+	var timeout <-chan time.Time
+
+	if t := conn.newTimer(rr.ctx); t != nil {
+		defer t.Stop()
+
+		timeout = t.C
+	}
+
 	select {
 	case pkt := <-rr.recv:
 		if rr.err != nil {
@@ -479,9 +1025,71 @@ func (conn *conn) recv(rr *requestResponse) ([]byte, error) {
 		}
 		return pkt, nil
 	case <-rr.ctx.Done():
-		conn.outstandingRequests.pop(rr.msgId)
+		conn.abandon(rr)
 
 		return nil, &ContextError{Err: rr.ctx.Err()}
+	case <-timeout:
+		conn.abandon(rr)
+
+		return nil, &ContextError{Err: context.DeadlineExceeded}
+	}
+}
+
+// abandon gives up waiting for rr after its context is done or the operation timeout expired.
+// CANCEL is sent for it, so that the server stops processing it rather than, say, holding a lock
+// or watching a directory forever. rr stays outstanding until its final response, usually STATUS_CANCELLED,
+// arrives, so that the credits of the response are granted and the response isn't reported as unknown.
+func (conn *conn) abandon(rr *requestResponse) {
+	// the caller is about to return, so the data of the response must not be placed into its buffer anymore.
+	rr.dstMu.Lock()
+	rr.dst = nil
+	rr.dstMu.Unlock()
+
+	atomic.StoreInt32(&rr.abandoned, 1)
+
+	go func() {
+		if err := conn.sendCancel(conn.cancelRequest(rr), rr.tc); err != nil {
+			conn.outstandingRequests.pop(rr.msgId)
+
+			conn.log().Warn("cancel failed", "err", err)
+		}
+	}()
+}
+
+// cancelRequest returns CANCEL for rr, which refers to its AsyncId once the server went async. ([MS-SMB2] 3.2.4.24)
+func (conn *conn) cancelRequest(rr *requestResponse) *CancelRequest {
+	req := new(CancelRequest)
+	req.MessageId = rr.msgId
+	if asyncId := atomic.LoadUint64(&rr.asyncId); asyncId != 0 {
+		req.Flags |= SMB2_FLAGS_ASYNC_COMMAND
+		req.AsyncId = asyncId
+	}
+	return req
+}
+
+// recvWithCancel is like recv, but sends CANCEL for the request when cancel is closed,
+// then keeps waiting for the final response, which is usually STATUS_CANCELLED.
+// The request may have completed before the server processes CANCEL,
+// so the caller must check the status of the response.
+func (conn *conn) recvWithCancel(rr *requestResponse, cancel <-chan struct{}) ([]byte, error) {
+	for {
+		select {
+		case pkt := <-rr.recv:
+			if rr.err != nil {
+				return nil, rr.err
+			}
+			return pkt, nil
+		case <-cancel:
+			cancel = nil
+
+			if err := conn.sendCancel(conn.cancelRequest(rr), rr.tc); err != nil {
+				return nil, err
+			}
+		case <-rr.ctx.Done():
+			conn.abandon(rr)
+
+			return nil, &ContextError{Err: rr.ctx.Err()}
+		}
 	}
 }
 
@@ -501,17 +1109,75 @@ func (conn *conn) runSender() {
 func (conn *conn) runReciever() {
 	var err error
 
+	var buf []byte // unused part of the current receive buffer
+
 	for {
 		n, e := conn.t.ReadSize()
 		if e != nil {
+			// a timeout after part of the header has been read isn't reported as such by the transport,
+			// since the framing is lost.
+			if isTimeout(e) && !conn.outstandingRequests.expectsData() {
+				// nothing is due from the server, keep waiting.
+				continue
+			}
+
 			err = &TransportError{e}
 
 			goto exit
 		}
 
-		pkt := make([]byte, n)
+		var pkt []byte
+
+		// owned is set if pkt has a pooled buffer of its own, which its consumer may release.
+		var owned bool
+
+		// data is the data of a READ response placed into the buffer of its caller, which follows pkt.
+		var data []byte
+
+		if n > conn.recvBufferSize {
+			// the header is read first to find out whether the data can be placed into the buffer of the caller.
+			if len(buf) < readResponseHeaderSize {
+				buf = make([]byte, conn.recvBufferSize)
+			}
+
+			hdr := buf[:readResponseHeaderSize:readResponseHeaderSize]
+			buf = buf[readResponseHeaderSize:]
 
-		_, e = conn.t.Read(pkt)
+			_, e = conn.t.Read(hdr)
+			if e != nil {
+				err = &TransportError{e}
+
+				goto exit
+			}
+
+			data, e = conn.readDirect(hdr, n)
+			if e != nil {
+				err = &TransportError{e}
+
+				goto exit
+			}
+
+			if data != nil {
+				pkt = hdr
+			} else {
+				pkt = getBuffer(n)
+				owned = true
+
+				copy(pkt, hdr)
+
+				_, e = conn.t.Read(pkt[readResponseHeaderSize:])
+			}
+		} else {
+			if n > len(buf) {
+				buf = make([]byte, conn.recvBufferSize)
+			}
+
+			// the capacity is limited so that appending to pkt never overwrites the next packet.
+			pkt = buf[:n:n]
+			buf = buf[n:]
+
+			_, e = conn.t.Read(pkt)
+		}
 		if e != nil {
 			err = &TransportError{e}
 
@@ -524,23 +1190,45 @@ func (conn *conn) runReciever() {
 
 		if hasSession {
 			pkt, e, isEncrypted = conn.tryDecrypt(pkt)
+			owned = owned && !isEncrypted
 			if e != nil {
-				logger.Println("skip:", e)
+				if _, ok := e.(*EncryptionError); ok {
+					// the response can't be matched with its request, which would wait forever.
+					err = e
+
+					goto exit
+				}
+
+				conn.log().Warn("skip", "err", e)
 
 				continue
 			}
+		}
+
+		raw := pkt
+
+		pkt, e = conn.tryDecompress(pkt)
+		if e != nil {
+			// the response can't be matched with its request, which would wait forever.
+			err = e
 
+			goto exit
+		}
+
+		owned = owned && len(pkt) == len(raw) && &pkt[0] == &raw[0]
+
+		if hasSession {
 			p := PacketCodec(pkt)
 			if s := conn.session; s != nil {
 				if s.sessionId != p.SessionId() {
-					logger.Println("skip:", &InvalidResponseError{"unknown session id"})
+					conn.log().Warn("skip", "err", &InvalidResponseError{"unknown session id"})
 
 					continue
 				}
 
 				if tc, ok := s.treeConnTables[p.TreeId()]; ok {
 					if tc.treeId != p.TreeId() {
-						logger.Println("skip:", &InvalidResponseError{"unknown tree id"})
+						conn.log().Warn("skip", "err", &InvalidResponseError{"unknown tree id"})
 
 						continue
 					}
@@ -555,17 +1243,18 @@ func (conn *conn) runReciever() {
 
 			if off := p.NextCommand(); off != 0 {
 				pkt, next = pkt[:off], pkt[off:]
+				owned = false
 			} else {
 				next = nil
 			}
 
 			if hasSession {
-				e = conn.tryVerify(pkt, isEncrypted)
+				e = conn.tryVerify(pkt, data, isEncrypted)
 			}
 
-			e = conn.tryHandle(pkt, e)
+			e = conn.tryHandleOwned(pkt, e, owned)
 			if e != nil {
-				logger.Println("skip:", e)
+				conn.log().Warn("skip", "err", e)
 			}
 
 			if next == nil {
@@ -581,12 +1270,17 @@ exit:
 	case <-conn.rdone:
 		err = nil
 	default:
-		logger.Println("error:", err)
+		conn.log().Error("receiver stopped", "err", err)
 	}
 
 	conn.m.Lock()
 	defer conn.m.Unlock()
 
+	if conn.err != nil {
+		// the connection was aborted.
+		err = conn.err
+	}
+
 	conn.outstandingRequests.shutdown(err)
 
 	conn.err = err
@@ -594,6 +1288,28 @@ exit:
 	close(conn.wdone)
 }
 
+// close stops the receiver, and the sender with it, and closes the transport.
+// The outstanding and following requests fail.
+func (conn *conn) close() {
+	select {
+	case conn.rdone <- struct{}{}:
+	default:
+	}
+
+	conn.t.Close()
+}
+
+// abort closes the connection, failing the outstanding and following requests with err.
+func (conn *conn) abort(err error) {
+	conn.m.Lock()
+	if conn.err == nil {
+		conn.err = err
+	}
+	conn.m.Unlock()
+
+	conn.t.Close()
+}
+
 func accept(cmd uint16, pkt []byte) (res []byte, err error) {
 	p := PacketCodec(pkt)
 	if command := p.Command(); cmd != command {
@@ -611,6 +1327,12 @@ func accept(cmd uint16, pkt []byte) (res []byte, err error) {
 		return nil, os.ErrNotExist
 	case STATUS_ACCESS_DENIED, STATUS_CANNOT_DELETE:
 		return nil, os.ErrPermission
+	case STATUS_NOT_A_DIRECTORY:
+		return nil, ErrNotDirectory
+	case STATUS_FILE_IS_A_DIRECTORY:
+		return nil, ErrIsDirectory
+	case STATUS_FILE_ENCRYPTED:
+		return nil, ErrEncrypted
 	}
 
 	switch cmd {
@@ -618,6 +1340,10 @@ func accept(cmd uint16, pkt []byte) (res []byte, err error) {
 		if status == STATUS_MORE_PROCESSING_REQUIRED {
 			return p.Data(), nil
 		}
+	case SMB2_TREE_CONNECT:
+		if status == STATUS_BAD_NETWORK_NAME || status == STATUS_BAD_NETWORK_PATH {
+			return nil, ErrShareNotFound
+		}
 	case SMB2_QUERY_INFO:
 		if status == STATUS_BUFFER_OVERFLOW {
 			return nil, &ResponseError{Code: uint32(status)}
@@ -630,6 +1356,10 @@ func accept(cmd uint16, pkt []byte) (res []byte, err error) {
 		}
 	case SMB2_READ:
 		if status == STATUS_BUFFER_OVERFLOW {
+			// a part of a pipe message.
+			if !ReadResponseDecoder(p.Data()).IsInvalid() {
+				return p.Data(), &ResponseError{Code: uint32(status)}
+			}
 			return nil, &ResponseError{Code: uint32(status)}
 		}
 	case SMB2_CHANGE_NOTIFY:
@@ -672,16 +1402,62 @@ func acceptError(status uint32, res []byte) error {
 	return &ResponseError{Code: status, data: [][]byte{eData}}
 }
 
+// readResponseHeaderSize is the size of the SMB2 header and the READ response header, which the data follows.
+const readResponseHeaderSize = 64 + 16
+
+// readDirect reads the rest of a packet of n bytes starting with hdr into the buffer of its request,
+// if the packet is a successful READ response in plain text, whose data fits into the buffer,
+// and returns the data. Otherwise nothing is read, and the packet is received as usual.
+// The request can't be abandoned while its buffer is being filled. (See abandon)
+func (conn *conn) readDirect(hdr []byte, n int) (data []byte, err error) {
+	p := PacketCodec(hdr)
+	if p.IsInvalid() || p.Command() != SMB2_READ || NtStatus(p.Status()) != STATUS_SUCCESS || p.NextCommand() != 0 {
+		return nil, nil
+	}
+
+	r := ReadResponseDecoder(p.Data())
+	if r.StructureSize() != 17 || int(r.DataOffset()) != len(hdr) || int(r.DataLength()) != n-len(hdr) {
+		return nil, nil
+	}
+
+	rr, ok := conn.outstandingRequests.get(p.MessageId())
+	if !ok {
+		return nil, nil
+	}
+
+	rr.dstMu.Lock()
+	defer rr.dstMu.Unlock()
+
+	if len(rr.dst) < n-len(hdr) {
+		return nil, nil
+	}
+
+	data = rr.dst[:n-len(hdr)]
+
+	_, err = conn.t.Read(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rr.direct = true
+
+	return data, nil
+}
+
 func (conn *conn) tryDecrypt(pkt []byte) ([]byte, error, bool) {
 	p := PacketCodec(pkt)
 	if p.IsInvalid() {
+		if !CompressionTransformCodec(pkt).IsInvalid() {
+			return pkt, nil, false
+		}
+
 		t := TransformCodec(pkt)
 		if t.IsInvalid() {
 			return nil, &InvalidResponseError{"broken packet header format"}, false
 		}
 
 		if t.Flags() != Encrypted {
-			return nil, &InvalidResponseError{"encrypted flag is not on"}, false
+			return nil, &EncryptionError{"encrypted flag is not on"}, false
 		}
 
 		if conn.session == nil || conn.session.sessionId != t.SessionId() {
@@ -690,7 +1466,7 @@ func (conn *conn) tryDecrypt(pkt []byte) ([]byte, error, bool) {
 
 		pkt, err := conn.session.decrypt(pkt)
 		if err != nil {
-			return nil, &InvalidResponseError{err.Error()}, false
+			return nil, &EncryptionError{err.Error()}, false
 		}
 
 		return pkt, nil, true
@@ -699,17 +1475,31 @@ func (conn *conn) tryDecrypt(pkt []byte) ([]byte, error, bool) {
 	return pkt, nil, false
 }
 
-func (conn *conn) tryVerify(pkt []byte, isEncrypted bool) error {
+// tryVerify verifies the signature of pkt, which is followed by data if the receiver placed it elsewhere.
+func (conn *conn) tryVerify(pkt, data []byte, isEncrypted bool) error {
 	p := PacketCodec(pkt)
 
 	msgId := p.MessageId()
 
 	if msgId != 0xFFFFFFFFFFFFFFFF {
+		if !isEncrypted {
+			if conn.requireEncryption {
+				if conn.session != nil && conn.session.sessionId == p.SessionId() {
+					return &InvalidResponseError{"encryption required"}
+				}
+			}
+
+			// the share may require encryption even if the session doesn't.
+			if rr, ok := conn.outstandingRequests.get(msgId); ok && rr.tc != nil && rr.tc.encryptData() {
+				return &InvalidResponseError{"encryption required"}
+			}
+		}
+
 		if p.Flags()&SMB2_FLAGS_SIGNED != 0 {
 			if conn.session == nil || conn.session.sessionId != p.SessionId() {
 				return &InvalidResponseError{"unknown session id returned"}
 			} else {
-				if !conn.session.verify(pkt) {
+				if !conn.session.verifyParts(pkt, data) {
 					return &InvalidResponseError{"unverified packet returned"}
 				}
 			}
@@ -730,24 +1520,44 @@ func (conn *conn) tryVerify(pkt []byte, isEncrypted bool) error {
 }
 
 func (conn *conn) tryHandle(pkt []byte, e error) error {
+	return conn.tryHandleOwned(pkt, e, false)
+}
+
+// tryHandleOwned is like tryHandle, but if owned is set, pkt has a pooled buffer of its own
+// that is handed over to the request. (See requestResponse.release)
+func (conn *conn) tryHandleOwned(pkt []byte, e error, owned bool) error {
 	p := PacketCodec(pkt)
 
 	msgId := p.MessageId()
 
-	rr, ok := conn.outstandingRequests.pop(msgId)
+	var rr *requestResponse
+	var ok bool
+
+	pending := e == nil && NtStatus(p.Status()) == STATUS_PENDING
+	if pending {
+		rr, ok = conn.outstandingRequests.interim(msgId, p.AsyncId())
+	} else {
+		rr, ok = conn.outstandingRequests.pop(msgId)
+	}
+
 	switch {
 	case !ok:
+		if msgId == 0xFFFFFFFFFFFFFFFF && p.Command() == SMB2_OPLOCK_BREAK {
+			return conn.handleOplockBreak(pkt)
+		}
 		return &InvalidResponseError{"unknown message id returned"}
 	case e != nil:
 		rr.err = e
 
 		close(rr.recv)
-	case NtStatus(p.Status()) == STATUS_PENDING:
-		rr.asyncId = p.AsyncId()
-		conn.account.charge(p.CreditResponse(), rr.creditRequest)
-		conn.outstandingRequests.set(msgId, rr)
+	case pending:
+		conn.account.grant(p.CreditResponse(), rr.creditRequest)
 	default:
-		conn.account.charge(p.CreditResponse(), rr.creditRequest)
+		conn.account.grant(p.CreditResponse(), rr.creditRequest)
+
+		if owned {
+			rr.buf = pkt
+		}
 
 		rr.recv <- pkt
 	}
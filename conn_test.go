@@ -0,0 +1,855 @@
+package smb2
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestNegotiatorContexts(t *testing.T) {
+	n := &Negotiator{}
+
+	contexts, err := n.contexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc := contexts[0].(*HashContext)
+	cc := contexts[1].(*CipherContext)
+	sc := contexts[2].(*SigningContext)
+
+	if !reflect.DeepEqual(hc.HashAlgorithms, clientHashAlgorithms) {
+		t.Errorf("unexpected default hash algorithms: %v", hc.HashAlgorithms)
+	}
+	if len(hc.HashSalt) != 32 {
+		t.Errorf("unexpected default hash salt length: %d", len(hc.HashSalt))
+	}
+	if !reflect.DeepEqual(cc.Ciphers, clientCiphers) {
+		t.Errorf("unexpected default ciphers: %v", cc.Ciphers)
+	}
+	if !reflect.DeepEqual(sc.SigningAlgorithms, clientSigningAlgorithms) {
+		t.Errorf("unexpected default signing algorithms: %v", sc.SigningAlgorithms)
+	}
+
+	n = &Negotiator{
+		Ciphers:           []uint16{CipherAES128CCM},
+		SigningAlgorithms: []uint16{SigningAES128CMAC},
+		HashSalt:          []byte("salt"),
+	}
+
+	contexts, err = n.contexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc = contexts[0].(*HashContext)
+	cc = contexts[1].(*CipherContext)
+	sc = contexts[2].(*SigningContext)
+
+	if !bytes.Equal(hc.HashSalt, []byte("salt")) {
+		t.Errorf("unexpected hash salt: %v", hc.HashSalt)
+	}
+	if !reflect.DeepEqual(cc.Ciphers, []uint16{CipherAES128CCM}) {
+		t.Errorf("unexpected ciphers: %v", cc.Ciphers)
+	}
+	if !reflect.DeepEqual(sc.SigningAlgorithms, []uint16{SigningAES128CMAC}) {
+		t.Errorf("unexpected signing algorithms: %v", sc.SigningAlgorithms)
+	}
+
+	for _, n := range []*Negotiator{
+		{Ciphers: []uint16{0xffff}},
+		{HashAlgorithms: []uint16{0xffff}},
+		{SigningAlgorithms: []uint16{HMACSHA256}},
+	} {
+		_, err = n.contexts()
+		if err == nil {
+			t.Errorf("expected error for %+v", n)
+		}
+	}
+}
+
+type packetTransport struct {
+	pkts [][]byte
+}
+
+func (t *packetTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (t *packetTransport) Close() error                { return nil }
+
+func (t *packetTransport) ReadSize() (int, error) {
+	if len(t.pkts) == 0 {
+		return 0, io.EOF
+	}
+	return len(t.pkts[0]), nil
+}
+
+func (t *packetTransport) Read(p []byte) (int, error) {
+	n := copy(p, t.pkts[0])
+	t.pkts = t.pkts[1:]
+	return n, nil
+}
+
+func newEncryptedTestConn(t *testing.T) (*conn, *session) {
+	ciph, err := aes.NewCipher([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCMWithNonceSize(ciph, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		recvBufferSize:      1024,
+		rdone:               make(chan struct{}),
+		wdone:               make(chan struct{}),
+	}
+
+	s := &session{
+		conn:      c,
+		sessionId: 1,
+		encrypter: aead,
+		decrypter: aead,
+	}
+
+	c.session = s
+	c.enableSession()
+
+	return c, s
+}
+
+func newTestPacket(msgId uint64) []byte {
+	pkt := make([]byte, 64)
+	p := PacketCodec(pkt)
+	p.SetProtocolId()
+	p.SetStructureSize()
+	p.SetMessageId(msgId)
+	p.SetSessionId(1)
+	return pkt
+}
+
+func TestTryDecrypt(t *testing.T) {
+	c, s := newEncryptedTestConn(t)
+
+	pkt := newTestPacket(1)
+
+	enc, err := s.encrypt(append([]byte{}, pkt...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err, isEncrypted := c.tryDecrypt(append([]byte{}, enc...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted || !bytes.Equal(dec, pkt) {
+		t.Error("unexpected decrypted packet")
+	}
+
+	for _, corrupt := range []func(t TransformCodec){
+		func(t TransformCodec) { t.Signature()[0] ^= 0xff },
+		func(t TransformCodec) { t.Nonce()[0] ^= 0xff },
+		func(t TransformCodec) { t.SetOriginalMessageSize(t.OriginalMessageSize() + 1) },
+		func(t TransformCodec) { t.SetFlags(0) },
+		func(t TransformCodec) { t.EncryptedData()[0] ^= 0xff },
+	} {
+		bad := append([]byte{}, enc...)
+		corrupt(TransformCodec(bad))
+
+		_, err, _ := c.tryDecrypt(bad)
+		if _, ok := err.(*EncryptionError); !ok {
+			t.Errorf("expected EncryptionError, got %v", err)
+		}
+	}
+}
+
+func TestRequireEncryption(t *testing.T) {
+	c, s := newEncryptedTestConn(t)
+
+	c.dialect = SMB311
+
+	if !s.supportsEncryption() {
+		t.Error("expected encryption to be supported")
+	}
+
+	c.dialect = SMB300
+
+	if s.supportsEncryption() {
+		t.Error("expected encryption not to be supported without the capability")
+	}
+
+	c.capabilities = SMB2_GLOBAL_CAP_ENCRYPTION
+
+	if !s.supportsEncryption() {
+		t.Error("expected encryption to be supported with the capability")
+	}
+
+	c.requireEncryption = true
+
+	if err := c.tryVerify(newTestPacket(1), nil, true); err != nil {
+		t.Errorf("expected an encrypted response to be accepted, got %v", err)
+	}
+	if _, ok := c.tryVerify(newTestPacket(1), nil, false).(*InvalidResponseError); !ok {
+		t.Error("expected an unencrypted response to be rejected")
+	}
+
+	// oplock breaks aren't checked, as with signing.
+	if err := c.tryVerify(newTestPacket(0xFFFFFFFFFFFFFFFF), nil, false); err != nil {
+		t.Errorf("expected an oplock break to be accepted, got %v", err)
+	}
+
+	s.encrypter = nil
+
+	if s.supportsEncryption() {
+		t.Error("expected encryption not to be supported without keys")
+	}
+}
+
+func TestRequireShareEncryption(t *testing.T) {
+	c, s := newEncryptedTestConn(t)
+
+	tc := &treeConn{session: s, treeId: 5, shareFlags: SMB2_SHAREFLAG_ENCRYPT_DATA}
+
+	c.outstandingRequests.set(1, &requestResponse{msgId: 1, tc: tc})
+	c.outstandingRequests.set(2, &requestResponse{msgId: 2, tc: &treeConn{session: s, treeId: 6}})
+
+	// the session doesn't require encryption, but the share of the request does.
+	if err := c.tryVerify(newTestPacket(1), nil, true); err != nil {
+		t.Errorf("expected an encrypted response to be accepted, got %v", err)
+	}
+	if _, ok := c.tryVerify(newTestPacket(1), nil, false).(*InvalidResponseError); !ok {
+		t.Error("expected an unencrypted response to be rejected")
+	}
+
+	// the same goes for a share encrypted by SetEncryption.
+	tc.shareFlags = 0
+	atomic.StoreInt32(&tc.forceEncryption, 1)
+
+	if _, ok := c.tryVerify(newTestPacket(1), nil, false).(*InvalidResponseError); !ok {
+		t.Error("expected an unencrypted response to be rejected")
+	}
+
+	// other shares aren't affected.
+	if err := c.tryVerify(newTestPacket(2), nil, false); err != nil {
+		t.Errorf("expected an unencrypted response to be accepted, got %v", err)
+	}
+}
+
+func TestSetEncryption(t *testing.T) {
+	c, s := newEncryptedTestConn(t)
+
+	c.dialect = SMB311
+
+	key := []byte("0123456789abcdef")
+	s.signer = hmac.New(sha256.New, key)
+
+	fs := &Share{treeConn: &treeConn{session: s, treeId: 5}, ctx: context.Background()}
+
+	encode := func() []byte {
+		req := &FlushRequest{FileId: &FileId{}}
+		req.CreditCharge = 1
+		pkt, err := c.encodePacket(req, fs.treeConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+
+	if pkt := encode(); !TransformCodec(pkt).IsInvalid() {
+		t.Error("expected a signed request")
+	}
+
+	if err := fs.SetEncryption(true); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := encode(); TransformCodec(pkt).IsInvalid() {
+		t.Error("expected an encrypted request")
+	}
+	if ctc := fs.treeConn.channel(); !ctc.encryptData() {
+		t.Error("expected the channels of the share to be encrypted")
+	}
+
+	if err := fs.SetEncryption(false); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := encode(); !TransformCodec(pkt).IsInvalid() {
+		t.Error("expected a signed request")
+	}
+
+	c.dialect = SMB210
+	s.encrypter = nil
+
+	if err := fs.SetEncryption(true); err != ErrEncryptionNotSupported {
+		t.Errorf("expected %v, got %v", ErrEncryptionNotSupported, err)
+	}
+}
+
+func TestDecryptionFailureClosesConn(t *testing.T) {
+	c, s := newEncryptedTestConn(t)
+
+	enc, err := s.encrypt(newTestPacket(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TransformCodec(enc).Signature()[0] ^= 0xff
+
+	c.t = &packetTransport{pkts: [][]byte{enc}}
+
+	rr := &requestResponse{
+		msgId: 1,
+		ctx:   context.Background(),
+		recv:  make(chan []byte, 1),
+	}
+
+	c.outstandingRequests.set(rr.msgId, rr)
+
+	c.runReciever()
+
+	_, err = c.recv(rr)
+	if _, ok := err.(*EncryptionError); !ok {
+		t.Errorf("expected EncryptionError, got %v", err)
+	}
+	if _, ok := c.err.(*EncryptionError); !ok {
+		t.Errorf("expected connection to fail with EncryptionError, got %v", c.err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, chained := range []bool{false, true} {
+		c := &conn{
+			compressionIds:     []uint16{SMB2_COMPRESSION_LZ77, SMB2_COMPRESSION_LZ77_HUFFMAN, SMB2_COMPRESSION_PATTERN_V1},
+			compressionChained: chained,
+			maxWriteSize:       1024 * 1024,
+		}
+
+		req := &WriteRequest{
+			FileId: &FileId{},
+			Data:   bytes.Repeat([]byte("0123456789"), 1000),
+		}
+		pkt := make([]byte, req.Size())
+		req.Encode(pkt)
+
+		cpkt := c.tryCompress(req, pkt)
+		if len(cpkt) >= len(pkt) {
+			t.Fatalf("chained=%v: expected compression, got %d bytes from %d", chained, len(cpkt), len(pkt))
+		}
+		if CompressionTransformCodec(cpkt).IsInvalid() {
+			t.Fatalf("chained=%v: expected compression transform header", chained)
+		}
+
+		ret, err := c.tryDecompress(cpkt)
+		if err != nil {
+			t.Fatalf("chained=%v: %v", chained, err)
+		}
+		if !bytes.Equal(ret, pkt) {
+			t.Errorf("chained=%v: round trip failed", chained)
+		}
+
+		// incompressible or small data is sent as is.
+		req.Data = []byte("small")
+		pkt = make([]byte, req.Size())
+		req.Encode(pkt)
+
+		if cpkt := c.tryCompress(req, pkt); !bytes.Equal(cpkt, pkt) {
+			t.Errorf("chained=%v: unexpected compression of small data", chained)
+		}
+
+		// uncompressed packets are passed through.
+		ret, err = c.tryDecompress(pkt)
+		if err != nil || !bytes.Equal(ret, pkt) {
+			t.Errorf("chained=%v: unexpected result for uncompressed packet: %v", chained, err)
+		}
+	}
+}
+
+func TestDecompressPatternV1(t *testing.T) {
+	c := &conn{
+		compressionIds:     []uint16{SMB2_COMPRESSION_PATTERN_V1},
+		compressionChained: true,
+		maxReadSize:        1024 * 1024,
+	}
+
+	hdr := newTestPacket(1)
+
+	pkt := make([]byte, 8+8+len(hdr)+8+8)
+
+	t0 := CompressionTransformCodec(pkt)
+	t0.SetProtocolId()
+	t0.SetOriginalCompressedSegmentSize(uint32(len(hdr) + 1000))
+
+	h := CompressionPayloadHeaderCodec(pkt[8:])
+	h.SetCompressionAlgorithm(SMB2_COMPRESSION_NONE)
+	h.SetFlags(SMB2_COMPRESSION_FLAG_CHAINED)
+	h.SetLength(uint32(len(hdr)))
+	copy(h[8:], hdr)
+
+	h = CompressionPayloadHeaderCodec(pkt[8+8+len(hdr):])
+	h.SetCompressionAlgorithm(SMB2_COMPRESSION_PATTERN_V1)
+	h.SetLength(8)
+	h[8] = 'x'
+	h[12] = 0xe8 // 1000 repetitions
+	h[13] = 0x03
+
+	ret, err := c.tryDecompress(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, append(hdr, bytes.Repeat([]byte("x"), 1000)...)) {
+		t.Error("unexpected decompressed message")
+	}
+
+	// the announced size doesn't match.
+	t0.SetOriginalCompressedSegmentSize(uint32(len(hdr) + 999))
+	if _, err := c.tryDecompress(pkt); err == nil {
+		t.Error("expected error for inconsistent size")
+	}
+
+	// a broken payload header.
+	t0.SetOriginalCompressedSegmentSize(uint32(len(hdr) + 1000))
+	h.SetLength(100)
+	if _, err := c.tryDecompress(pkt); err == nil {
+		t.Error("expected error for broken payload header")
+	}
+
+	// compression wasn't negotiated.
+	h.SetLength(8)
+	c.compressionIds = nil
+	if _, err := c.tryDecompress(pkt); err == nil {
+		t.Error("expected error without negotiated compression")
+	}
+}
+
+func TestCompressionContext(t *testing.T) {
+	cc := &CompressionContext{
+		Flags:                 SMB2_COMPRESSION_CAPABILITIES_FLAG_CHAINED,
+		CompressionAlgorithms: clientCompressionAlgorithms,
+	}
+
+	buf := make([]byte, cc.Size())
+	cc.Encode(buf)
+
+	ctx := NegotiateContextDecoder(buf)
+	if ctx.IsInvalid() || ctx.ContextType() != SMB2_COMPRESSION_CAPABILITIES {
+		t.Fatal("unexpected negotiate context")
+	}
+
+	d := CompressionContextDataDecoder(ctx.Data())
+	if d.IsInvalid() {
+		t.Fatal("unexpected compression context data")
+	}
+	if d.Flags() != SMB2_COMPRESSION_CAPABILITIES_FLAG_CHAINED {
+		t.Errorf("unexpected flags: %d", d.Flags())
+	}
+	if !reflect.DeepEqual(d.CompressionAlgorithms(), clientCompressionAlgorithms) {
+		t.Errorf("unexpected algorithms: %v", d.CompressionAlgorithms())
+	}
+
+	n := &Negotiator{compression: true}
+
+	contexts, err := n.contexts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := contexts[len(contexts)-1].(*CompressionContext); !ok {
+		t.Error("expected compression context")
+	}
+}
+
+func TestRecvCancelsAbandonedRequest(t *testing.T) {
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rr := &requestResponse{
+		msgId:   5,
+		asyncId: 42,
+		ctx:     ctx,
+		recv:    make(chan []byte, 1),
+	}
+
+	c.outstandingRequests.set(rr.msgId, rr)
+
+	if _, err := c.recv(rr); err == nil {
+		t.Fatal("expected a context error")
+	}
+
+	pkt := <-c.write
+	c.werr <- nil
+
+	p := PacketCodec(pkt)
+	if p.Command() != SMB2_CANCEL || p.MessageId() != 5 || p.Flags()&SMB2_FLAGS_ASYNC_COMMAND == 0 || p.AsyncId() != 42 {
+		t.Errorf("unexpected cancel request: %x", pkt)
+	}
+
+	// the final response is still consumed.
+	res := newTestPacket(5)
+	PacketCodec(res).SetStatus(uint32(STATUS_CANCELLED))
+
+	if err := c.tryHandle(res, nil); err != nil {
+		t.Error(err)
+	}
+	if _, ok := c.outstandingRequests.pop(rr.msgId); ok {
+		t.Error("expected the request to be completed")
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+		operationTimeout:    10 * time.Millisecond,
+	}
+
+	rr := &requestResponse{
+		msgId: 1,
+		ctx:   context.Background(),
+		recv:  make(chan []byte, 1),
+	}
+
+	c.outstandingRequests.set(rr.msgId, rr)
+
+	_, err := c.recv(rr)
+	if err, ok := err.(*ContextError); !ok || !err.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	if pkt := <-c.write; PacketCodec(pkt).Command() != SMB2_CANCEL {
+		t.Errorf("unexpected cancel request: %x", pkt)
+	}
+	c.werr <- nil
+
+	// contexts with a deadline are not limited.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	if timer := c.newTimer(ctx); timer != nil {
+		t.Error("expected no timer")
+	}
+}
+
+func TestDialContextTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// the server accepts the connection, but never responds to NEGOTIATE.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, server)
+		close(closed)
+	}()
+
+	d := &Dialer{Initiator: &NTLMInitiator{User: "user", Password: "password"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := d.DialContext(ctx, client)
+	if err, ok := err.(*ContextError); !ok || err.Err != context.DeadlineExceeded {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestBindChannelClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// the server negotiates the dialect of the session, but without multi-channel.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+
+		srv := &testFileServer{conn: server}
+
+		var size [4]byte
+		if _, err := io.ReadFull(server, size[:]); err != nil {
+			return
+		}
+		pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(server, pkt); err != nil {
+			return
+		}
+
+		srv.respond(pkt, &NegotiateResponse{
+			DialectRevision: SMB300,
+			MaxTransactSize: 65536,
+			MaxReadSize:     65536,
+			MaxWriteSize:    65536,
+			SystemTime:      &Filetime{},
+			ServerStartTime: &Filetime{},
+		})
+
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	s := &session{
+		conn:       &conn{dialect: SMB300, capabilities: SMB2_GLOBAL_CAP_MULTI_CHANNEL},
+		dialer:     &Dialer{},
+		sessionKey: make([]byte, 16),
+	}
+
+	if err := s.bindChannel(client, context.Background()); err != ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to be closed")
+	}
+
+	// the sender and the receiver of the channel exit.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines, got %d", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+		wdone:               make(chan struct{}),
+	}
+
+	tc := &treeConn{treeId: 1}
+
+	// a pending CHANGE_NOTIFY on the tree and a request on another tree.
+	notify := &requestResponse{msgId: 1, asyncId: 5, tc: tc}
+	other := &requestResponse{msgId: 2, tc: &treeConn{treeId: 2}}
+
+	c.outstandingRequests.set(notify.msgId, notify)
+	c.outstandingRequests.set(other.msgId, other)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.drain(tc, context.Background())
+	}()
+
+	pkt := <-c.write
+	if p := PacketCodec(pkt); p.Command() != SMB2_CANCEL || p.AsyncId() != 5 {
+		t.Errorf("unexpected cancel request: %x", pkt)
+	}
+	c.werr <- nil
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected drain to wait for the response, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// STATUS_CANCELLED arrives.
+	c.outstandingRequests.pop(notify.msgId)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err, ok := c.drain(nil, ctx).(*ContextError); !ok || !err.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	close(c.wdone)
+
+	if err := c.drain(nil, context.Background()); err != nil {
+		t.Errorf("expected no error on a closed connection, got %v", err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+		wdone:               make(chan struct{}),
+	}
+
+	// a synchronous request the server never answers.
+	rr := &requestResponse{msgId: 1, recv: make(chan []byte, 1)}
+
+	c.outstandingRequests.set(rr.msgId, rr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.drainFor(nil, context.Background(), 10*time.Millisecond)
+	}()
+
+	// it's cancelled once the timeout expires.
+	pkt := <-c.write
+	if p := PacketCodec(pkt); p.Command() != SMB2_CANCEL || p.MessageId() != 1 {
+		t.Errorf("unexpected cancel request: %x", pkt)
+	}
+	c.werr <- nil
+
+	// and failed after another timeout.
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain doesn't return")
+	}
+
+	if _, ok := c.outstandingRequests.get(rr.msgId); ok {
+		t.Error("request should not be outstanding")
+	}
+	if _, ok := <-rr.recv; ok {
+		t.Error("request should be failed")
+	}
+	if err, ok := rr.err.(*ContextError); !ok || !err.Timeout() {
+		t.Errorf("expected a timeout, got %v", rr.err)
+	}
+}
+
+func TestInterimResponse(t *testing.T) {
+	c := &conn{
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+	}
+
+	rr := &requestResponse{msgId: 1, recv: make(chan []byte, 1)}
+
+	c.outstandingRequests.set(rr.msgId, rr)
+
+	_, changed := c.outstandingRequests.of(nil)
+
+	pkt := newTestPacket(1)
+	p := PacketCodec(pkt)
+	p.SetStatus(uint32(STATUS_PENDING))
+	p.SetFlags(SMB2_FLAGS_SERVER_TO_REDIR | SMB2_FLAGS_ASYNC_COMMAND)
+	p.SetAsyncId(5)
+
+	if err := c.tryHandle(pkt, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// drain is woken up to cancel the request, which is still outstanding meanwhile.
+	select {
+	case <-changed:
+	default:
+		t.Error("waiters should be woken up")
+	}
+	if rrs, _ := c.outstandingRequests.of(nil); len(rrs) != 1 || rrs[0] != rr {
+		t.Errorf("request should stay outstanding, got %v", rrs)
+	}
+	if rr.asyncId != 5 {
+		t.Errorf("unexpected async id: %d", rr.asyncId)
+	}
+}
+
+func TestAcceptPartialPipeRead(t *testing.T) {
+	res := &ReadResponse{Data: []byte("part of a message")}
+	pkt := make([]byte, res.Size())
+	res.Encode(pkt)
+	PacketCodec(pkt).SetStatus(uint32(STATUS_BUFFER_OVERFLOW))
+
+	data, err := accept(SMB2_READ, pkt)
+	if rerr, ok := err.(*ResponseError); !ok || NtStatus(rerr.Code) != STATUS_BUFFER_OVERFLOW {
+		t.Fatalf("expected STATUS_BUFFER_OVERFLOW, got %v", err)
+	}
+	if r := ReadResponseDecoder(data); r.IsInvalid() || string(r.Data()) != "part of a message" {
+		t.Errorf("unexpected data: %x", data)
+	}
+}
+
+func TestMinDialect(t *testing.T) {
+	for _, tc := range []struct {
+		n       Negotiator
+		dialect uint16
+		ok      bool
+	}{
+		{Negotiator{}, SMB210, true},
+		{Negotiator{MinDialect: SMB300}, SMB210, false},
+		{Negotiator{MinDialect: SMB300}, SMB2, false},
+		{Negotiator{NoDowngrade: true}, SMB202, false},
+		{Negotiator{MinDialect: SMB302}, SMB300, false},
+		{Negotiator{MinDialect: SMB300}, SMB302, true},
+		{Negotiator{}, 0x222, false}, // not offered
+		{Negotiator{SpecifiedDialect: SMB302}, SMB300, false},
+	} {
+		client, server := net.Pipe()
+
+		go func(dialect uint16) {
+			srv := &testFileServer{conn: server}
+
+			var size [4]byte
+			if _, err := io.ReadFull(server, size[:]); err != nil {
+				return
+			}
+			pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(server, pkt); err != nil {
+				return
+			}
+
+			srv.respond(pkt, &NegotiateResponse{
+				DialectRevision: dialect,
+				MaxTransactSize: 65536,
+				MaxReadSize:     65536,
+				MaxWriteSize:    65536,
+				SystemTime:      &Filetime{},
+				ServerStartTime: &Filetime{},
+			})
+		}(tc.dialect)
+
+		c, err := tc.n.negotiate(direct(client), openAccount(8), clientRecvBufferSize, context.Background())
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%+v, %#x: unexpected error: %v", tc.n, tc.dialect, err)
+			} else if c.dialect != tc.dialect {
+				t.Errorf("%+v: expected dialect %#x, got %#x", tc.n, tc.dialect, c.dialect)
+			}
+		} else {
+			if err == nil {
+				t.Errorf("%+v, %#x: expected an error", tc.n, tc.dialect)
+			}
+			if err, ok := err.(*DialectNotAllowedError); ok && err.MinDialect != tc.n.minDialect() {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,302 @@
+package smb2
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// CrossServerCopy copies from src to dst until EOF is reached on src, like io.Copy.
+// It's meant for files on different servers or sessions; for files on the same share,
+// io.Copy is preferable since it invokes server-side copy.
+//
+// Unlike io.Copy, reads from src and writes to dst are pipelined: the data is split into chunks that fit
+// both the max read size of src and the max write size of dst, and several chunks are in flight at once,
+// each read as soon as a buffer is available and written as soon as it has been read.
+// The number of chunks in flight is derived from the credits available on both connections.
+// If Dialer.AutoTune was set for the connection of src, it's adjusted during the copy.
+//
+// Copying starts at the current offsets of src and dst, which are advanced by the number of bytes copied.
+// If an error occurs, the offsets are left unchanged and dst may have been partially written.
+func CrossServerCopy(src, dst *File) (n int64, err error) {
+	if src == nil || dst == nil {
+		return 0, os.ErrInvalid
+	}
+
+	n, err = src.crossServerCopy(dst)
+	if err != nil {
+		return n, &os.LinkError{Op: "copy", Old: src.name, New: dst.name, Err: err}
+	}
+	return n, nil
+}
+
+func (f *File) crossServerCopy(wf *File) (n int64, err error) {
+	f.m.Lock()
+	roff := f.offset
+	f.m.Unlock()
+
+	wf.m.Lock()
+	woff := wf.offset
+	wf.m.Unlock()
+
+	chunkSize := f.maxReadSize()
+	if maxWriteSize := wf.maxWriteSize(); maxWriteSize < chunkSize {
+		chunkSize = maxWriteSize
+	}
+
+	conc := f.fs.concurrency(0)
+	if c := wf.fs.concurrency(0); c < conc {
+		conc = c
+	}
+
+	l := newTunedLimiter(conc, newTuner(
+		creditBudget{conn: f.fs.conn, charge: f.fs.conn.transferCharge(chunkSize)},
+		creditBudget{conn: wf.fs.conn, charge: wf.fs.conn.transferCharge(chunkSize)},
+	))
+
+	// at most maxAutoTuneDepth buffers are in use at once, so the pool never grows beyond that.
+	pool := make(chan []byte, maxAutoTuneDepth)
+
+	var wg sync.WaitGroup
+
+	var eof int32
+
+	for off := int64(0); atomic.LoadInt32(&eof) == 0 && !l.failed(); off += int64(chunkSize) {
+		off := off
+
+		l.do(&wg, func() error {
+			var buf []byte
+			select {
+			case buf = <-pool:
+			default:
+				buf = make([]byte, chunkSize)
+			}
+			defer func() { pool <- buf }()
+
+			m, err := f.readAt(buf, roff+off)
+			if err != nil {
+				if rerr, ok := err.(*ResponseError); ok && NtStatus(rerr.Code) == STATUS_END_OF_FILE {
+					atomic.StoreInt32(&eof, 1)
+					return nil
+				}
+				return err
+			}
+			if m < len(buf) {
+				atomic.StoreInt32(&eof, 1)
+			}
+			if m == 0 {
+				return nil
+			}
+
+			_, err = wf.writeAt(buf[:m], woff+off)
+			if err != nil {
+				return err
+			}
+
+			atomic.AddInt64(&n, int64(m))
+
+			return nil
+		})
+	}
+
+	wg.Wait()
+
+	if err := l.error(); err != nil {
+		return atomic.LoadInt64(&n), err
+	}
+
+	f.m.Lock()
+	f.offset = roff + n
+	f.m.Unlock()
+
+	wf.m.Lock()
+	wf.offset = woff + n
+	wf.m.Unlock()
+
+	return n, nil
+}
+
+// ServerSideCopy copies from src to dst until EOF is reached on src, like io.Copy, but the data
+// is copied by the server (FSCTL_SRV_COPYCHUNK_WRITE) instead of going through the client.
+// src and dst must be files of the session, possibly on different shares.
+// src needs read access, and dst write access.
+//
+// The copy is split into requests within the limits of the server, which are learned
+// from the first request if the defaults of the client exceed them.
+// If the server doesn't support server-side copy, the data is copied through the client like CrossServerCopy.
+// ctx is checked between requests.
+//
+// Copying starts at the current offsets of src and dst, which are advanced by the number of bytes copied.
+// If an error occurs, the offsets are left unchanged and dst may have been partially written.
+func (c *Session) ServerSideCopy(ctx context.Context, src, dst *File) (n int64, err error) {
+	if ctx == nil {
+		panic("nil context")
+	}
+	if src == nil || dst == nil {
+		return 0, os.ErrInvalid
+	}
+	if src.fs.session != c.s || dst.fs.session != c.s {
+		return 0, &os.LinkError{Op: "copy", Old: src.name, New: dst.name, Err: os.ErrInvalid}
+	}
+
+	n, err = src.serverSideCopy(dst, ctx)
+	if err == ErrNotSupported {
+		n, err = src.crossServerCopy(dst)
+	}
+	if err != nil {
+		return n, &os.LinkError{Op: "copy", Old: src.name, New: dst.name, Err: err}
+	}
+	return n, nil
+}
+
+// serverSideCopy copies f from its offset to EOF into wf at its offset, and advances both offsets.
+// It returns ErrNotSupported if the server doesn't support server-side copy, in which case nothing was copied.
+func (f *File) serverSideCopy(wf *File, ctx context.Context) (n int64, err error) {
+	// the requests are made with ctx.
+	rf := &File{fs: f.fs.WithContext(ctx), fd: f.fd, name: f.name}
+	wf2 := &File{fs: wf.fs.WithContext(ctx), fd: wf.fd, name: wf.name}
+
+	key, err := rf.requestResumeKey()
+	if err != nil {
+		return 0, err
+	}
+
+	f.m.Lock()
+	roff := f.offset
+	f.m.Unlock()
+
+	end, err := rf.seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	wf.m.Lock()
+	woff := wf.offset
+	wf.m.Unlock()
+
+	maxChunks := uint32(clientCopychunkMaxChunks)
+	maxChunkSize := uint32(clientCopychunkMaxChunkSize)
+	maxTotalSize := uint32(clientCopychunkMaxTotalSize)
+
+	adjusted := false
+
+	for roff+n < end {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		scc := &SrvCopychunkCopy{}
+		copy(scc.SourceKey[:], key)
+
+		var total uint32
+
+		for off := roff + n; off < end && uint32(len(scc.Chunks)) < maxChunks && total < maxTotalSize; {
+			size := maxChunkSize
+			if rest := maxTotalSize - total; rest < size {
+				size = rest
+			}
+			if rest := end - off; rest < int64(size) {
+				size = uint32(rest)
+			}
+
+			scc.Chunks = append(scc.Chunks, &SrvCopychunk{
+				SourceOffset: off,
+				TargetOffset: woff + (off - roff),
+				Length:       size,
+			})
+
+			off += int64(size)
+			total += size
+		}
+
+		req := &IoctlRequest{
+			CtlCode:           FSCTL_SRV_COPYCHUNK_WRITE,
+			OutputOffset:      0,
+			OutputCount:       0,
+			MaxInputResponse:  0,
+			MaxOutputResponse: 12,
+			Flags:             SMB2_0_IOCTL_IS_FSCTL,
+			Input:             scc,
+		}
+
+		output, err := wf2.ioctl(req)
+		if err != nil {
+			if rerr, ok := err.(*ResponseError); ok {
+				switch NtStatus(rerr.Code) {
+				case STATUS_INVALID_PARAMETER:
+					// the request exceeds the limits of the server, which are returned instead. ([MS-SMB2] 3.3.5.15.6.2)
+					r := SrvCopychunkResponseDecoder(output)
+					if !adjusted && !r.IsInvalid() && r.ChunksWritten() != 0 && r.ChunksBytesWritten() != 0 && r.TotalBytesWritten() != 0 {
+						maxChunks = r.ChunksWritten()
+						maxChunkSize = r.ChunksBytesWritten()
+						maxTotalSize = r.TotalBytesWritten()
+						adjusted = true
+						continue
+					}
+				case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST:
+					if n == 0 {
+						return 0, ErrNotSupported
+					}
+				}
+			}
+			return n, err
+		}
+
+		r := SrvCopychunkResponseDecoder(output)
+		if r.IsInvalid() {
+			return n, &InvalidResponseError{"broken srv copy chunk response format"}
+		}
+
+		// the server may stop before the end of the request; the rest is requested again.
+		if r.TotalBytesWritten() == 0 {
+			return n, io.ErrShortWrite
+		}
+
+		n += int64(r.TotalBytesWritten())
+	}
+
+	f.m.Lock()
+	f.offset = roff + n
+	f.m.Unlock()
+
+	wf.m.Lock()
+	wf.offset = woff + n
+	wf.m.Unlock()
+
+	return n, nil
+}
+
+// requestResumeKey returns the key identifying f as the source of server-side copies.
+func (f *File) requestResumeKey() ([]byte, error) {
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_SRV_REQUEST_RESUME_KEY,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: 32,
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+	}
+
+	output, err := f.ioctl(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST:
+				return nil, ErrNotSupported
+			}
+		}
+		return nil, err
+	}
+
+	r := SrvRequestResumeKeyResponseDecoder(output)
+	if r.IsInvalid() {
+		return nil, &InvalidResponseError{"broken srv request resume key response format"}
+	}
+
+	return r.ResumeKey(), nil
+}
@@ -0,0 +1,188 @@
+package smb2
+
+import (
+	"os"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// AccessMask represents the access requested to a file. ([MS-SMB2] 2.2.13.1)
+type AccessMask uint32
+
+const (
+	AccessReadData        AccessMask = FILE_READ_DATA
+	AccessWriteData       AccessMask = FILE_WRITE_DATA
+	AccessAppendData      AccessMask = FILE_APPEND_DATA
+	AccessReadEA          AccessMask = FILE_READ_EA
+	AccessWriteEA         AccessMask = FILE_WRITE_EA
+	AccessExecute         AccessMask = FILE_EXECUTE
+	AccessDeleteChild     AccessMask = FILE_DELETE_CHILD
+	AccessReadAttributes  AccessMask = FILE_READ_ATTRIBUTES
+	AccessWriteAttributes AccessMask = FILE_WRITE_ATTRIBUTES
+	AccessDelete          AccessMask = DELETE
+	AccessReadControl     AccessMask = READ_CONTROL
+	AccessWriteDAC        AccessMask = WRITE_DAC
+	AccessWriteOwner      AccessMask = WRITE_OWNER
+	AccessSynchronize     AccessMask = SYNCHRONIZE
+	AccessSystemSecurity  AccessMask = ACCESS_SYSTEM_SECURITY // requires SeSecurityPrivilege on the server
+	AccessMaximumAllowed  AccessMask = MAXIMUM_ALLOWED
+	AccessGenericAll      AccessMask = GENERIC_ALL
+	AccessGenericExecute  AccessMask = GENERIC_EXECUTE
+	AccessGenericWrite    AccessMask = GENERIC_WRITE
+	AccessGenericRead     AccessMask = GENERIC_READ
+)
+
+// ShareAccess represents the access other opens of a file are allowed while it's open.
+type ShareAccess uint32
+
+const (
+	ShareAccessRead   ShareAccess = FILE_SHARE_READ
+	ShareAccessWrite  ShareAccess = FILE_SHARE_WRITE
+	ShareAccessDelete ShareAccess = FILE_SHARE_DELETE
+)
+
+// CreateDisposition represents what the server does depending on whether a file exists.
+type CreateDisposition int
+
+const (
+	// DispositionOpen opens the file if it exists, and fails otherwise.
+	DispositionOpen CreateDisposition = iota + 1
+
+	// DispositionCreate creates the file if it doesn't exist, and fails otherwise.
+	DispositionCreate
+
+	// DispositionOpenIf opens the file if it exists, and creates it otherwise.
+	DispositionOpenIf
+
+	// DispositionOverwrite overwrites the file if it exists, and fails otherwise.
+	DispositionOverwrite
+
+	// DispositionOverwriteIf overwrites the file if it exists, and creates it otherwise.
+	DispositionOverwriteIf
+
+	// DispositionSupersede replaces the file if it exists, and creates it otherwise.
+	// Unlike overwriting, replacing drops the attributes and the streams of the file.
+	DispositionSupersede
+)
+
+func (d CreateDisposition) value() (uint32, bool) {
+	switch d {
+	case 0, DispositionOpen:
+		return FILE_OPEN, true
+	case DispositionCreate:
+		return FILE_CREATE, true
+	case DispositionOpenIf:
+		return FILE_OPEN_IF, true
+	case DispositionOverwrite:
+		return FILE_OVERWRITE, true
+	case DispositionOverwriteIf:
+		return FILE_OVERWRITE_IF, true
+	case DispositionSupersede:
+		return FILE_SUPERSEDE, true
+	}
+	return 0, false
+}
+
+// CreateOption represents the options applied when a file is opened or created.
+type CreateOption uint32
+
+const (
+	CreateDirectoryFile           CreateOption = FILE_DIRECTORY_FILE
+	CreateWriteThrough            CreateOption = FILE_WRITE_THROUGH
+	CreateSequentialOnly          CreateOption = FILE_SEQUENTIAL_ONLY
+	CreateNoIntermediateBuffering CreateOption = FILE_NO_INTERMEDIATE_BUFFERING
+	CreateNonDirectoryFile        CreateOption = FILE_NON_DIRECTORY_FILE
+	CreateRandomAccess            CreateOption = FILE_RANDOM_ACCESS
+	CreateDeleteOnClose           CreateOption = FILE_DELETE_ON_CLOSE // requires AccessDelete
+	CreateOpenForBackupIntent     CreateOption = FILE_OPEN_FOR_BACKUP_INTENT
+	CreateNoCompression           CreateOption = FILE_NO_COMPRESSION
+	CreateOpenReparsePoint        CreateOption = FILE_OPEN_REPARSE_POINT
+	CreateOpenNoRecall            CreateOption = FILE_OPEN_NO_RECALL
+)
+
+// FileAttributes represents the attributes of a file. ([MS-FSCC] 2.6)
+type FileAttributes uint32
+
+const (
+	AttributeReadonly          FileAttributes = FILE_ATTRIBUTE_READONLY
+	AttributeHidden            FileAttributes = FILE_ATTRIBUTE_HIDDEN
+	AttributeSystem            FileAttributes = FILE_ATTRIBUTE_SYSTEM
+	AttributeDirectory         FileAttributes = FILE_ATTRIBUTE_DIRECTORY
+	AttributeArchive           FileAttributes = FILE_ATTRIBUTE_ARCHIVE
+	AttributeNormal            FileAttributes = FILE_ATTRIBUTE_NORMAL
+	AttributeTemporary         FileAttributes = FILE_ATTRIBUTE_TEMPORARY
+	AttributeSparseFile        FileAttributes = FILE_ATTRIBUTE_SPARSE_FILE
+	AttributeReparsePoint      FileAttributes = FILE_ATTRIBUTE_REPARSE_POINT
+	AttributeCompressed        FileAttributes = FILE_ATTRIBUTE_COMPRESSED
+	AttributeOffline           FileAttributes = FILE_ATTRIBUTE_OFFLINE
+	AttributeNotContentIndexed FileAttributes = FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+	AttributeEncrypted         FileAttributes = FILE_ATTRIBUTE_ENCRYPTED
+)
+
+// CreateOptions are the parameters of the CREATE request sent by Share.OpenWith. ([MS-SMB2] 2.2.13)
+// Unlike the flags of OpenFile, they are passed to the server as they are.
+type CreateOptions struct {
+	// DesiredAccess is the access requested to the file, e.g. AccessGenericRead|AccessDelete.
+	DesiredAccess AccessMask
+
+	// ShareAccess is the access other opens of the file are allowed while it's open.
+	ShareAccess ShareAccess
+
+	// CreateDisposition tells what to do depending on whether the file exists.
+	// The zero value opens an existing file.
+	CreateDisposition CreateDisposition
+
+	// CreateOptions are the options applied to the open, e.g. CreateDirectoryFile.
+	// Symbolic links aren't followed with CreateOpenReparsePoint.
+	CreateOptions CreateOption
+
+	// FileAttributes are the attributes of the file, if it's created, overwritten or replaced.
+	// The zero value is the same as AttributeNormal.
+	FileAttributes FileAttributes
+
+	// ImpersonationLevel is the impersonation level requested to the server.
+	// The zero value requests SecurityImpersonation.
+	ImpersonationLevel ImpersonationLevel
+}
+
+// OpenWith opens the named file with the CREATE parameters of opts.
+// It gives full control over the request OpenFile derives from POSIX-like flags.
+func (fs *Share) OpenWith(name string, opts CreateOptions) (*File, error) {
+	name, err := cleanPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	createmode, ok := opts.CreateDisposition.value()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	impersonation, ok := opts.ImpersonationLevel.value()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	req := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        uint32(opts.DesiredAccess),
+		FileAttributes:       uint32(opts.FileAttributes),
+		ShareAccess:          uint32(opts.ShareAccess),
+		CreateDisposition:    createmode,
+		CreateOptions:        uint32(opts.CreateOptions),
+	}
+
+	err = fs.requestLease(req)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	f, err := fs.createFile(name, req, opts.CreateOptions&CreateOpenReparsePoint == 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
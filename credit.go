@@ -3,12 +3,36 @@ package smb2
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 )
 
+// CreditStats is a snapshot of the credit accounting of a connection. See Session.CreditStats.
+type CreditStats struct {
+	Available     int    // credits currently available for new requests
+	PeakAvailable int    // highest number of credits available at once, i.e. the largest window granted by the server
+	MaxBalance    int    // maximum number of credits the client keeps
+	Outstanding   int    // requests waiting for their responses, which return their credits
+	Granted       uint64 // total credits granted by the server
+	Charged       uint64 // total credits consumed by requests
+	Blocked       uint64 // number of times a request had to wait for credits
+}
+
+// creditStats holds the counters behind CreditStats.
+type creditStats struct {
+	granted uint64
+	charged uint64
+	blocked uint64
+	peak    uint64 // highest balance
+}
+
 type account struct {
+	stats creditStats // keep it first for 64-bit alignment of atomic operations.
+
 	m        sync.Mutex
 	balance  chan struct{}
 	_opening uint16
+
+	initialRequest uint16 // credits requested by NEGOTIATE and SESSION_SETUP, if it's not zero
 }
 
 func openAccount(maxCreditBalance uint16) *account {
@@ -18,31 +42,82 @@ func openAccount(maxCreditBalance uint16) *account {
 
 	return &account{
 		balance: balance,
+		stats:   creditStats{peak: 1},
+	}
+}
+
+// snapshot returns the statistics of the account. Outstanding is left to the caller.
+func (a *account) snapshot() CreditStats {
+	return CreditStats{
+		Available:     len(a.balance),
+		PeakAvailable: int(atomic.LoadUint64(&a.stats.peak)),
+		MaxBalance:    cap(a.balance),
+		Granted:       atomic.LoadUint64(&a.stats.granted),
+		Charged:       atomic.LoadUint64(&a.stats.charged),
+		Blocked:       atomic.LoadUint64(&a.stats.blocked),
 	}
 }
 
 func (a *account) initRequest() uint16 {
-	return uint16(cap(a.balance) - len(a.balance))
+	if n := uint16(cap(a.balance) - len(a.balance)); n > a.initialRequest {
+		return n
+	}
+	return a.initialRequest
 }
 
 func (a *account) loan(creditCharge uint16, ctx context.Context) (uint16, bool, error) {
-	select {
-	case <-a.balance:
-	case <-ctx.Done():
-		return 0, false, &ContextError{Err: ctx.Err()}
+	if err := a.wait(ctx); err != nil {
+		return 0, false, err
 	}
 
 	for i := uint16(1); i < creditCharge; i++ {
 		select {
 		case <-a.balance:
 		default:
+			atomic.AddUint64(&a.stats.charged, uint64(i))
+
 			return i, false, nil
 		}
 	}
 
+	atomic.AddUint64(&a.stats.charged, uint64(creditCharge))
+
 	return creditCharge, true, nil
 }
 
+// wait takes a credit from the balance, waiting for it if the balance is empty.
+func (a *account) wait(ctx context.Context) error {
+	select {
+	case <-a.balance:
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&a.stats.blocked, 1)
+
+	select {
+	case <-a.balance:
+		return nil
+	case <-ctx.Done():
+		return &ContextError{Err: ctx.Err()}
+	}
+}
+
+// loanAll is like loan but waits until all of creditCharge is available.
+func (a *account) loanAll(creditCharge uint16, ctx context.Context) error {
+	for i := uint16(0); i < creditCharge; i++ {
+		if err := a.wait(ctx); err != nil {
+			a.charge(i, i)
+
+			return err
+		}
+	}
+
+	atomic.AddUint64(&a.stats.charged, uint64(creditCharge))
+
+	return nil
+}
+
 func (a *account) opening() uint16 {
 	a.m.Lock()
 
@@ -54,6 +129,13 @@ func (a *account) opening() uint16 {
 	return ret
 }
 
+// grant returns the credits granted by a response to the balance.
+func (a *account) grant(granted, requested uint16) {
+	atomic.AddUint64(&a.stats.granted, uint64(granted))
+
+	a.charge(granted, requested)
+}
+
 func (a *account) charge(granted, requested uint16) {
 	if granted == 0 && requested == 0 {
 		return
@@ -67,10 +149,18 @@ func (a *account) charge(granted, requested uint16) {
 
 	a.m.Unlock()
 
+loop:
 	for i := uint16(0); i < granted; i++ {
 		select {
 		case a.balance <- struct{}{}:
 		default:
+			break loop
+		}
+	}
+
+	for balance := uint64(len(a.balance)); ; {
+		peak := atomic.LoadUint64(&a.stats.peak)
+		if balance <= peak || atomic.CompareAndSwapUint64(&a.stats.peak, peak, balance) {
 			return
 		}
 	}
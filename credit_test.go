@@ -0,0 +1,100 @@
+package smb2
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestAccountStats(t *testing.T) {
+	a := openAccount(8)
+
+	n, ok, err := a.loan(1, context.Background())
+	if err != nil || n != 1 || !ok {
+		t.Fatal("unexpected loan:", n, ok, err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		a.grant(4, 1)
+	}()
+
+	n, ok, err = a.loan(2, context.Background())
+	if err != nil || n != 2 || !ok {
+		t.Fatal("unexpected loan:", n, ok, err)
+	}
+
+	stats := a.snapshot()
+
+	// the waiting loan may take a credit before the grant is complete.
+	expected := CreditStats{Available: 2, PeakAvailable: stats.PeakAvailable, MaxBalance: 8, Granted: 4, Charged: 3, Blocked: 1}
+	if stats != expected || stats.PeakAvailable < 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAccountInitRequest(t *testing.T) {
+	a := openAccount(8)
+
+	if n := a.initRequest(); n != 7 {
+		t.Error("unexpected initial request:", n)
+	}
+
+	a.initialRequest = 64
+
+	if n := a.initRequest(); n != 64 {
+		t.Error("unexpected initial request:", n)
+	}
+
+	// the balance is capped by the maximum.
+	a.grant(64, 64)
+
+	if len(a.balance) != 8 || a.stats.peak != 8 {
+		t.Error("unexpected balance:", len(a.balance), a.stats.peak)
+	}
+}
+
+func TestRawRequestPartialLoan(t *testing.T) {
+	f, srv := newTestFile(0, -1)
+	defer srv.conn.Close()
+
+	conn := f.fs.conn
+	conn.capabilities |= SMB2_GLOBAL_CAP_LARGE_MTU
+
+	// leave a single credit, which doesn't cover the payload.
+	for len(conn.account.balance) > 1 {
+		<-conn.account.balance
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := (&Session{s: f.fs.session, ctx: context.Background()}).RawRequest(&RawPacket{
+			Command:     SMB2_ECHO,
+			Body:        []byte{4, 0, 0, 0},
+			PayloadSize: 3 * 64 * 1024,
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatal("expected the request to wait for the rest of its charge, got", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	conn.account.charge(2, 2)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	srv.m.Lock()
+	defer srv.m.Unlock()
+
+	if len(srv.echoes) != 1 || srv.echoes[0].CreditCharge != 3 {
+		t.Errorf("unexpected requests: %+v", srv.echoes)
+	}
+}
@@ -0,0 +1,396 @@
+package smb2
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// DFSReferral redirects the paths under a DFS link or root to a target. ([MS-DFSC] 2.2.4)
+type DFSReferral struct {
+	Path   string        // prefix of the resolved path covered by the referral, e.g. `\\example.com\dfs\data`
+	Target string        // UNC path that replaces Path, e.g. `\\fileserver\data`
+	TTL    time.Duration // how long the referral may be cached
+	Root   bool          // whether Target is a DFS root rather than the target of a link
+}
+
+// ResolvePath asks the server for the targets of a DFS path, like `\\example.com\dfs\data\file.txt`.
+// The referrals are returned in the order of preference of the server, and cached for their TTL.
+// Shares mounted on DFS namespaces resolve paths transparently; ResolvePath is only needed
+// to find out where a path actually lives.
+func (c *Session) ResolvePath(path string) ([]DFSReferral, error) {
+	path = normPath(path)
+
+	if !strings.HasPrefix(path, `\\`) {
+		return nil, &os.PathError{Op: "resolve", Path: path, Err: os.ErrInvalid}
+	}
+
+	refs, err := c.s.resolvePath(path, c.ctx)
+	if err != nil {
+		return nil, &os.PathError{Op: "resolve", Path: path, Err: err}
+	}
+
+	return refs, nil
+}
+
+// dfsCache caches referrals, and the trees mounted on their targets with the sessions of other servers.
+type dfsCache struct {
+	m         sync.Mutex
+	referrals map[string]dfsCacheEntry // keyed by the lower-cased path of the referrals
+	trees     map[string]*treeConn     // keyed by the lower-cased share name
+	sessions  map[string]*session      // keyed by the lower-cased server name
+}
+
+type dfsCacheEntry struct {
+	referrals []DFSReferral
+	expires   time.Time
+}
+
+func newDFSCache() *dfsCache {
+	return &dfsCache{
+		referrals: make(map[string]dfsCacheEntry),
+		trees:     make(map[string]*treeConn),
+		sessions:  make(map[string]*session),
+	}
+}
+
+// lookup returns the unexpired referrals covering the longest prefix of path.
+func (c *dfsCache) lookup(path string) []DFSReferral {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	key := strings.ToLower(path)
+
+	for {
+		if e, ok := c.referrals[key]; ok {
+			if now.Before(e.expires) {
+				return e.referrals
+			}
+			delete(c.referrals, key)
+		}
+
+		i := strings.LastIndex(key, `\`)
+		if i <= 1 {
+			return nil
+		}
+		key = key[:i]
+	}
+}
+
+func (c *dfsCache) store(refs []DFSReferral) {
+	ttl := refs[0].TTL
+	for _, ref := range refs[1:] {
+		if ref.TTL < ttl {
+			ttl = ref.TTL
+		}
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.referrals[strings.ToLower(refs[0].Path)] = dfsCacheEntry{
+		referrals: refs,
+		expires:   time.Now().Add(ttl),
+	}
+}
+
+func (s *session) resolvePath(path string, ctx context.Context) ([]DFSReferral, error) {
+	if refs := s.dfs.lookup(path); refs != nil {
+		return refs, nil
+	}
+
+	refs, err := s.getDFSReferrals(path, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dfs.store(refs)
+
+	return refs, nil
+}
+
+// getDFSReferrals sends FSCTL_DFS_GET_REFERRALS over the IPC$ share of the server of path. ([MS-SMB2] 3.2.4.20.3)
+func (s *session) getDFSReferrals(path string, ctx context.Context) ([]DFSReferral, error) {
+	server, _ := splitUNC(path)
+
+	tc, err := treeConnect(s, `\\`+server+`\IPC$`, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.disconnect(ctx)
+
+	req := &IoctlRequest{
+		CtlCode: FSCTL_DFS_GET_REFERRALS,
+		FileId: &FileId{
+			Persistent: [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			Volatile:   [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		MaxOutputResponse: clientMaxDFSReferralSize,
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input: &DfsReferralRequest{
+			MaxReferralLevel: 4,
+			RequestFileName:  path[1:], // the request path has a single leading backslash
+		},
+	}
+
+	req.CreditCharge = 1
+
+	res, err := tc.sendRecv(SMB2_IOCTL, req, ctx)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_FOUND, STATUS_NO_SUCH_DEVICE, STATUS_FS_DRIVER_REQUIRED:
+				// the server doesn't know the path as a DFS path.
+				return nil, os.ErrNotExist
+			}
+		}
+		return nil, err
+	}
+
+	r := IoctlResponseDecoder(res)
+	if r.IsInvalid() {
+		return nil, &InvalidResponseError{"broken ioctl response format"}
+	}
+
+	return parseDFSReferrals(path, r.Output())
+}
+
+// parseDFSReferrals decodes a RESP_GET_DFS_REFERRAL for the request path.
+// Entries of version 1 and name list referrals, which list domain controllers, are skipped.
+func parseDFSReferrals(path string, output []byte) ([]DFSReferral, error) {
+	r := DfsReferralResponseDecoder(output)
+	if r.IsInvalid() {
+		return nil, &InvalidResponseError{"broken dfs referral response format"}
+	}
+
+	// PathConsumed counts the bytes of the UTF-16 request path, which has a single leading backslash.
+	u := utf16.Encode([]rune(path[1:]))
+	n := int(r.PathConsumed()) / 2
+	if n == 0 || n > len(u) {
+		return nil, &InvalidResponseError{"broken dfs referral response format"}
+	}
+	prefix := `\` + strings.TrimSuffix(string(utf16.Decode(u[:n])), `\`)
+
+	var refs []DFSReferral
+
+	entries := r.ReferralEntries()
+
+	for i := 0; i < int(r.NumberOfReferrals()); i++ {
+		e := DfsReferralEntryDecoder(entries)
+		if e.IsInvalid() {
+			return nil, &InvalidResponseError{"broken dfs referral entry format"}
+		}
+
+		entries = entries[e.Size():]
+
+		if e.VersionNumber() < 2 || e.VersionNumber() > 4 || e.IsNameListReferral() {
+			continue
+		}
+
+		target := e.NetworkAddress()
+		if !strings.HasPrefix(target, `\`) {
+			return nil, &InvalidResponseError{"broken dfs referral entry format"}
+		}
+
+		refs = append(refs, DFSReferral{
+			Path:   prefix,
+			Target: `\` + strings.TrimSuffix(target, `\`),
+			TTL:    time.Duration(e.TimeToLive()) * time.Second,
+			Root:   e.ServerType() == DFS_SERVER_TYPE_ROOT,
+		})
+	}
+
+	if len(refs) == 0 {
+		return nil, &InvalidResponseError{"dfs referral response without targets"}
+	}
+
+	return refs, nil
+}
+
+// splitUNC splits `\\server\share\name` into "server" and `share\name`.
+func splitUNC(path string) (server, rest string) {
+	path = strings.TrimPrefix(path, `\\`)
+	if i := strings.IndexRune(path, '\\'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+// isPathNotCovered reports whether err tells that a path lies under a DFS link.
+func isPathNotCovered(err error) bool {
+	rerr, ok := err.(*ResponseError)
+	return ok && NtStatus(rerr.Code) == STATUS_PATH_NOT_COVERED
+}
+
+// createName sets the name of req for name, which is the full DFS path on DFS shares. ([MS-SMB2] 3.2.4.3)
+func (fs *Share) createName(req *CreateRequest, name string) {
+	if fs.shareFlags&SMB2_SHAREFLAG_DFS == 0 {
+		req.Name = name
+		req.Flags &^= SMB2_FLAGS_DFS_OPERATIONS
+		return
+	}
+
+	req.Name = fs.path[2:]
+	if name != "" {
+		req.Name += `\` + name
+	}
+	req.Flags |= SMB2_FLAGS_DFS_OPERATIONS
+}
+
+// redirectDFS returns the share and the name a path of fs is redirected to by the referrals of the server.
+// The targets are tried in order until one of them can be mounted.
+func (fs *Share) redirectDFS(name string) (*Share, string, error) {
+	path := fs.path
+	if name != "" {
+		path += `\` + name
+	}
+
+	refs, err := fs.session.resolvePath(path, fs.ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = &InvalidResponseError{"dfs referral loops back to the same path"}
+
+	for _, ref := range refs {
+		if len(ref.Path) > len(path) {
+			continue
+		}
+
+		target := ref.Target + path[len(ref.Path):]
+
+		// a referral to the path itself would be followed forever.
+		if strings.EqualFold(target, path) {
+			continue
+		}
+
+		server, rest := splitUNC(target)
+		share, name := splitUNC(`\\` + rest)
+		origin, _ := splitUNC(fs.path)
+
+		var tc *treeConn
+
+		tc, err = fs.session.mountDFS(server, share, strings.EqualFold(server, origin), fs.ctx)
+		if err != nil {
+			continue
+		}
+
+		return &Share{treeConn: tc, ctx: fs.ctx}, name, nil
+	}
+
+	return nil, "", err
+}
+
+// mountDFS mounts `\\server\share` on behalf of a redirection, reusing the trees and the sessions it mounted before.
+// Shares of other servers than the one of s (!local) are mounted by sessions set up with the Dialer of s.
+// The cache isn't locked while the server is dialed and the tree is connected, so that other lookups aren't
+// held up by a slow network; if the same tree or session is set up concurrently, the first one is kept.
+func (s *session) mountDFS(server, share string, local bool, ctx context.Context) (*treeConn, error) {
+	sharename := `\\` + server + `\` + share
+	key := strings.ToLower(sharename)
+	serverKey := strings.ToLower(server)
+
+	c := s.dfs
+
+	c.m.Lock()
+	tc, ok := c.trees[key]
+	ts := s
+	if !local {
+		ts = c.sessions[serverKey]
+	}
+	c.m.Unlock()
+
+	if ok {
+		return tc, nil
+	}
+
+	if ts == nil {
+		if s.dialer == nil {
+			return nil, &InternalError{"cannot connect to DFS target " + sharename}
+		}
+
+		dialed, err := s.dialer.dialDFS(server, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.m.Lock()
+		ts = c.sessions[serverKey]
+		if ts == nil {
+			ts = dialed
+			c.sessions[serverKey] = ts
+		}
+		c.m.Unlock()
+
+		if ts != dialed {
+			dialed.logoff(ctx)
+		}
+	}
+
+	tc, err := treeConnect(ts, sharename, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	mounted, ok := c.trees[key]
+	if !ok {
+		c.trees[key] = tc
+	}
+	c.m.Unlock()
+
+	if ok {
+		tc.disconnect(ctx)
+
+		return mounted, nil
+	}
+
+	return tc, nil
+}
+
+// dialDFS sets up a session with the server of a DFS target.
+func (d *Dialer) dialDFS(server string, ctx context.Context) (*session, error) {
+	var tcpConn net.Conn
+	var err error
+
+	if d.DialDFS != nil {
+		tcpConn, err = d.DialDFS(ctx, server)
+	} else {
+		tcpConn, err = new(net.Dialer).DialContext(ctx, "tcp", net.JoinHostPort(server, "445"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.DialContext(ctx, tcpConn)
+	if err != nil {
+		tcpConn.Close()
+		return nil, err
+	}
+
+	return c.s, nil
+}
+
+// close logs off the sessions set up for DFS targets.
+func (c *dfsCache) close(ctx context.Context) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, s := range c.sessions {
+		s.logoff(ctx)
+	}
+	c.sessions = make(map[string]*session)
+	c.trees = make(map[string]*treeConn)
+}
@@ -0,0 +1,164 @@
+package smb2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
+)
+
+// encodeReferralResponse encodes a RESP_GET_DFS_REFERRAL with V4 entries for targets.
+func encodeReferralResponse(pathConsumed uint16, ttl uint32, targets ...string) []byte {
+	le := binary.LittleEndian
+
+	const entrySize = 34
+
+	var strs []byte
+
+	off := len(targets) * entrySize
+
+	p := make([]byte, 8+off)
+	le.PutUint16(p[:2], pathConsumed)
+	le.PutUint16(p[2:4], uint16(len(targets)))
+	le.PutUint32(p[4:8], 0x2) // storage servers
+
+	for i, target := range targets {
+		e := p[8+i*entrySize:]
+		le.PutUint16(e[:2], 4)
+		le.PutUint16(e[2:4], entrySize)
+		le.PutUint32(e[8:12], ttl)
+
+		addr := off - i*entrySize + len(strs)
+		le.PutUint16(e[12:14], uint16(addr))
+		le.PutUint16(e[14:16], uint16(addr))
+		le.PutUint16(e[16:18], uint16(addr))
+
+		strs = append(strs, utf16le.EncodeStringToBytes(target)...)
+		strs = append(strs, 0, 0)
+	}
+
+	return append(p, strs...)
+}
+
+func TestParseDFSReferrals(t *testing.T) {
+	path := `\\example.com\dfs\data\dir\file.txt`
+
+	// `\example.com\dfs\data` is consumed.
+	consumed := uint16(2 * len(`\example.com\dfs\data`))
+
+	refs, err := parseDFSReferrals(path, encodeReferralResponse(consumed, 300, `\fs1\data`, `\fs2\data\`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []DFSReferral{
+		{Path: `\\example.com\dfs\data`, Target: `\\fs1\data`, TTL: 300 * time.Second},
+		{Path: `\\example.com\dfs\data`, Target: `\\fs2\data`, TTL: 300 * time.Second},
+	}
+	if len(refs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, refs)
+	}
+	for i := range refs {
+		if refs[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], refs[i])
+		}
+	}
+
+	for _, output := range [][]byte{
+		encodeReferralResponse(consumed, 300),                           // no targets
+		encodeReferralResponse(uint16(2*len(path)+2), 300, `\fs1\data`), // consumes more than the path
+		encodeReferralResponse(consumed, 300, `fs1\data`),               // not a UNC path
+		encodeReferralResponse(consumed, 300, `\fs1\data`)[:8+20],       // truncated entry
+	} {
+		if _, err := parseDFSReferrals(path, output); err == nil {
+			t.Errorf("expected an error for %x", output)
+		}
+	}
+}
+
+func TestDFSCache(t *testing.T) {
+	c := newDFSCache()
+
+	c.store([]DFSReferral{{Path: `\\example.com\dfs\data`, Target: `\\fs1\data`, TTL: time.Minute}})
+	c.store([]DFSReferral{{Path: `\\example.com\dfs\tmp`, Target: `\\fs1\tmp`}}) // not cached
+
+	if refs := c.lookup(`\\EXAMPLE.com\dfs\Data\dir\file.txt`); len(refs) != 1 || refs[0].Target != `\\fs1\data` {
+		t.Errorf("expected the referral of the prefix, got %v", refs)
+	}
+	if refs := c.lookup(`\\example.com\dfs\database`); refs != nil {
+		t.Errorf("expected no referral, got %v", refs)
+	}
+	if refs := c.lookup(`\\example.com\dfs\tmp\file.txt`); refs != nil {
+		t.Errorf("expected no referral, got %v", refs)
+	}
+
+	c.referrals[`\\example.com\dfs\data`] = dfsCacheEntry{
+		referrals: []DFSReferral{{Path: `\\example.com\dfs\data`}},
+		expires:   time.Now().Add(-time.Second),
+	}
+
+	if refs := c.lookup(`\\example.com\dfs\data\file.txt`); refs != nil {
+		t.Errorf("expected the expired referral to be dropped, got %v", refs)
+	}
+	if len(c.referrals) != 0 {
+		t.Errorf("expected an empty cache, got %v", c.referrals)
+	}
+}
+
+func TestMountDFSDialUnlocked(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s := &session{
+		dfs: newDFSCache(),
+		dialer: &Dialer{
+			DialDFS: func(ctx context.Context, server string) (net.Conn, error) {
+				close(started)
+				<-release
+				return nil, errors.New("unreachable")
+			},
+		},
+	}
+
+	mounted := &treeConn{session: s}
+	s.dfs.trees[`\\fs1\data`] = mounted
+
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := s.mountDFS("fs2", "data", false, context.Background())
+		errc <- err
+	}()
+
+	<-started
+
+	// the trees already mounted are found while fs2 is dialed.
+	done := make(chan *treeConn, 1)
+
+	go func() {
+		tc, _ := s.mountDFS("FS1", "Data", false, context.Background())
+		done <- tc
+	}()
+
+	select {
+	case tc := <-done:
+		if tc != mounted {
+			t.Error("expected the mounted tree")
+		}
+	case <-time.After(time.Second):
+		t.Error("lookup blocked by the dial")
+	}
+
+	close(release)
+
+	if err := <-errc; err == nil {
+		t.Error("expected the dial to fail")
+	}
+	if len(s.dfs.sessions) != 0 {
+		t.Errorf("unexpected sessions: %v", s.dfs.sessions)
+	}
+}
@@ -0,0 +1,295 @@
+package smb2
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SymlinkPolicy specifies how DownloadTree treats symbolic links on the server.
+type SymlinkPolicy int
+
+const (
+	// SymlinkSkip ignores symbolic links.
+	SymlinkSkip SymlinkPolicy = iota
+
+	// SymlinkFollow downloads the target of symbolic links as if it were a regular file or directory.
+	// Links leading back to a directory being downloaded, or nested deeper than clientMaxSymlinkDepth,
+	// fail the download with ErrSymlinkLoop. (See feature.go for more details)
+	SymlinkFollow
+
+	// SymlinkRecreate creates local symbolic links that have the same target.
+	SymlinkRecreate
+)
+
+// DownloadOptions contains optional parameters for Share.DownloadTree.
+type DownloadOptions struct {
+	// Symlinks specifies how to handle symbolic links. Default is SymlinkSkip.
+	Symlinks SymlinkPolicy
+
+	// Progress, if set, is called each time a file, a directory or a symbolic link has been downloaded.
+	// n is the number of bytes copied for regular files, and zero otherwise.
+	// It may be called concurrently from multiple goroutines.
+	Progress func(remote, local string, n int64)
+
+	// Concurrency limits the number of files downloaded in parallel.
+	// If it's zero, a value derived from the credit window is used.
+	Concurrency int
+}
+
+// DownloadTree recursively copies the remote directory tree rooted at remote into localDir.
+// Missing local directories are created, existing local files are overwritten.
+// Modification and access times are preserved.
+// If opts is nil, default options are used.
+//
+// Names and symbolic links provided by the server are not trusted: names that aren't a single path element,
+// and symbolic links recreated with a target outside of localDir, fail the download with ErrUnsafePath.
+// Existing local symbolic links below localDir aren't followed either.
+func (fs *Share) DownloadTree(remote, localDir string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+
+	remote, err := cleanPath("download", remote)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fs.Lstat(remote)
+	if err != nil {
+		return err
+	}
+
+	d := &downloader{
+		src:  shareSource{fs},
+		opts: opts,
+		root: filepath.Clean(localDir),
+		l:    newLimiter(fs.concurrency(opts.Concurrency)),
+	}
+
+	var wg sync.WaitGroup
+
+	d.download(remote, d.root, fi, nil, 0, &wg)
+
+	wg.Wait()
+
+	return d.l.error()
+}
+
+// downloadSource is the part of Share read by DownloadTree.
+type downloadSource interface {
+	Lstat(name string) (os.FileInfo, error)
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	Readlink(name string) (string, error)
+	open(name string) (io.ReadCloser, error)
+}
+
+type shareSource struct {
+	*Share
+}
+
+func (s shareSource) open(name string) (io.ReadCloser, error) {
+	return s.Open(name)
+}
+
+type downloader struct {
+	src  downloadSource
+	opts *DownloadOptions
+	root string // cleaned local directory; nothing is written outside of it
+	l    *limiter
+}
+
+// download copies remote to local. Regular files are copied asynchronously and tracked by wg.
+// ancestors holds the index numbers of the directories above remote, and links the number of
+// symbolic links to directories followed to reach it, to detect cycles. Errors are reported to d.l.
+func (d *downloader) download(remote, local string, fi os.FileInfo, ancestors []int64, links int, wg *sync.WaitGroup) {
+	if local != d.root {
+		// don't write through symbolic links, whether they were there before or recreated by us.
+		if lfi, err := os.Lstat(local); err == nil && lfi.Mode()&os.ModeSymlink != 0 {
+			d.l.fail(&os.PathError{Op: "download", Path: local, Err: ErrUnsafePath})
+			return
+		}
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch d.opts.Symlinks {
+		case SymlinkSkip:
+			return
+		case SymlinkFollow:
+			target, err := d.src.Stat(remote)
+			if err != nil {
+				d.l.fail(err)
+				return
+			}
+			if target.IsDir() {
+				links++
+				if links > clientMaxSymlinkDepth || isAncestor(ancestors, target) {
+					d.l.fail(&os.PathError{Op: "download", Path: remote, Err: ErrSymlinkLoop})
+					return
+				}
+			}
+			fi = target
+		case SymlinkRecreate:
+			target, err := d.src.Readlink(remote)
+			if err != nil {
+				d.l.fail(err)
+				return
+			}
+			target, err = d.symlinkTarget(local, target)
+			if err != nil {
+				d.l.fail(&os.PathError{Op: "download", Path: remote, Err: err})
+				return
+			}
+			err = os.Symlink(target, local)
+			if err != nil {
+				d.l.fail(err)
+				return
+			}
+			if d.opts.Progress != nil {
+				d.opts.Progress(remote, local, 0)
+			}
+			return
+		default:
+			d.l.fail(&InternalError{"unknown symlink policy"})
+			return
+		}
+	}
+
+	if fi.IsDir() {
+		err := os.MkdirAll(local, 0755)
+		if err != nil {
+			d.l.fail(err)
+			return
+		}
+
+		fis, err := d.src.ReadDir(remote)
+		if err != nil {
+			d.l.fail(err)
+			return
+		}
+
+		if stat, ok := fi.Sys().(*FileStat); ok && stat.IndexNumber != 0 {
+			ancestors = append(ancestors[:len(ancestors):len(ancestors)], stat.IndexNumber)
+		}
+
+		var children sync.WaitGroup
+
+		for _, child := range fis {
+			if d.l.failed() {
+				break
+			}
+			name := child.Name()
+			if !isLocalName(name) || !isWithin(d.root, filepath.Join(local, name)) {
+				d.l.fail(&os.PathError{Op: "download", Path: remote + string(PathSeparator) + name, Err: ErrUnsafePath})
+				break
+			}
+			d.download(remote+string(PathSeparator)+name, filepath.Join(local, name), child, ancestors, links, &children)
+		}
+
+		children.Wait()
+
+		if d.l.failed() {
+			return
+		}
+
+		// set times after populating the directory, because creating children updates them.
+		err = chtimesLocal(local, fi)
+		if err != nil {
+			d.l.fail(err)
+			return
+		}
+
+		if d.opts.Progress != nil {
+			d.opts.Progress(remote, local, 0)
+		}
+		return
+	}
+
+	d.l.do(wg, func() error {
+		n, err := d.downloadFile(remote, local)
+		if err != nil {
+			return err
+		}
+
+		err = chtimesLocal(local, fi)
+		if err != nil {
+			return err
+		}
+
+		if d.opts.Progress != nil {
+			d.opts.Progress(remote, local, n)
+		}
+		return nil
+	})
+}
+
+func (d *downloader) downloadFile(remote, local string) (n int64, err error) {
+	rf, err := d.src.open(remote)
+	if err != nil {
+		return 0, err
+	}
+	defer rf.Close()
+
+	lf, err := os.Create(local)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err = io.Copy(lf, rf)
+	if e := lf.Close(); err == nil {
+		err = e
+	}
+	return n, err
+}
+
+// symlinkTarget converts the target of the remote symbolic link to be recreated at local.
+// Absolute targets, including the ones with a drive letter, and targets that resolve outside of the root are refused.
+// The target is cleaned, so that it doesn't depend on the local links it goes through.
+func (d *downloader) symlinkTarget(local, target string) (string, error) {
+	if target == "" || target[0] == '\\' || target[0] == '/' || strings.Contains(target, ":") {
+		return "", ErrUnsafePath
+	}
+	target = filepath.Clean(strings.Replace(target, `\`, string(filepath.Separator), -1))
+	if filepath.IsAbs(target) || !isWithin(d.root, filepath.Join(filepath.Dir(local), target)) {
+		return "", ErrUnsafePath
+	}
+	return target, nil
+}
+
+// isLocalName reports whether name is a single, non-special path element on the local system.
+func isLocalName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && filepath.VolumeName(name) == ""
+}
+
+// isWithin reports whether the cleaned path is root or below it.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isAncestor reports whether the directory fi is one of ancestors.
+func isAncestor(ancestors []int64, fi os.FileInfo) bool {
+	stat, ok := fi.Sys().(*FileStat)
+	if !ok || stat.IndexNumber == 0 {
+		return false
+	}
+	for _, id := range ancestors {
+		if id == stat.IndexNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func chtimesLocal(local string, fi os.FileInfo) error {
+	if stat, ok := fi.Sys().(*FileStat); ok {
+		return os.Chtimes(local, stat.LastAccessTime, stat.LastWriteTime)
+	}
+	return os.Chtimes(local, fi.ModTime(), fi.ModTime())
+}
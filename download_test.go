@@ -0,0 +1,229 @@
+package smb2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// testSource is a downloadSource serving a fixed tree. Symbolic links to directories are resolved
+// through targets, which maps the link to the path of the directory it points to.
+type testSource struct {
+	files   map[string]*FileStat   // by path
+	dirs    map[string][]*FileStat // children by path of the directory
+	links   map[string]string      // link targets returned by Readlink, by path
+	targets map[string]string      // paths followed by Stat, by path
+}
+
+func (s *testSource) Lstat(name string) (os.FileInfo, error) {
+	if i := strings.LastIndex(name, `\`); i != -1 {
+		name = s.resolve(name[:i]) + name[i:]
+	}
+	if fi, ok := s.files[name]; ok {
+		return fi, nil
+	}
+	return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+func (s *testSource) Stat(name string) (os.FileInfo, error) {
+	return s.Lstat(s.resolve(name))
+}
+
+func (s *testSource) ReadDir(dirname string) ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	for _, fi := range s.dirs[s.resolve(dirname)] {
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+// resolve replaces the links in name by their targets.
+func (s *testSource) resolve(name string) string {
+	elems := strings.Split(name, `\`)
+	path := elems[0]
+	for _, elem := range elems[1:] {
+		path += `\` + elem
+		if target, ok := s.targets[path]; ok {
+			path = target
+		}
+	}
+	return path
+}
+
+func (s *testSource) Readlink(name string) (string, error) {
+	return s.links[name], nil
+}
+
+func (s *testSource) open(name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte("data"))), nil
+}
+
+func (s *testSource) add(dir string, fi *FileStat) {
+	if s.files == nil {
+		s.files = make(map[string]*FileStat)
+		s.dirs = make(map[string][]*FileStat)
+		s.links = make(map[string]string)
+		s.targets = make(map[string]string)
+	}
+	path := fi.FileName
+	if dir != "" {
+		path = dir + `\` + fi.FileName
+		s.dirs[dir] = append(s.dirs[dir], fi)
+	}
+	s.files[path] = fi
+}
+
+func testDownload(t *testing.T, src *testSource, policy SymlinkPolicy) (string, error) {
+	localDir, err := ioutil.TempDir("", "TestDownload")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &downloader{
+		src:  src,
+		opts: &DownloadOptions{Symlinks: policy},
+		root: localDir,
+		l:    newLimiter(1),
+	}
+
+	fi, _ := src.Lstat("root")
+
+	var wg sync.WaitGroup
+
+	d.download("root", localDir, fi, nil, 0, &wg)
+
+	wg.Wait()
+
+	return localDir, d.l.error()
+}
+
+func dirStat(name string, id int64) *FileStat {
+	return &FileStat{FileName: name, FileAttributes: FILE_ATTRIBUTE_DIRECTORY, IndexNumber: id}
+}
+
+func linkStat(name string) *FileStat {
+	return &FileStat{FileName: name, FileAttributes: FILE_ATTRIBUTE_REPARSE_POINT}
+}
+
+func TestDownloadUnsafeName(t *testing.T) {
+	for _, name := range []string{"..", ".", "", `..\x`, "../x", "a/b", `a\b`} {
+		src := new(testSource)
+		src.add("", dirStat("root", 1))
+		src.add("root", &FileStat{FileName: name})
+
+		localDir, err := testDownload(t, src, SymlinkSkip)
+		os.RemoveAll(localDir)
+
+		if e, ok := err.(*os.PathError); !ok || e.Err != ErrUnsafePath {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+}
+
+func TestDownloadUnsafeSymlink(t *testing.T) {
+	for _, target := range []string{`..\x`, `sub\..\..`, `\x`, `C:\x`, `\??\C:\x`, "/x", ""} {
+		src := new(testSource)
+		src.add("", dirStat("root", 1))
+		src.add("root", linkStat("link"))
+		src.links[`root\link`] = target
+
+		localDir, err := testDownload(t, src, SymlinkRecreate)
+		os.RemoveAll(localDir)
+
+		if e, ok := err.(*os.PathError); !ok || e.Err != ErrUnsafePath {
+			t.Errorf("%q: unexpected error: %v", target, err)
+		}
+	}
+
+	src := new(testSource)
+	src.add("", dirStat("root", 1))
+	src.add("root", dirStat("sub", 2))
+	src.add("root", linkStat("link"))
+	src.add(`root\sub`, linkStat("up"))
+	src.links[`root\link`] = `sub\x\..`
+	src.links[`root\sub\up`] = `..\link`
+
+	localDir, err := testDownload(t, src, SymlinkRecreate)
+	defer os.RemoveAll(localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"link": "sub", "sub/up": "../link"} {
+		target, err := os.Readlink(filepath.Join(localDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != filepath.FromSlash(expected) {
+			t.Errorf("%s: unexpected target: %s", name, target)
+		}
+	}
+
+	// a directory of the same name as a recreated link must not be written through it.
+	src = new(testSource)
+	src.add("", dirStat("root", 1))
+	src.add("root", linkStat("sub"))
+	src.add("root", dirStat("sub", 2))
+	src.links[`root\sub`] = "."
+
+	localDir, err = testDownload(t, src, SymlinkRecreate)
+	defer os.RemoveAll(localDir)
+	if e, ok := err.(*os.PathError); !ok || e.Err != ErrUnsafePath {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadSymlinkLoop(t *testing.T) {
+	// root\sub\up points back to root.
+	src := new(testSource)
+	src.add("", dirStat("root", 1))
+	src.add("root", dirStat("sub", 2))
+	src.add(`root\sub`, linkStat("up"))
+	src.targets[`root\sub\up`] = "root"
+
+	localDir, err := testDownload(t, src, SymlinkFollow)
+	os.RemoveAll(localDir)
+	if e, ok := err.(*os.PathError); !ok || e.Err != ErrSymlinkLoop || e.Path != `root\sub\up` {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// without index numbers, the cycle is stopped by the depth limit.
+	src = new(testSource)
+	src.add("", dirStat("root", 0))
+	src.add("root", linkStat("self"))
+	src.targets[`root\self`] = "root"
+
+	localDir, err = testDownload(t, src, SymlinkFollow)
+	os.RemoveAll(localDir)
+	if e, ok := err.(*os.PathError); !ok || e.Err != ErrSymlinkLoop || strings.Count(e.Path, "self") != clientMaxSymlinkDepth+1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// links to directories that aren't ancestors are followed.
+	src = new(testSource)
+	src.add("", dirStat("root", 1))
+	src.add("root", dirStat("a", 2))
+	src.add("root", linkStat("b"))
+	src.add(`root\a`, &FileStat{FileName: "f"})
+	src.targets[`root\b`] = `root\a`
+
+	localDir, err = testDownload(t, src, SymlinkFollow)
+	defer os.RemoveAll(localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(localDir, "b", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "data" {
+		t.Error("unexpected content:", string(bs))
+	}
+}
@@ -0,0 +1,506 @@
+package smb2
+
+import (
+	"crypto/rand"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// durableHandle holds what's needed to reclaim a durable handle after a disconnect. ([MS-SMB2] 3.2.4.4)
+type durableHandle struct {
+	v2         bool          // durable handle v2 (SMB 3.x)?
+	persistent bool          // persistent handle, v2 only
+	createGuid [16]byte      // identifies the open on the server, v2 only
+	timeout    time.Duration // granted by the server, v2 only
+	req        CreateRequest // the original request, reissued on reconnect
+}
+
+// requestDurable adds the durable handle request context to req, with the batch oplock durability depends on.
+// The response is examined by grantDurable.
+func (fs *Share) requestDurable(req *CreateRequest, timeout time.Duration) error {
+	if fs.dialect >= SMB300 {
+		ms := timeout / time.Millisecond
+		if ms < 0 || ms > math.MaxUint32 {
+			return os.ErrInvalid
+		}
+
+		var createGuid [16]byte
+
+		_, err := rand.Read(createGuid[:])
+		if err != nil {
+			return &InternalError{err.Error()}
+		}
+
+		var flags uint32
+		if fs.continuouslyAvailable() {
+			flags = SMB2_DHANDLE_FLAG_PERSISTENT
+		}
+
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2,
+			Data: &DurableHandleRequestV2{
+				Timeout:    uint32(ms),
+				Flags:      flags,
+				CreateGuid: createGuid,
+			},
+		})
+	} else {
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST,
+			Data: &DurableHandleRequest{},
+		})
+	}
+
+	req.RequestedOplockLevel = SMB2_OPLOCK_LEVEL_BATCH
+
+	return nil
+}
+
+// continuouslyAvailable reports whether persistent handles can be requested on fs,
+// i.e. whether the share is continuously available and the server supports persistent handles.
+func (fs *Share) continuouslyAvailable() bool {
+	return fs.dialect >= SMB300 && fs.shareCapabilities&SMB2_SHARE_CAP_CONTINUOUS_AVAILABILITY != 0 &&
+		fs.capabilities&SMB2_GLOBAL_CAP_PERSISTENT_HANDLES != 0
+}
+
+// requestAppInstance adds the app instance id and version contexts to req, which requests a durable handle v2.
+// When another client opens the file with the same id, typically the same application restarted on
+// another node of a cluster, the server closes the handle instead of failing the open. ([MS-SMB2] 3.3.5.9.13)
+func (fs *Share) requestAppInstance(req *CreateRequest, id [16]byte, high, low uint64) {
+	if fs.dialect < SMB300 || id == [16]byte{} {
+		return
+	}
+
+	req.Contexts = append(req.Contexts, &CreateContext{
+		Name: SMB2_CREATE_APP_INSTANCE_ID,
+		Data: &AppInstanceId{AppInstanceId: id},
+	})
+
+	if fs.dialect >= SMB311 && (high != 0 || low != 0) {
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_APP_INSTANCE_VERSION,
+			Data: &AppInstanceVersion{AppInstanceVersionHigh: high, AppInstanceVersionLow: low},
+		})
+	}
+}
+
+// grantDurable returns the durable state of the handle opened by req,
+// or nil if the server didn't grant durability.
+func grantDurable(r CreateResponseDecoder, req *CreateRequest) *durableHandle {
+	var d *durableHandle
+
+	for _, c := range req.Contexts {
+		c, ok := c.(*CreateContext)
+		if !ok {
+			continue
+		}
+
+		switch data := c.Data.(type) {
+		case *DurableHandleRequestV2:
+			d = &durableHandle{v2: true, createGuid: data.CreateGuid}
+		case *DurableHandleRequest:
+			d = &durableHandle{}
+		}
+	}
+
+	if d == nil {
+		return nil
+	}
+
+	granted := false
+
+	for cs := r.CreateContexts(); len(cs) > 0; {
+		c := CreateContextDecoder(cs)
+		if c.IsInvalid() {
+			return nil
+		}
+
+		switch c.Name() {
+		case SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2:
+			res := DurableHandleResponseV2Decoder(c.Data())
+			if res.IsInvalid() {
+				return nil
+			}
+			d.timeout = time.Duration(res.Timeout()) * time.Millisecond
+			d.persistent = res.Flags()&SMB2_DHANDLE_FLAG_PERSISTENT != 0
+			granted = d.v2
+		case SMB2_CREATE_DURABLE_HANDLE_REQUEST:
+			granted = !d.v2
+		}
+
+		next := c.Next()
+		if next == 0 || int(next) > len(cs) {
+			break
+		}
+		cs = cs[next:]
+	}
+
+	if !granted {
+		return nil
+	}
+
+	d.req = reopenRequest(req)
+
+	return d
+}
+
+// reopenRequest returns a copy of the request that opened a handle, without its header and contexts,
+// to be reissued with the reconnect context by Reconnect.
+func reopenRequest(req *CreateRequest) CreateRequest {
+	reopen := *req
+	reopen.PacketHeader = PacketHeader{}
+	reopen.Contexts = nil
+	return reopen
+}
+
+// IsDurable reports whether the handle is durable, i.e. whether it can be reclaimed by Reconnect.
+// See OpenOptions.Durable.
+func (f *File) IsDurable() bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.durable != nil
+}
+
+// IsPersistent reports whether the handle is persistent, i.e. a durable handle that the server keeps
+// across failovers of the nodes of a cluster, and doesn't give away to other clients until it times out.
+// Durable handles are requested persistent on continuously available shares, see OpenOptions.Durable.
+func (f *File) IsPersistent() bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.durable != nil && f.durable.persistent
+}
+
+// DurableTimeout returns the time the server keeps the durable handle after a disconnect.
+// It's zero if the handle isn't durable or the server didn't report it (SMB 2.x).
+func (f *File) DurableTimeout() time.Duration {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.durable == nil {
+		return 0
+	}
+	return f.durable.timeout
+}
+
+// Reconnect reclaims the durable handle on fs, typically the same share mounted by a new session
+// after the connection of f was lost. Then f uses fs, keeping its name and offset.
+// Persistent handles can be reclaimed through any node of the cluster that serves the share,
+// e.g. the one a scale-out file server fails over to.
+// The new session must use the client GUID of the old one, see Negotiator.StableClientGuid.
+// Handles made resilient by RequestResiliency are reclaimed the same way, with the file id of the old handle.
+// If the handle is neither durable nor resilient, it returns os.ErrInvalid.
+// If the server doesn't keep the handle anymore, e.g. because the timeout has elapsed
+// or another client has opened the file in the meantime, it returns ErrHandleExpired.
+// Reconnect must not be called concurrently with other methods of f.
+func (f *File) Reconnect(fs *Share) error {
+	err := f.reconnect(fs)
+	if err != nil {
+		return &os.PathError{Op: "reconnect", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) reconnect(fs *Share) (err error) {
+	f.m.Lock()
+	d := f.reclaimable()
+	fd := f.fd
+	f.m.Unlock()
+
+	if d == nil || fd == nil {
+		return os.ErrInvalid
+	}
+
+	req := d.reconnectRequest(fs, f.name, fd)
+
+	req.CreditCharge, _, err = fs.loanCredit(0)
+	defer func() {
+		if err != nil {
+			fs.chargeCredit(req.CreditCharge)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	res, err := fs.sendRecv(SMB2_CREATE, req)
+	if err != nil {
+		// the server doesn't know the handle anymore.
+		if err == os.ErrNotExist {
+			return ErrHandleExpired
+		}
+		return err
+	}
+
+	r := CreateResponseDecoder(res)
+	if r.IsInvalid() {
+		return &InvalidResponseError{"broken create response format"}
+	}
+
+	f.fs.untrackHandle(fd)
+	f.fs.oplocks.delete(fd)
+
+	newFd := r.FileId().Decode()
+
+	f.m.Lock()
+	f.fs = fs
+	f.fd = newFd
+	f.m.Unlock()
+
+	if mayWrite(req.DesiredAccess) {
+		fs.trackHandle(newFd, f.name, req.DesiredAccess)
+	}
+	if r.OplockLevel() != SMB2_OPLOCK_LEVEL_NONE {
+		fs.oplocks.set(newFd, f)
+	}
+
+	return nil
+}
+
+// reclaimable returns the state needed to reclaim the handle, or nil if it can't be reclaimed.
+// Resilient handles are reclaimed like durable handles v1. ([MS-SMB2] 3.2.4.4)
+// It's called with f.m held.
+func (f *File) reclaimable() *durableHandle {
+	if f.durable != nil {
+		return f.durable
+	}
+	if f.resiliencyTimeout != 0 && f.reopen != nil {
+		return &durableHandle{timeout: f.resiliencyTimeout, req: *f.reopen}
+	}
+	return nil
+}
+
+// reconnectRequest returns the request reclaiming the handle fd of name on fs.
+func (d *durableHandle) reconnectRequest(fs *Share, name string, fd *FileId) *CreateRequest {
+	req := d.req
+
+	fs.createName(&req, name)
+
+	if d.v2 {
+
+		var flags uint32
+		if d.persistent {
+			flags = SMB2_DHANDLE_FLAG_PERSISTENT
+		}
+
+		req.Contexts = []Encoder{&CreateContext{
+			Name: SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2,
+			Data: &DurableHandleReconnectV2{
+				FileId:     fd,
+				CreateGuid: d.createGuid,
+				Flags:      flags,
+			},
+		}}
+	} else {
+		req.Contexts = []Encoder{&CreateContext{
+			Name: SMB2_CREATE_DURABLE_HANDLE_RECONNECT,
+			Data: &DurableHandleReconnect{
+				FileId: fd,
+			},
+		}}
+	}
+
+	return &req
+}
+
+// oplockTable maps the handles holding an oplock and the keys of leases to their files,
+// so that the break notifications sent by the server can be acknowledged.
+// It's shared by the channels of a session.
+type oplockTable struct {
+	m       sync.Mutex
+	files   map[FileId]*File
+	leases  map[[16]byte]*File
+	handler func(*OplockBreak) // see Session.OnOplockBreak
+}
+
+func newOplockTable() *oplockTable {
+	return &oplockTable{
+		files:  make(map[FileId]*File),
+		leases: make(map[[16]byte]*File),
+	}
+}
+
+func (t *oplockTable) set(fd *FileId, f *File) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.files[*fd] = f
+}
+
+func (t *oplockTable) get(fd *FileId) *File {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.files[*fd]
+}
+
+func (t *oplockTable) delete(fd *FileId) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	delete(t.files, *fd)
+}
+
+func (t *oplockTable) setLease(key [16]byte, f *File) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.leases[key] = f
+}
+
+func (t *oplockTable) getLease(key [16]byte) *File {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	return t.leases[key]
+}
+
+func (t *oplockTable) deleteLease(key [16]byte) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	delete(t.leases, key)
+}
+
+func (t *oplockTable) setHandler(handler func(*OplockBreak)) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.handler = handler
+}
+
+// notify passes b to the registered handler, if any, and reports whether there was one.
+func (t *oplockTable) notify(b *OplockBreak) bool {
+	t.m.Lock()
+	handler := t.handler
+	t.m.Unlock()
+
+	if handler == nil {
+		return false
+	}
+
+	handler(b)
+
+	return true
+}
+
+// OplockBreak is an oplock or lease break notification sent by the server. See Session.OnOplockBreak.
+type OplockBreak struct {
+	// File is the file holding the oplock or the lease, or nil if it isn't open by this client,
+	// e.g. because it was opened by Session.RawRequest.
+	File *File
+
+	// FileId is the persistent and the volatile file id of the handle, for oplock breaks.
+	FileId [16]byte
+
+	// LeaseKey is the key of the lease, for lease breaks.
+	LeaseKey [16]byte
+
+	// Lease reports whether the notification breaks a lease rather than an oplock.
+	Lease bool
+
+	// NewState is the new oplock level (0 for none, 1 for level II), or the new LeaseState.
+	NewState uint32
+}
+
+// OnOplockBreak registers handler to be called on each oplock or lease break notification
+// sent by the server, before the break is acknowledged. It replaces the handler registered before;
+// nil unregisters it. Breaks of files opened by the session are acknowledged automatically
+// after handler returns, so it should return promptly. Breaks of other handles are only passed to handler.
+// handler is called from its own goroutine.
+func (c *Session) OnOplockBreak(handler func(b *OplockBreak)) {
+	c.s.oplocks.setHandler(handler)
+}
+
+// handleOplockBreak acknowledges an oplock break notification, which the server sends
+// when another client opens a file on which an oplock is held. ([MS-SMB2] 3.2.5.19.1)
+// The other open waits until the acknowledgement, or until the server gives up after 35 seconds.
+// It's called by the receiver, so the acknowledgement is sent by another goroutine.
+// Lease break notifications share the command and are passed to handleLeaseBreak.
+func (conn *conn) handleOplockBreak(pkt []byte) error {
+	if r := LeaseBreakNotificationDecoder(PacketCodec(pkt).Data()); !r.IsInvalid() {
+		return conn.handleLeaseBreak(r)
+	}
+
+	r := OplockBreakDecoder(PacketCodec(pkt).Data())
+	if r.IsInvalid() {
+		return &InvalidResponseError{"broken oplock break notification format"}
+	}
+
+	s := conn.session
+	if s == nil || s.oplocks == nil {
+		return &InvalidResponseError{"unexpected oplock break notification"}
+	}
+
+	b := &OplockBreak{NewState: uint32(r.OplockLevel())}
+	copy(b.FileId[:], r.FileId())
+
+	f := s.oplocks.get(r.FileId().Decode())
+	if f == nil {
+		go func() {
+			if !s.oplocks.notify(b) {
+				s.log().Warn("skip", "err", &InvalidResponseError{"oplock break notification for unknown file"})
+			}
+		}()
+
+		return nil
+	}
+
+	b.File = f
+
+	go f.acknowledgeOplockBreak(b)
+
+	return nil
+}
+
+func (f *File) acknowledgeOplockBreak(b *OplockBreak) {
+	level := uint8(b.NewState)
+
+	f.m.Lock()
+	fd := f.fd
+	fs := f.fs
+	f.m.Unlock()
+
+	if fd == nil {
+		return
+	}
+
+	fs.oplocks.notify(b)
+
+	req := &OplockBreakAcknowledgement{
+		OplockLevel: level,
+		FileId:      fd,
+	}
+
+	req.CreditCharge = 1
+
+	res, err := fs.sendRecv(SMB2_OPLOCK_BREAK, req)
+	if err != nil {
+		fs.log().Warn("oplock break failed", "err", err)
+
+		return
+	}
+
+	r := OplockBreakDecoder(res)
+	if r.IsInvalid() {
+		fs.log().Warn("oplock break failed", "err", &InvalidResponseError{"broken oplock break response format"})
+
+		return
+	}
+
+	// durability depends on the batch oplock.
+	if r.OplockLevel() != SMB2_OPLOCK_LEVEL_BATCH {
+		f.m.Lock()
+		f.durable = nil
+		f.m.Unlock()
+	}
+
+	if r.OplockLevel() == SMB2_OPLOCK_LEVEL_NONE {
+		fs.oplocks.delete(fd)
+	}
+}
@@ -0,0 +1,280 @@
+package smb2
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func encodeCreateResponse(contexts ...Encoder) CreateResponseDecoder {
+	res := &CreateResponse{
+		OplockLevel:    SMB2_OPLOCK_LEVEL_BATCH,
+		CreationTime:   &Filetime{},
+		LastAccessTime: &Filetime{},
+		LastWriteTime:  &Filetime{},
+		ChangeTime:     &Filetime{},
+		FileId:         &FileId{},
+		Contexts:       contexts,
+	}
+
+	pkt := make([]byte, res.Size())
+	res.Encode(pkt)
+
+	return CreateResponseDecoder(pkt[64:])
+}
+
+func TestGrantDurable(t *testing.T) {
+	createGuid := [16]byte{1, 2, 3}
+
+	req := &CreateRequest{
+		Name: "file",
+		Contexts: []Encoder{&CreateContext{
+			Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2,
+			Data: &DurableHandleRequestV2{CreateGuid: createGuid},
+		}},
+	}
+	req.MessageId = 5
+
+	// the response context precedes another one, so that Next is followed.
+	r := encodeCreateResponse(
+		&CreateContext{Name: "MxAc", Data: &DurableHandleReconnectV2{}},
+		&CreateContext{Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2, Data: &DurableHandleRequestV2{Timeout: 60000}},
+	)
+
+	d := grantDurable(r, req)
+	if d == nil {
+		t.Fatal("expected durable handle")
+	}
+	if !d.v2 || d.createGuid != createGuid || d.timeout != time.Minute {
+		t.Errorf("unexpected durable handle: %+v", d)
+	}
+	if d.req.Name != "file" || d.req.MessageId != 0 || d.req.Contexts != nil {
+		t.Errorf("unexpected request to reissue: %+v", d.req)
+	}
+
+	// not granted.
+	if d := grantDurable(encodeCreateResponse(), req); d != nil {
+		t.Errorf("unexpected durable handle: %+v", d)
+	}
+
+	// not requested.
+	if d := grantDurable(r, &CreateRequest{}); d != nil {
+		t.Errorf("unexpected durable handle: %+v", d)
+	}
+
+	// durable handle v1.
+	req.Contexts = []Encoder{&CreateContext{
+		Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST,
+		Data: &DurableHandleRequest{},
+	}}
+
+	r = encodeCreateResponse(&CreateContext{Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST, Data: &DurableHandleRequest{}})
+
+	d = grantDurable(r, req)
+	if d == nil || d.v2 {
+		t.Errorf("unexpected durable handle: %+v", d)
+	}
+}
+
+func TestCreateContexts(t *testing.T) {
+	fd := &FileId{Persistent: [8]byte{1}, Volatile: [8]byte{2}}
+
+	req := &CreateRequest{
+		Name: "file",
+		Contexts: []Encoder{
+			&CreateContext{Name: SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2, Data: &DurableHandleReconnectV2{FileId: fd, Flags: SMB2_DHANDLE_FLAG_PERSISTENT}},
+			&CreateContext{Name: SMB2_CREATE_DURABLE_HANDLE_RECONNECT, Data: &DurableHandleReconnect{FileId: fd}},
+		},
+	}
+
+	pkt := make([]byte, req.Size())
+	req.Encode(pkt)
+
+	r := CreateRequestDecoder(pkt[64:])
+	if r.IsInvalid() {
+		t.Fatal("broken create request")
+	}
+
+	cs := pkt[r.CreateContextsOffset():]
+
+	var names []string
+
+	for len(cs) > 0 {
+		c := CreateContextDecoder(cs)
+		if c.IsInvalid() {
+			t.Fatal("broken create context")
+		}
+
+		names = append(names, c.Name())
+
+		if got := FileIdDecoder(c.Data()).Decode(); *got != *fd {
+			t.Errorf("unexpected file id: %v", got)
+		}
+
+		if c.Next() == 0 {
+			break
+		}
+		if c.Next()%8 != 0 {
+			t.Errorf("unaligned next context: %d", c.Next())
+		}
+		cs = cs[c.Next():]
+	}
+
+	if len(names) != 2 || names[0] != SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2 || names[1] != SMB2_CREATE_DURABLE_HANDLE_RECONNECT {
+		t.Errorf("unexpected contexts: %v", names)
+	}
+}
+
+func TestRequestAppInstance(t *testing.T) {
+	id := [16]byte{1, 2, 3}
+
+	share := func(dialect uint16) *Share {
+		return &Share{treeConn: &treeConn{session: &session{conn: &conn{dialect: dialect}}}}
+	}
+
+	contexts := func(req *CreateRequest) map[string][]byte {
+		m := make(map[string][]byte)
+		for _, c := range req.Contexts {
+			c := c.(*CreateContext)
+			p := make([]byte, c.Data.Size())
+			c.Data.Encode(p)
+			m[c.Name] = p
+		}
+		return m
+	}
+
+	req := new(CreateRequest)
+	share(SMB311).requestAppInstance(req, id, 0, 7)
+
+	cs := contexts(req)
+	if p := cs[SMB2_CREATE_APP_INSTANCE_ID]; len(p) != 20 || p[0] != 20 || p[4] != 1 || p[6] != 3 {
+		t.Errorf("unexpected app instance id: %x", p)
+	}
+	if p := cs[SMB2_CREATE_APP_INSTANCE_VERSION]; len(p) != 24 || p[0] != 24 || p[16] != 7 {
+		t.Errorf("unexpected app instance version: %x", p)
+	}
+
+	// the version requires SMB 3.1.1.
+	req = new(CreateRequest)
+	share(SMB300).requestAppInstance(req, id, 0, 7)
+
+	if cs := contexts(req); len(cs) != 1 || cs[SMB2_CREATE_APP_INSTANCE_ID] == nil {
+		t.Errorf("unexpected contexts: %x", cs)
+	}
+
+	// no id.
+	req = new(CreateRequest)
+	share(SMB311).requestAppInstance(req, [16]byte{}, 0, 7)
+
+	if len(req.Contexts) != 0 {
+		t.Errorf("unexpected contexts: %v", req.Contexts)
+	}
+
+	// no durable handle v2.
+	req = new(CreateRequest)
+	share(SMB210).requestAppInstance(req, id, 0, 7)
+
+	if len(req.Contexts) != 0 {
+		t.Errorf("unexpected contexts: %v", req.Contexts)
+	}
+}
+
+func TestPersistentHandle(t *testing.T) {
+	fs := &Share{treeConn: &treeConn{
+		session:           &session{conn: &conn{dialect: SMB300, capabilities: SMB2_GLOBAL_CAP_PERSISTENT_HANDLES}},
+		shareCapabilities: SMB2_SHARE_CAP_CONTINUOUS_AVAILABILITY,
+	}}
+
+	req := new(CreateRequest)
+
+	err := fs.requestDurable(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := req.Contexts[0].(*CreateContext).Data.(*DurableHandleRequestV2); data.Flags != SMB2_DHANDLE_FLAG_PERSISTENT {
+		t.Errorf("expected a persistent handle request, got flags %#x", data.Flags)
+	}
+
+	r := encodeCreateResponse(&CreateContext{
+		Name: SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2,
+		Data: &DurableHandleRequestV2{Flags: SMB2_DHANDLE_FLAG_PERSISTENT},
+	})
+
+	if d := grantDurable(r, req); d == nil || !d.persistent {
+		t.Errorf("expected a persistent handle, got %+v", d)
+	}
+
+	// the share isn't continuously available.
+	fs.shareCapabilities = 0
+
+	req = new(CreateRequest)
+
+	err = fs.requestDurable(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := req.Contexts[0].(*CreateContext).Data.(*DurableHandleRequestV2); data.Flags != 0 {
+		t.Errorf("expected a durable handle request, got flags %#x", data.Flags)
+	}
+}
+
+func TestReconnectResilient(t *testing.T) {
+	fs := &Share{treeConn: &treeConn{session: &session{conn: &conn{dialect: SMB311}}}}
+
+	fd := &FileId{Persistent: [8]byte{1}, Volatile: [8]byte{2}}
+
+	f := &File{fs: fs, fd: fd, name: "file", reopen: &CreateRequest{DesiredAccess: FILE_WRITE_DATA}}
+
+	if f.reclaimable() != nil {
+		t.Error("handle should not be reclaimable before RequestResiliency")
+	}
+
+	f.resiliencyTimeout = time.Minute
+
+	d := f.reclaimable()
+	if d == nil {
+		t.Fatal("resilient handle should be reclaimable")
+	}
+
+	// resilient handles are reclaimed by a durable handle v1 reconnect, even on SMB 3.x.
+	req := d.reconnectRequest(fs, f.name, f.fd)
+	if req.Name != "file" || req.DesiredAccess != FILE_WRITE_DATA || len(req.Contexts) != 1 {
+		t.Fatalf("unexpected reconnect request: %+v", req)
+	}
+	c := req.Contexts[0].(*CreateContext)
+	if data, ok := c.Data.(*DurableHandleReconnect); c.Name != SMB2_CREATE_DURABLE_HANDLE_RECONNECT || !ok || data.FileId != fd {
+		t.Errorf("unexpected reconnect context: %+v", c)
+	}
+
+	// a durable handle is reclaimed as such.
+	f.durable = &durableHandle{v2: true, createGuid: [16]byte{3}}
+
+	req = f.reclaimable().reconnectRequest(fs, f.name, f.fd)
+	if c := req.Contexts[0].(*CreateContext); c.Name != SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2 {
+		t.Errorf("unexpected reconnect context: %+v", c)
+	}
+}
+
+func TestResiliencyTimeoutMs(t *testing.T) {
+	for _, tc := range []struct {
+		timeout time.Duration
+		ms      uint32
+		ok      bool
+	}{
+		{0, 0, true},
+		{time.Nanosecond, 1, true},
+		{999 * time.Microsecond, 1, true},
+		{1500 * time.Microsecond, 1, true},
+		{time.Minute, 60000, true},
+		{-time.Millisecond, 0, false},
+		{-time.Nanosecond, 0, false},
+		{(math.MaxUint32 + 1) * time.Millisecond, 0, false},
+	} {
+		ms, err := resiliencyTimeoutMs(tc.timeout)
+		if (err == nil) != tc.ok || ms != tc.ms {
+			t.Errorf("%v: unexpected result: %d, %v", tc.timeout, ms, err)
+		}
+	}
+}
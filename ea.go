@@ -0,0 +1,222 @@
+package smb2
+
+import (
+	"os"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// EAInfo is an extended attribute of a file.
+type EAInfo struct {
+	Name  string // ASCII, up to 255 characters; NTFS compares names case-insensitively and stores them in upper case
+	Value []byte
+	Need  bool // whether the file can't be interpreted without the EA (FILE_NEED_EA)
+}
+
+// validEAName reports whether name can be used as the name of an EA. ([MS-FSCC] 2.4.15)
+func validEAName(name string) bool {
+	if len(name) == 0 || len(name) > 255 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 0x20 || c >= 0x7f {
+			return false
+		}
+		switch c {
+		case '"', '*', '+', ',', '/', ':', ';', '<', '=', '>', '?', '[', '\\', ']', '|':
+			return false
+		}
+	}
+	return true
+}
+
+// ListEA returns the extended attributes of the file, using FileFullEaInformation.
+// If the server or the underlying file system doesn't support EAs, it returns ErrNotSupported.
+func (f *File) ListEA() ([]EAInfo, error) {
+	eas, err := f.listEA()
+	if err != nil {
+		return nil, &os.PathError{Op: "listea", Path: f.name, Err: err}
+	}
+	return eas, nil
+}
+
+func (f *File) listEA() ([]EAInfo, error) {
+	size := 4096
+
+	for {
+		if size > f.maxTransactSize() {
+			size = f.maxTransactSize()
+		}
+
+		req := &QueryInfoRequest{
+			InfoType:              SMB2_0_INFO_FILE,
+			FileInfoClass:         FileFullEaInformation,
+			AdditionalInformation: 0,
+			Flags:                 SL_RESTART_SCAN,
+			OutputBufferLength:    uint32(size),
+		}
+
+		infoBytes, err := f.queryInfo(req)
+		if err != nil {
+			if rerr, ok := err.(*ResponseError); ok {
+				switch NtStatus(rerr.Code) {
+				case STATUS_NO_EAS_ON_FILE, STATUS_NO_MORE_EAS:
+					return nil, nil
+				case STATUS_BUFFER_OVERFLOW, STATUS_BUFFER_TOO_SMALL:
+					// the server doesn't return a part of the list.
+					if size < f.maxTransactSize() {
+						size *= 2
+						continue
+					}
+				}
+			}
+			return nil, eaError(err)
+		}
+
+		return parseEAs(infoBytes)
+	}
+}
+
+// GetEA returns the value of the extended attribute name of the file.
+// If the file has no such EA, it returns os.ErrNotExist.
+// If the server or the underlying file system doesn't support EAs, it returns ErrNotSupported.
+func (f *File) GetEA(name string) ([]byte, error) {
+	value, err := f.getEA(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "getea", Path: f.name, Err: err}
+	}
+	return value, nil
+}
+
+func (f *File) getEA(name string) ([]byte, error) {
+	if !validEAName(name) {
+		return nil, os.ErrInvalid
+	}
+
+	req := &QueryInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileFullEaInformation,
+		AdditionalInformation: 0,
+		Flags:                 0,
+		OutputBufferLength:    uint32(f.maxTransactSize()),
+		Input:                 FileGetEaInformationList{name},
+	}
+
+	if req.OutputBufferLength > 8+255+1+65535 {
+		req.OutputBufferLength = 8 + 255 + 1 + 65535
+	}
+
+	infoBytes, err := f.queryInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NO_EAS_ON_FILE, STATUS_NONEXISTENT_EA_ENTRY:
+				return nil, os.ErrNotExist
+			}
+		}
+		return nil, eaError(err)
+	}
+
+	eas, err := parseEAs(infoBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server returns an empty value for a missing EA, since setting an empty value deletes the EA.
+	if len(eas) == 0 || len(eas[0].Value) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	return eas[0].Value, nil
+}
+
+// SetEA sets the value of the extended attribute name of the file, using FileFullEaInformation.
+// An empty value removes the EA. The total size of the EAs of a file is limited by the server,
+// to 64 KiB on NTFS.
+// If the server or the underlying file system doesn't support EAs, it returns ErrNotSupported.
+func (f *File) SetEA(name string, value []byte) error {
+	err := f.setEA(name, value)
+	if err != nil {
+		return &os.PathError{Op: "setea", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *File) setEA(name string, value []byte) error {
+	if !validEAName(name) || len(value) > 0xffff {
+		return os.ErrInvalid
+	}
+
+	req := &SetInfoRequest{
+		InfoType:              SMB2_0_INFO_FILE,
+		FileInfoClass:         FileFullEaInformation,
+		AdditionalInformation: 0,
+		Input: FileFullEaInformationList{
+			&FileFullEaInformationEncoder{
+				EaName:  name,
+				EaValue: value,
+			},
+		},
+	}
+
+	err := f.setInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NONEXISTENT_EA_ENTRY:
+				// removing a missing EA.
+				if len(value) == 0 {
+					return nil
+				}
+			case STATUS_INVALID_EA_NAME:
+				return os.ErrInvalid
+			}
+		}
+		return eaError(err)
+	}
+
+	return nil
+}
+
+// eaError translates the status of servers without support for EAs.
+func eaError(err error) error {
+	if rerr, ok := err.(*ResponseError); ok {
+		switch NtStatus(rerr.Code) {
+		case STATUS_EAS_NOT_SUPPORTED, STATUS_NOT_SUPPORTED, STATUS_INVALID_INFO_CLASS, STATUS_INVALID_DEVICE_REQUEST:
+			return ErrNotSupported
+		}
+	}
+	return err
+}
+
+// parseEAs decodes a list of FILE_FULL_EA_INFORMATION.
+func parseEAs(infoBytes []byte) ([]EAInfo, error) {
+	var eas []EAInfo
+
+	for len(infoBytes) > 0 {
+		ea := FileFullEaInformationDecoder(infoBytes)
+		if ea.IsInvalid() {
+			return nil, &InvalidResponseError{"broken full ea information format"}
+		}
+
+		eas = append(eas, EAInfo{
+			Name:  ea.EaName(),
+			Value: append([]byte(nil), ea.EaValue()...),
+			Need:  ea.Flags()&FILE_NEED_EA != 0,
+		})
+
+		next := ea.NextEntryOffset()
+		if next == 0 {
+			break
+		}
+		if int(next) > len(infoBytes) {
+			return nil, &InvalidResponseError{"broken full ea information format"}
+		}
+
+		infoBytes = infoBytes[next:]
+	}
+
+	return eas, nil
+}
@@ -0,0 +1,60 @@
+package smb2
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestParseEAs(t *testing.T) {
+	list := FileFullEaInformationList{
+		&FileFullEaInformationEncoder{EaName: "USER.COMMENT", EaValue: []byte("hello")},
+		&FileFullEaInformationEncoder{Flags: FILE_NEED_EA, EaName: "X", EaValue: []byte{0, 1, 2}},
+		&FileFullEaInformationEncoder{EaName: "EMPTY"},
+	}
+
+	p := make([]byte, list.Size())
+	list.Encode(p)
+
+	// entries are 4-byte aligned: 8+12+1+5 = 26 -> 28, 8+1+1+3 = 13 -> 16, 8+5+1.
+	if len(p) != 28+16+14 {
+		t.Fatalf("unexpected size: %d", len(p))
+	}
+
+	eas, err := parseEAs(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eas) != 3 {
+		t.Fatalf("unexpected EAs: %v", eas)
+	}
+	if eas[0].Name != "USER.COMMENT" || string(eas[0].Value) != "hello" || eas[0].Need {
+		t.Errorf("unexpected EA: %v", eas[0])
+	}
+	if eas[1].Name != "X" || !bytes.Equal(eas[1].Value, []byte{0, 1, 2}) || !eas[1].Need {
+		t.Errorf("unexpected EA: %v", eas[1])
+	}
+	if eas[2].Name != "EMPTY" || len(eas[2].Value) != 0 {
+		t.Errorf("unexpected EA: %v", eas[2])
+	}
+
+	for _, n := range []int{4, 20, 30} {
+		if _, err := parseEAs(p[:n]); err == nil {
+			t.Errorf("expected an error for %d bytes", n)
+		}
+	}
+}
+
+func TestValidEAName(t *testing.T) {
+	for _, name := range []string{"user.comment", "A", string(bytes.Repeat([]byte{'a'}, 255))} {
+		if !validEAName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+	for _, name := range []string{"", "a=b", "a\\b", "a\x00", "é", string(bytes.Repeat([]byte{'a'}, 256))} {
+		if validEAName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
@@ -2,11 +2,72 @@ package smb2
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	. "github.com/hirochachacha/go-smb2/internal/erref"
 )
 
+var (
+	// ErrNotDirectory is returned when a directory is expected but the file is not a directory.
+	ErrNotDirectory = errors.New("not a directory")
+
+	// ErrIsDirectory is returned when a non-directory is expected but the file is a directory.
+	ErrIsDirectory = errors.New("is a directory")
+
+	// ErrEncrypted is returned when the file is encrypted by EFS and can't be accessed as requested.
+	ErrEncrypted = errors.New("file is encrypted")
+
+	// ErrSharingViolation matches the ResponseError of opens failed because the file is open by another handle
+	// with an incompatible share access, e.g. a file being written by another process on Windows.
+	ErrSharingViolation = errors.New("sharing violation")
+
+	// ErrDiskFull matches the ResponseError of writes and creates failed because the volume is full.
+	ErrDiskFull = errors.New("disk full")
+
+	// ErrLockNotGranted is returned when a byte-range lock conflicts with a lock held by another handle.
+	ErrLockNotGranted = errors.New("lock not granted")
+
+	// ErrLockTimeout is returned by File.LockWait when the lock isn't granted in time.
+	ErrLockTimeout = errors.New("lock wait timed out")
+
+	// ErrShareNotFound is returned by Session.Mount when the share doesn't exist on the server.
+	ErrShareNotFound = errors.New("share not found")
+
+	// ErrShareUnavailable matches the ResponseError of requests failed because the share
+	// was deleted or paused, or the server is unavailable. Mounting the share again may succeed later.
+	ErrShareUnavailable = errors.New("share unavailable")
+
+	// ErrNotSupported is returned when the server doesn't support the requested operation.
+	ErrNotSupported = errors.New("operation not supported by server")
+
+	// ErrHandleExpired is returned by File.Reconnect when the server doesn't keep the durable handle anymore.
+	ErrHandleExpired = errors.New("durable handle expired")
+
+	// ErrGuestSession is returned by Dial when the server logged the client on as guest and Dialer.RejectGuest is set.
+	ErrGuestSession = errors.New("logged on as guest")
+
+	// ErrEncryptionNotSupported is returned by Dial when Dialer.RequireEncryption is set and the session can't be encrypted,
+	// and by Share.SetEncryption for such sessions.
+	ErrEncryptionNotSupported = errors.New("encryption not supported")
+
+	// ErrUnsafePath is returned by Share.DownloadTree when a name or a symbolic link from the server
+	// would make it write outside of the local directory.
+	ErrUnsafePath = errors.New("path escapes the local directory")
+
+	// ErrSymlinkLoop is returned by Share.DownloadTree when following symbolic links leads to a cycle.
+	ErrSymlinkLoop = errors.New("too many levels of symbolic links")
+
+	// ErrPoolClosed is returned by SessionPool.Get once the pool is closed.
+	ErrPoolClosed = errors.New("session pool closed")
+
+	// ErrKeepAliveTimeout is wrapped in the TransportError of requests failed because the connection was closed
+	// after the server didn't answer an ECHO in time. (See Dialer.KeepAlive)
+	ErrKeepAliveTimeout = errors.New("keepalive timed out")
+)
+
 // TransportError represents a error come from net.Conn layer.
 type TransportError struct {
 	Err error
@@ -16,6 +77,42 @@ func (err *TransportError) Error() string {
 	return fmt.Sprintf("connection error: %v", err.Err)
 }
 
+func (err *TransportError) Unwrap() error {
+	return err.Err
+}
+
+// NetBIOSSessionError is returned by Dial when the server refuses the NetBIOS session request. (RFC 1002 4.3.4)
+// See Dialer.NetBIOS.
+type NetBIOSSessionError struct {
+	Code byte // error code of the NEGATIVE SESSION RESPONSE
+}
+
+func (err *NetBIOSSessionError) Error() string {
+	switch err.Code {
+	case 0x80:
+		return "netbios session refused: not listening on called name"
+	case 0x81:
+		return "netbios session refused: not listening for calling name"
+	case 0x82:
+		return "netbios session refused: called name not present"
+	case 0x83:
+		return "netbios session refused: called name present, but insufficient resources"
+	default:
+		return fmt.Sprintf("netbios session refused: error %#x", err.Code)
+	}
+}
+
+// DialectNotAllowedError is returned by Dial when the server selects a dialect lower than the minimum one.
+// See Negotiator.MinDialect.
+type DialectNotAllowedError struct {
+	Dialect    uint16 // selected by the server, or 0x2ff if it only told that it supports SMB 2.1 or later
+	MinDialect uint16
+}
+
+func (err *DialectNotAllowedError) Error() string {
+	return fmt.Sprintf("server selected dialect %#x, lower than the minimum dialect %#x", err.Dialect, err.MinDialect)
+}
+
 // InternalError represents internal error.
 type InternalError struct {
 	Message string
@@ -34,6 +131,18 @@ func (err *InvalidResponseError) Error() string {
 	return fmt.Sprintf("invalid response error: %s", err.Message)
 }
 
+// EncryptionError represents a failure to encrypt a request or to decrypt a response.
+// Since the message id of a response is part of the encrypted data, a response that can't be decrypted
+// can't be matched with its request. In that case, the connection is closed and
+// all pending requests fail with the EncryptionError.
+type EncryptionError struct {
+	Message string
+}
+
+func (err *EncryptionError) Error() string {
+	return fmt.Sprintf("encryption error: %s", err.Message)
+}
+
 // ResponseError represents a error with a nt status code sent by the server.
 // The NTSTATUS is defined in [MS-ERREF].
 // https://msdn.microsoft.com/en-au/library/cc704588.aspx
@@ -46,6 +155,85 @@ func (err *ResponseError) Error() string {
 	return fmt.Sprintf("response error: %v", NtStatus(err.Code))
 }
 
+// Is reports whether the status of err is one of the statuses target stands for, e.g. STATUS_NETWORK_NAME_DELETED
+// for ErrShareUnavailable or STATUS_NO_SUCH_FILE for os.ErrNotExist, so that errors.Is works with the sentinel errors.
+func (err *ResponseError) Is(target error) bool {
+	return statusErrors[NtStatus(err.Code)] == target
+}
+
+// IsTransient reports whether the request may succeed if it's retried later, possibly on a new connection,
+// e.g. because the server is busy or out of resources, the file is in use, or the session or the connection was lost.
+func (err *ResponseError) IsTransient() bool {
+	return transientStatuses[NtStatus(err.Code)]
+}
+
+// statusErrors maps the statuses not converted to sentinel errors by accept to the errors they match.
+// They stay ResponseErrors, so that IsTransient and the checks of the status, e.g. STATUS_END_OF_FILE
+// while reading, keep working; errors.Is finds them through the os.PathError wrapping them.
+var statusErrors = map[NtStatus]error{
+	STATUS_NO_SUCH_FILE:         os.ErrNotExist,
+	STATUS_OBJECT_NAME_INVALID:  os.ErrInvalid,
+	STATUS_END_OF_FILE:          io.EOF,
+	STATUS_SHARING_VIOLATION:    ErrSharingViolation,
+	STATUS_DISK_FULL:            ErrDiskFull,
+	STATUS_BAD_NETWORK_NAME:     ErrShareNotFound,
+	STATUS_BAD_NETWORK_PATH:     ErrShareNotFound,
+	STATUS_NETWORK_NAME_DELETED: ErrShareUnavailable,
+	STATUS_SHARING_PAUSED:       ErrShareUnavailable,
+	STATUS_SERVER_UNAVAILABLE:   ErrShareUnavailable,
+	STATUS_NOT_SUPPORTED:        ErrNotSupported,
+	STATUS_LOCK_NOT_GRANTED:     ErrLockNotGranted,
+	STATUS_FILE_LOCK_CONFLICT:   ErrLockNotGranted,
+}
+
+var transientStatuses = map[NtStatus]bool{
+	STATUS_INSUFFICIENT_RESOURCES:      true,
+	STATUS_INSUFF_SERVER_RESOURCES:     true,
+	STATUS_NETWORK_BUSY:                true,
+	STATUS_REQUEST_NOT_ACCEPTED:        true,
+	STATUS_RETRY:                       true,
+	STATUS_IO_TIMEOUT:                  true,
+	STATUS_SHARING_VIOLATION:           true,
+	STATUS_FILE_LOCK_CONFLICT:          true,
+	STATUS_LOCK_NOT_GRANTED:            true,
+	STATUS_FILE_NOT_AVAILABLE:          true,
+	STATUS_SERVER_UNAVAILABLE:          true,
+	STATUS_SHARING_PAUSED:              true,
+	STATUS_NETWORK_NAME_DELETED:        true,
+	STATUS_USER_SESSION_DELETED:        true,
+	STATUS_NETWORK_SESSION_EXPIRED:     true,
+	STATUS_SERVER_SHUTDOWN_IN_PROGRESS: true,
+	STATUS_UNEXPECTED_NETWORK_ERROR:    true,
+	STATUS_REMOTE_DISCONNECT:           true,
+	STATUS_CONNECTION_DISCONNECTED:     true,
+	STATUS_CONNECTION_RESET:            true,
+	STATUS_CONNECTION_ABORTED:          true,
+}
+
+// IsTransient reports whether err, or the error it wraps in an *os.PathError or an *os.LinkError,
+// is a failure that may go away if the operation is retried later: a ResponseError whose IsTransient is true,
+// a TransportError, or a ContextError caused by Dialer.OperationTimeout or a deadline.
+// Errors like os.ErrNotExist and os.ErrPermission are permanent.
+func IsTransient(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+
+	switch e := err.(type) {
+	case *ResponseError:
+		return e.IsTransient()
+	case *TransportError:
+		return true
+	case *ContextError:
+		return e.Timeout()
+	}
+
+	return false
+}
+
 // ContextError wraps a context error to support os.IsTimeout function.
 type ContextError struct {
 	Err error
@@ -58,3 +246,7 @@ func (err *ContextError) Timeout() bool {
 func (err *ContextError) Error() string {
 	return err.Err.Error()
 }
+
+func (err *ContextError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,54 @@
+package smb2
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+)
+
+func TestResponseErrorIs(t *testing.T) {
+	for _, tc := range []struct {
+		status NtStatus
+		target error
+	}{
+		{STATUS_NETWORK_NAME_DELETED, ErrShareUnavailable},
+		{STATUS_BAD_NETWORK_NAME, ErrShareNotFound},
+		{STATUS_NO_SUCH_FILE, os.ErrNotExist},
+		{STATUS_FILE_LOCK_CONFLICT, ErrLockNotGranted},
+		{STATUS_SHARING_VIOLATION, ErrSharingViolation},
+		{STATUS_DISK_FULL, ErrDiskFull},
+		{STATUS_END_OF_FILE, io.EOF},
+	} {
+		err := &ResponseError{Code: uint32(tc.status)}
+		if !err.Is(tc.target) {
+			t.Errorf("%v: expected to match %v", tc.status, tc.target)
+		}
+		if err.Is(os.ErrPermission) {
+			t.Errorf("%v: expected not to match %v", tc.status, os.ErrPermission)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&ResponseError{Code: uint32(STATUS_INSUFF_SERVER_RESOURCES)}, true},
+		{&ResponseError{Code: uint32(STATUS_SHARING_VIOLATION)}, true},
+		{&os.PathError{Op: "open", Path: "a", Err: &ResponseError{Code: uint32(STATUS_NETWORK_NAME_DELETED)}}, true},
+		{&ResponseError{Code: uint32(STATUS_INVALID_PARAMETER)}, false},
+		{&TransportError{io.EOF}, true},
+		{&ContextError{context.DeadlineExceeded}, true},
+		{&ContextError{context.Canceled}, false},
+		{&os.PathError{Op: "open", Path: "a", Err: os.ErrNotExist}, false},
+		{os.ErrPermission, false},
+	} {
+		if IsTransient(tc.err) != tc.transient {
+			t.Errorf("%v: expected %v", tc.err, tc.transient)
+		}
+	}
+}
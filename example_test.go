@@ -1,10 +1,13 @@
 package smb2_test
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"time"
 
 	"github.com/hirochachacha/go-smb2"
 )
@@ -62,3 +65,125 @@ func Example() {
 
 	// Hello world!
 }
+
+func ExampleDialer_Dial_tunnel() {
+	// SMB through a TLS tunnel, e.g. terminated by stunnel in front of the server.
+	conn, err := tls.Dial("tcp", "fileserver.example.com:8445", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	d := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     "Guest",
+			Password: "",
+		},
+		// the remote address of the connection is the one of the tunnel.
+		ServerName: "fileserver",
+	}
+
+	c, err := d.Dial(conn)
+	if err != nil {
+		panic(err)
+	}
+	defer c.Logoff()
+
+	names, err := c.ListSharenames()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(names)
+}
+
+func ExampleDialer_Dial_azureFiles() {
+	// Azure Files authenticates the name and the key of the storage account with NTLM.
+	// Outside of the Azure region of the account, it only accepts SMB 3.x with encryption,
+	// which the server turns on for the session.
+	conn, err := net.Dial("tcp", "mystorageaccount.file.core.windows.net:445")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	d := &smb2.Dialer{
+		Negotiator: smb2.Negotiator{
+			NoDowngrade: true,
+		},
+		Initiator: &smb2.NTLMInitiator{
+			User:     "mystorageaccount",
+			Password: "<storage account key>",
+			Domain:   "localhost",
+		},
+		RejectGuest: true,
+	}
+
+	c, err := d.Dial(conn)
+	if err != nil {
+		panic(err)
+	}
+	defer c.Logoff()
+
+	fs, err := c.Mount("myshare")
+	if err != nil {
+		panic(err)
+	}
+	defer fs.Umount()
+
+	names, err := fs.ReadDir("")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, fi := range names {
+		fmt.Println(fi.Name())
+	}
+}
+
+func ExampleSessionPool() {
+	d := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     "Guest",
+			Password: "",
+		},
+	}
+
+	p := &smb2.SessionPool{
+		Dial: func(ctx context.Context) (*smb2.Session, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, "tcp", "localhost:445")
+			if err != nil {
+				return nil, err
+			}
+			c, err := d.DialContext(ctx, conn)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return c, nil
+		},
+		MaxIdle:             4,
+		MaxLifetime:         time.Hour,
+		HealthCheckInterval: time.Minute,
+	}
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	defer p.Put(c)
+
+	fs, err := c.Mount("sharename")
+	if err != nil {
+		panic(err)
+	}
+	defer fs.Umount()
+
+	fi, err := fs.Stat("hello.txt")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(fi.Size())
+}
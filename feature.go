@@ -1,19 +1,25 @@
 package smb2
 
 import (
+	"time"
+
 	. "github.com/hirochachacha/go-smb2/internal/smb2"
 )
 
 // client
 
 const (
-	clientCapabilities = SMB2_GLOBAL_CAP_LARGE_MTU | SMB2_GLOBAL_CAP_ENCRYPTION
+	clientCapabilities = SMB2_GLOBAL_CAP_LARGE_MTU | SMB2_GLOBAL_CAP_MULTI_CHANNEL | SMB2_GLOBAL_CAP_ENCRYPTION | SMB2_GLOBAL_CAP_PERSISTENT_HANDLES
 )
 
 var (
-	clientHashAlgorithms = []uint16{SHA512}
-	clientCiphers        = []uint16{AES128GCM, AES128CCM}
-	clientDialects       = []uint16{SMB311, SMB302, SMB300, SMB210, SMB202}
+	clientHashAlgorithms    = []uint16{SHA512}
+	clientCiphers           = []uint16{AES128GCM, AES128CCM, AES256GCM, AES256CCM}
+	clientSigningAlgorithms = []uint16{AES128GMAC, AES128CMAC}
+	clientDialects          = []uint16{SMB311, SMB302, SMB300, SMB210, SMB202}
+
+	// only LZ77 is used to compress requests; the others are supported for responses.
+	clientCompressionAlgorithms = []uint16{SMB2_COMPRESSION_LZ77, SMB2_COMPRESSION_LZ77_HUFFMAN, SMB2_COMPRESSION_PATTERN_V1}
 )
 
 const (
@@ -22,4 +28,63 @@ const (
 
 const (
 	clientMaxSymlinkDepth = 8
+
+	// a DFS path is redirected at most this many times, which stops malformed referral chains.
+	clientMaxDFSReferralDepth = 8
+	clientMaxDFSReferralSize  = 8 * 1024
+)
+
+const (
+	clientRecvBufferSize = 64 * 1024
+)
+
+// directories read in batches are queried with buffers sized for entries of this many bytes on average,
+// but large enough for an entry with the longest name.
+const (
+	clientDirEntrySizeHint = 128
+	clientMinDirBufferSize = 4 * 1024
+)
+
+// the changes reported by a CHANGE_NOTIFY response must fit in the buffer, otherwise they are lost.
+// Windows doesn't accept more than 64 KiB over the network.
+const (
+	clientChangeNotifyBufferSize = 64 * 1024
+)
+
+// a lease break that isn't acknowledged by the application in time is acknowledged anyway,
+// before the server gives up waiting after 35 seconds.
+const (
+	clientLeaseBreakTimeout = 30 * time.Second
+)
+
+// opening a named pipe whose instances are all busy is retried this many times, waiting twice as long each time.
+const (
+	clientPipeBusyRetries = 5
+	clientPipeBusyBackoff = 100 * time.Millisecond
+)
+
+// the output of a pipe transceive request; longer replies are read with READ.
+const (
+	clientPipeTransceiveSize = 64 * 1024
+)
+
+// LOGOFF and TREE_DISCONNECT wait this long for the outstanding requests, then cancel them,
+// and fail the ones still outstanding after waiting as long again.
+const (
+	clientDrainTimeout = 30 * time.Second
+)
+
+// a session pool keeps this many idle sessions unless SessionPool.MaxIdle is set,
+// and waits this long for the LOGOFF of the sessions it drops.
+const (
+	clientPoolMaxIdle       = 2
+	clientPoolLogoffTimeout = 5 * time.Second
+)
+
+// limits of a server-side copy request, lowered to the ones of the server if it rejects them.
+// https://msdn.microsoft.com/en-us/library/cc512134(v=vs.85).aspx
+const (
+	clientCopychunkMaxChunks    = 16
+	clientCopychunkMaxChunkSize = 1024 * 1024
+	clientCopychunkMaxTotalSize = 16 * 1024 * 1024
 )
@@ -309,7 +309,8 @@ func (fs *Share) glob(dir, pattern string, matches []string) (m []string, e erro
 	if !fi.IsDir() {
 		return // ignore I/O error
 	}
-	d, err := fs.Open(dir)
+	// only the names are needed.
+	d, err := fs.OpenFileWith(dir, os.O_RDONLY, 0, &OpenOptions{DirectoryInfo: DirectoryInfo})
 	if err != nil {
 		return // ignore I/O error
 	}
@@ -319,7 +320,7 @@ func (fs *Share) glob(dir, pattern string, matches []string) (m []string, e erro
 
 L:
 	for {
-		dirents, err := d.readdir(simplifyPattern(pattern))
+		dirents, err := d.readdir(simplifyPattern(pattern), 0, 0)
 		for _, st := range dirents {
 			names = append(names, st.Name())
 		}
@@ -0,0 +1,73 @@
+package smb2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"hash"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// gmac computes AES-GMAC signatures of SMB 3.1.1, i.e. the tag of AES-GCM over an empty plaintext
+// with the message as additional data. ([MS-SMB2] 3.1.4.1)
+// The nonce is derived from the header of the message, so the message is buffered until Sum.
+type gmac struct {
+	aead cipher.AEAD
+	buf  []byte
+}
+
+func newGMAC(signingKey []byte) (hash.Hash, error) {
+	ciph, err := aes.NewCipher(signingKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(ciph)
+	if err != nil {
+		return nil, err
+	}
+	return &gmac{aead: aead}, nil
+}
+
+func (h *gmac) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	return len(p), nil
+}
+
+// Sum appends the signature of the message written so far to b.
+// The nonce is the message id, followed by a bit set for responses and a bit set for CANCEL requests.
+func (h *gmac) Sum(b []byte) []byte {
+	nonce := make([]byte, h.aead.NonceSize())
+
+	if len(h.buf) >= 64 {
+		p := PacketCodec(h.buf)
+
+		binary.LittleEndian.PutUint64(nonce[:8], p.MessageId())
+
+		if p.Flags()&SMB2_FLAGS_SERVER_TO_REDIR != 0 {
+			nonce[8] |= 0x1
+		}
+		if p.Command() == SMB2_CANCEL {
+			nonce[8] |= 0x2
+		}
+	}
+
+	return h.aead.Seal(b, nonce, nil, h.buf)
+}
+
+func (h *gmac) Reset() {
+	// don't keep the buffer of large messages, like WRITE requests, around.
+	if cap(h.buf) > clientRecvBufferSize {
+		h.buf = nil
+	} else {
+		h.buf = h.buf[:0]
+	}
+}
+
+func (h *gmac) Size() int {
+	return h.aead.Overhead()
+}
+
+func (h *gmac) BlockSize() int {
+	return aes.BlockSize
+}
@@ -23,7 +23,17 @@ type NTLMInitiator struct {
 	Hash        []byte
 	Domain      string
 	Workstation string
-	TargetSPN   string
+	TargetSPN   string // e.g. "cifs/fileserver.example.com", checked by servers hardening SPN validation
+
+	// EnableChannelBinding sends a channel binding token (MsvAvChannelBindings) computed from ChannelBindingData,
+	// as required by servers enforcing Extended Protection for Authentication (EPA).
+	// Otherwise, the token is all zeros, meaning that the client has no channel to bind to.
+	EnableChannelBinding bool
+
+	// ChannelBindingData is the application data of the channel bindings (gss_channel_bindings_struct),
+	// e.g. "tls-server-end-point:" followed by the hash of the TLS certificate of the server (RFC 5929)
+	// when the transport is protected by TLS. It's only used if EnableChannelBinding is set.
+	ChannelBindingData []byte
 
 	ntlm   *ntlm.Client
 	seqNum uint32
@@ -42,6 +52,11 @@ func (i *NTLMInitiator) initSecContext() ([]byte, error) {
 		Workstation: i.Workstation,
 		TargetSPN:   i.TargetSPN,
 	}
+	if i.EnableChannelBinding {
+		i.ntlm.ChannelBindings = &ntlm.ChannelBindings{
+			ApplicationData: i.ChannelBindingData,
+		}
+	}
 	nmsg, err := i.ntlm.Negotiate()
 	if err != nil {
 		return nil, err
@@ -69,3 +84,37 @@ func (i *NTLMInitiator) sessionKey() []byte {
 func (i *NTLMInitiator) infoMap() *ntlm.InfoMap {
 	return i.ntlm.Session().InfoMap()
 }
+
+// AnonymousInitiator implements session-setup of an anonymous (null) session through NTLM.
+// The session has no user name, no password and no session key, so its messages are neither signed
+// nor encrypted, and Dial fails if signing is required by the server or by Negotiator.RequireMessageSigning.
+// Most modern servers reject anonymous sessions or restrict them to IPC$ and a few pipes.
+// It's meant for compatibility with legacy systems and for security scanning.
+type AnonymousInitiator struct {
+	Workstation string
+
+	ntlm *ntlm.Client
+}
+
+func (i *AnonymousInitiator) oid() asn1.ObjectIdentifier {
+	return spnego.NlmpOid
+}
+
+func (i *AnonymousInitiator) initSecContext() ([]byte, error) {
+	i.ntlm = &ntlm.Client{
+		Workstation: i.Workstation,
+	}
+	return i.ntlm.Negotiate()
+}
+
+func (i *AnonymousInitiator) acceptSecContext(sc []byte) ([]byte, error) {
+	return i.ntlm.Authenticate(sc)
+}
+
+func (i *AnonymousInitiator) sum(bs []byte) []byte {
+	return nil
+}
+
+func (i *AnonymousInitiator) sessionKey() []byte {
+	return nil
+}
@@ -2,9 +2,15 @@ package smb2
 
 import (
 	"encoding/asn1"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/gssapi2"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/types"
 
 	"github.com/hirochachacha/go-smb2/internal/spnego"
@@ -18,6 +24,97 @@ type KerberosInitiator struct {
 	gssimpl *gssapi2.GSSAPI
 }
 
+// KerberosInitiatorFromKeytab returns a KerberosInitiator logged in as principal, e.g. "svc-backup" or "svc-backup@EXAMPLE.COM",
+// with the keys of keytabPath. If realm is empty, the realm of principal or the default realm of the configuration is used.
+// The configuration is loaded from krb5confPath, $KRB5_CONFIG or /etc/krb5.conf, in that order.
+// SPN must be set to the service principal of the server, e.g. "cifs/fileserver.example.com", before dialing.
+func KerberosInitiatorFromKeytab(keytabPath, principal, realm, krb5confPath string) (*KerberosInitiator, error) {
+	conf, err := loadKrb5Config(krb5confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load keytab %s: %v", keytabPath, err)
+	}
+
+	user, prealm := types.ParseSPNString(principal)
+	if realm == "" {
+		realm = prealm
+	}
+	if realm == "" {
+		realm = conf.LibDefaults.DefaultRealm
+	}
+
+	cl := client.NewWithKeytab(user.PrincipalNameString(), realm, kt, conf, client.DisablePAFXFAST(true))
+
+	err = cl.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	return &KerberosInitiator{
+		Client: cl,
+		User:   user,
+	}, nil
+}
+
+// KerberosInitiatorFromCCache returns a KerberosInitiator using the tickets of the credential cache at ccachePath,
+// as obtained by kinit. If ccachePath is empty, the cache named by $KRB5CCNAME or /tmp/krb5cc_<uid> is used.
+// The configuration is loaded as KerberosInitiatorFromKeytab does.
+// Tickets from a credential cache are not renewed; dialing fails once they have expired.
+func KerberosInitiatorFromCCache(ccachePath, krb5confPath string) (*KerberosInitiator, error) {
+	conf, err := loadKrb5Config(krb5confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if ccachePath == "" {
+		ccachePath = defaultCCachePath()
+	}
+
+	cc, err := credentials.LoadCCache(ccachePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load credential cache %s: %v", ccachePath, err)
+	}
+
+	cl, err := client.NewFromCCache(cc, conf, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return &KerberosInitiator{
+		Client: cl,
+		User:   cc.GetClientPrincipalName(),
+	}, nil
+}
+
+func loadKrb5Config(path string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv("KRB5_CONFIG")
+	}
+	if path == "" {
+		path = "/etc/krb5.conf"
+	}
+
+	conf, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load kerberos configuration %s: %v", path, err)
+	}
+
+	return conf, nil
+}
+
+// defaultCCachePath returns the path of the default credential cache.
+// Only caches of the FILE type are supported.
+func defaultCCachePath() string {
+	if name := os.Getenv("KRB5CCNAME"); name != "" {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
+
 func (k *KerberosInitiator) oid() asn1.ObjectIdentifier {
 	return spnego.KerberosOid
 }
@@ -42,12 +139,8 @@ func (k *KerberosInitiator) sum(bs []byte) []byte {
 	return k.gssimpl.GetMIC(bs)
 }
 
+// sessionKey returns the full key of the security context, e.g. 32 bytes with aes256-cts-hmac-sha1-96.
+// The session truncates it for signing and uses it as is for AES-256 encryption.
 func (k *KerberosInitiator) sessionKey() []byte {
-	// Only the first 16 bytes are used, if less than that are available
-	// zero padding is added.
-	sliced := k.gssimpl.SessionKey()[:16]
-	for len(sliced) < 16 {
-		sliced = append(sliced, 0x00)
-	}
-	return sliced
+	return k.gssimpl.SessionKey()
 }
@@ -0,0 +1,32 @@
+package smb2
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDefaultCCachePath(t *testing.T) {
+	old, ok := os.LookupEnv("KRB5CCNAME")
+	defer func() {
+		if ok {
+			os.Setenv("KRB5CCNAME", old)
+		} else {
+			os.Unsetenv("KRB5CCNAME")
+		}
+	}()
+
+	for _, tc := range []struct {
+		env      string
+		expected string
+	}{
+		{"", fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())},
+		{"/var/run/krb5cc_svc", "/var/run/krb5cc_svc"},
+		{"FILE:/var/run/krb5cc_svc", "/var/run/krb5cc_svc"},
+	} {
+		os.Setenv("KRB5CCNAME", tc.env)
+		if path := defaultCCachePath(); path != tc.expected {
+			t.Errorf("KRB5CCNAME=%q: expected %s, got %s", tc.env, tc.expected, path)
+		}
+	}
+}
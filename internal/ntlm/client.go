@@ -23,7 +23,7 @@ type Client struct {
 	Workstation string // e.g "localhost", "HOME-PC"
 
 	TargetSPN       string           // SPN ::= "service/hostname[:port]"; e.g "cifs/remotehost:1020"
-	channelBindings *channelBindings // reserved for future implementation
+	ChannelBindings *ChannelBindings // if nil, the channel binding token is all zeros
 
 	nmsg    []byte
 	session *Session
@@ -99,6 +99,10 @@ func (c *Client) Authenticate(cmsg []byte) (amsg []byte, err error) {
 		return nil, errors.New("invalid negotiate flags")
 	}
 
+	if c.User == "" && c.Password == "" && c.Hash == nil {
+		return c.authenticateAnonymous(flags), nil
+	}
+
 	targetInfoLen := le.Uint16(cmsg[40:42])    // cmsg.TargetInfoLen
 	targetInfoMaxLen := le.Uint16(cmsg[42:44]) // cmsg.TargetInfoMaxLen
 	if targetInfoMaxLen < targetInfoLen {
@@ -109,7 +113,7 @@ func (c *Client) Authenticate(cmsg []byte) (amsg []byte, err error) {
 		return nil, errors.New("invalid target info format")
 	}
 	targetInfo := cmsg[targetInfoBufferOffset : targetInfoBufferOffset+uint32(targetInfoLen)] // cmsg.TargetInfo
-	info := newTargetInfoEncoder(targetInfo, utf16le.EncodeStringToBytes(c.TargetSPN))
+	info := newTargetInfoEncoder(targetInfo, utf16le.EncodeStringToBytes(c.TargetSPN), c.ChannelBindings)
 	if info == nil {
 		return nil, errors.New("invalid target info format")
 	}
@@ -313,6 +317,48 @@ func (c *Client) Authenticate(cmsg []byte) (amsg []byte, err error) {
 	return amsg, nil
 }
 
+// authenticateAnonymous returns the AuthenticateMessage of an anonymous authentication,
+// which has empty user and domain names, an empty NtChallengeResponse and a single zero byte
+// as LmChallengeResponse. No session key is established. ([MS-NLMP] 3.1.5.1.2)
+func (c *Client) authenticateAnonymous(flags uint32) []byte {
+	off := 64 + 8 + 16
+
+	workstation := utf16le.EncodeStringToBytes(c.Workstation)
+
+	amsg := make([]byte, off+len(workstation)+1)
+
+	copy(amsg[:8], signature)
+	le.PutUint32(amsg[8:12], NtLmAuthenticate)
+
+	le.PutUint32(amsg[32:36], uint32(off)) // amsg.DomainNameBufferOffset
+	le.PutUint32(amsg[40:44], uint32(off)) // amsg.UserNameBufferOffset
+
+	if workstation != nil {
+		len := copy(amsg[off:], workstation)
+		le.PutUint16(amsg[44:46], uint16(len))
+		le.PutUint16(amsg[46:48], uint16(len))
+		le.PutUint32(amsg[48:52], uint32(off))
+		off += len
+	}
+
+	le.PutUint16(amsg[12:14], 1) // amsg.LmChallengeResponseLen
+	le.PutUint16(amsg[14:16], 1) // amsg.LmChallengeResponseMaxLen
+	le.PutUint32(amsg[16:20], uint32(off))
+	off++
+
+	le.PutUint32(amsg[24:28], uint32(off)) // amsg.NtChallengeResponseBufferOffset
+	le.PutUint32(amsg[56:60], uint32(off)) // amsg.EncryptedRandomSessionKeyBufferOffset
+
+	flags |= NTLMSSP_ANONYMOUS
+	flags &^= NTLMSSP_NEGOTIATE_KEY_EXCH | NTLMSSP_NEGOTIATE_SIGN | NTLMSSP_NEGOTIATE_SEAL
+
+	le.PutUint32(amsg[60:64], flags)
+
+	copy(amsg[64:], version)
+
+	return amsg
+}
+
 func (c *Client) Session() *Session {
 	return c.session
 }
@@ -78,16 +78,38 @@ const (
 	MsvAvChannelBindings
 )
 
-type addr struct {
-	typ uint32
-	val []byte
+// ChannelBindings represents gss_channel_bindings_struct. (RFC 2744 3.11)
+// The addresses are usually empty; ApplicationData binds the authentication to the channel,
+// e.g. "tls-server-end-point:" followed by the hash of the certificate of the server (RFC 5929 4.1).
+type ChannelBindings struct {
+	InitiatorAddrType uint32
+	InitiatorAddress  []byte
+	AcceptorAddrType  uint32
+	AcceptorAddress   []byte
+	ApplicationData   []byte
 }
 
-// channelBindings represents gss_channel_bindings_struct
-type channelBindings struct {
-	InitiatorAddress addr
-	AcceptorAddress  addr
-	AppData          []byte
+// hash returns the MD5 hash of the flattened structure, which is the value of MsvAvChannelBindings. ([MS-NLMP] 2.2.2.1)
+func (cb *ChannelBindings) hash() []byte {
+	bs := make([]byte, 20+len(cb.InitiatorAddress)+len(cb.AcceptorAddress)+len(cb.ApplicationData))
+
+	off := 0
+	for _, v := range []struct {
+		typ uint32
+		val []byte
+	}{
+		{cb.InitiatorAddrType, cb.InitiatorAddress},
+		{cb.AcceptorAddrType, cb.AcceptorAddress},
+	} {
+		le.PutUint32(bs[off:off+4], v.typ)
+		le.PutUint32(bs[off+4:off+8], uint32(len(v.val)))
+		off += 8 + copy(bs[off+8:], v.val)
+	}
+	le.PutUint32(bs[off:off+4], uint32(len(cb.ApplicationData)))
+	copy(bs[off+4:], cb.ApplicationData)
+
+	sum := md5.Sum(bs)
+	return sum[:]
 }
 
 var signature = []byte("NTLMSSP\x00")
@@ -174,21 +196,26 @@ func (b bytesEncoder) encode(bs []byte) {
 }
 
 type targetInfoEncoder struct {
-	Info    []byte
-	SPN     []byte
-	InfoMap map[uint16][]byte
+	Info            []byte
+	SPN             []byte
+	ChannelBindings []byte // MD5 hash of the channel bindings, or nil for none
+	InfoMap         map[uint16][]byte
 }
 
-func newTargetInfoEncoder(info, spn []byte) *targetInfoEncoder {
+func newTargetInfoEncoder(info, spn []byte, cb *ChannelBindings) *targetInfoEncoder {
 	infoMap, ok := parseAvPairs(info)
 	if !ok {
 		return nil
 	}
-	return &targetInfoEncoder{
+	enc := &targetInfoEncoder{
 		Info:    info,
 		SPN:     spn,
 		InfoMap: infoMap,
 	}
+	if cb != nil {
+		enc.ChannelBindings = cb.hash()
+	}
+	return enc
 }
 
 func (i *targetInfoEncoder) size() int {
@@ -220,8 +247,10 @@ func (i *targetInfoEncoder) encode(dst []byte) {
 		off += 8
 	}
 
+	// all zeros if there are no channel bindings.
 	le.PutUint16(dst[off:off+2], MsvAvChannelBindings)
 	le.PutUint16(dst[off+2:off+4], 16)
+	copy(dst[off+4:off+20], i.ChannelBindings)
 
 	off += 20
 
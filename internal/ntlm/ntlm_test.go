@@ -247,3 +247,93 @@ func TestClientServer(t *testing.T) {
 		t.Error("error")
 	}
 }
+
+func TestChannelBindings(t *testing.T) {
+	for _, c := range []struct {
+		cb   *ChannelBindings
+		hash string
+	}{
+		{&ChannelBindings{}, "441018525208457705bf09a8ee3c1093"},
+		{&ChannelBindings{ApplicationData: append([]byte("tls-server-end-point:"), bytes.Repeat([]byte{0xaa}, 32)...)}, "887bbef10e2b16116a7405bb2d7483a8"},
+	} {
+		if ret := hex.EncodeToString(c.cb.hash()); ret != c.hash {
+			t.Errorf("expected %s, got %s", c.hash, ret)
+		}
+	}
+
+	cb := &ChannelBindings{ApplicationData: []byte("tls-server-end-point:hash")}
+
+	c := &Client{
+		User:            "user",
+		Password:        "password",
+		ChannelBindings: cb,
+	}
+
+	s := NewServer("server")
+
+	s.AddAccount("user", "password")
+
+	nmsg, err := c.Negotiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmsg, err := s.Challenge(nmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amsg, err := c.Authenticate(cmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// MsvAvChannelBindings + AvLen + hash
+	pair := append([]byte{0x0a, 0x00, 0x10, 0x00}, cb.hash()...)
+	if !bytes.Contains(amsg, pair) {
+		t.Errorf("expected %x in %x", pair, amsg)
+	}
+
+	err = s.Authenticate(amsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnonymous(t *testing.T) {
+	c := &Client{Workstation: "client"}
+
+	nmsg, err := c.Negotiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmsg, err := NewServer("server").Challenge(nmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amsg, err := c.Authenticate(cmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := le.Uint16(amsg[12:14]); n != 1 {
+		t.Errorf("expected LmChallengeResponse of 1 byte, got %d", n)
+	}
+	if n := le.Uint16(amsg[20:22]); n != 0 {
+		t.Errorf("expected empty NtChallengeResponse, got %d bytes", n)
+	}
+	if n := le.Uint16(amsg[36:38]); n != 0 {
+		t.Errorf("expected empty user name, got %d bytes", n)
+	}
+	if n := le.Uint16(amsg[44:46]); n != uint16(len("client")*2) {
+		t.Errorf("unexpected workstation length: %d", n)
+	}
+	if flags := le.Uint32(amsg[60:64]); flags&NTLMSSP_ANONYMOUS == 0 || flags&NTLMSSP_NEGOTIATE_KEY_EXCH != 0 {
+		t.Errorf("unexpected flags: %#x", flags)
+	}
+	if c.Session() != nil {
+		t.Error("expected no session")
+	}
+}
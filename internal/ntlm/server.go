@@ -6,6 +6,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rc4"
+	"crypto/subtle"
 	"errors"
 	"strings"
 
@@ -199,7 +200,7 @@ func (s *Server) Authenticate(amsg []byte) (err error) {
 		clientChallenge := ntlmv2ClientChallenge[16:24]
 		targetInfo := ntlmv2ClientChallenge[28:]
 		encodeNtlmv2Response(expectedNtChallengeResponse, h, serverChallenge, clientChallenge, timeStamp, bytesEncoder(targetInfo))
-		if !bytes.Equal(ntChallengeResponse, expectedNtChallengeResponse) {
+		if subtle.ConstantTimeCompare(ntChallengeResponse, expectedNtChallengeResponse) != 1 {
 			return errors.New("login failure")
 		}
 
@@ -241,7 +242,7 @@ func (s *Server) Authenticate(amsg []byte) (err error) {
 				h.Write(s.nmsg)
 				h.Write(s.cmsg)
 				h.Write(amsg)
-				if !bytes.Equal(MIC, h.Sum(nil)) {
+				if subtle.ConstantTimeCompare(MIC, h.Sum(nil)) != 1 {
 					return errors.New("login failure")
 				}
 			}
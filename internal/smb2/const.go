@@ -5,6 +5,7 @@ package smb2
 const (
 	MAGIC  = "\xfeSMB"
 	MAGIC2 = "\xfdSMB"
+	MAGIC3 = "\xfcSMB"
 )
 
 // ----------------------------------------------------------------------------
@@ -64,6 +65,18 @@ const (
 	Encrypted = 1 << iota
 )
 
+// ----------------------------------------------------------------------------
+// SMB2 COMPRESSION_TRANSFORM_HEADER
+//
+
+// From SMB311
+
+// Flags
+const (
+	SMB2_COMPRESSION_FLAG_NONE    = 0x0
+	SMB2_COMPRESSION_FLAG_CHAINED = 0x1
+)
+
 // ----------------------------------------------------------------------------
 // SMB2 Error Response
 //
@@ -147,8 +160,10 @@ const (
 
 // ContextType
 const (
-	SMB2_PREAUTH_INTEGRITY_CAPABILITIES = 1 << iota
-	SMB2_ENCRYPTION_CAPABILITIES
+	SMB2_PREAUTH_INTEGRITY_CAPABILITIES = 0x1
+	SMB2_ENCRYPTION_CAPABILITIES        = 0x2
+	SMB2_COMPRESSION_CAPABILITIES       = 0x3
+	SMB2_SIGNING_CAPABILITIES           = 0x8
 )
 
 // HashAlgorithms
@@ -158,8 +173,32 @@ const (
 
 // Ciphers
 const (
-	AES128CCM = 1 << iota
-	AES128GCM
+	AES128CCM = 0x1
+	AES128GCM = 0x2
+	AES256CCM = 0x3
+	AES256GCM = 0x4
+)
+
+// SigningAlgorithms
+const (
+	HMACSHA256 = 0x0
+	AES128CMAC = 0x1
+	AES128GMAC = 0x2
+)
+
+// CompressionAlgorithms
+const (
+	SMB2_COMPRESSION_NONE         = 0x0
+	SMB2_COMPRESSION_LZNT1        = 0x1
+	SMB2_COMPRESSION_LZ77         = 0x2
+	SMB2_COMPRESSION_LZ77_HUFFMAN = 0x3
+	SMB2_COMPRESSION_PATTERN_V1   = 0x4
+)
+
+// Compression Capabilities Flags
+const (
+	SMB2_COMPRESSION_CAPABILITIES_FLAG_NONE    = 0x0
+	SMB2_COMPRESSION_CAPABILITIES_FLAG_CHAINED = 0x1
 )
 
 // ----------------------------------------------------------------------------
@@ -392,6 +431,39 @@ const (
 	SMB2_CREATE_FLAG_REPARSEPOINT = 1 << iota
 )
 
+// Create Context Names
+const (
+	SMB2_CREATE_DURABLE_HANDLE_REQUEST      = "DHnQ"
+	SMB2_CREATE_DURABLE_HANDLE_RECONNECT    = "DHnC"
+	SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2   = "DH2Q"
+	SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2 = "DH2C"
+	SMB2_CREATE_REQUEST_LEASE               = "RqLs"
+	SMB2_CREATE_REQUEST_LEASE_V2            = "RqLs"
+	SMB2_CREATE_TIMEWARP_TOKEN              = "TWrp"
+	SMB2_CREATE_ALLOCATION_SIZE             = "AlSi"
+	SMB2_CREATE_APP_INSTANCE_ID             = "\x45\xbc\xa6\x6a\xef\xa7\xf7\x4a\x90\x08\xfa\x46\x2e\x14\x4d\x74"
+	SMB2_CREATE_APP_INSTANCE_VERSION        = "\xb9\x82\xd0\xb7\x3b\x56\x07\x4f\xa0\x7b\x52\x4a\x81\x16\xa0\x10"
+)
+
+// LeaseState of SMB2_CREATE_REQUEST_LEASE and SMB2_CREATE_REQUEST_LEASE_V2
+const (
+	SMB2_LEASE_NONE           = 0x0
+	SMB2_LEASE_READ_CACHING   = 0x1
+	SMB2_LEASE_HANDLE_CACHING = 0x2
+	SMB2_LEASE_WRITE_CACHING  = 0x4
+)
+
+// LeaseFlags of SMB2_CREATE_REQUEST_LEASE and SMB2_CREATE_REQUEST_LEASE_V2
+const (
+	SMB2_LEASE_FLAG_BREAK_IN_PROGRESS    = 0x2
+	SMB2_LEASE_FLAG_PARENT_LEASE_KEY_SET = 0x4
+)
+
+// Flags of SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2 and SMB2_CREATE_DURABLE_HANDLE_RECONNECT_V2
+const (
+	SMB2_DHANDLE_FLAG_PERSISTENT = 0x2
+)
+
 // CreateAction
 const (
 // FILE_SUPERSEDE = iota
@@ -468,6 +540,7 @@ const (
 // Flags
 const (
 	SMB2_READFLAG_READ_UNBUFFERED = 1 << iota
+	SMB2_READFLAG_REQUEST_COMPRESSED
 )
 
 // Channel
@@ -502,7 +575,10 @@ const (
 // SMB2 OPLOCK_BREAK Notification, Acknowledgement and Response
 //
 
-//
+// Flags of Lease Break Notification
+const (
+	SMB2_NOTIFY_BREAK_LEASE_FLAG_ACK_REQUIRED = 0x1
+)
 
 //
 
@@ -510,6 +586,14 @@ const (
 // SMB2 LOCK Request and Response
 //
 
+// Flags
+const (
+	SMB2_LOCKFLAG_SHARED_LOCK      = 0x1
+	SMB2_LOCKFLAG_EXCLUSIVE_LOCK   = 0x2
+	SMB2_LOCKFLAG_UNLOCK           = 0x4
+	SMB2_LOCKFLAG_FAIL_IMMEDIATELY = 0x10
+)
+
 //
 
 // ----------------------------------------------------------------------------
@@ -601,7 +685,38 @@ const (
 // SMB2 CHANGE_NOTIFY Request and Response
 //
 
-//
+// Flags
+const (
+	SMB2_WATCH_TREE = 0x1
+)
+
+// CompletionFilter
+const (
+	FILE_NOTIFY_CHANGE_FILE_NAME = 1 << iota
+	FILE_NOTIFY_CHANGE_DIR_NAME
+	FILE_NOTIFY_CHANGE_ATTRIBUTES
+	FILE_NOTIFY_CHANGE_SIZE
+	FILE_NOTIFY_CHANGE_LAST_WRITE
+	FILE_NOTIFY_CHANGE_LAST_ACCESS
+	FILE_NOTIFY_CHANGE_CREATION
+	FILE_NOTIFY_CHANGE_EA
+	FILE_NOTIFY_CHANGE_SECURITY
+	FILE_NOTIFY_CHANGE_STREAM_NAME
+	FILE_NOTIFY_CHANGE_STREAM_SIZE
+	FILE_NOTIFY_CHANGE_STREAM_WRITE
+)
+
+// Action of FILE_NOTIFY_INFORMATION (from MS-FSCC)
+const (
+	FILE_ACTION_ADDED = 1 + iota
+	FILE_ACTION_REMOVED
+	FILE_ACTION_MODIFIED
+	FILE_ACTION_RENAMED_OLD_NAME
+	FILE_ACTION_RENAMED_NEW_NAME
+	FILE_ACTION_ADDED_STREAM
+	FILE_ACTION_REMOVED_STREAM
+	FILE_ACTION_MODIFIED_STREAM
+)
 
 // ----------------------------------------------------------------------------
 // SMB2 QUERY_INFO Request and Response
@@ -0,0 +1,144 @@
+// ref: MS-DFSC
+
+package smb2
+
+import (
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
+)
+
+const (
+	DFS_REFERRAL_SERVERS    = 0x1
+	DFS_STORAGE_SERVERS     = 0x2
+	DFS_TARGET_FAILBACK     = 0x4
+	DFS_SERVER_TYPE_LINK    = 0x0
+	DFS_SERVER_TYPE_ROOT    = 0x1
+	DFS_NAME_LIST_REFERRAL  = 0x2
+	DFS_TARGET_SET_BOUNDARY = 0x4
+)
+
+type DfsReferralRequest struct {
+	MaxReferralLevel uint16
+	RequestFileName  string
+}
+
+func (c *DfsReferralRequest) Size() int {
+	return 2 + utf16le.EncodedStringLen(c.RequestFileName) + 2
+}
+
+func (c *DfsReferralRequest) Encode(p []byte) {
+	le.PutUint16(p[:2], c.MaxReferralLevel)
+	n := utf16le.EncodeString(p[2:], c.RequestFileName)
+	le.PutUint16(p[2+n:4+n], 0) // null terminator
+}
+
+type DfsReferralResponseDecoder []byte
+
+func (c DfsReferralResponseDecoder) IsInvalid() bool {
+	return len(c) < 8
+}
+
+func (c DfsReferralResponseDecoder) PathConsumed() uint16 {
+	return le.Uint16(c[:2])
+}
+
+func (c DfsReferralResponseDecoder) NumberOfReferrals() uint16 {
+	return le.Uint16(c[2:4])
+}
+
+func (c DfsReferralResponseDecoder) ReferralHeaderFlags() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+// ReferralEntries returns the referral entries followed by the string buffer they refer to.
+func (c DfsReferralResponseDecoder) ReferralEntries() []byte {
+	return c[8:]
+}
+
+// DfsReferralEntryDecoder decodes a DFS_REFERRAL_V2, V3 or V4 entry.
+// It must extend to the end of the response, since the offsets of the strings are relative to the entry.
+type DfsReferralEntryDecoder []byte
+
+func (c DfsReferralEntryDecoder) IsInvalid() bool {
+	if len(c) < 8 {
+		return true
+	}
+
+	size := int(c.Size())
+	if size < 8 || len(c) < size {
+		return true
+	}
+
+	switch c.VersionNumber() {
+	case 2:
+		return size < 22
+	case 3, 4:
+		return size < 18
+	}
+
+	// version 1 and unknown versions are skipped by the caller.
+	return false
+}
+
+func (c DfsReferralEntryDecoder) VersionNumber() uint16 {
+	return le.Uint16(c[:2])
+}
+
+func (c DfsReferralEntryDecoder) Size() uint16 {
+	return le.Uint16(c[2:4])
+}
+
+func (c DfsReferralEntryDecoder) ServerType() uint16 {
+	return le.Uint16(c[4:6])
+}
+
+func (c DfsReferralEntryDecoder) ReferralEntryFlags() uint16 {
+	return le.Uint16(c[6:8])
+}
+
+// IsNameListReferral reports whether the entry lists the domain controllers of a domain
+// rather than the target of a path. (V3 and V4 only)
+func (c DfsReferralEntryDecoder) IsNameListReferral() bool {
+	return c.VersionNumber() >= 3 && c.ReferralEntryFlags()&DFS_NAME_LIST_REFERRAL != 0
+}
+
+func (c DfsReferralEntryDecoder) TimeToLive() uint32 {
+	if c.VersionNumber() == 2 {
+		return le.Uint32(c[12:16])
+	}
+	return le.Uint32(c[8:12])
+}
+
+func (c DfsReferralEntryDecoder) offsets() int {
+	if c.VersionNumber() == 2 {
+		return 16
+	}
+	return 12
+}
+
+func (c DfsReferralEntryDecoder) DFSPath() string {
+	return c.stringAt(le.Uint16(c[c.offsets() : c.offsets()+2]))
+}
+
+func (c DfsReferralEntryDecoder) DFSAlternatePath() string {
+	return c.stringAt(le.Uint16(c[c.offsets()+2 : c.offsets()+4]))
+}
+
+func (c DfsReferralEntryDecoder) NetworkAddress() string {
+	return c.stringAt(le.Uint16(c[c.offsets()+4 : c.offsets()+6]))
+}
+
+// stringAt decodes the null-terminated string at off, or returns "" if it's out of range.
+func (c DfsReferralEntryDecoder) stringAt(off uint16) string {
+	if int(off) >= len(c) {
+		return ""
+	}
+
+	bs := c[off:]
+	for i := 0; i+1 < len(bs); i += 2 {
+		if bs[i] == 0 && bs[i+1] == 0 {
+			return utf16le.DecodeToString(bs[:i])
+		}
+	}
+
+	return utf16le.DecodeToString(bs[:len(bs)&^1])
+}
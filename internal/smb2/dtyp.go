@@ -91,7 +91,7 @@ func (sid *Sid) Encode(p []byte) {
 	p[0] = sid.Revision
 	p[1] = uint8(len(sid.SubAuthority))
 	for j := 0; j < 6; j++ {
-		p[2+j] = byte(sid.IdentifierAuthority >> uint64(8*(6-j)))
+		p[2+j] = byte(sid.IdentifierAuthority >> uint64(8*(5-j)))
 	}
 	off := 8
 	for _, u := range sid.SubAuthority {
@@ -148,3 +148,357 @@ func (c SidDecoder) Decode() *Sid {
 		SubAuthority:        c.SubAuthority(),
 	}
 }
+
+const (
+	SE_OWNER_DEFAULTED       = 0x0001
+	SE_GROUP_DEFAULTED       = 0x0002
+	SE_DACL_PRESENT          = 0x0004
+	SE_DACL_DEFAULTED        = 0x0008
+	SE_SACL_PRESENT          = 0x0010
+	SE_SACL_DEFAULTED        = 0x0020
+	SE_DACL_TRUSTED          = 0x0040
+	SE_SERVER_SECURITY       = 0x0080
+	SE_DACL_AUTO_INHERIT_REQ = 0x0100
+	SE_SACL_AUTO_INHERIT_REQ = 0x0200
+	SE_DACL_AUTO_INHERITED   = 0x0400
+	SE_SACL_AUTO_INHERITED   = 0x0800
+	SE_DACL_PROTECTED        = 0x1000
+	SE_SACL_PROTECTED        = 0x2000
+	SE_RM_CONTROL_VALID      = 0x4000
+	SE_SELF_RELATIVE         = 0x8000
+)
+
+// SecurityDescriptorEncoder encodes a self-relative SECURITY_DESCRIPTOR.
+// The parts are laid out in the order used by Windows: SACL, DACL, owner and group.
+type SecurityDescriptorEncoder struct {
+	Control uint16
+	Owner   *Sid
+	Group   *Sid
+	Sacl    *AclEncoder
+	Dacl    *AclEncoder
+}
+
+func (c *SecurityDescriptorEncoder) Size() int {
+	size := 20
+	if c.Sacl != nil {
+		size += c.Sacl.Size()
+	}
+	if c.Dacl != nil {
+		size += c.Dacl.Size()
+	}
+	if c.Owner != nil {
+		size += c.Owner.Size()
+	}
+	if c.Group != nil {
+		size += c.Group.Size()
+	}
+	return size
+}
+
+func (c *SecurityDescriptorEncoder) Encode(p []byte) {
+	control := c.Control | SE_SELF_RELATIVE
+
+	off := 20
+
+	if c.Sacl != nil {
+		control |= SE_SACL_PRESENT
+		c.Sacl.Encode(p[off:])
+		le.PutUint32(p[12:16], uint32(off)) // OffsetSacl
+		off += c.Sacl.Size()
+	}
+	if c.Dacl != nil {
+		control |= SE_DACL_PRESENT
+		c.Dacl.Encode(p[off:])
+		le.PutUint32(p[16:20], uint32(off)) // OffsetDacl
+		off += c.Dacl.Size()
+	}
+	if c.Owner != nil {
+		c.Owner.Encode(p[off:])
+		le.PutUint32(p[4:8], uint32(off)) // OffsetOwner
+		off += c.Owner.Size()
+	}
+	if c.Group != nil {
+		c.Group.Encode(p[off:])
+		le.PutUint32(p[8:12], uint32(off)) // OffsetGroup
+	}
+
+	p[0] = 1 // Revision
+	p[1] = 0 // Sbz1
+	le.PutUint16(p[2:4], control)
+}
+
+type SecurityDescriptorDecoder []byte
+
+func (c SecurityDescriptorDecoder) IsInvalid() bool {
+	if len(c) < 20 {
+		return true
+	}
+
+	if c.Revision() != 1 || c.Control()&SE_SELF_RELATIVE == 0 {
+		return true
+	}
+
+	for _, off := range []uint32{c.OffsetOwner(), c.OffsetGroup(), c.OffsetSacl(), c.OffsetDacl()} {
+		if off != 0 && (off < 20 || int(off) >= len(c)) {
+			return true
+		}
+	}
+
+	if off := c.OffsetOwner(); off != 0 && SidDecoder(c[off:]).IsInvalid() {
+		return true
+	}
+	if off := c.OffsetGroup(); off != 0 && SidDecoder(c[off:]).IsInvalid() {
+		return true
+	}
+	if off := c.OffsetSacl(); off != 0 && AclDecoder(c[off:]).IsInvalid() {
+		return true
+	}
+	if off := c.OffsetDacl(); off != 0 && AclDecoder(c[off:]).IsInvalid() {
+		return true
+	}
+
+	return false
+}
+
+func (c SecurityDescriptorDecoder) Revision() uint8 {
+	return c[0]
+}
+
+func (c SecurityDescriptorDecoder) Control() uint16 {
+	return le.Uint16(c[2:4])
+}
+
+func (c SecurityDescriptorDecoder) OffsetOwner() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c SecurityDescriptorDecoder) OffsetGroup() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+func (c SecurityDescriptorDecoder) OffsetSacl() uint32 {
+	return le.Uint32(c[12:16])
+}
+
+func (c SecurityDescriptorDecoder) OffsetDacl() uint32 {
+	return le.Uint32(c[16:20])
+}
+
+// Owner returns the owner SID, or nil if it's absent.
+func (c SecurityDescriptorDecoder) Owner() SidDecoder {
+	if off := c.OffsetOwner(); off != 0 {
+		return SidDecoder(c[off:])
+	}
+	return nil
+}
+
+// Group returns the group SID, or nil if it's absent.
+func (c SecurityDescriptorDecoder) Group() SidDecoder {
+	if off := c.OffsetGroup(); off != 0 {
+		return SidDecoder(c[off:])
+	}
+	return nil
+}
+
+// Sacl returns the SACL, or nil if it's absent.
+func (c SecurityDescriptorDecoder) Sacl() AclDecoder {
+	if off := c.OffsetSacl(); off != 0 {
+		return AclDecoder(c[off:])
+	}
+	return nil
+}
+
+// Dacl returns the DACL, or nil if it's absent.
+func (c SecurityDescriptorDecoder) Dacl() AclDecoder {
+	if off := c.OffsetDacl(); off != 0 {
+		return AclDecoder(c[off:])
+	}
+	return nil
+}
+
+const (
+	ACL_REVISION    = 2
+	ACL_REVISION_DS = 4
+)
+
+type AclEncoder struct {
+	AclRevision uint8
+	Aces        []*AceEncoder
+}
+
+func (c *AclEncoder) Size() int {
+	size := 8
+	for _, ace := range c.Aces {
+		size += ace.Size()
+	}
+	return size
+}
+
+func (c *AclEncoder) Encode(p []byte) {
+	p[0] = c.AclRevision
+	p[1] = 0 // Sbz1
+	le.PutUint16(p[2:4], uint16(c.Size()))
+	le.PutUint16(p[4:6], uint16(len(c.Aces)))
+	le.PutUint16(p[6:8], 0) // Sbz2
+
+	off := 8
+	for _, ace := range c.Aces {
+		ace.Encode(p[off:])
+		off += ace.Size()
+	}
+}
+
+type AclDecoder []byte
+
+func (c AclDecoder) IsInvalid() bool {
+	if len(c) < 8 {
+		return true
+	}
+
+	size := int(c.AclSize())
+	if size < 8 || len(c) < size {
+		return true
+	}
+
+	aces := c[8:size]
+	for i := 0; i < int(c.AceCount()); i++ {
+		ace := AceDecoder(aces)
+		if ace.IsInvalid() {
+			return true
+		}
+		aces = aces[ace.AceSize():]
+	}
+
+	return false
+}
+
+func (c AclDecoder) AclRevision() uint8 {
+	return c[0]
+}
+
+func (c AclDecoder) AclSize() uint16 {
+	return le.Uint16(c[2:4])
+}
+
+func (c AclDecoder) AceCount() uint16 {
+	return le.Uint16(c[4:6])
+}
+
+// Aces returns the ACEs, which are decoded one after another by AceDecoder.
+func (c AclDecoder) Aces() []byte {
+	return c[8:c.AclSize()]
+}
+
+const (
+	ACCESS_ALLOWED_ACE_TYPE                 = 0x00
+	ACCESS_DENIED_ACE_TYPE                  = 0x01
+	SYSTEM_AUDIT_ACE_TYPE                   = 0x02
+	SYSTEM_ALARM_ACE_TYPE                   = 0x03
+	ACCESS_ALLOWED_COMPOUND_ACE_TYPE        = 0x04
+	ACCESS_ALLOWED_OBJECT_ACE_TYPE          = 0x05
+	ACCESS_DENIED_OBJECT_ACE_TYPE           = 0x06
+	SYSTEM_AUDIT_OBJECT_ACE_TYPE            = 0x07
+	SYSTEM_ALARM_OBJECT_ACE_TYPE            = 0x08
+	ACCESS_ALLOWED_CALLBACK_ACE_TYPE        = 0x09
+	ACCESS_DENIED_CALLBACK_ACE_TYPE         = 0x0A
+	ACCESS_ALLOWED_CALLBACK_OBJECT_ACE_TYPE = 0x0B
+	ACCESS_DENIED_CALLBACK_OBJECT_ACE_TYPE  = 0x0C
+	SYSTEM_AUDIT_CALLBACK_ACE_TYPE          = 0x0D
+	SYSTEM_ALARM_CALLBACK_ACE_TYPE          = 0x0E
+	SYSTEM_AUDIT_CALLBACK_OBJECT_ACE_TYPE   = 0x0F
+	SYSTEM_ALARM_CALLBACK_OBJECT_ACE_TYPE   = 0x10
+	SYSTEM_MANDATORY_LABEL_ACE_TYPE         = 0x11
+	SYSTEM_RESOURCE_ATTRIBUTE_ACE_TYPE      = 0x12
+	SYSTEM_SCOPED_POLICY_ID_ACE_TYPE        = 0x13
+)
+
+const (
+	OBJECT_INHERIT_ACE         = 0x01
+	CONTAINER_INHERIT_ACE      = 0x02
+	NO_PROPAGATE_INHERIT_ACE   = 0x04
+	INHERIT_ONLY_ACE           = 0x08
+	INHERITED_ACE              = 0x10
+	SUCCESSFUL_ACCESS_ACE_FLAG = 0x40
+	FAILED_ACCESS_ACE_FLAG     = 0x80
+)
+
+// AceEncoder encodes an ACE. If Sid is set, the body is the mask and the SID followed by Data,
+// which is the layout of the ACE types other than the compound and object ones.
+// Otherwise, Data is the whole body.
+type AceEncoder struct {
+	AceType  uint8
+	AceFlags uint8
+	Mask     uint32
+	Sid      *Sid
+	Data     []byte
+}
+
+func (c *AceEncoder) Size() int {
+	size := 4 + len(c.Data)
+	if c.Sid != nil {
+		size += 4 + c.Sid.Size()
+	}
+	return (size + 3) &^ 3 // ACEs are 4-byte aligned
+}
+
+func (c *AceEncoder) Encode(p []byte) {
+	size := c.Size()
+
+	p[0] = c.AceType
+	p[1] = c.AceFlags
+	le.PutUint16(p[2:4], uint16(size))
+
+	off := 4
+	if c.Sid != nil {
+		le.PutUint32(p[4:8], c.Mask)
+		c.Sid.Encode(p[8:])
+		off = 8 + c.Sid.Size()
+	}
+	off += copy(p[off:], c.Data)
+
+	for ; off < size; off++ {
+		p[off] = 0
+	}
+}
+
+type AceDecoder []byte
+
+func (c AceDecoder) IsInvalid() bool {
+	if len(c) < 4 {
+		return true
+	}
+
+	size := int(c.AceSize())
+	if size < 4 || len(c) < size {
+		return true
+	}
+
+	return false
+}
+
+func (c AceDecoder) AceType() uint8 {
+	return c[0]
+}
+
+func (c AceDecoder) AceFlags() uint8 {
+	return c[1]
+}
+
+func (c AceDecoder) AceSize() uint16 {
+	return le.Uint16(c[2:4])
+}
+
+// Mask returns the access mask of the ACE types with a SID.
+func (c AceDecoder) Mask() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+// Sid returns the SID of the ACE types with a SID.
+func (c AceDecoder) Sid() SidDecoder {
+	return SidDecoder(c[8:c.AceSize()])
+}
+
+// Body returns the ACE without its header.
+func (c AceDecoder) Body() []byte {
+	return c[4:c.AceSize()]
+}
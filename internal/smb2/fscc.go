@@ -37,6 +37,9 @@ const (
 	FSCTL_DFS_GET_REFERRALS_EX         = 0x000601B0
 	FSCTL_FILE_LEVEL_TRIM              = 0x00098208
 	FSCTL_VALIDATE_NEGOTIATE_INFO      = 0x00140204
+	FSCTL_SET_ENCRYPTION               = 0x000900D7
+	FSCTL_SET_SPARSE                   = 0x000900C4
+	FSCTL_QUERY_ALLOCATED_RANGES       = 0x000940CF
 )
 
 type SymbolicLinkReparseDataBuffer struct {
@@ -138,6 +141,100 @@ func (c SymbolicLinkReparseDataBufferDecoder) PrintName() string {
 	return utf16le.DecodeToString(c.PathBuffer()[off : off+len])
 }
 
+// ReparseDataBufferDecoder decodes the header common to all reparse data buffers.
+type ReparseDataBufferDecoder []byte
+
+func (c ReparseDataBufferDecoder) IsInvalid() bool {
+	return len(c) < 8 || len(c) < 8+int(c.ReparseDataLength())
+}
+
+func (c ReparseDataBufferDecoder) ReparseTag() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c ReparseDataBufferDecoder) ReparseDataLength() uint16 {
+	return le.Uint16(c[4:6])
+}
+
+// ReparseData returns the tag-specific data following the header.
+func (c ReparseDataBufferDecoder) ReparseData() []byte {
+	return c[8 : 8+int(c.ReparseDataLength())]
+}
+
+// MountPointReparseDataBufferDecoder decodes a mount point (junction) reparse data buffer.
+// It's the same as the symbolic link one without the Flags field.
+type MountPointReparseDataBufferDecoder []byte
+
+func (c MountPointReparseDataBufferDecoder) IsInvalid() bool {
+	if len(c) < 16 {
+		return true
+	}
+
+	if c.ReparseTag() != IO_REPARSE_TAG_MOUNT_POINT {
+		return true
+	}
+
+	rlen := int(c.ReparseDataLength())
+	soff := int(c.SubstituteNameOffset())
+	slen := int(c.SubstituteNameLength())
+	poff := int(c.PrintNameOffset())
+	plen := int(c.PrintNameLength())
+
+	if (soff&1 | poff&1) != 0 {
+		return true
+	}
+
+	if len(c) < 8+rlen {
+		return true
+	}
+
+	if rlen < 8+soff+slen || rlen < 8+poff+plen {
+		return true
+	}
+
+	return false
+}
+
+func (c MountPointReparseDataBufferDecoder) ReparseTag() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c MountPointReparseDataBufferDecoder) ReparseDataLength() uint16 {
+	return le.Uint16(c[4:6])
+}
+
+func (c MountPointReparseDataBufferDecoder) SubstituteNameOffset() uint16 {
+	return le.Uint16(c[8:10])
+}
+
+func (c MountPointReparseDataBufferDecoder) SubstituteNameLength() uint16 {
+	return le.Uint16(c[10:12])
+}
+
+func (c MountPointReparseDataBufferDecoder) PrintNameOffset() uint16 {
+	return le.Uint16(c[12:14])
+}
+
+func (c MountPointReparseDataBufferDecoder) PrintNameLength() uint16 {
+	return le.Uint16(c[14:16])
+}
+
+func (c MountPointReparseDataBufferDecoder) PathBuffer() []byte {
+	return c[16:]
+}
+
+func (c MountPointReparseDataBufferDecoder) SubstituteName() string {
+	off := c.SubstituteNameOffset()
+	len := c.SubstituteNameLength()
+	return utf16le.DecodeToString(c.PathBuffer()[off : off+len])
+}
+
+func (c MountPointReparseDataBufferDecoder) PrintName() string {
+	off := c.PrintNameOffset()
+	len := c.PrintNameLength()
+	return utf16le.DecodeToString(c.PathBuffer()[off : off+len])
+}
+
 type SrvRequestResumeKeyResponseDecoder []byte
 
 func (c SrvRequestResumeKeyResponseDecoder) IsInvalid() bool {
@@ -211,6 +308,125 @@ func (c SrvCopychunkResponseDecoder) TotalBytesWritten() uint32 {
 	return le.Uint32(c[8:12])
 }
 
+type NetworkResiliencyRequest struct {
+	Timeout uint32 // milliseconds
+}
+
+func (c *NetworkResiliencyRequest) Size() int {
+	return 8
+}
+
+func (c *NetworkResiliencyRequest) Encode(p []byte) {
+	le.PutUint32(p[:4], c.Timeout)
+	le.PutUint32(p[4:8], 0) // Reserved
+}
+
+const (
+	FILE_SET_ENCRYPTION   = 0x00000001
+	FILE_CLEAR_ENCRYPTION = 0x00000002
+)
+
+type EncryptionBuffer struct {
+	EncryptionOperation uint32
+}
+
+func (c *EncryptionBuffer) Size() int {
+	return 8
+}
+
+func (c *EncryptionBuffer) Encode(p []byte) {
+	le.PutUint32(p[:4], c.EncryptionOperation)
+	p[4] = 0 // Private
+}
+
+type FileSparseBuffer struct {
+	SetSparse bool
+}
+
+func (c *FileSparseBuffer) Size() int {
+	return 1
+}
+
+func (c *FileSparseBuffer) Encode(p []byte) {
+	if c.SetSparse {
+		p[0] = 1
+	} else {
+		p[0] = 0
+	}
+}
+
+type FileAllocatedRangeBuffer struct {
+	FileOffset int64
+	Length     int64
+}
+
+func (c *FileAllocatedRangeBuffer) Size() int {
+	return 16
+}
+
+func (c *FileAllocatedRangeBuffer) Encode(p []byte) {
+	le.PutUint64(p[:8], uint64(c.FileOffset))
+	le.PutUint64(p[8:16], uint64(c.Length))
+}
+
+type FileAllocatedRangeBufferDecoder []byte
+
+func (c FileAllocatedRangeBufferDecoder) IsInvalid() bool {
+	return len(c) < 16
+}
+
+func (c FileAllocatedRangeBufferDecoder) FileOffset() int64 {
+	return int64(le.Uint64(c[:8]))
+}
+
+func (c FileAllocatedRangeBufferDecoder) Length() int64 {
+	return int64(le.Uint64(c[8:16]))
+}
+
+type ValidateNegotiateInfoRequest struct {
+	Capabilities uint32
+	Guid         [16]byte
+	SecurityMode uint16
+	Dialects     []uint16
+}
+
+func (c *ValidateNegotiateInfoRequest) Size() int {
+	return 24 + len(c.Dialects)*2
+}
+
+func (c *ValidateNegotiateInfoRequest) Encode(p []byte) {
+	le.PutUint32(p[:4], c.Capabilities)
+	copy(p[4:20], c.Guid[:])
+	le.PutUint16(p[20:22], c.SecurityMode)
+	le.PutUint16(p[22:24], uint16(len(c.Dialects)))
+	off := 24
+	for i, d := range c.Dialects {
+		le.PutUint16(p[off+i*2:off+i*2+2], d)
+	}
+}
+
+type ValidateNegotiateInfoResponseDecoder []byte
+
+func (c ValidateNegotiateInfoResponseDecoder) IsInvalid() bool {
+	return len(c) < 24
+}
+
+func (c ValidateNegotiateInfoResponseDecoder) Capabilities() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c ValidateNegotiateInfoResponseDecoder) Guid() []byte {
+	return c[4:20]
+}
+
+func (c ValidateNegotiateInfoResponseDecoder) SecurityMode() uint16 {
+	return le.Uint16(c[20:22])
+}
+
+func (c ValidateNegotiateInfoResponseDecoder) Dialect() uint16 {
+	return le.Uint16(c[22:24])
+}
+
 const (
 	FILE_ATTRIBUTE_ARCHIVE             = 0x20
 	FILE_ATTRIBUTE_COMPRESSED          = 0x800
@@ -303,7 +519,7 @@ const (
 type FileDirectoryInformationDecoder []byte
 
 func (c FileDirectoryInformationDecoder) IsInvalid() bool {
-	return len(c) < int(64+c.FileNameLength())
+	return len(c) < 64 || len(c) < 64+int(c.FileNameLength())
 }
 
 func (c FileDirectoryInformationDecoder) NextEntryOffset() uint32 {
@@ -347,7 +563,80 @@ func (c FileDirectoryInformationDecoder) FileNameLength() uint32 {
 }
 
 func (c FileDirectoryInformationDecoder) FileName() string {
-	return utf16le.DecodeToString(c[64 : 64+c.FileNameLength()])
+	return utf16le.DecodeToString(c[64 : 64+int(c.FileNameLength())])
+}
+
+// FileFullDirectoryInformationDecoder decodes the fields following those of FILE_DIRECTORY_INFORMATION.
+type FileFullDirectoryInformationDecoder []byte
+
+func (c FileFullDirectoryInformationDecoder) IsInvalid() bool {
+	return len(c) < 68 || len(c) < 68+int(FileDirectoryInformationDecoder(c).FileNameLength())
+}
+
+func (c FileFullDirectoryInformationDecoder) EaSize() uint32 {
+	return le.Uint32(c[64:68])
+}
+
+func (c FileFullDirectoryInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[68 : 68+int(FileDirectoryInformationDecoder(c).FileNameLength())])
+}
+
+type FileIdFullDirectoryInformationDecoder []byte
+
+func (c FileIdFullDirectoryInformationDecoder) IsInvalid() bool {
+	return len(c) < 80 || len(c) < 80+int(FileDirectoryInformationDecoder(c).FileNameLength())
+}
+
+func (c FileIdFullDirectoryInformationDecoder) EaSize() uint32 {
+	return le.Uint32(c[64:68])
+}
+
+func (c FileIdFullDirectoryInformationDecoder) FileId() int64 {
+	return int64(le.Uint64(c[72:80]))
+}
+
+func (c FileIdFullDirectoryInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[80 : 80+int(FileDirectoryInformationDecoder(c).FileNameLength())])
+}
+
+type FileBothDirectoryInformationDecoder []byte
+
+func (c FileBothDirectoryInformationDecoder) IsInvalid() bool {
+	return len(c) < 94 || c[68] > 24 || len(c) < 94+int(FileDirectoryInformationDecoder(c).FileNameLength())
+}
+
+func (c FileBothDirectoryInformationDecoder) EaSize() uint32 {
+	return le.Uint32(c[64:68])
+}
+
+func (c FileBothDirectoryInformationDecoder) ShortName() string {
+	return utf16le.DecodeToString(c[70 : 70+c[68]])
+}
+
+func (c FileBothDirectoryInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[94 : 94+int(FileDirectoryInformationDecoder(c).FileNameLength())])
+}
+
+type FileIdBothDirectoryInformationDecoder []byte
+
+func (c FileIdBothDirectoryInformationDecoder) IsInvalid() bool {
+	return len(c) < 104 || c[68] > 24 || len(c) < 104+int(FileDirectoryInformationDecoder(c).FileNameLength())
+}
+
+func (c FileIdBothDirectoryInformationDecoder) EaSize() uint32 {
+	return le.Uint32(c[64:68])
+}
+
+func (c FileIdBothDirectoryInformationDecoder) ShortName() string {
+	return utf16le.DecodeToString(c[70 : 70+c[68]])
+}
+
+func (c FileIdBothDirectoryInformationDecoder) FileId() int64 {
+	return int64(le.Uint64(c[96:104]))
+}
+
+func (c FileIdBothDirectoryInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[104 : 104+int(FileDirectoryInformationDecoder(c).FileNameLength())])
 }
 
 type FileRenameInformationType2Encoder struct {
@@ -410,6 +699,114 @@ func (c *FilePositionInformationEncoder) Encode(p []byte) {
 	le.PutUint64(p[:8], uint64(c.CurrentByteOffset))
 }
 
+// SrvSnapshotArrayDecoder decodes the output of FSCTL_SRV_ENUMERATE_SNAPSHOTS. ([MS-SMB2] 2.2.32.2)
+type SrvSnapshotArrayDecoder []byte
+
+func (c SrvSnapshotArrayDecoder) IsInvalid() bool {
+	return len(c) < 12 || len(c) < 12+int(c.SnapShotArraySize())
+}
+
+func (c SrvSnapshotArrayDecoder) NumberOfSnapShots() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c SrvSnapshotArrayDecoder) NumberOfSnapShotsReturned() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c SrvSnapshotArrayDecoder) SnapShotArraySize() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+// SnapShots returns the null-terminated tokens of the snapshots returned, like "@GMT-2006.01.02-15.04.05".
+func (c SrvSnapshotArrayDecoder) SnapShots() []string {
+	var tokens []string
+
+	bs := c[12 : 12+c.SnapShotArraySize()]
+	for start, i := 0, 0; i+1 < len(bs); i += 2 {
+		if bs[i] == 0 && bs[i+1] == 0 {
+			if i == start {
+				// the array ends with an empty string.
+				break
+			}
+			tokens = append(tokens, utf16le.DecodeToString(bs[start:i]))
+			start = i + 2
+		}
+	}
+
+	return tokens
+}
+
+type FileFsVolumeInformationDecoder []byte
+
+func (c FileFsVolumeInformationDecoder) IsInvalid() bool {
+	return len(c) < 18 || len(c) < 18+int(c.VolumeLabelLength())
+}
+
+func (c FileFsVolumeInformationDecoder) VolumeCreationTime() FiletimeDecoder {
+	return FiletimeDecoder(c[:8])
+}
+
+func (c FileFsVolumeInformationDecoder) VolumeSerialNumber() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+func (c FileFsVolumeInformationDecoder) VolumeLabelLength() uint32 {
+	return le.Uint32(c[12:16])
+}
+
+func (c FileFsVolumeInformationDecoder) SupportsObjects() uint8 {
+	return c[16]
+}
+
+func (c FileFsVolumeInformationDecoder) VolumeLabel() string {
+	return utf16le.DecodeToString(c[18 : 18+c.VolumeLabelLength()])
+}
+
+type FileFsSizeInformationDecoder []byte
+
+func (c FileFsSizeInformationDecoder) IsInvalid() bool {
+	return len(c) < 24
+}
+
+func (c FileFsSizeInformationDecoder) TotalAllocationUnits() int64 {
+	return int64(le.Uint64(c[:8]))
+}
+
+func (c FileFsSizeInformationDecoder) AvailableAllocationUnits() int64 {
+	return int64(le.Uint64(c[8:16]))
+}
+
+func (c FileFsSizeInformationDecoder) SectorsPerAllocationUnit() uint32 {
+	return le.Uint32(c[16:20])
+}
+
+func (c FileFsSizeInformationDecoder) BytesPerSector() uint32 {
+	return le.Uint32(c[20:24])
+}
+
+type FileFsAttributeInformationDecoder []byte
+
+func (c FileFsAttributeInformationDecoder) IsInvalid() bool {
+	return len(c) < 12 || len(c) < 12+int(c.FileSystemNameLength())
+}
+
+func (c FileFsAttributeInformationDecoder) FileSystemAttributes() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileFsAttributeInformationDecoder) MaximumComponentNameLength() int32 {
+	return int32(le.Uint32(c[4:8]))
+}
+
+func (c FileFsAttributeInformationDecoder) FileSystemNameLength() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+func (c FileFsAttributeInformationDecoder) FileSystemName() string {
+	return utf16le.DecodeToString(c[12 : 12+c.FileSystemNameLength()])
+}
+
 type FileFsFullSizeInformationDecoder []byte
 
 func (c FileFsFullSizeInformationDecoder) IsInvalid() bool {
@@ -436,10 +833,82 @@ func (c FileFsFullSizeInformationDecoder) BytesPerSector() uint32 {
 	return le.Uint32(c[28:32])
 }
 
+type FileFsSectorSizeInformationDecoder []byte
+
+func (c FileFsSectorSizeInformationDecoder) IsInvalid() bool {
+	return len(c) < 28
+}
+
+func (c FileFsSectorSizeInformationDecoder) LogicalBytesPerSector() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileFsSectorSizeInformationDecoder) PhysicalBytesPerSectorForAtomicity() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c FileFsSectorSizeInformationDecoder) PhysicalBytesPerSectorForPerformance() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+func (c FileFsSectorSizeInformationDecoder) FileSystemEffectivePhysicalBytesPerSectorForAtomicity() uint32 {
+	return le.Uint32(c[12:16])
+}
+
+func (c FileFsSectorSizeInformationDecoder) Flags() uint32 {
+	return le.Uint32(c[16:20])
+}
+
+func (c FileFsSectorSizeInformationDecoder) ByteOffsetForSectorAlignment() uint32 {
+	return le.Uint32(c[20:24])
+}
+
+func (c FileFsSectorSizeInformationDecoder) ByteOffsetForPartitionAlignment() uint32 {
+	return le.Uint32(c[24:28])
+}
+
+type FileQuotaInformationEncoder struct {
+	QuotaThreshold int64
+	QuotaLimit     int64
+	Sid            *Sid
+}
+
+type FileQuotaInformationList []*FileQuotaInformationEncoder
+
+func (c FileQuotaInformationList) Size() int {
+	l := 0
+	for i, q := range c {
+		if i > 0 {
+			l = Roundup(l, 8)
+		}
+		l += 40 + q.Sid.Size()
+	}
+	return l
+}
+
+func (c FileQuotaInformationList) Encode(p []byte) {
+	off := 0
+	for i, q := range c {
+		size := q.Sid.Size()
+		next := 0
+		if i < len(c)-1 {
+			next = Roundup(40+size, 8)
+		}
+		le.PutUint32(p[off:off+4], uint32(next))
+		le.PutUint32(p[off+4:off+8], uint32(size))
+		le.PutUint64(p[off+8:off+16], 0)  // ChangeTime
+		le.PutUint64(p[off+16:off+24], 0) // QuotaUsed
+		le.PutUint64(p[off+24:off+32], uint64(q.QuotaThreshold))
+		le.PutUint64(p[off+32:off+40], uint64(q.QuotaLimit))
+		q.Sid.Encode(p[off+40:])
+		off += next
+	}
+}
+
 type FileQuotaInformationDecoder []byte
 
 func (c FileQuotaInformationDecoder) IsInvalid() bool {
-	return len(c) < int(40+c.SidLength())
+	return len(c) < 40 || len(c) < 40+int(c.SidLength())
 }
 
 func (c FileQuotaInformationDecoder) NextEntryOffset() uint32 {
@@ -467,7 +936,152 @@ func (c FileQuotaInformationDecoder) QuotaLimit() int64 {
 }
 
 func (c FileQuotaInformationDecoder) Sid() SidDecoder {
-	return SidDecoder(c[40 : 40+c.SidLength()])
+	return SidDecoder(c[40 : 40+int(c.SidLength())])
+}
+
+const (
+	FILE_NEED_EA = 0x80
+)
+
+type FileFullEaInformationEncoder struct {
+	Flags   uint8
+	EaName  string // ASCII, without the null terminator
+	EaValue []byte
+}
+
+// FileFullEaInformationList encodes chained FILE_FULL_EA_INFORMATION entries, aligned to 4 bytes.
+type FileFullEaInformationList []*FileFullEaInformationEncoder
+
+func (c FileFullEaInformationList) Size() int {
+	l := 0
+	for i, ea := range c {
+		if i > 0 {
+			l = Roundup(l, 4)
+		}
+		l += 8 + len(ea.EaName) + 1 + len(ea.EaValue)
+	}
+	return l
+}
+
+func (c FileFullEaInformationList) Encode(p []byte) {
+	off := 0
+	for i, ea := range c {
+		size := 8 + len(ea.EaName) + 1 + len(ea.EaValue)
+		next := 0
+		if i < len(c)-1 {
+			next = Roundup(size, 4)
+		}
+		le.PutUint32(p[off:off+4], uint32(next))
+		p[off+4] = ea.Flags
+		p[off+5] = uint8(len(ea.EaName))
+		le.PutUint16(p[off+6:off+8], uint16(len(ea.EaValue)))
+		copy(p[off+8:], ea.EaName)
+		p[off+8+len(ea.EaName)] = 0 // null terminator
+		copy(p[off+9+len(ea.EaName):], ea.EaValue)
+		for j := off + size; j < off+next; j++ {
+			p[j] = 0
+		}
+		off += next
+	}
+}
+
+type FileFullEaInformationDecoder []byte
+
+func (c FileFullEaInformationDecoder) IsInvalid() bool {
+	return len(c) < 8 || len(c) < 8+int(c.EaNameLength())+1+int(c.EaValueLength())
+}
+
+func (c FileFullEaInformationDecoder) NextEntryOffset() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileFullEaInformationDecoder) Flags() uint8 {
+	return c[4]
+}
+
+func (c FileFullEaInformationDecoder) EaNameLength() uint8 {
+	return c[5]
+}
+
+func (c FileFullEaInformationDecoder) EaValueLength() uint16 {
+	return le.Uint16(c[6:8])
+}
+
+func (c FileFullEaInformationDecoder) EaName() string {
+	return string(c[8 : 8+int(c.EaNameLength())])
+}
+
+func (c FileFullEaInformationDecoder) EaValue() []byte {
+	off := 8 + int(c.EaNameLength()) + 1
+	return c[off : off+int(c.EaValueLength())]
+}
+
+// FileGetEaInformationList encodes chained FILE_GET_EA_INFORMATION entries, aligned to 4 bytes.
+// It selects the EAs returned by a query of FileFullEaInformation.
+type FileGetEaInformationList []string
+
+func (c FileGetEaInformationList) Size() int {
+	l := 0
+	for i, name := range c {
+		if i > 0 {
+			l = Roundup(l, 4)
+		}
+		l += 5 + len(name) + 1
+	}
+	return l
+}
+
+func (c FileGetEaInformationList) Encode(p []byte) {
+	off := 0
+	for i, name := range c {
+		size := 5 + len(name) + 1
+		next := 0
+		if i < len(c)-1 {
+			next = Roundup(size, 4)
+		}
+		le.PutUint32(p[off:off+4], uint32(next))
+		p[off+4] = uint8(len(name))
+		copy(p[off+5:], name)
+		p[off+5+len(name)] = 0 // null terminator
+		for j := off + size; j < off+next; j++ {
+			p[j] = 0
+		}
+		off += next
+	}
+}
+
+type FileNotifyInformationDecoder []byte
+
+func (c FileNotifyInformationDecoder) IsInvalid() bool {
+	return len(c) < 12 || len(c) < 12+int(c.FileNameLength())
+}
+
+func (c FileNotifyInformationDecoder) NextEntryOffset() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileNotifyInformationDecoder) Action() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c FileNotifyInformationDecoder) FileNameLength() uint32 {
+	return le.Uint32(c[8:12])
+}
+
+func (c FileNotifyInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[12 : 12+c.FileNameLength()])
+}
+
+type FileAllocationInformationEncoder struct {
+	AllocationSize int64
+}
+
+func (c *FileAllocationInformationEncoder) Size() int {
+	return 8
+}
+
+func (c *FileAllocationInformationEncoder) Encode(p []byte) {
+	le.PutUint64(p[:8], uint64(c.AllocationSize))
 }
 
 type FileEndOfFileInformationEncoder struct {
@@ -674,6 +1288,55 @@ func (c FileAlignmentInformationDecoder) AlignmentRequirement() uint32 {
 	return le.Uint32(c[:4])
 }
 
+type FileLinksInformationDecoder []byte
+
+func (c FileLinksInformationDecoder) IsInvalid() bool {
+	return len(c) < 8
+}
+
+func (c FileLinksInformationDecoder) BytesNeeded() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileLinksInformationDecoder) EntriesReturned() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c FileLinksInformationDecoder) Entries() []byte {
+	return c[8:]
+}
+
+type FileLinkEntryInformationDecoder []byte
+
+func (c FileLinkEntryInformationDecoder) IsInvalid() bool {
+	if len(c) < 16 {
+		return true
+	}
+
+	if len(c) < 16+int(c.FileNameLength())*2 {
+		return true
+	}
+
+	return false
+}
+
+func (c FileLinkEntryInformationDecoder) NextEntryOffset() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c FileLinkEntryInformationDecoder) ParentFileId() []byte {
+	return c[4:12]
+}
+
+// FileNameLength returns the length of the file name in characters.
+func (c FileLinkEntryInformationDecoder) FileNameLength() uint32 {
+	return le.Uint32(c[12:16])
+}
+
+func (c FileLinkEntryInformationDecoder) FileName() string {
+	return utf16le.DecodeToString(c[16 : 16+int(c.FileNameLength())*2])
+}
+
 type FileNameInformationDecoder []byte
 
 func (c FileNameInformationDecoder) IsInvalid() bool {
@@ -681,7 +1344,7 @@ func (c FileNameInformationDecoder) IsInvalid() bool {
 		return true
 	}
 
-	if len(c) < int(4+c.FileNameLength()) {
+	if len(c) < 4+int(c.FileNameLength()) {
 		return true
 	}
 
@@ -693,5 +1356,5 @@ func (c FileNameInformationDecoder) FileNameLength() uint32 {
 }
 
 func (c FileNameInformationDecoder) FileName() string {
-	return utf16le.DecodeToString(c[4 : 4+c.FileNameLength()])
+	return utf16le.DecodeToString(c[4 : 4+int(c.FileNameLength())])
 }
@@ -11,3 +11,14 @@ type Decoder interface {
 	IsInvalid() bool
 	// Decode() Encoder
 }
+
+// Bytes encodes a buffer as is, e.g. the data of a pipe transceive request.
+type Bytes []byte
+
+func (c Bytes) Size() int {
+	return len(c)
+}
+
+func (c Bytes) Encode(p []byte) {
+	copy(p, c)
+}
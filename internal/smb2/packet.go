@@ -313,3 +313,150 @@ func (t TransformCodec) Flags() uint16 {
 func (t TransformCodec) SetFlags(u uint16) {
 	le.PutUint16(t[42:44], u)
 }
+
+// ----------------------------------------------------------------------------
+// SMB2 COMPRESSION_TRANSFORM_HEADER
+//
+
+// From SMB311
+
+type CompressionTransformCodec []byte
+
+func (p CompressionTransformCodec) IsInvalid() bool {
+	if len(p) < 16 {
+		return true
+	}
+
+	magic := p.ProtocolId()
+	if magic[0] != 0xfc {
+		return true
+	}
+	if magic[1] != 'S' {
+		return true
+	}
+	if magic[2] != 'M' {
+		return true
+	}
+	if magic[3] != 'B' {
+		return true
+	}
+
+	return false
+}
+
+func (p CompressionTransformCodec) ProtocolId() []byte {
+	return p[:4]
+}
+
+func (p CompressionTransformCodec) SetProtocolId() {
+	copy(p[:4], MAGIC3)
+}
+
+func (p CompressionTransformCodec) OriginalCompressedSegmentSize() uint32 {
+	return le.Uint32(p[4:8])
+}
+
+func (p CompressionTransformCodec) SetOriginalCompressedSegmentSize(u uint32) {
+	le.PutUint32(p[4:8], u)
+}
+
+func (p CompressionTransformCodec) CompressionAlgorithm() uint16 {
+	return le.Uint16(p[8:10])
+}
+
+func (p CompressionTransformCodec) SetCompressionAlgorithm(u uint16) {
+	le.PutUint16(p[8:10], u)
+}
+
+func (p CompressionTransformCodec) Flags() uint16 {
+	return le.Uint16(p[10:12])
+}
+
+func (p CompressionTransformCodec) SetFlags(u uint16) {
+	le.PutUint16(p[10:12], u)
+}
+
+// Offset is the size of the uncompressed data that precedes the compressed segment.
+// It's only valid if Flags is SMB2_COMPRESSION_FLAG_NONE.
+func (p CompressionTransformCodec) Offset() uint32 {
+	return le.Uint32(p[12:16])
+}
+
+func (p CompressionTransformCodec) SetOffset(u uint32) {
+	le.PutUint32(p[12:16], u)
+}
+
+// Data returns the data following the header in the unchained format,
+// or the list of payloads starting with the first payload header in the chained format.
+func (p CompressionTransformCodec) Data() []byte {
+	if p.Flags()&SMB2_COMPRESSION_FLAG_CHAINED != 0 {
+		return p[8:]
+	}
+	return p[16:]
+}
+
+type CompressionPayloadHeaderCodec []byte
+
+func (p CompressionPayloadHeaderCodec) IsInvalid() bool {
+	if len(p) < 8 {
+		return true
+	}
+
+	if len(p) < 8+int(p.Length()) {
+		return true
+	}
+
+	return false
+}
+
+func (p CompressionPayloadHeaderCodec) CompressionAlgorithm() uint16 {
+	return le.Uint16(p[:2])
+}
+
+func (p CompressionPayloadHeaderCodec) Flags() uint16 {
+	return le.Uint16(p[2:4])
+}
+
+func (p CompressionPayloadHeaderCodec) SetCompressionAlgorithm(u uint16) {
+	le.PutUint16(p[:2], u)
+}
+
+func (p CompressionPayloadHeaderCodec) SetFlags(u uint16) {
+	le.PutUint16(p[2:4], u)
+}
+
+func (p CompressionPayloadHeaderCodec) Length() uint32 {
+	return le.Uint32(p[4:8])
+}
+
+func (p CompressionPayloadHeaderCodec) SetLength(u uint32) {
+	le.PutUint32(p[4:8], u)
+}
+
+// Data returns the payload, which starts with OriginalPayloadSize for compressed payloads.
+func (p CompressionPayloadHeaderCodec) Data() []byte {
+	return p[8 : 8+p.Length()]
+}
+
+// OriginalPayloadSize is only valid for compressed payloads. (not for NONE and Pattern_V1)
+func (p CompressionPayloadHeaderCodec) OriginalPayloadSize() uint32 {
+	return le.Uint32(p[8:12])
+}
+
+func (p CompressionPayloadHeaderCodec) SetOriginalPayloadSize(u uint32) {
+	le.PutUint32(p[8:12], u)
+}
+
+type PatternPayloadV1Decoder []byte
+
+func (p PatternPayloadV1Decoder) IsInvalid() bool {
+	return len(p) < 8
+}
+
+func (p PatternPayloadV1Decoder) Pattern() uint8 {
+	return p[0]
+}
+
+func (p PatternPayloadV1Decoder) Repetitions() uint32 {
+	return le.Uint32(p[4:8])
+}
@@ -434,6 +434,7 @@ type CreateRequest struct {
 	CreateDisposition    uint32
 	CreateOptions        uint32
 	Name                 string
+	FileIdName           []byte // 8-byte file id sent instead of Name with FILE_OPEN_BY_FILE_ID
 
 	Contexts []Encoder
 }
@@ -442,12 +443,19 @@ func (c *CreateRequest) Header() *PacketHeader {
 	return &c.PacketHeader
 }
 
+func (c *CreateRequest) nameLen() int {
+	if c.FileIdName != nil {
+		return len(c.FileIdName)
+	}
+	return utf16le.EncodedStringLen(c.Name)
+}
+
 func (c *CreateRequest) Size() int {
-	if len(c.Name) == 0 && len(c.Contexts) == 0 {
+	if c.nameLen() == 0 && len(c.Contexts) == 0 {
 		return 64 + 56 + 1
 	}
 
-	size := 64 + 56 + utf16le.EncodedStringLen(c.Name)
+	size := 64 + 56 + c.nameLen()
 
 	for _, ctx := range c.Contexts {
 		size = Roundup(size, 8)
@@ -474,7 +482,12 @@ func (c *CreateRequest) Encode(pkt []byte) {
 	le.PutUint32(req[40:44], c.CreateOptions)
 
 	// Name
-	nlen := utf16le.EncodeString(req[56:], c.Name)
+	var nlen int
+	if c.FileIdName != nil {
+		nlen = copy(req[56:], c.FileIdName)
+	} else {
+		nlen = utf16le.EncodeString(req[56:], c.Name)
+	}
 
 	le.PutUint16(req[44:46], 56+64)
 	le.PutUint16(req[46:48], uint16(nlen))
@@ -482,7 +495,7 @@ func (c *CreateRequest) Encode(pkt []byte) {
 	off := 56 + nlen
 
 	var ctx []byte
-	var next int
+	var prev int
 
 	for i, c := range c.Contexts {
 		off = Roundup(off, 8)
@@ -490,16 +503,16 @@ func (c *CreateRequest) Encode(pkt []byte) {
 		if i == 0 {
 			le.PutUint32(req[48:52], uint32(64+off)) // CreateContextsOffset
 		} else {
-			le.PutUint32(ctx[:4], uint32(next)) // Next
+			le.PutUint32(ctx[:4], uint32(off-prev)) // Next, including the padding of the previous context
 		}
 
 		ctx = req[off:]
 
 		c.Encode(ctx)
 
-		next = c.Size()
+		prev = off
 
-		off += next
+		off += c.Size()
 	}
 
 	le.PutUint32(req[52:56], uint32(off-(56+nlen))) // CreateContextsLength
@@ -960,14 +973,157 @@ func (r WriteRequestDecoder) Flags() uint32 {
 // SMB2 OPLOCK_BREAK Acknowledgement
 //
 
+type OplockBreakAcknowledgement struct {
+	PacketHeader
+
+	OplockLevel uint8
+	FileId      *FileId
+}
+
+func (c *OplockBreakAcknowledgement) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *OplockBreakAcknowledgement) Size() int {
+	return 64 + 24
+}
+
+func (c *OplockBreakAcknowledgement) Encode(pkt []byte) {
+	c.Command = SMB2_OPLOCK_BREAK
+	c.encodeHeader(pkt)
+
+	req := pkt[64:]
+	le.PutUint16(req[:2], 24) // StructureSize
+	req[2] = c.OplockLevel
+	c.FileId.Encode(req[8:24])
+}
+
+// ----------------------------------------------------------------------------
+// SMB2 Lease Break Acknowledgement
+//
+
+type LeaseBreakAcknowledgement struct {
+	PacketHeader
+
+	Flags      uint32
+	LeaseKey   [16]byte
+	LeaseState uint32
+}
+
+func (c *LeaseBreakAcknowledgement) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *LeaseBreakAcknowledgement) Size() int {
+	return 64 + 36
+}
+
+func (c *LeaseBreakAcknowledgement) Encode(pkt []byte) {
+	c.Command = SMB2_OPLOCK_BREAK
+	c.encodeHeader(pkt)
+
+	req := pkt[64:]
+	le.PutUint16(req[:2], 36) // StructureSize
+	le.PutUint32(req[4:8], c.Flags)
+	copy(req[8:24], c.LeaseKey[:])
+	le.PutUint32(req[24:28], c.LeaseState)
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 LOCK Request Packet
 //
 
+type LockRequest struct {
+	PacketHeader
+
+	LockSequenceNumber uint8
+	LockSequenceIndex  uint32
+	FileId             *FileId
+	Locks              []*LockElement
+}
+
+func (c *LockRequest) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *LockRequest) Size() int {
+	return 64 + 24 + len(c.Locks)*24
+}
+
+func (c *LockRequest) Encode(pkt []byte) {
+	c.Command = SMB2_LOCK
+	c.encodeHeader(pkt)
+
+	req := pkt[64:]
+	le.PutUint16(req[:2], 48) // StructureSize
+	le.PutUint16(req[2:4], uint16(len(c.Locks)))
+	le.PutUint32(req[4:8], c.LockSequenceIndex<<4|uint32(c.LockSequenceNumber&0xf))
+	c.FileId.Encode(req[8:24])
+
+	off := 24
+	for i, l := range c.Locks {
+		l.Encode(req[off+i*24 : off+i*24+24])
+	}
+}
+
+type LockElement struct {
+	Offset uint64
+	Length uint64
+	Flags  uint32
+}
+
+func (c *LockElement) Size() int {
+	return 24
+}
+
+func (c *LockElement) Encode(p []byte) {
+	le.PutUint64(p[:8], c.Offset)
+	le.PutUint64(p[8:16], c.Length)
+	le.PutUint32(p[16:20], c.Flags)
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 ECHO Request Packet
 //
 
+type EchoRequest struct {
+	PacketHeader
+}
+
+func (c *EchoRequest) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *EchoRequest) Size() int {
+	return 64 + 4
+}
+
+func (c *EchoRequest) Encode(pkt []byte) {
+	c.Command = SMB2_ECHO
+	c.encodeHeader(pkt)
+
+	req := pkt[64:]
+	le.PutUint16(req[:2], 4) // StructureSize
+}
+
+type EchoRequestDecoder []byte
+
+func (r EchoRequestDecoder) IsInvalid() bool {
+	if len(r) < 4 {
+		return true
+	}
+
+	if r.StructureSize() != 4 {
+		return true
+	}
+
+	return false
+}
+
+func (r EchoRequestDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 CANCEL Request Packet
 //
@@ -1224,6 +1380,35 @@ func (r QueryDirectoryRequestDecoder) OutputBufferLength() uint32 {
 // SMB2 CHANGE_NOTIFY Request Packet
 //
 
+type ChangeNotifyRequest struct {
+	PacketHeader
+
+	Flags              uint16
+	OutputBufferLength uint32
+	FileId             *FileId
+	CompletionFilter   uint32
+}
+
+func (c *ChangeNotifyRequest) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *ChangeNotifyRequest) Size() int {
+	return 64 + 32
+}
+
+func (c *ChangeNotifyRequest) Encode(pkt []byte) {
+	c.Command = SMB2_CHANGE_NOTIFY
+	c.encodeHeader(pkt)
+
+	req := pkt[64:]
+	le.PutUint16(req[:2], 32) // StructureSize
+	le.PutUint16(req[2:4], c.Flags)
+	le.PutUint32(req[4:8], c.OutputBufferLength)
+	c.FileId.Encode(req[8:24])
+	le.PutUint32(req[24:28], c.CompletionFilter)
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 QUERY_INFO Request Packet
 //
@@ -1423,3 +1608,28 @@ func (r SetInfoRequestDecoder) AdditionalInformation() uint32 {
 func (r SetInfoRequestDecoder) FileId() FileIdDecoder {
 	return FileIdDecoder(r[16:32])
 }
+
+// ----------------------------------------------------------------------------
+// SMB2 Raw Request Packet
+//
+
+// RawRequest is a request whose body is encoded by the caller.
+type RawRequest struct {
+	PacketHeader
+
+	Body []byte
+}
+
+func (c *RawRequest) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *RawRequest) Size() int {
+	return 64 + len(c.Body)
+}
+
+func (c *RawRequest) Encode(pkt []byte) {
+	c.encodeHeader(pkt)
+
+	copy(pkt[64:], c.Body)
+}
@@ -801,7 +801,7 @@ func (c *CreateResponse) Encode(pkt []byte) {
 	off := 88
 
 	var ctx []byte
-	var next int
+	var prev int
 
 	for i, c := range c.Contexts {
 		off = Roundup(off, 8)
@@ -809,16 +809,16 @@ func (c *CreateResponse) Encode(pkt []byte) {
 		if i == 0 {
 			le.PutUint32(res[80:84], uint32(64+off)) // CreateContextsOffset
 		} else {
-			le.PutUint32(ctx[:4], uint32(next)) // Next
+			le.PutUint32(ctx[:4], uint32(off-prev)) // Next, including the padding of the previous context
 		}
 
 		ctx = res[off:]
 
 		c.Encode(ctx)
 
-		next = c.Size()
+		prev = off
 
-		off += next
+		off += c.Size()
 	}
 
 	le.PutUint32(res[84:88], uint32(off-88)) // CreateContextsLength
@@ -1079,7 +1079,7 @@ func (c *ReadResponse) Encode(pkt []byte) {
 
 	res := pkt[64:]
 	le.PutUint16(res[:2], 17) // StructureSize
-	res[2] = 16               // DataOffset
+	res[2] = 64 + 16          // DataOffset
 	copy(res[16:], c.Data)
 	le.PutUint32(res[4:8], uint32(len(c.Data))) // DataLength
 	le.PutUint32(res[8:12], c.DataRemaining)
@@ -1200,14 +1200,169 @@ func (r WriteResponseDecoder) WriteChannelInfoLength() uint16 {
 // SMB2 OPLOCK_BREAK Notification and Response
 //
 
+// OplockBreakDecoder decodes both the notification and the response to the acknowledgement.
+type OplockBreakDecoder []byte
+
+func (r OplockBreakDecoder) IsInvalid() bool {
+	if len(r) < 24 {
+		return true
+	}
+
+	if r.StructureSize() != 24 {
+		return true
+	}
+
+	return false
+}
+
+func (r OplockBreakDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
+func (r OplockBreakDecoder) OplockLevel() uint8 {
+	return r[2]
+}
+
+func (r OplockBreakDecoder) FileId() FileIdDecoder {
+	return FileIdDecoder(r[8:24])
+}
+
+// ----------------------------------------------------------------------------
+// SMB2 Lease Break Notification and Response
+//
+
+type LeaseBreakNotificationDecoder []byte
+
+func (r LeaseBreakNotificationDecoder) IsInvalid() bool {
+	if len(r) < 44 {
+		return true
+	}
+
+	if r.StructureSize() != 44 {
+		return true
+	}
+
+	return false
+}
+
+func (r LeaseBreakNotificationDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
+func (r LeaseBreakNotificationDecoder) NewEpoch() uint16 {
+	return le.Uint16(r[2:4])
+}
+
+func (r LeaseBreakNotificationDecoder) Flags() uint32 {
+	return le.Uint32(r[4:8])
+}
+
+func (r LeaseBreakNotificationDecoder) LeaseKey() []byte {
+	return r[8:24]
+}
+
+func (r LeaseBreakNotificationDecoder) CurrentLeaseState() uint32 {
+	return le.Uint32(r[24:28])
+}
+
+func (r LeaseBreakNotificationDecoder) NewLeaseState() uint32 {
+	return le.Uint32(r[28:32])
+}
+
+type LeaseBreakResponseDecoder []byte
+
+func (r LeaseBreakResponseDecoder) IsInvalid() bool {
+	if len(r) < 36 {
+		return true
+	}
+
+	if r.StructureSize() != 36 {
+		return true
+	}
+
+	return false
+}
+
+func (r LeaseBreakResponseDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
+func (r LeaseBreakResponseDecoder) Flags() uint32 {
+	return le.Uint32(r[4:8])
+}
+
+func (r LeaseBreakResponseDecoder) LeaseKey() []byte {
+	return r[8:24]
+}
+
+func (r LeaseBreakResponseDecoder) LeaseState() uint32 {
+	return le.Uint32(r[24:28])
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 LOCK Response
 //
 
+type LockResponseDecoder []byte
+
+func (r LockResponseDecoder) IsInvalid() bool {
+	if len(r) < 4 {
+		return true
+	}
+
+	if r.StructureSize() != 4 {
+		return true
+	}
+
+	return false
+}
+
+func (r LockResponseDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 ECHO Response
 //
 
+type EchoResponse struct {
+	PacketHeader
+}
+
+func (c *EchoResponse) Header() *PacketHeader {
+	return &c.PacketHeader
+}
+
+func (c *EchoResponse) Size() int {
+	return 64 + 4
+}
+
+func (c *EchoResponse) Encode(pkt []byte) {
+	c.Command = SMB2_ECHO
+	c.encodeHeader(pkt)
+
+	res := pkt[64:]
+	le.PutUint16(res[:2], 4) // StructureSize
+}
+
+type EchoResponseDecoder []byte
+
+func (r EchoResponseDecoder) IsInvalid() bool {
+	if len(r) < 4 {
+		return true
+	}
+
+	if r.StructureSize() != 4 {
+		return true
+	}
+
+	return false
+}
+
+func (r EchoResponseDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 IOCTL Response
 //
@@ -1425,6 +1580,48 @@ func (r QueryDirectoryResponseDecoder) OutputBuffer() []byte {
 // SMB2 CHANGE_NOTIFY Response
 //
 
+type ChangeNotifyResponseDecoder []byte
+
+func (r ChangeNotifyResponseDecoder) IsInvalid() bool {
+	if len(r) < 8 {
+		return true
+	}
+
+	if r.StructureSize() != 9 {
+		return true
+	}
+
+	if r.OutputBufferLength() > 0 {
+		off := int(r.OutputBufferOffset()) - 64
+		if off < 8 || len(r) < off+int(r.OutputBufferLength()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r ChangeNotifyResponseDecoder) StructureSize() uint16 {
+	return le.Uint16(r[:2])
+}
+
+func (r ChangeNotifyResponseDecoder) OutputBufferOffset() uint16 {
+	return le.Uint16(r[2:4])
+}
+
+func (r ChangeNotifyResponseDecoder) OutputBufferLength() uint32 {
+	return le.Uint32(r[4:8])
+}
+
+// OutputBuffer returns the list of FILE_NOTIFY_INFORMATION, which is empty if the changes didn't fit.
+func (r ChangeNotifyResponseDecoder) OutputBuffer() []byte {
+	if r.OutputBufferLength() == 0 {
+		return nil
+	}
+	off := int(r.OutputBufferOffset()) - 64
+	return r[off : off+int(r.OutputBufferLength())]
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 QUERY_INFO Response
 //
@@ -59,6 +59,258 @@ func (fd FileIdDecoder) Decode() *FileId {
 	return &ret
 }
 
+// ----------------------------------------------------------------------------
+// SMB2 CREATE Contexts
+//
+
+type CreateContext struct {
+	Name string // e.g. SMB2_CREATE_DURABLE_HANDLE_REQUEST_V2
+	Data Encoder
+}
+
+func (c *CreateContext) dataOffset() int {
+	return Roundup(16+len(c.Name), 8)
+}
+
+func (c *CreateContext) Size() int {
+	return c.dataOffset() + c.Data.Size()
+}
+
+func (c *CreateContext) Encode(p []byte) {
+	off := c.dataOffset()
+
+	le.PutUint16(p[4:6], 16)                      // NameOffset
+	le.PutUint16(p[6:8], uint16(len(c.Name)))     // NameLength
+	le.PutUint16(p[10:12], uint16(off))           // DataOffset
+	le.PutUint32(p[12:16], uint32(c.Data.Size())) // DataLength
+	copy(p[16:], c.Name)
+	c.Data.Encode(p[off:])
+}
+
+type CreateContextDecoder []byte
+
+func (c CreateContextDecoder) IsInvalid() bool {
+	if len(c) < 16 {
+		return true
+	}
+
+	if len(c) < int(c.NameOffset())+int(c.NameLength()) {
+		return true
+	}
+
+	if len(c) < int(c.DataOffset())+int(c.DataLength()) {
+		return true
+	}
+
+	return false
+}
+
+func (c CreateContextDecoder) Next() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c CreateContextDecoder) NameOffset() uint16 {
+	return le.Uint16(c[4:6])
+}
+
+func (c CreateContextDecoder) NameLength() uint16 {
+	return le.Uint16(c[6:8])
+}
+
+func (c CreateContextDecoder) DataOffset() uint16 {
+	return le.Uint16(c[10:12])
+}
+
+func (c CreateContextDecoder) DataLength() uint32 {
+	return le.Uint32(c[12:16])
+}
+
+func (c CreateContextDecoder) Name() string {
+	off := c.NameOffset()
+	return string(c[off : off+c.NameLength()])
+}
+
+func (c CreateContextDecoder) Data() []byte {
+	off := uint32(c.DataOffset())
+	return c[off : off+c.DataLength()]
+}
+
+type DurableHandleRequest struct {
+}
+
+func (c *DurableHandleRequest) Size() int {
+	return 16
+}
+
+func (c *DurableHandleRequest) Encode(p []byte) {
+	copy(p[:16], zero[:]) // Reserved
+}
+
+type DurableHandleReconnect struct {
+	FileId *FileId
+}
+
+func (c *DurableHandleReconnect) Size() int {
+	return 16
+}
+
+func (c *DurableHandleReconnect) Encode(p []byte) {
+	c.FileId.Encode(p[:16])
+}
+
+// From SMB300
+
+type DurableHandleRequestV2 struct {
+	Timeout    uint32 // milliseconds
+	Flags      uint32
+	CreateGuid [16]byte
+}
+
+func (c *DurableHandleRequestV2) Size() int {
+	return 32
+}
+
+func (c *DurableHandleRequestV2) Encode(p []byte) {
+	le.PutUint32(p[:4], c.Timeout)
+	le.PutUint32(p[4:8], c.Flags)
+	copy(p[16:32], c.CreateGuid[:])
+}
+
+// From SMB300
+
+type DurableHandleReconnectV2 struct {
+	FileId     *FileId
+	CreateGuid [16]byte
+	Flags      uint32
+}
+
+func (c *DurableHandleReconnectV2) Size() int {
+	return 36
+}
+
+func (c *DurableHandleReconnectV2) Encode(p []byte) {
+	c.FileId.Encode(p[:16])
+	copy(p[16:32], c.CreateGuid[:])
+	le.PutUint32(p[32:36], c.Flags)
+}
+
+// From SMB300
+
+type AppInstanceId struct {
+	AppInstanceId [16]byte
+}
+
+func (c *AppInstanceId) Size() int {
+	return 20
+}
+
+func (c *AppInstanceId) Encode(p []byte) {
+	le.PutUint16(p[:2], 20) // StructureSize
+	copy(p[4:20], c.AppInstanceId[:])
+}
+
+// From SMB311
+
+type AppInstanceVersion struct {
+	AppInstanceVersionHigh uint64
+	AppInstanceVersionLow  uint64
+}
+
+func (c *AppInstanceVersion) Size() int {
+	return 24
+}
+
+func (c *AppInstanceVersion) Encode(p []byte) {
+	le.PutUint16(p[:2], 24) // StructureSize
+	le.PutUint64(p[8:16], c.AppInstanceVersionHigh)
+	le.PutUint64(p[16:24], c.AppInstanceVersionLow)
+}
+
+// From SMB300
+
+type DurableHandleResponseV2Decoder []byte
+
+func (c DurableHandleResponseV2Decoder) IsInvalid() bool {
+	return len(c) < 8
+}
+
+func (c DurableHandleResponseV2Decoder) Timeout() uint32 {
+	return le.Uint32(c[:4])
+}
+
+func (c DurableHandleResponseV2Decoder) Flags() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+// From SMB210
+
+type LeaseRequest struct {
+	LeaseKey   [16]byte
+	LeaseState uint32
+}
+
+func (c *LeaseRequest) Size() int {
+	return 32
+}
+
+func (c *LeaseRequest) Encode(p []byte) {
+	copy(p[:16], c.LeaseKey[:])
+	le.PutUint32(p[16:20], c.LeaseState)
+	le.PutUint32(p[20:24], 0) // LeaseFlags
+	le.PutUint64(p[24:32], 0) // LeaseDuration
+}
+
+// From SMB300
+
+type LeaseRequestV2 struct {
+	LeaseKey       [16]byte
+	LeaseState     uint32
+	Flags          uint32
+	ParentLeaseKey [16]byte
+	Epoch          uint16
+}
+
+func (c *LeaseRequestV2) Size() int {
+	return 52
+}
+
+func (c *LeaseRequestV2) Encode(p []byte) {
+	copy(p[:16], c.LeaseKey[:])
+	le.PutUint32(p[16:20], c.LeaseState)
+	le.PutUint32(p[20:24], c.Flags)
+	le.PutUint64(p[24:32], 0) // LeaseDuration
+	copy(p[32:48], c.ParentLeaseKey[:])
+	le.PutUint16(p[48:50], c.Epoch)
+	le.PutUint16(p[50:52], 0) // Reserved
+}
+
+// LeaseResponseDecoder decodes both SMB2_CREATE_RESPONSE_LEASE and SMB2_CREATE_RESPONSE_LEASE_V2.
+type LeaseResponseDecoder []byte
+
+func (c LeaseResponseDecoder) IsInvalid() bool {
+	return len(c) < 32
+}
+
+func (c LeaseResponseDecoder) LeaseKey() []byte {
+	return c[:16]
+}
+
+func (c LeaseResponseDecoder) LeaseState() uint32 {
+	return le.Uint32(c[16:20])
+}
+
+func (c LeaseResponseDecoder) Flags() uint32 {
+	return le.Uint32(c[20:24])
+}
+
+// Epoch returns the epoch of a V2 response, or 0.
+func (c LeaseResponseDecoder) Epoch() uint16 {
+	if len(c) < 52 {
+		return 0
+	}
+	return le.Uint16(c[48:50])
+}
+
 // ----------------------------------------------------------------------------
 // SMB2 NEGOTIATE Contexts
 //
@@ -124,6 +376,59 @@ func (c *CipherContext) Encode(p []byte) {
 	}
 }
 
+type SigningContext struct {
+	SigningAlgorithms []uint16
+}
+
+func (c *SigningContext) Size() int {
+	return 8 + 2 + len(c.SigningAlgorithms)*2
+}
+
+func (c *SigningContext) Encode(p []byte) {
+	le.PutUint16(p[:2], SMB2_SIGNING_CAPABILITIES)             // ContextType
+	le.PutUint16(p[2:4], uint16(2+len(c.SigningAlgorithms)*2)) // DataLength
+
+	{
+		d := NegotiateContextDecoder(p).Data()
+
+		{ // SigningAlgorithms
+			bs := d[2:]
+			for i, alg := range c.SigningAlgorithms {
+				le.PutUint16(bs[2*i:2*i+2], alg)
+			}
+			le.PutUint16(d[:2], uint16(len(c.SigningAlgorithms))) // SigningAlgorithmCount
+		}
+	}
+}
+
+type CompressionContext struct {
+	Flags                 uint32
+	CompressionAlgorithms []uint16
+}
+
+func (c *CompressionContext) Size() int {
+	return 8 + 8 + len(c.CompressionAlgorithms)*2
+}
+
+func (c *CompressionContext) Encode(p []byte) {
+	le.PutUint16(p[:2], SMB2_COMPRESSION_CAPABILITIES)             // ContextType
+	le.PutUint16(p[2:4], uint16(8+len(c.CompressionAlgorithms)*2)) // DataLength
+
+	{
+		d := NegotiateContextDecoder(p).Data()
+
+		le.PutUint16(d[:2], uint16(len(c.CompressionAlgorithms))) // CompressionAlgorithmCount
+		le.PutUint32(d[4:8], c.Flags)
+
+		{ // CompressionAlgorithms
+			bs := d[8:]
+			for i, alg := range c.CompressionAlgorithms {
+				le.PutUint16(bs[2*i:2*i+2], alg)
+			}
+		}
+	}
+}
+
 // From SMB311
 
 type NegotiateContextDecoder []byte
@@ -223,22 +528,81 @@ func (c CipherContextDataDecoder) Ciphers() []uint16 {
 	return cs
 }
 
+type SigningContextDataDecoder []byte
+
+func (c SigningContextDataDecoder) IsInvalid() bool {
+	if len(c) < 2 {
+		return true
+	}
+
+	if len(c) < 2+int(c.SigningAlgorithmCount())*2 {
+		return true
+	}
+
+	return false
+}
+
+func (c SigningContextDataDecoder) SigningAlgorithmCount() uint16 {
+	return le.Uint16(c[:2])
+}
+
+func (c SigningContextDataDecoder) SigningAlgorithms() []uint16 {
+	bs := c[2:]
+	algs := make([]uint16, c.SigningAlgorithmCount())
+	for i := range algs {
+		algs[i] = le.Uint16(bs[2*i : 2*i+2])
+	}
+	return algs
+}
+
+type CompressionContextDataDecoder []byte
+
+func (c CompressionContextDataDecoder) IsInvalid() bool {
+	if len(c) < 8 {
+		return true
+	}
+
+	if len(c) < 8+int(c.CompressionAlgorithmCount())*2 {
+		return true
+	}
+
+	return false
+}
+
+func (c CompressionContextDataDecoder) CompressionAlgorithmCount() uint16 {
+	return le.Uint16(c[:2])
+}
+
+func (c CompressionContextDataDecoder) Flags() uint32 {
+	return le.Uint32(c[4:8])
+}
+
+func (c CompressionContextDataDecoder) CompressionAlgorithms() []uint16 {
+	bs := c[8:]
+	algs := make([]uint16, c.CompressionAlgorithmCount())
+	for i := range algs {
+		algs[i] = le.Uint16(bs[2*i : 2*i+2])
+	}
+	return algs
+}
+
 type QueryQuotaInfo struct {
 	ReturnSingle bool
 	RestartScan  bool
-	Sids         []Sid
+	Sids         []*Sid
 }
 
 func (q *QueryQuotaInfo) Size() int {
-	if len(q.Sids) == 0 {
-		return 16
-	}
-	if len(q.Sids) == 1 {
-		return 16 + q.Sids[0].Size()
-	}
-	l := 16
-	for _, sid := range q.Sids {
-		l += 8 + sid.Size()
+	return 16 + q.sidListLength()
+}
+
+func (q *QueryQuotaInfo) sidListLength() int {
+	l := 0
+	for i, sid := range q.Sids {
+		if i > 0 {
+			l = Roundup(l, 8)
+		}
+		l += 8 + sid.Size() // FILE_GET_QUOTA_INFORMATION
 	}
 	return l
 }
@@ -246,26 +610,28 @@ func (q *QueryQuotaInfo) Size() int {
 func (q *QueryQuotaInfo) Encode(p []byte) {
 	if q.ReturnSingle {
 		p[0] = 1
+	} else {
+		p[0] = 0
 	}
 	if q.RestartScan {
 		p[1] = 1
+	} else {
+		p[1] = 0
 	}
-	if len(q.Sids) > 0 {
-		if len(q.Sids) == 1 {
-			sid := q.Sids[0]
-			sid.Encode(p[16:])
-			le.PutUint32(p[8:12], uint32(sid.Size()))
-			le.PutUint32(p[12:16], 0)
-		} else {
-			le.PutUint32(p[4:8], 1)
-			off := 16
-			for _, sid := range q.Sids {
-				size := sid.Size()
-				sid.Encode(p[off+8:])
-				le.PutUint32(p[off:off+4], uint32(off+size))
-				le.PutUint32(p[off+4:off+8], uint32(size))
-				off += 8 + size
-			}
+	le.PutUint32(p[4:8], uint32(q.sidListLength()))
+	le.PutUint32(p[8:12], 0)  // StartSidLength
+	le.PutUint32(p[12:16], 0) // StartSidOffset
+
+	off := 16
+	for i, sid := range q.Sids {
+		size := sid.Size()
+		next := 0
+		if i < len(q.Sids)-1 {
+			next = Roundup(8+size, 8)
 		}
+		le.PutUint32(p[off:off+4], uint32(next))
+		le.PutUint32(p[off+4:off+8], uint32(size))
+		sid.Encode(p[off+8:])
+		off += next
 	}
 }
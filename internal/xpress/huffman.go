@@ -0,0 +1,180 @@
+package xpress
+
+const (
+	huffmanSymbols   = 512
+	huffmanMaxLength = 15
+	huffmanBlockSize = 65536
+)
+
+// DecompressHuffman decompresses src in the LZ77+Huffman format and appends size bytes to dst. ([MS-XCA] 2.2.4)
+// Like Decompress, the window of matches includes dst.
+func DecompressHuffman(dst, src []byte, size int) ([]byte, error) {
+	end := len(dst) + size
+
+	var table [1 << huffmanMaxLength]uint16
+	var lengths [1 << huffmanMaxLength]uint8
+
+	for i := 0; len(dst) < end; {
+		// each block starts with the code lengths of the 512 symbols, packed in 4 bits each.
+		if len(src)-i < huffmanSymbols/2+4 {
+			return nil, ErrCorrupt
+		}
+
+		if err := buildDecodingTable(&table, &lengths, src[i:i+huffmanSymbols/2]); err != nil {
+			return nil, err
+		}
+
+		i += huffmanSymbols / 2
+
+		br := bitReader{src: src, pos: i}
+		br.init()
+
+		blockEnd := len(dst) + huffmanBlockSize
+		if blockEnd > end {
+			blockEnd = end
+		}
+
+		for len(dst) < blockEnd {
+			next15Bits := br.bits >> (32 - huffmanMaxLength)
+
+			symbol := int(table[next15Bits])
+
+			br.consume(uint(lengths[next15Bits]))
+
+			if symbol < 256 {
+				dst = append(dst, byte(symbol))
+				continue
+			}
+
+			symbol -= 256
+
+			length := symbol % 16
+			offsetBits := uint(symbol / 16)
+
+			if length == 15 {
+				b, err := br.readByte()
+				if err != nil {
+					return nil, err
+				}
+				length = int(b)
+
+				if length == 255 {
+					u, err := br.readUint16()
+					if err != nil {
+						return nil, err
+					}
+					length = int(u)
+
+					if length < 15 {
+						return nil, ErrCorrupt
+					}
+					length -= 15
+				}
+				length += 15
+			}
+			length += 3
+
+			offset := 1 << offsetBits
+			if offsetBits > 0 {
+				offset += int(br.bits >> (32 - offsetBits))
+			}
+
+			br.consume(offsetBits)
+
+			var err error
+			dst, err = copyMatch(dst, offset, length, end)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		i = br.pos
+	}
+
+	return dst, nil
+}
+
+// buildDecodingTable fills the table mapping the next 15 bits of the stream to a symbol and its code length
+// from the packed code lengths. Codes are assigned in order of length, then of symbol value.
+func buildDecodingTable(table *[1 << huffmanMaxLength]uint16, lengths *[1 << huffmanMaxLength]uint8, packed []byte) error {
+	entry := 0
+
+	for bitLength := 1; bitLength <= huffmanMaxLength; bitLength++ {
+		for symbol := 0; symbol < huffmanSymbols; symbol++ {
+			l := int(packed[symbol/2])
+			if symbol%2 == 0 {
+				l &= 0xf
+			} else {
+				l >>= 4
+			}
+
+			if l != bitLength {
+				continue
+			}
+
+			count := 1 << uint(huffmanMaxLength-bitLength)
+			if entry+count > len(table) {
+				return ErrCorrupt
+			}
+
+			for j := 0; j < count; j++ {
+				table[entry] = uint16(symbol)
+				lengths[entry] = uint8(bitLength)
+				entry++
+			}
+		}
+	}
+
+	if entry != len(table) {
+		return ErrCorrupt
+	}
+
+	return nil
+}
+
+// bitReader reads the bit stream of a block, which is made of 16-bit little-endian words
+// interleaved with the bytes of extended match lengths.
+type bitReader struct {
+	src   []byte
+	pos   int
+	bits  uint32 // next bits of the stream, most significant first
+	extra int    // number of bits available beyond the first 16 of bits
+}
+
+func (br *bitReader) init() {
+	br.bits = uint32(le.Uint16(br.src[br.pos:]))<<16 | uint32(le.Uint16(br.src[br.pos+2:]))
+	br.extra = 16
+	br.pos += 4
+}
+
+func (br *bitReader) consume(n uint) {
+	br.bits <<= n
+	br.extra -= int(n)
+
+	if br.extra < 0 {
+		// the decoder reads ahead of the last symbol, so the end of the input is padded with zeros.
+		if len(br.src)-br.pos >= 2 {
+			br.bits |= uint32(le.Uint16(br.src[br.pos:])) << uint(-br.extra)
+			br.pos += 2
+		}
+		br.extra += 16
+	}
+}
+
+func (br *bitReader) readByte() (byte, error) {
+	if br.pos >= len(br.src) {
+		return 0, ErrCorrupt
+	}
+	b := br.src[br.pos]
+	br.pos++
+	return b, nil
+}
+
+func (br *bitReader) readUint16() (uint16, error) {
+	if len(br.src)-br.pos < 2 {
+		return 0, ErrCorrupt
+	}
+	u := le.Uint16(br.src[br.pos:])
+	br.pos += 2
+	return u, nil
+}
@@ -0,0 +1,243 @@
+// Package xpress implements the Plain LZ77 and LZ77+Huffman compression formats. ([MS-XCA])
+package xpress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	le = binary.LittleEndian
+)
+
+var ErrCorrupt = errors.New("xpress: corrupt input")
+
+// Decompress decompresses src in the Plain LZ77 format and appends size bytes to dst. ([MS-XCA] 2.4.4)
+// The window of matches includes dst, so that a message can be decompressed after an uncompressed prefix.
+func Decompress(dst, src []byte, size int) ([]byte, error) {
+	base := len(dst)
+	end := base + size
+
+	var flags uint32
+	var flagCount uint
+
+	var lastLengthHalfByte int // position of the shared length nibble + 1, or 0 if none
+
+	for i := 0; len(dst) < end; {
+		if flagCount == 0 {
+			if len(src)-i < 4 {
+				return nil, ErrCorrupt
+			}
+			flags = le.Uint32(src[i:])
+			flagCount = 32
+			i += 4
+		}
+
+		flagCount--
+
+		if flags&(1<<flagCount) == 0 {
+			if i >= len(src) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[i])
+			i++
+			continue
+		}
+
+		if len(src)-i < 2 {
+			return nil, ErrCorrupt
+		}
+		matchBytes := int(le.Uint16(src[i:]))
+		i += 2
+
+		length := matchBytes % 8
+		offset := matchBytes/8 + 1
+
+		if length == 7 {
+			if lastLengthHalfByte == 0 {
+				if i >= len(src) {
+					return nil, ErrCorrupt
+				}
+				length = int(src[i] % 16)
+				lastLengthHalfByte = i + 1
+				i++
+			} else {
+				length = int(src[lastLengthHalfByte-1] / 16)
+				lastLengthHalfByte = 0
+			}
+
+			if length == 15 {
+				if i >= len(src) {
+					return nil, ErrCorrupt
+				}
+				length = int(src[i])
+				i++
+
+				if length == 255 {
+					if len(src)-i < 2 {
+						return nil, ErrCorrupt
+					}
+					length = int(le.Uint16(src[i:]))
+					i += 2
+
+					if length == 0 {
+						if len(src)-i < 4 {
+							return nil, ErrCorrupt
+						}
+						length = int(le.Uint32(src[i:]))
+						i += 4
+					}
+
+					if length < 15+7 {
+						return nil, ErrCorrupt
+					}
+					length -= 15 + 7
+				}
+				length += 15
+			}
+			length += 7
+		}
+		length += 3
+
+		var err error
+		dst, err = copyMatch(dst, offset, length, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dst, nil
+}
+
+// copyMatch appends length bytes copied from offset bytes back in dst.
+// The source and the destination may overlap.
+func copyMatch(dst []byte, offset, length, end int) ([]byte, error) {
+	if offset > len(dst) || length > end-len(dst) {
+		return nil, ErrCorrupt
+	}
+
+	for pos := len(dst) - offset; length > 0; length-- {
+		dst = append(dst, dst[pos])
+		pos++
+	}
+
+	return dst, nil
+}
+
+const (
+	minMatch  = 3
+	maxOffset = 8192
+	hashBits  = 14
+)
+
+// Compress compresses src in the Plain LZ77 format and appends the result to dst. ([MS-XCA] 2.3.4)
+func Compress(dst, src []byte) []byte {
+	var table [1 << hashBits]int32 // position of the last occurrence of a 3-byte sequence + 1
+
+	flagPos := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+
+	var flags uint32
+	var flagCount uint
+
+	lastLengthHalfByte := -1
+
+	for i := 0; i < len(src); {
+		length, offset := 0, 0
+
+		if len(src)-i >= minMatch {
+			h := (uint32(src[i]) | uint32(src[i+1])<<8 | uint32(src[i+2])<<16) * 2654435761 >> (32 - hashBits)
+
+			if j := int(table[h]) - 1; j >= 0 && i-j <= maxOffset {
+				for length < len(src)-i && src[j+length] == src[i+length] {
+					length++
+				}
+				offset = i - j
+			}
+
+			table[h] = int32(i + 1)
+		}
+
+		if length < minMatch {
+			dst = append(dst, src[i])
+			i++
+
+			flags <<= 1
+		} else {
+			// register the positions covered by the match so that later data can refer to them.
+			for k := i + 1; k < i+length && len(src)-k >= minMatch; k++ {
+				h := (uint32(src[k]) | uint32(src[k+1])<<8 | uint32(src[k+2])<<16) * 2654435761 >> (32 - hashBits)
+				table[h] = int32(k + 1)
+			}
+
+			i += length
+
+			dst, lastLengthHalfByte = appendMatch(dst, offset, length, lastLengthHalfByte)
+
+			flags = flags<<1 | 1
+		}
+
+		flagCount++
+
+		if flagCount == 32 {
+			le.PutUint32(dst[flagPos:], flags)
+			flagCount = 0
+			flagPos = len(dst)
+			dst = append(dst, 0, 0, 0, 0)
+		}
+	}
+
+	// the remaining flags are set, so that the decoder stops at the end of the input.
+	flags <<= 32 - flagCount
+	flags |= 1<<(32-flagCount) - 1
+	le.PutUint32(dst[flagPos:], flags)
+
+	return dst
+}
+
+func appendMatch(dst []byte, offset, length, lastLengthHalfByte int) ([]byte, int) {
+	length -= 3
+	token := (offset - 1) << 3
+
+	if length < 7 {
+		return append(dst, byte(token+length), byte((token+length)>>8)), lastLengthHalfByte
+	}
+
+	token |= 7
+	dst = append(dst, byte(token), byte(token>>8))
+
+	length -= 7
+
+	nibble := length
+	if nibble > 15 {
+		nibble = 15
+	}
+
+	if lastLengthHalfByte < 0 {
+		lastLengthHalfByte = len(dst)
+		dst = append(dst, byte(nibble))
+	} else {
+		dst[lastLengthHalfByte] |= byte(nibble << 4)
+		lastLengthHalfByte = -1
+	}
+
+	if length >= 15 {
+		length -= 15
+
+		if length < 255 {
+			dst = append(dst, byte(length))
+		} else {
+			dst = append(dst, 255)
+
+			length += 15 + 7
+
+			if length < 1<<16 {
+				dst = append(dst, byte(length), byte(length>>8))
+			} else {
+				dst = append(dst, 0, 0, byte(length), byte(length>>8), byte(length>>16), byte(length>>24))
+			}
+		}
+	}
+
+	return dst, lastLengthHalfByte
+}
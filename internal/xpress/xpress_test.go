@@ -0,0 +1,282 @@
+package xpress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func testInputs() [][]byte {
+	r := rand.New(rand.NewSource(1))
+
+	random := make([]byte, 100000)
+	r.Read(random)
+
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 3000)
+
+	mixed := make([]byte, 0, 200000)
+	for len(mixed) < 200000 {
+		if r.Intn(2) == 0 {
+			mixed = append(mixed, bytes.Repeat([]byte{byte(r.Intn(256))}, r.Intn(70000))...)
+		} else {
+			chunk := make([]byte, r.Intn(1000))
+			r.Read(chunk)
+			mixed = append(mixed, chunk...)
+		}
+	}
+
+	return [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("abcdefghijklmnopqrstuvwxyz"),
+		bytes.Repeat([]byte("abc"), 100),
+		make([]byte, 300000),
+		random,
+		text,
+		mixed,
+	}
+}
+
+func TestCompress(t *testing.T) {
+	// examples from [MS-XCA] 3.1
+	for _, tc := range []struct {
+		in  []byte
+		out []byte
+	}{
+		{
+			[]byte("abcdefghijklmnopqrstuvwxyz"),
+			append([]byte{0x3f, 0x00, 0x00, 0x00}, "abcdefghijklmnopqrstuvwxyz"...),
+		},
+		{
+			bytes.Repeat([]byte("abc"), 100),
+			[]byte{0xff, 0xff, 0xff, 0x1f, 0x61, 0x62, 0x63, 0x17, 0x00, 0x0f, 0xff, 0x26, 0x01},
+		},
+	} {
+		out := Compress(nil, tc.in)
+		if !bytes.Equal(out, tc.out) {
+			t.Errorf("expected % x, got % x", tc.out, out)
+		}
+
+		in, err := Decompress(nil, tc.out, len(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(in, tc.in) {
+			t.Errorf("expected %q, got %q", tc.in, in)
+		}
+	}
+
+	for _, in := range testInputs() {
+		out := Compress(nil, in)
+
+		ret, err := Decompress([]byte("prefix"), out, len(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ret, append([]byte("prefix"), in...)) {
+			t.Errorf("round trip failed for input of %d bytes", len(in))
+		}
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	out := Compress(nil, bytes.Repeat([]byte("abc"), 100))
+
+	for n := 0; n < len(out); n++ {
+		_, err := Decompress(nil, out[:n], 300)
+		if err == nil {
+			t.Errorf("expected error for truncated input of %d bytes", n)
+		}
+	}
+
+	// the first match refers to data before the start of the output.
+	_, err := Decompress(nil, []byte{0xff, 0xff, 0xff, 0xff, 0x08, 0x00}, 4)
+	if err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+// huffmanWriter encodes in the LZ77+Huffman format with fixed code lengths.
+// Words of the bit stream are reserved at the position where the decoder reads them,
+// so that the bytes of extended match lengths can be interleaved.
+type huffmanWriter struct {
+	out   []byte
+	codes [huffmanSymbols]uint32
+	lens  [huffmanSymbols]uint
+
+	slots    []int // positions of reserved words that are not filled yet
+	reserved int   // number of words reserved in the block
+	total    int   // number of bits written in the block
+	acc      uint32
+	n        uint // number of bits in acc
+}
+
+func newHuffmanWriter(lens [huffmanSymbols]uint) *huffmanWriter {
+	w := &huffmanWriter{lens: lens}
+
+	code := uint32(0)
+	for l := uint(1); l <= huffmanMaxLength; l++ {
+		for sym := 0; sym < huffmanSymbols; sym++ {
+			if lens[sym] == l {
+				w.codes[sym] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+
+	return w
+}
+
+func (w *huffmanWriter) startBlock() {
+	for sym := 0; sym < huffmanSymbols; sym += 2 {
+		w.out = append(w.out, byte(w.lens[sym]|w.lens[sym+1]<<4))
+	}
+
+	w.slots = nil
+	w.reserved = 0
+	w.total = 0
+	w.reserve()
+	w.reserve()
+}
+
+func (w *huffmanWriter) reserve() {
+	w.slots = append(w.slots, len(w.out))
+	w.out = append(w.out, 0, 0)
+	w.reserved++
+}
+
+func (w *huffmanWriter) writeBits(v uint32, n uint) {
+	w.acc = w.acc<<n | v
+	w.n += n
+	w.total += int(n)
+
+	for w.total > 16*(w.reserved-1) {
+		w.reserve()
+	}
+
+	for w.n >= 16 {
+		w.n -= 16
+		word := uint16(w.acc >> w.n)
+		w.out[w.slots[0]] = byte(word)
+		w.out[w.slots[0]+1] = byte(word >> 8)
+		w.slots = w.slots[1:]
+	}
+}
+
+func (w *huffmanWriter) endBlock() {
+	if w.n > 0 {
+		w.writeBits(0, 16-w.n)
+	}
+}
+
+func (w *huffmanWriter) symbol(sym int) {
+	w.writeBits(w.codes[sym], w.lens[sym])
+}
+
+func (w *huffmanWriter) match(offset, length int) {
+	offsetBits := uint(0)
+	for 1<<(offsetBits+1) <= offset {
+		offsetBits++
+	}
+
+	l := length - 3
+	if l > 15 {
+		l = 15
+	}
+
+	w.symbol(256 + int(offsetBits)*16 + l)
+
+	if length-3 >= 15 {
+		if length-3-15 < 255 {
+			w.out = append(w.out, byte(length-3-15))
+		} else {
+			w.out = append(w.out, 255, byte(length-3), byte((length-3)>>8))
+		}
+	}
+
+	w.writeBits(uint32(offset-1<<offsetBits), offsetBits)
+}
+
+func compressHuffman(in []byte, lens [huffmanSymbols]uint) []byte {
+	w := newHuffmanWriter(lens)
+
+	blockEnd := 0
+
+	for i := 0; i < len(in); {
+		if i >= blockEnd {
+			if i > 0 {
+				w.endBlock()
+			}
+			w.startBlock()
+			blockEnd = i + huffmanBlockSize
+		}
+
+		// naive search of the longest match in a small window.
+		bestOffset, bestLength := 0, 0
+		for offset := 1; offset <= 64 && offset <= i; offset++ {
+			length := 0
+			for i+length < len(in) && length < 65538 && in[i+length-offset] == in[i+length] {
+				length++
+			}
+			if length > bestLength {
+				bestOffset, bestLength = offset, length
+			}
+		}
+
+		if bestLength >= 3 {
+			w.match(bestOffset, bestLength)
+			i += bestLength
+		} else {
+			w.symbol(int(in[i]))
+			i++
+		}
+	}
+
+	if len(in) > 0 {
+		w.symbol(256) // EOF
+		w.endBlock()
+	}
+
+	return w.out
+}
+
+func TestDecompressHuffman(t *testing.T) {
+	var uniform, skewed [huffmanSymbols]uint
+	for sym := range uniform {
+		uniform[sym] = 9
+
+		switch {
+		case sym < 128:
+			skewed[sym] = 8
+		case sym < 256:
+			skewed[sym] = 9
+		default:
+			skewed[sym] = 10
+		}
+	}
+
+	for _, lens := range [][huffmanSymbols]uint{uniform, skewed} {
+		for _, in := range testInputs() {
+			if len(in) == 0 {
+				continue
+			}
+
+			out := compressHuffman(in, lens)
+
+			ret, err := DecompressHuffman([]byte("prefix"), out, len(in))
+			if err != nil {
+				t.Fatalf("input of %d bytes: %v", len(in), err)
+			}
+			if !bytes.Equal(ret, append([]byte("prefix"), in...)) {
+				t.Errorf("round trip failed for input of %d bytes", len(in))
+			}
+		}
+	}
+
+	// code lengths that don't form a complete prefix code.
+	_, err := DecompressHuffman(nil, make([]byte, 260), 1)
+	if err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
@@ -9,13 +9,20 @@ import (
 
 // KDF in Counter Mode with h = 256, r = 32, L = 128
 func kdf(ki, label, context []byte) []byte {
+	return kdfN(ki, label, context, 16)
+}
+
+// KDF in Counter Mode with h = 256, r = 32, L = 8 * n (n <= 32)
+func kdfN(ki, label, context []byte, n int) []byte {
 	h := hmac.New(sha256.New, ki)
 
+	l := uint32(8 * n)
+
 	h.Write([]byte{0x00, 0x00, 0x00, 0x01})
 	h.Write(label)
 	h.Write([]byte{0x00})
 	h.Write(context)
-	h.Write([]byte{0x00, 0x00, 0x00, 0x80})
+	h.Write([]byte{byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)})
 
-	return h.Sum(nil)[:16]
+	return h.Sum(nil)[:n]
 }
@@ -0,0 +1,280 @@
+package smb2
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// LeaseState is a set of the caching permissions granted by a lease. ([MS-SMB2] 2.2.13.2.8)
+type LeaseState uint32
+
+const (
+	LeaseRead   LeaseState = SMB2_LEASE_READ_CACHING   // data may be cached for reading
+	LeaseHandle LeaseState = SMB2_LEASE_HANDLE_CACHING // closes may be deferred
+	LeaseWrite  LeaseState = SMB2_LEASE_WRITE_CACHING  // writes may be cached
+)
+
+// String returns the permissions as "R", "W" and "H", e.g. "RWH", or "NONE".
+func (s LeaseState) String() string {
+	var b []byte
+	if s&LeaseRead != 0 {
+		b = append(b, 'R')
+	}
+	if s&LeaseWrite != 0 {
+		b = append(b, 'W')
+	}
+	if s&LeaseHandle != 0 {
+		b = append(b, 'H')
+	}
+	if b == nil {
+		return "NONE"
+	}
+	return string(b)
+}
+
+// LeaseBreak notifies that the server breaks the lease of a file to a lower state,
+// typically because another client opens the file.
+// Before calling Acknowledge, the application must write cached data back if New lacks LeaseWrite,
+// and stop using cached data if New lacks LeaseRead.
+type LeaseBreak struct {
+	Current LeaseState
+	New     LeaseState
+
+	ack func()
+}
+
+// Acknowledge lets the client acknowledge the break to the server.
+// The other client waits until then, so it should be called as soon as possible.
+// Calling it more than once has no effect.
+func (b LeaseBreak) Acknowledge() {
+	if b.ack != nil {
+		b.ack()
+	}
+}
+
+// lease is the state of the lease of a file. See Dialer.RequestLeases.
+type lease struct {
+	key    [16]byte
+	state  LeaseState
+	epoch  uint16
+	breaks chan LeaseBreak // created by LeaseBreaks
+}
+
+// requestLease adds the lease request context to req if leasing was negotiated.
+// The response is examined by grantLease.
+func (fs *Share) requestLease(req *CreateRequest) error {
+	if fs.capabilities&SMB2_GLOBAL_CAP_LEASING == 0 {
+		return nil
+	}
+
+	var key [16]byte
+
+	_, err := rand.Read(key[:])
+	if err != nil {
+		return &InternalError{err.Error()}
+	}
+
+	state := uint32(SMB2_LEASE_READ_CACHING | SMB2_LEASE_WRITE_CACHING | SMB2_LEASE_HANDLE_CACHING)
+
+	if fs.dialect >= SMB300 {
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_REQUEST_LEASE_V2,
+			Data: &LeaseRequestV2{
+				LeaseKey:   key,
+				LeaseState: state,
+			},
+		})
+	} else {
+		req.Contexts = append(req.Contexts, &CreateContext{
+			Name: SMB2_CREATE_REQUEST_LEASE,
+			Data: &LeaseRequest{
+				LeaseKey:   key,
+				LeaseState: state,
+			},
+		})
+	}
+
+	req.RequestedOplockLevel = SMB2_OPLOCK_LEVEL_LEASE
+
+	return nil
+}
+
+// grantLease returns the lease granted to the handle opened by req, or nil.
+func grantLease(r CreateResponseDecoder, req *CreateRequest) *lease {
+	if r.OplockLevel() != SMB2_OPLOCK_LEVEL_LEASE {
+		return nil
+	}
+
+	for cs := r.CreateContexts(); len(cs) > 0; {
+		c := CreateContextDecoder(cs)
+		if c.IsInvalid() {
+			return nil
+		}
+
+		if c.Name() == SMB2_CREATE_REQUEST_LEASE {
+			res := LeaseResponseDecoder(c.Data())
+			if res.IsInvalid() || res.LeaseState() == SMB2_LEASE_NONE {
+				return nil
+			}
+
+			l := &lease{
+				state: LeaseState(res.LeaseState()),
+				epoch: res.Epoch(),
+			}
+			copy(l.key[:], res.LeaseKey())
+
+			return l
+		}
+
+		next := c.Next()
+		if next == 0 || int(next) > len(cs) {
+			break
+		}
+		cs = cs[next:]
+	}
+
+	return nil
+}
+
+// LeaseState returns the caching permissions currently granted by the lease of the file,
+// or 0 if the file has no lease. See Dialer.RequestLeases.
+func (f *File) LeaseState() LeaseState {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.lease == nil {
+		return 0
+	}
+	return f.lease.state
+}
+
+// LeaseBreaks returns the channel on which the breaks of the lease of the file are delivered,
+// or nil if the file has no lease. Each break must be acknowledged by LeaseBreak.Acknowledge.
+// A break that isn't received or acknowledged within clientLeaseBreakTimeout is acknowledged automatically.
+// Until LeaseBreaks is called, breaks are acknowledged as soon as they arrive.
+func (f *File) LeaseBreaks() <-chan LeaseBreak {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.lease == nil {
+		return nil
+	}
+	if f.lease.breaks == nil {
+		f.lease.breaks = make(chan LeaseBreak)
+	}
+	return f.lease.breaks
+}
+
+// handleLeaseBreak starts breaking the lease of a notification. ([MS-SMB2] 3.2.5.19.2)
+// It's called by the receiver, so the break is delivered and acknowledged by another goroutine.
+func (conn *conn) handleLeaseBreak(r LeaseBreakNotificationDecoder) error {
+	s := conn.session
+	if s == nil || s.oplocks == nil {
+		return &InvalidResponseError{"unexpected lease break notification"}
+	}
+
+	var key [16]byte
+	copy(key[:], r.LeaseKey())
+
+	b := &OplockBreak{LeaseKey: key, Lease: true, NewState: r.NewLeaseState()}
+
+	f := s.oplocks.getLease(key)
+	if f == nil {
+		go func() {
+			if !s.oplocks.notify(b) {
+				s.log().Warn("skip", "err", &InvalidResponseError{"lease break notification for unknown lease"})
+			}
+		}()
+
+		return nil
+	}
+
+	b.File = f
+
+	go f.breakLease(LeaseState(r.CurrentLeaseState()), LeaseState(r.NewLeaseState()), r.NewEpoch(), r.Flags()&SMB2_NOTIFY_BREAK_LEASE_FLAG_ACK_REQUIRED != 0, b)
+
+	return nil
+}
+
+func (f *File) breakLease(current, next LeaseState, epoch uint16, ackRequired bool, b *OplockBreak) {
+	f.m.Lock()
+	l := f.lease
+	fs := f.fs
+	var breaks chan LeaseBreak
+	if l != nil {
+		breaks = l.breaks
+	}
+	f.m.Unlock()
+
+	if l == nil {
+		return
+	}
+
+	if b != nil {
+		fs.oplocks.notify(b)
+	}
+
+	if breaks != nil {
+		done := make(chan struct{})
+
+		var once sync.Once
+
+		lb := LeaseBreak{
+			Current: current,
+			New:     next,
+			ack:     func() { once.Do(func() { close(done) }) },
+		}
+
+		timer := time.NewTimer(clientLeaseBreakTimeout)
+
+		select {
+		case breaks <- lb:
+			select {
+			case <-done:
+			case <-timer.C:
+			}
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+
+	f.m.Lock()
+	fd := f.fd
+	fs = f.fs
+	l.state = next
+	l.epoch = epoch
+	f.m.Unlock()
+
+	if !ackRequired || fd == nil {
+		return
+	}
+
+	req := &LeaseBreakAcknowledgement{
+		LeaseKey:   l.key,
+		LeaseState: uint32(next),
+	}
+
+	req.CreditCharge = 1
+
+	res, err := fs.sendRecv(SMB2_OPLOCK_BREAK, req)
+	if err != nil {
+		fs.log().Warn("lease break failed", "err", err)
+
+		return
+	}
+
+	r := LeaseBreakResponseDecoder(res)
+	if r.IsInvalid() {
+		fs.log().Warn("lease break failed", "err", &InvalidResponseError{"broken lease break response format"})
+
+		return
+	}
+
+	f.m.Lock()
+	l.state = LeaseState(r.LeaseState())
+	f.m.Unlock()
+}
@@ -0,0 +1,139 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestGrantLease(t *testing.T) {
+	key := [16]byte{1, 2, 3}
+
+	r := encodeCreateResponse(
+		&CreateContext{Name: "MxAc", Data: &DurableHandleReconnectV2{}},
+		&CreateContext{Name: SMB2_CREATE_REQUEST_LEASE_V2, Data: &LeaseRequestV2{
+			LeaseKey:   key,
+			LeaseState: SMB2_LEASE_READ_CACHING | SMB2_LEASE_HANDLE_CACHING,
+			Epoch:      1,
+		}},
+	)
+
+	// not granted without the lease oplock level.
+	if l := grantLease(r, &CreateRequest{}); l != nil {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+
+	r[2] = SMB2_OPLOCK_LEVEL_LEASE
+
+	l := grantLease(r, &CreateRequest{})
+	if l == nil {
+		t.Fatal("expected lease")
+	}
+	if l.key != key || l.state != LeaseRead|LeaseHandle || l.epoch != 1 {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+	if l.state.String() != "RH" {
+		t.Errorf("unexpected lease state: %v", l.state)
+	}
+
+	// lease v1.
+	r = encodeCreateResponse(&CreateContext{Name: SMB2_CREATE_REQUEST_LEASE, Data: &LeaseRequest{
+		LeaseKey:   key,
+		LeaseState: SMB2_LEASE_READ_CACHING | SMB2_LEASE_WRITE_CACHING | SMB2_LEASE_HANDLE_CACHING,
+	}})
+	r[2] = SMB2_OPLOCK_LEVEL_LEASE
+
+	l = grantLease(r, &CreateRequest{})
+	if l == nil || l.key != key || l.state.String() != "RWH" || l.epoch != 0 {
+		t.Errorf("unexpected lease: %+v", l)
+	}
+}
+
+func TestLeaseBreak(t *testing.T) {
+	f := &File{lease: &lease{state: LeaseRead | LeaseWrite | LeaseHandle}}
+
+	breaks := f.LeaseBreaks()
+
+	// the file is closed, so the break isn't acknowledged to the server.
+	go f.breakLease(LeaseRead|LeaseWrite|LeaseHandle, LeaseRead, 2, true, nil)
+
+	b := <-breaks
+	if b.Current != LeaseRead|LeaseWrite|LeaseHandle || b.New != LeaseRead {
+		t.Errorf("unexpected lease break: %+v", b)
+	}
+	if f.LeaseState() != LeaseRead|LeaseWrite|LeaseHandle {
+		t.Errorf("lease state changed before the acknowledgement: %v", f.LeaseState())
+	}
+
+	b.Acknowledge()
+	b.Acknowledge()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.LeaseState() != LeaseRead {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected lease state: %v", f.LeaseState())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if (&File{}).LeaseBreaks() != nil || (&File{}).LeaseState() != 0 {
+		t.Error("unexpected lease of a file without lease")
+	}
+}
+
+func TestOnOplockBreak(t *testing.T) {
+	c := &conn{}
+	s := &session{conn: c, oplocks: newOplockTable()}
+	c.session = s
+
+	breaks := make(chan *OplockBreak, 1)
+
+	(&Session{s: s}).OnOplockBreak(func(b *OplockBreak) { breaks <- b })
+
+	recv := func() *OplockBreak {
+		select {
+		case b := <-breaks:
+			return b
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler not called")
+		}
+		return nil
+	}
+
+	// an oplock break of a handle unknown to the client.
+	ack := &OplockBreakAcknowledgement{
+		OplockLevel: SMB2_OPLOCK_LEVEL_II,
+		FileId:      &FileId{Persistent: [8]byte{1}, Volatile: [8]byte{2}},
+	}
+	pkt := make([]byte, ack.Size())
+	ack.Encode(pkt)
+
+	if err := c.handleOplockBreak(pkt); err != nil {
+		t.Fatal(err)
+	}
+
+	b := recv()
+	if b.File != nil || b.Lease || b.FileId != [16]byte{0: 1, 8: 2} || b.NewState != SMB2_OPLOCK_LEVEL_II {
+		t.Errorf("unexpected oplock break: %+v", b)
+	}
+
+	// a lease break of an unknown lease.
+	pkt = make([]byte, 64+44)
+	le := binary.LittleEndian
+	le.PutUint16(pkt[64:66], 44) // StructureSize
+	le.PutUint32(pkt[68:72], SMB2_NOTIFY_BREAK_LEASE_FLAG_ACK_REQUIRED)
+	pkt[72] = 7 // LeaseKey
+	le.PutUint32(pkt[88:92], SMB2_LEASE_READ_CACHING|SMB2_LEASE_HANDLE_CACHING)
+	le.PutUint32(pkt[92:96], SMB2_LEASE_READ_CACHING)
+
+	if err := c.handleOplockBreak(pkt); err != nil {
+		t.Fatal(err)
+	}
+
+	b = recv()
+	if b.File != nil || !b.Lease || b.LeaseKey != [16]byte{7} || LeaseState(b.NewState) != LeaseRead {
+		t.Errorf("unexpected lease break: %+v", b)
+	}
+}
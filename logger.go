@@ -0,0 +1,69 @@
+package smb2
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Logger receives the diagnostic messages of a connection, such as packets skipped by the receiver
+// and failures of requests sent in the background like oplock break acknowledgements and ECHO.
+// The arguments are alternating keys and values, e.g. "err", err, in the style of log/slog,
+// so that a *slog.Logger can be used as is.
+type Logger interface {
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// defaultLogger is used if Dialer.Logger is nil.
+// It discards the messages unless the DEBUG environment variable is set.
+var defaultLogger Logger
+
+func init() {
+	if debug {
+		defaultLogger = &stdLogger{log.New(os.Stderr, "smb2: ", log.LstdFlags)}
+	} else {
+		defaultLogger = nopLogger{}
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// stdLogger writes the messages to a *log.Logger, as "msg key=value ...".
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (l *stdLogger) Warn(msg string, args ...interface{}) {
+	l.l.Println(formatLog("warn", msg, args))
+}
+
+func (l *stdLogger) Error(msg string, args ...interface{}) {
+	l.l.Println(formatLog("error", msg, args))
+}
+
+func formatLog(level, msg string, args []interface{}) string {
+	var b strings.Builder
+
+	b.WriteString(level)
+	b.WriteString(": ")
+	b.WriteString(msg)
+
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+
+	return b.String()
+}
+
+// log returns the logger of conn.
+func (conn *conn) log() Logger {
+	if conn.logger == nil {
+		return defaultLogger
+	}
+	return conn.logger
+}
@@ -0,0 +1,30 @@
+package smb2
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := &stdLogger{log.New(&buf, "", 0)}
+
+	c := &conn{logger: l}
+	if c.log() != l {
+		t.Error("expected the logger of the connection")
+	}
+	if c := new(conn); c.log() != defaultLogger {
+		t.Error("expected the default logger")
+	}
+
+	c.log().Warn("skip", "err", errors.New("unknown tree id"))
+	c.log().Error("receiver stopped", "err", errors.New("EOF"), "dangling")
+
+	expected := "warn: skip err=unknown tree id\nerror: receiver stopped err=EOF\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
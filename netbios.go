@@ -0,0 +1,210 @@
+package smb2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// NetBIOS session service packet types. (RFC 1002 4.3.1)
+const (
+	nbssSessionMessage          = 0x00
+	nbssSessionRequest          = 0x81
+	nbssPositiveSessionResponse = 0x82
+	nbssNegativeSessionResponse = 0x83
+	nbssRetargetSessionResponse = 0x84
+	nbssSessionKeepAlive        = 0x85
+)
+
+// netBIOSPort is the port of the NetBIOS session service.
+const netBIOSPort = 139
+
+// defaultNetBIOSName is the called name accepted by most servers, whatever their actual name is.
+const defaultNetBIOSName = "*SMBSERVER"
+
+// defaultNetBIOSCallingName is the calling name of the client if it has no name. (See Dialer.ClientName)
+const defaultNetBIOSCallingName = "SMB2CLIENT"
+
+// netBIOS is the transport of the NetBIOS session service. Once the session is established,
+// SMB2 messages are framed by session messages, which have the same header as direct TCP.
+// Windows and Samba accept 24-bit lengths for SMB2, rather than the 17-bit lengths of RFC 1002.
+// The server may send keep-alive messages in between, which are skipped.
+type netBIOS struct {
+	directTCP
+}
+
+func netBIOSSession(tcpConn net.Conn) transport {
+	return &netBIOS{directTCP{conn: tcpConn}}
+}
+
+func (t *netBIOS) ReadSize() (size int, err error) {
+	for {
+		bs, err := t.readHeader()
+		if err != nil {
+			return -1, err
+		}
+
+		switch bs[0] {
+		case nbssSessionMessage:
+			return int(be.Uint32(bs)), nil
+		case nbssSessionKeepAlive:
+			// do nothing
+		default:
+			return -1, errors.New("invalid transport format")
+		}
+	}
+}
+
+// useNetBIOS reports whether the session service is requested on tcpConn, which is the case
+// if Dialer.NetBIOS is set or tcpConn is connected to port 139.
+func (d *Dialer) useNetBIOS(tcpConn net.Conn) bool {
+	if d.NetBIOS {
+		return true
+	}
+	addr, ok := tcpConn.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.Port == netBIOSPort
+}
+
+// netBIOSNames returns the called name of the server and the calling name of the client.
+func (d *Dialer) netBIOSNames() (called, calling string) {
+	called = d.NetBIOSName
+	if called == "" {
+		called = defaultNetBIOSName
+	}
+
+	calling = d.clientName()
+	if calling == "" {
+		calling = defaultNetBIOSCallingName
+	}
+
+	return called, calling
+}
+
+// requestNetBIOSSession sends a SESSION REQUEST from calling to called on tcpConn
+// and waits for the positive response of the server. (RFC 1002 4.3.2)
+// The deadline of tcpConn is only changed if ctx has a deadline or is done during the request.
+// It's cleared afterwards, since net.Conn can't tell the previous one. (See Dialer.NetBIOS)
+func requestNetBIOSSession(tcpConn net.Conn, called, calling string, ctx context.Context) (err error) {
+	// changed is set once the deadline of tcpConn has been changed.
+	var changed bool
+
+	if deadline, ok := ctx.Deadline(); ok {
+		tcpConn.SetDeadline(deadline)
+		changed = true
+	}
+
+	// deferred first, so that it runs once the watcher below has exited.
+	defer func() {
+		if changed {
+			tcpConn.SetDeadline(time.Time{})
+		}
+
+		if err != nil && ctx.Err() != nil {
+			err = &ContextError{Err: ctx.Err()}
+		}
+	}()
+
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			select {
+			case <-ctx.Done():
+				// unblock the read or the write.
+				tcpConn.SetDeadline(time.Unix(1, 0))
+				changed = true
+			case <-stop:
+			}
+		}()
+
+		defer func() {
+			close(stop)
+
+			// the deadline must not be changed by the watcher once it's been cleared.
+			<-done
+		}()
+	}
+
+	req := make([]byte, 4+2*34)
+	req[0] = nbssSessionRequest
+	be.PutUint16(req[2:4], uint16(len(req)-4))
+	encodeNetBIOSName(req[4:38], called, 0x20)   // file server service
+	encodeNetBIOSName(req[38:72], calling, 0x00) // workstation service
+
+	if _, err := tcpConn.Write(req); err != nil {
+		return &TransportError{err}
+	}
+
+	var hdr [4]byte
+
+	if _, err := io.ReadFull(tcpConn, hdr[:]); err != nil {
+		return &TransportError{err}
+	}
+
+	length := int(hdr[1]&1)<<16 | int(be.Uint16(hdr[2:4]))
+
+	switch hdr[0] {
+	case nbssPositiveSessionResponse:
+		if length != 0 {
+			return &InvalidResponseError{"broken netbios session response format"}
+		}
+		return nil
+	case nbssNegativeSessionResponse:
+		if length != 1 {
+			return &InvalidResponseError{"broken netbios session response format"}
+		}
+
+		var code [1]byte
+
+		if _, err := io.ReadFull(tcpConn, code[:]); err != nil {
+			return &TransportError{err}
+		}
+
+		return &NetBIOSSessionError{Code: code[0]}
+	case nbssRetargetSessionResponse:
+		if length != 6 {
+			return &InvalidResponseError{"broken netbios session response format"}
+		}
+
+		var addr [6]byte
+
+		if _, err := io.ReadFull(tcpConn, addr[:]); err != nil {
+			return &TransportError{err}
+		}
+
+		return &InvalidResponseError{fmt.Sprintf("netbios session retargeted to %v:%d, which is not supported", net.IP(addr[:4]), be.Uint16(addr[4:]))}
+	default:
+		return &InvalidResponseError{fmt.Sprintf("unexpected netbios session response type: %#x", hdr[0])}
+	}
+}
+
+// encodeNetBIOSName encodes name with the suffix into the 34 bytes of p with the first level encoding,
+// which splits each byte of the name padded with spaces into two letters, and no scope. (RFC 1001 14.1)
+func encodeNetBIOSName(p []byte, name string, suffix byte) {
+	var bs [16]byte
+
+	name = strings.ToUpper(name)
+	if len(name) > 15 {
+		name = name[:15]
+	}
+
+	copy(bs[:], name)
+	for i := len(name); i < 15; i++ {
+		bs[i] = ' '
+	}
+	bs[15] = suffix
+
+	p[0] = 32
+	for i, b := range bs {
+		p[1+2*i] = 'A' + b>>4
+		p[2+2*i] = 'A' + b&0xf
+	}
+	p[33] = 0
+}
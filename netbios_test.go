@@ -0,0 +1,117 @@
+package smb2
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeNetBIOSName(t *testing.T) {
+	p := make([]byte, 34)
+
+	// RFC 1001 14.1
+	encodeNetBIOSName(p, "fred", 0x20)
+	if s := string(p[1:33]); p[0] != 32 || s != "EGFCEFEECACACACACACACACACACACACA" || p[33] != 0 {
+		t.Errorf("unexpected encoding: %x", p)
+	}
+
+	encodeNetBIOSName(p, "a-very-long-host-name", 0x00)
+	if s := string(p[1:33]); s != "EBCNFGEFFCFJCNEMEPEOEHCNEIEPFDAA" {
+		t.Errorf("unexpected encoding: %s", s)
+	}
+}
+
+// serveNetBIOSSession reads a SESSION REQUEST and responds with res.
+func serveNetBIOSSession(t *testing.T, server net.Conn, res []byte) {
+	req := make([]byte, 72)
+	if _, err := io.ReadFull(server, req); err != nil {
+		t.Error(err)
+		return
+	}
+	if req[0] != nbssSessionRequest || req[3] != 68 || string(req[5:37]) != "CKFDENECFDEFFCFGEFFCCACACACACACA" {
+		t.Errorf("unexpected session request: %x", req)
+	}
+	server.Write(res)
+}
+
+func TestRequestNetBIOSSession(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go serveNetBIOSSession(t, server, []byte{nbssPositiveSessionResponse, 0, 0, 0})
+
+	if err := requestNetBIOSSession(client, defaultNetBIOSName, "client", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep-alive messages are skipped.
+	go server.Write([]byte{nbssSessionKeepAlive, 0, 0, 0, nbssSessionMessage, 0x01, 0x00, 0x00})
+
+	if n, err := netBIOSSession(client).ReadSize(); err != nil || n != 0x10000 {
+		t.Errorf("unexpected size: %d, %v", n, err)
+	}
+
+	client, server = net.Pipe()
+	defer client.Close()
+
+	go serveNetBIOSSession(t, server, []byte{nbssNegativeSessionResponse, 0, 0, 1, 0x82})
+
+	err := requestNetBIOSSession(client, defaultNetBIOSName, "client", context.Background())
+	if err, ok := err.(*NetBIOSSessionError); !ok || err.Code != 0x82 {
+		t.Errorf("expected a negative response, got %v", err)
+	}
+
+	// the server never responds.
+	client, server = net.Pipe()
+	defer client.Close()
+
+	go io.Copy(ioutil.Discard, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err, ok := requestNetBIOSSession(client, defaultNetBIOSName, "client", ctx).(*ContextError); !ok || err.Err != context.Canceled {
+		t.Errorf("expected a context error, got %v", err)
+	}
+
+	// the deadline of the context is cleared afterwards.
+	client, server = net.Pipe()
+	defer client.Close()
+
+	go serveNetBIOSSession(t, server, []byte{nbssPositiveSessionResponse, 0, 0, 0})
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := requestNetBIOSSession(client, defaultNetBIOSName, "client", ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		server.Write([]byte{nbssSessionMessage, 0, 0, 1})
+	}()
+
+	if n, err := netBIOSSession(client).ReadSize(); err != nil || n != 1 {
+		t.Errorf("unexpected size: %d, %v", n, err)
+	}
+
+	// without a deadline nor cancellation, the deadline set by the caller is kept.
+	client, server = net.Pipe()
+	defer client.Close()
+
+	go serveNetBIOSSession(t, server, []byte{nbssPositiveSessionResponse, 0, 0, 0})
+
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	if err := requestNetBIOSSession(client, defaultNetBIOSName, "client", context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := netBIOSSession(client).ReadSize(); !isTimeout(err) {
+		t.Errorf("expected the deadline of the caller to expire, got %v", err)
+	}
+}
@@ -95,6 +95,56 @@ func validateMountPath(path string) error {
 	return nil
 }
 
+// CleanPath converts p into the share-relative form expected by the methods of Share.
+//
+// Both '/' and '\\' are accepted as path separators.
+// Leading and trailing separators, empty elements and "." elements are removed,
+// and ".." elements are resolved lexically. The root of the share is represented by "".
+//
+// It returns an error if p is a UNC path (`\\<server>\<share>...`) or
+// if a ".." element would escape the root of the share.
+func CleanPath(p string) (string, error) {
+	p = strings.Replace(p, `/`, `\`, -1)
+
+	if strings.HasPrefix(p, `\\`) {
+		return "", &os.PathError{Op: "clean", Path: p, Err: errors.New("UNC path is not allowed; use a path relative to the mounted share")}
+	}
+
+	var elems []string
+
+	for _, elem := range strings.Split(p, `\`) {
+		switch elem {
+		case "", ".":
+		case "..":
+			if len(elems) == 0 {
+				return "", &os.PathError{Op: "clean", Path: p, Err: errors.New("path escapes from the share root")}
+			}
+			elems = elems[:len(elems)-1]
+		default:
+			elems = append(elems, elem)
+		}
+	}
+
+	return strings.Join(elems, `\`), nil
+}
+
+// cleanPath cleans a share-relative path argument of op.
+// If NORMALIZE_PATH is false, the path is only validated.
+func cleanPath(op string, path string) (string, error) {
+	if !NORMALIZE_PATH {
+		if err := validatePath(op, path, false); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	cleaned, err := CleanPath(path)
+	if err != nil {
+		return "", &os.PathError{Op: op, Path: path, Err: err.(*os.PathError).Err}
+	}
+	return cleaned, nil
+}
+
 func normPath(path string) string {
 	if !NORMALIZE_PATH {
 		return path
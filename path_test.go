@@ -66,3 +66,39 @@ func TestValidateMountPath(t *testing.T) {
 		}
 	}
 }
+
+var testCleanPath = []struct {
+	Path  string
+	Clean string
+	Ok    bool
+}{
+	{"", "", true},
+	{".", "", true},
+	{`\`, "", true},
+	{`/`, "", true},
+	{`foo`, "foo", true},
+	{`\foo\bar\`, `foo\bar`, true},
+	{`/foo/bar/`, `foo\bar`, true},
+	{`foo/bar\baz`, `foo\bar\baz`, true},
+	{`.\foo\.\bar`, `foo\bar`, true},
+	{`foo\\bar`, `foo\bar`, true},
+	{`foo\..\bar`, "bar", true},
+	{`foo\bar\..\..`, "", true},
+	{`..`, "", false},
+	{`foo\..\..\bar`, "", false},
+	{`\\server\share\foo`, "", false},
+	{`//server/share/foo`, "", false},
+}
+
+func TestCleanPath(t *testing.T) {
+	for _, c := range testCleanPath {
+		clean, err := CleanPath(c.Path)
+		if err == nil != c.Ok {
+			t.Errorf("path: %v, expected: %v, got: %v", c.Path, c.Ok, err)
+			continue
+		}
+		if clean != c.Clean {
+			t.Errorf("path: %v, expected: %v, got: %v", c.Path, c.Clean, clean)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package smb2
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// NamedPipe represents a named pipe opened on the IPC$ share of a server,
+// e.g. to talk DCE/RPC to a service over SMB (ncacn_np).
+type NamedPipe struct {
+	f *File
+}
+
+// OpenPipe opens the named pipe name, like "svcctl" or `\PIPE\svcctl`, for reading and writing.
+// fs must be mounted on a pipe share, which is IPC$; otherwise it returns os.ErrInvalid.
+// If all the instances of the pipe are busy, the open is retried a few times with increasing delays.
+func (fs *Share) OpenPipe(name string) (*NamedPipe, error) {
+	name = strings.TrimPrefix(normPath(name), `\`)
+	if len(name) > 5 && strings.EqualFold(name[:5], `PIPE\`) {
+		name = name[5:]
+	}
+
+	if fs.shareType != SMB2_SHARE_TYPE_PIPE || name == "" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+	}
+
+	backoff := clientPipeBusyBackoff
+
+	for i := 0; ; i++ {
+		create := &CreateRequest{
+			SecurityFlags:        0,
+			RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+			ImpersonationLevel:   Impersonation,
+			SmbCreateFlags:       0,
+			DesiredAccess:        FILE_READ_DATA | FILE_WRITE_DATA | FILE_READ_ATTRIBUTES,
+			FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+			ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE,
+			CreateDisposition:    FILE_OPEN,
+			CreateOptions:        0,
+		}
+
+		f, err := fs.createFile(name, create, false)
+		if err == nil {
+			return &NamedPipe{f: f}, nil
+		}
+
+		if i == clientPipeBusyRetries || !isPipeBusy(err) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		if err := sleep(backoff, fs.ctx); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		backoff *= 2
+	}
+}
+
+// isPipeBusy reports whether err tells that no instance of a pipe is available for the moment.
+func isPipeBusy(err error) bool {
+	if rerr, ok := err.(*ResponseError); ok {
+		switch NtStatus(rerr.Code) {
+		case STATUS_PIPE_BUSY, STATUS_PIPE_NOT_AVAILABLE, STATUS_INSTANCE_NOT_AVAILABLE:
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of the pipe as passed to OpenPipe, without the `\PIPE\` prefix.
+func (p *NamedPipe) Name() string {
+	return p.f.name
+}
+
+// Call writes the message in to the pipe and reads the reply in a single exchange (FSCTL_PIPE_TRANSCEIVE),
+// which is how DCE/RPC requests are usually sent over SMB. Replies of any length are returned whole.
+func (p *NamedPipe) Call(in []byte) ([]byte, error) {
+	f := p.f
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	size := clientPipeTransceiveSize
+	if max := f.maxTransactSize(); size > max {
+		size = max
+	}
+
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_PIPE_TRANSCEIVE,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: uint32(size),
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input:             Bytes(in),
+	}
+
+	output, err := f.ioctl(req)
+	if err == nil {
+		return output, nil
+	}
+	if rerr, ok := err.(*ResponseError); !ok || NtStatus(rerr.Code) != STATUS_BUFFER_OVERFLOW {
+		return nil, &os.PathError{Op: "call", Path: f.name, Err: pipeError(err)}
+	}
+
+	// the reply didn't fit; the rest of it is read from the pipe.
+	out := append([]byte{}, output...)
+	buf := make([]byte, f.maxReadSize())
+
+	for {
+		n, more, err := p.read(buf)
+		if err != nil {
+			return nil, &os.PathError{Op: "call", Path: f.name, Err: err}
+		}
+
+		out = append(out, buf[:n]...)
+
+		if !more {
+			return out, nil
+		}
+	}
+}
+
+// Read reads from the pipe, waiting until data is available.
+// On message pipes, a message longer than b is returned by successive calls.
+// It returns io.EOF once the other end of the pipe is closed.
+func (p *NamedPipe) Read(b []byte) (int, error) {
+	p.f.m.Lock()
+	defer p.f.m.Unlock()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if max := p.f.maxReadSize(); len(b) > max {
+		b = b[:max]
+	}
+
+	n, _, err := p.read(b)
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, &os.PathError{Op: "read", Path: p.f.name, Err: err}
+	}
+	return n, nil
+}
+
+// read reads the next message, or the next part of it, into b, which mustn't exceed the max read size.
+// more reports that the rest of the message is still to be read.
+func (p *NamedPipe) read(b []byte) (n int, more bool, err error) {
+	fs := p.f.fs.channel()
+
+	creditCharge, m, err := fs.loanCredit(len(b))
+	defer func() {
+		if err != nil {
+			fs.chargeCredit(creditCharge)
+		}
+	}()
+	if err != nil {
+		return 0, false, err
+	}
+
+	req := &ReadRequest{
+		Padding:         0,
+		Flags:           0,
+		Length:          uint32(m),
+		Offset:          0,
+		MinimumCount:    0,
+		Channel:         0,
+		RemainingBytes:  0,
+		ReadChannelInfo: nil,
+	}
+
+	req.FileId = p.f.fd
+
+	req.CreditCharge = creditCharge
+
+	res, err := fs.sendRecv(SMB2_READ, req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); !ok || NtStatus(rerr.Code) != STATUS_BUFFER_OVERFLOW {
+			return 0, false, pipeError(err)
+		}
+		more, err = true, nil
+	}
+
+	r := ReadResponseDecoder(res)
+	if r.IsInvalid() {
+		return 0, false, &InvalidResponseError{"broken read response format"}
+	}
+
+	return copy(b, r.Data()), more, nil
+}
+
+// Write writes b to the pipe. On message pipes, b should be a single message
+// no longer than the max write size of the server, otherwise it's split into several messages.
+func (p *NamedPipe) Write(b []byte) (int, error) {
+	p.f.m.Lock()
+	defer p.f.m.Unlock()
+
+	n, err := p.f.writeAt(b, 0)
+	if err != nil {
+		return n, &os.PathError{Op: "write", Path: p.f.name, Err: err}
+	}
+	return n, nil
+}
+
+// Close closes the pipe.
+func (p *NamedPipe) Close() error {
+	return p.f.Close()
+}
+
+// pipeError maps the errors reporting that the other end of a pipe is gone to io.EOF.
+func pipeError(err error) error {
+	if rerr, ok := err.(*ResponseError); ok {
+		switch NtStatus(rerr.Code) {
+		case STATUS_PIPE_BROKEN, STATUS_PIPE_CLOSING, STATUS_PIPE_DISCONNECTED, STATUS_END_OF_FILE:
+			return io.EOF
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package smb2
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Buffers of requests and of large responses are pooled by size class, the powers of two
+// from 512 bytes to 16 MiB. Larger buffers are allocated each time.
+const (
+	minPooledBufferShift = 9
+	maxPooledBufferShift = 24
+
+	minPooledBufferSize = 1 << minPooledBufferShift
+	maxPooledBufferSize = 1 << maxPooledBufferShift
+)
+
+var bufferPools [maxPooledBufferShift - minPooledBufferShift + 1]sync.Pool
+
+// bufferClass returns the index of the pool of the buffers of at least n bytes, or -1 if they aren't pooled.
+func bufferClass(n int) int {
+	if n > maxPooledBufferSize {
+		return -1
+	}
+	if n <= minPooledBufferSize {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minPooledBufferShift
+}
+
+// getBuffer returns a zeroed buffer of n bytes, as make does, since encoders leave reserved fields untouched.
+func getBuffer(n int) []byte {
+	c := bufferClass(n)
+	if c < 0 {
+		return make([]byte, n)
+	}
+	if p, ok := bufferPools[c].Get().(*[]byte); ok {
+		b := (*p)[:n]
+		for i := range b {
+			b[i] = 0
+		}
+		return b
+	}
+	return make([]byte, n, minPooledBufferSize<<uint(c))
+}
+
+// putBuffer returns b, obtained from getBuffer, to its pool.
+// b must not be used afterwards, nor any other slice of the same array.
+func putBuffer(b []byte) {
+	c := bufferClass(cap(b))
+	if c < 0 || cap(b) != minPooledBufferSize<<uint(c) {
+		return
+	}
+	b = b[:0]
+	bufferPools[c].Put(&b)
+}
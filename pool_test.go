@@ -0,0 +1,44 @@
+package smb2
+
+import (
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	for _, tc := range []struct {
+		n   int
+		cap int
+	}{
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{64*1024 + 80, 128 * 1024},
+		{1 << 24, 1 << 24},
+		{1<<24 + 1, 1<<24 + 1}, // not pooled
+	} {
+		b := getBuffer(tc.n)
+		if len(b) != tc.n || cap(b) != tc.cap {
+			t.Errorf("getBuffer(%d): expected cap %d, got len %d, cap %d", tc.n, tc.cap, len(b), cap(b))
+		}
+		putBuffer(b)
+	}
+
+	// buffers not obtained from the pool are ignored.
+	putBuffer(make([]byte, 1000))
+	if b := getBuffer(1000); cap(b) != 1024 {
+		t.Errorf("unexpected cap %d", cap(b))
+	}
+}
+
+func TestBufferPoolZeroed(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		b := getBuffer(100)
+		for j, c := range b {
+			if c != 0 {
+				t.Fatalf("expected a zeroed buffer, got %d at %d", c, j)
+			}
+			b[j] = 0xff
+		}
+		putBuffer(b)
+	}
+}
@@ -0,0 +1,193 @@
+package smb2
+
+import (
+	"os"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// QuotaEntry represents the quota of a user on the volume backing a share.
+// Sizes are in bytes. A limit or threshold of -1 means no limit.
+type QuotaEntry struct {
+	SID            *SID
+	ChangeTime     time.Time // ignored by SetQuota
+	QuotaUsed      int64     // ignored by SetQuota
+	QuotaThreshold int64
+	QuotaLimit     int64
+}
+
+// GetQuota returns the quota entries of the users identified by sids.
+// If sids is empty, it returns all quota entries of the volume.
+// If quotas aren't enabled or supported on the volume, it returns ErrNotSupported.
+func (fs *Share) GetQuota(sids []SID) ([]QuotaEntry, error) {
+	f, err := fs.openQuota(FILE_READ_DATA | FILE_READ_ATTRIBUTES)
+	if err != nil {
+		return nil, &os.PathError{Op: "getquota", Path: "", Err: err}
+	}
+
+	entries, err := f.getQuota(sids)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "getquota", Path: "", Err: err}
+	}
+	return entries, nil
+}
+
+// SetQuota sets the threshold and the limit of the quota entries.
+// If quotas aren't enabled or supported on the volume, it returns ErrNotSupported.
+func (fs *Share) SetQuota(entries []QuotaEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	f, err := fs.openQuota(FILE_READ_DATA | FILE_WRITE_DATA | FILE_READ_ATTRIBUTES)
+	if err != nil {
+		return &os.PathError{Op: "setquota", Path: "", Err: err}
+	}
+
+	err = f.setQuota(entries)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return &os.PathError{Op: "setquota", Path: "", Err: err}
+	}
+	return nil
+}
+
+// openQuota opens the root directory of the share, on which quota requests are issued.
+func (fs *Share) openQuota(access uint32) (*File, error) {
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        access,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_DIRECTORY_FILE,
+	}
+
+	return fs.createFile("", create, true)
+}
+
+func (f *File) getQuota(sids []SID) (entries []QuotaEntry, err error) {
+	info := &QueryQuotaInfo{
+		ReturnSingle: false,
+		RestartScan:  true,
+	}
+
+	for i := range sids {
+		info.Sids = append(info.Sids, sids[i].sid())
+	}
+
+	for {
+		req := &QueryInfoRequest{
+			InfoType:              SMB2_0_INFO_QUOTA,
+			FileInfoClass:         0, // MUST be 0 for quota requests
+			AdditionalInformation: 0,
+			Flags:                 0,
+			OutputBufferLength:    uint32(f.maxTransactSize()),
+			Input:                 info,
+		}
+
+		output, err := f.queryInfo(req)
+		if err != nil {
+			if rerr, ok := err.(*ResponseError); ok {
+				switch NtStatus(rerr.Code) {
+				case STATUS_NO_MORE_ENTRIES:
+					return entries, nil
+				case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST, STATUS_INVALID_INFO_CLASS:
+					return nil, ErrNotSupported
+				}
+			}
+			return nil, err
+		}
+
+		entries, err = parseQuotaEntries(entries, output)
+		if err != nil {
+			return nil, err
+		}
+
+		// the server returns all the requested entries at once.
+		if len(sids) > 0 || len(output) == 0 {
+			return entries, nil
+		}
+
+		info.RestartScan = false
+	}
+}
+
+// parseQuotaEntries appends the entries of a list of FILE_QUOTA_INFORMATION to entries.
+func parseQuotaEntries(entries []QuotaEntry, output []byte) ([]QuotaEntry, error) {
+	for len(output) > 0 {
+		q := FileQuotaInformationDecoder(output)
+		if q.IsInvalid() {
+			return nil, &InvalidResponseError{"broken quota information format"}
+		}
+
+		sid := q.Sid()
+		if sid.IsInvalid() {
+			return nil, &InvalidResponseError{"broken sid format"}
+		}
+
+		entries = append(entries, QuotaEntry{
+			SID:            newSID(sid),
+			ChangeTime:     time.Unix(0, q.ChangeTime().Nanoseconds()),
+			QuotaUsed:      q.QuotaUsed(),
+			QuotaThreshold: q.QuotaThreshold(),
+			QuotaLimit:     q.QuotaLimit(),
+		})
+
+		next := q.NextEntryOffset()
+		if next == 0 {
+			break
+		}
+		if int(next) > len(output) {
+			return nil, &InvalidResponseError{"broken quota information format"}
+		}
+
+		output = output[next:]
+	}
+
+	return entries, nil
+}
+
+func (f *File) setQuota(entries []QuotaEntry) error {
+	list := make(FileQuotaInformationList, len(entries))
+	for i, e := range entries {
+		if e.SID == nil {
+			return os.ErrInvalid
+		}
+		list[i] = &FileQuotaInformationEncoder{
+			QuotaThreshold: e.QuotaThreshold,
+			QuotaLimit:     e.QuotaLimit,
+			Sid:            e.SID.sid(),
+		}
+	}
+
+	req := &SetInfoRequest{
+		InfoType:              SMB2_0_INFO_QUOTA,
+		FileInfoClass:         0, // MUST be 0 for quota requests
+		AdditionalInformation: 0,
+		Input:                 list,
+	}
+
+	err := f.setInfo(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST, STATUS_INVALID_INFO_CLASS:
+				return ErrNotSupported
+			}
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestParseQuotaEntries(t *testing.T) {
+	var list FileQuotaInformationList
+
+	for _, s := range []string{"S-1-5-32-544", "S-1-5-21-1004336348-1177238915-682003330-512"} {
+		sid, err := ParseSID(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, &FileQuotaInformationEncoder{QuotaThreshold: 1 << 20, QuotaLimit: -1, Sid: sid.sid()})
+	}
+
+	output := make([]byte, list.Size())
+	list.Encode(output)
+
+	entries, err := parseQuotaEntries(nil, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	for i, e := range entries {
+		if e.SID.String() != list[i].Sid.String() || e.QuotaThreshold != 1<<20 || e.QuotaLimit != -1 {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+	}
+
+	for _, n := range []int{39, 50, len(output) - 1} {
+		if _, err := parseQuotaEntries(nil, output[:n]); err == nil {
+			t.Errorf("expected an error for %d bytes", n)
+		}
+	}
+
+	le := binary.LittleEndian
+
+	// the first entry points past the end of the output.
+	broken := append([]byte(nil), output...)
+	le.PutUint32(broken[:4], uint32(len(broken)+8))
+	if _, err := parseQuotaEntries(nil, broken); err == nil {
+		t.Error("expected an error for a next entry offset past the end")
+	}
+
+	// the sid length wraps around when added to the header length.
+	broken = append([]byte(nil), output...)
+	le.PutUint32(broken[4:8], 0xffffffff)
+	if _, err := parseQuotaEntries(nil, broken); err == nil {
+		t.Error("expected an error for a huge sid length")
+	}
+}
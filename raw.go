@@ -0,0 +1,72 @@
+package smb2
+
+import (
+	"context"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// RawPacket is a SMB2 request encoded by the caller.
+// The header fields not listed here (MessageId, SessionId, TreeId, credits, signature)
+// are filled by the library.
+type RawPacket struct {
+	Command uint16 // SMB2 command code; e.g. 0x000D for ECHO
+	Flags   uint32 // SMB2 header flags; signing is applied by the library
+	Body    []byte // request body following the 64-byte SMB2 header
+
+	// PayloadSize is the size used to compute the credit charge.
+	// If it's zero, len(Body) is used.
+	PayloadSize int
+}
+
+// RawRequest sends pkt in the session and returns the body of the response,
+// which follows the 64-byte SMB2 header. Signing and encryption are applied as
+// for any other request. A non-success status is returned as *ResponseError.
+//
+// This is an escape hatch for commands the library doesn't implement.
+// It bypasses all the checks done by the high-level API, so the caller is
+// responsible for encoding and decoding the messages correctly.
+func (c *Session) RawRequest(pkt *RawPacket) ([]byte, error) {
+	return rawRequest(c.s.sendRecv, c.s.conn, pkt, c.ctx)
+}
+
+// RawRequest is like Session.RawRequest but sends pkt on the tree connection of the share.
+func (fs *Share) RawRequest(pkt *RawPacket) ([]byte, error) {
+	return rawRequest(fs.treeConn.sendRecv, fs.session.conn, pkt, fs.ctx)
+}
+
+func rawRequest(sendRecv func(uint16, Packet, context.Context) ([]byte, error), conn *conn, pkt *RawPacket, ctx context.Context) (res []byte, err error) {
+	payloadSize := pkt.PayloadSize
+	if payloadSize == 0 {
+		payloadSize = len(pkt.Body)
+	}
+
+	req := &RawRequest{Body: pkt.Body}
+
+	req.Command = pkt.Command
+	req.Flags = pkt.Flags
+
+	var grantedPayloadSize int
+
+	req.CreditCharge, grantedPayloadSize, err = conn.loanCredit(payloadSize, ctx)
+	defer func() {
+		if err != nil {
+			conn.chargeCredit(req.CreditCharge)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// the body can't be split like the data of READ and WRITE,
+	// so a partial loan is topped up to the charge of the whole payload.
+	if grantedPayloadSize < payloadSize {
+		rest := uint16((payloadSize-1)/(64*1024)+1) - req.CreditCharge
+		if err = conn.account.loanAll(rest, ctx); err != nil {
+			return nil, err
+		}
+		req.CreditCharge += rest
+	}
+
+	return sendRecv(pkt.Command, req, ctx)
+}
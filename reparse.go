@@ -0,0 +1,125 @@
+package smb2
+
+import (
+	"os"
+	"strings"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// Reparse tags of common reparse points. ([MS-FSCC] 2.1.2.1)
+const (
+	ReparseTagMountPoint = IO_REPARSE_TAG_MOUNT_POINT // junctions and mounted volumes
+	ReparseTagSymlink    = IO_REPARSE_TAG_SYMLINK
+	ReparseTagDFS        = IO_REPARSE_TAG_DFS
+)
+
+// ReparsePoint is the raw reparse point of a file.
+type ReparsePoint struct {
+	Tag  uint32 // e.g. ReparseTagSymlink or ReparseTagMountPoint
+	Data []byte // the data specific to the tag, following the header of the reparse data buffer
+}
+
+// ReadReparsePoint returns the reparse point of the file (FSCTL_GET_REPARSE_POINT).
+// The file must have been opened without following reparse points, e.g. with OpenOptions.NoFollow.
+// If the file isn't a reparse point, it returns os.ErrInvalid.
+func (f *File) ReadReparsePoint() (*ReparsePoint, error) {
+	output, err := f.getReparsePoint()
+	if err != nil {
+		return nil, &os.PathError{Op: "readreparsepoint", Path: f.name, Err: err}
+	}
+
+	r := ReparseDataBufferDecoder(output)
+	if r.IsInvalid() {
+		return nil, &os.PathError{Op: "readreparsepoint", Path: f.name, Err: &InvalidResponseError{"broken reparse data buffer format"}}
+	}
+
+	return &ReparsePoint{
+		Tag:  r.ReparseTag(),
+		Data: append([]byte(nil), r.ReparseData()...),
+	}, nil
+}
+
+// Readlink returns the target of the symbolic link or the mount point (junction) of the file.
+// NT path prefixes are removed from the target, i.e. `\??\C:\dir` is returned as `C:\dir`
+// and `\??\UNC\server\share` as `\\server\share`. Targets of relative symbolic links are returned as is.
+// The file must have been opened without following reparse points, e.g. with OpenOptions.NoFollow.
+// If the file is neither a symbolic link nor a mount point, it returns os.ErrInvalid.
+func (f *File) Readlink() (string, error) {
+	target, err := f.readlink()
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: f.name, Err: err}
+	}
+	return target, nil
+}
+
+func (f *File) readlink() (string, error) {
+	output, err := f.getReparsePoint()
+	if err != nil {
+		return "", err
+	}
+
+	return parseReparseTarget(output)
+}
+
+// parseReparseTarget returns the target of a symbolic link or mount point reparse data buffer.
+func parseReparseTarget(output []byte) (string, error) {
+	r := ReparseDataBufferDecoder(output)
+	if r.IsInvalid() {
+		return "", &InvalidResponseError{"broken reparse data buffer format"}
+	}
+
+	switch r.ReparseTag() {
+	case IO_REPARSE_TAG_SYMLINK:
+		r := SymbolicLinkReparseDataBufferDecoder(output)
+		if r.IsInvalid() {
+			return "", &InvalidResponseError{"broken symbolic link response data buffer format"}
+		}
+		return substituteNameToPath(r.SubstituteName()), nil
+	case IO_REPARSE_TAG_MOUNT_POINT:
+		r := MountPointReparseDataBufferDecoder(output)
+		if r.IsInvalid() {
+			return "", &InvalidResponseError{"broken mount point response data buffer format"}
+		}
+		return substituteNameToPath(r.SubstituteName()), nil
+	}
+
+	return "", os.ErrInvalid
+}
+
+func (f *File) getReparsePoint() ([]byte, error) {
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_GET_REPARSE_POINT,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: uint32(f.maxTransactSize()),
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input:             nil,
+	}
+
+	output, err := f.ioctl(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_A_REPARSE_POINT:
+				return nil, os.ErrInvalid
+			}
+		}
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// substituteNameToPath removes the NT path prefix of the substitute name of a reparse point.
+func substituteNameToPath(name string) string {
+	switch {
+	case strings.HasPrefix(name, `\??\UNC\`):
+		return `\\` + name[8:]
+	case strings.HasPrefix(name, `\??\`):
+		return name[4:]
+	}
+	return name
+}
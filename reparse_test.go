@@ -0,0 +1,70 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func encodeSymlink(flags uint32, target string) []byte {
+	rdbuf := &SymbolicLinkReparseDataBuffer{Flags: flags, SubstituteName: target, PrintName: target}
+	p := make([]byte, rdbuf.Size())
+	rdbuf.Encode(p)
+	return p
+}
+
+func encodeMountPoint(target string) []byte {
+	le := binary.LittleEndian
+
+	name := utf16le.EncodeStringToBytes(target)
+
+	p := make([]byte, 16+len(name)+2)
+	le.PutUint32(p[:4], IO_REPARSE_TAG_MOUNT_POINT)
+	le.PutUint16(p[4:6], uint16(len(p)-8))
+	le.PutUint16(p[10:12], uint16(len(name))) // SubstituteNameLength
+	le.PutUint16(p[12:14], uint16(len(name))) // PrintNameOffset, empty
+	copy(p[16:], name)
+	return p
+}
+
+func TestParseReparseTarget(t *testing.T) {
+	cases := []struct {
+		output []byte
+		target string
+	}{
+		{encodeSymlink(0, `\??\C:\dir\file`), `C:\dir\file`},
+		{encodeSymlink(0, `\??\UNC\server\share\file`), `\\server\share\file`},
+		{encodeSymlink(SYMLINK_FLAG_RELATIVE, `..\file`), `..\file`},
+		{encodeMountPoint(`\??\C:\target`), `C:\target`},
+		{encodeMountPoint(`\??\Volume{01234567-89ab-cdef-0123-456789abcdef}\`), `Volume{01234567-89ab-cdef-0123-456789abcdef}\`},
+	}
+
+	for _, c := range cases {
+		target, err := parseReparseTarget(c.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != c.target {
+			t.Errorf("expected %q, got %q", c.target, target)
+		}
+	}
+
+	dedup := []byte{0x13, 0x00, 0x00, 0x80, 0x04, 0x00, 0x00, 0x00, 1, 2, 3, 4} // IO_REPARSE_TAG_DEDUP
+	if _, err := parseReparseTarget(dedup); err != os.ErrInvalid {
+		t.Errorf("expected os.ErrInvalid, got %v", err)
+	}
+
+	for _, output := range [][]byte{
+		encodeSymlink(0, `\??\C:\dir`)[:20],
+		encodeMountPoint(`\??\C:\dir`)[:12],
+		dedup[:10],
+	} {
+		if _, err := parseReparseTarget(output); err == nil || err == os.ErrInvalid {
+			t.Errorf("expected a broken response error for %x, got %v", output, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package smb2
+
+import (
+	"context"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+)
+
+// RetryPolicy controls how requests rejected by a busy server are retried.
+// Currently, STATUS_INSUFFICIENT_RESOURCES and STATUS_INSUFF_SERVER_RESOURCES are retried.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries of a single request.
+	MaxRetries int
+
+	// InitialBackoff is the delay before the first retry. It doubles at each retry.
+	// If it's zero, 100 milliseconds is used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries.
+	// If it's zero, 5 seconds is used.
+	MaxBackoff time.Duration
+}
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p.InitialBackoff > 0 {
+		return p.InitialBackoff
+	}
+	return 100 * time.Millisecond
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return 5 * time.Second
+}
+
+// nextBackoff returns the delay before the retry following one delayed by prev, or before the first retry if prev is zero.
+func (p *RetryPolicy) nextBackoff(prev time.Duration) time.Duration {
+	backoff := p.initialBackoff()
+	if prev > 0 {
+		backoff = prev * 2
+	}
+	if max := p.maxBackoff(); backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// isResourceShortage reports whether err means that the server is temporarily out of resources.
+func isResourceShortage(err error) bool {
+	if rerr, ok := err.(*ResponseError); ok {
+		switch NtStatus(rerr.Code) {
+		case STATUS_INSUFFICIENT_RESOURCES, STATUS_INSUFF_SERVER_RESOURCES:
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(d time.Duration, ctx context.Context) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return &ContextError{Err: ctx.Err()}
+	}
+}
@@ -0,0 +1,148 @@
+package smb2
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+)
+
+func TestIsResourceShortage(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		expected bool
+	}{
+		{&ResponseError{Code: uint32(STATUS_INSUFFICIENT_RESOURCES)}, true},
+		{&ResponseError{Code: uint32(STATUS_INSUFF_SERVER_RESOURCES)}, true},
+		{&ResponseError{Code: uint32(STATUS_ACCESS_DENIED)}, false},
+		{&ResponseError{Code: uint32(STATUS_DISK_FULL)}, false},
+		{&TransportError{errors.New("broken pipe")}, false},
+		{nil, false},
+	} {
+		if isResourceShortage(tc.err) != tc.expected {
+			t.Errorf("%v: expected %v", tc.err, tc.expected)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	// the delay doubles up to the cap.
+	p := &RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	var backoffs []time.Duration
+	var backoff time.Duration
+	for i := 0; i < 5; i++ {
+		backoff = p.nextBackoff(backoff)
+		backoffs = append(backoffs, backoff)
+	}
+
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i := range expected {
+		if backoffs[i] != expected[i]*time.Millisecond {
+			t.Fatalf("unexpected backoffs: %v", backoffs)
+		}
+	}
+
+	// defaults.
+	p = new(RetryPolicy)
+
+	if backoff := p.nextBackoff(0); backoff != 100*time.Millisecond {
+		t.Errorf("unexpected initial backoff: %v", backoff)
+	}
+	if backoff := p.nextBackoff(4 * time.Second); backoff != 5*time.Second {
+		t.Errorf("unexpected max backoff: %v", backoff)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	data := []byte("0123456789")
+
+	newFile := func(busy int, policy *RetryPolicy) (*File, *testFileServer) {
+		f, srv := newTestFile(0, -1)
+		f.fs.conn.retryPolicy = policy
+		srv.data = data
+		srv.busy = busy
+		return f, srv
+	}
+
+	policy := &RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	// the request succeeds after two retries.
+	f, srv := newFile(2, policy)
+	defer srv.conn.Close()
+
+	balance := len(f.fs.account.balance)
+
+	b := make([]byte, len(data))
+
+	n, err := f.ReadAt(b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(data) || string(b) != string(data) {
+		t.Errorf("unexpected content: %q", b[:n])
+	}
+	if len(srv.reads) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(srv.reads))
+	}
+
+	// each attempt loans the credits again and requests as many credits as the first one,
+	// under a new message id.
+	for i, hdr := range srv.reads[1:] {
+		if hdr.CreditCharge != srv.reads[0].CreditCharge || hdr.CreditRequestResponse != srv.reads[0].CreditRequestResponse {
+			t.Errorf("attempt %d: unexpected credits: %+v, first attempt %+v", i+2, hdr, srv.reads[0])
+		}
+		if hdr.MessageId == srv.reads[i].MessageId {
+			t.Errorf("attempt %d: message id reused: %d", i+2, hdr.MessageId)
+		}
+	}
+	if len(f.fs.account.balance) != balance {
+		t.Errorf("expected %d credits in the balance, got %d", balance, len(f.fs.account.balance))
+	}
+
+	// the request fails after MaxRetries retries.
+	f, srv = newFile(10, policy)
+	defer srv.conn.Close()
+
+	_, err = f.ReadAt(b, 0)
+	if e, ok := err.(*os.PathError); !ok || !isResourceShortage(e.Err) {
+		t.Errorf("expected a resource shortage, got %v", err)
+	}
+	if len(srv.reads) != 1+policy.MaxRetries {
+		t.Errorf("expected %d attempts, got %d", 1+policy.MaxRetries, len(srv.reads))
+	}
+	if len(f.fs.account.balance) != balance {
+		t.Errorf("expected %d credits in the balance, got %d", balance, len(f.fs.account.balance))
+	}
+
+	// without a policy, the request isn't retried.
+	f, srv = newFile(1, nil)
+	defer srv.conn.Close()
+
+	_, err = f.ReadAt(b, 0)
+	if e, ok := err.(*os.PathError); !ok || !isResourceShortage(e.Err) {
+		t.Errorf("expected a resource shortage, got %v", err)
+	}
+	if len(srv.reads) != 1 {
+		t.Errorf("expected 1 attempt, got %d", len(srv.reads))
+	}
+
+	// the backoff is interrupted when the context is done.
+	f, srv = newFile(10, &RetryPolicy{MaxRetries: 3, InitialBackoff: time.Hour})
+	defer srv.conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	f.fs = f.fs.WithContext(ctx)
+
+	_, err = f.ReadAt(b, 0)
+	if e, ok := err.(*os.PathError); !ok {
+		t.Errorf("expected a context error, got %v", err)
+	} else if e, ok := e.Err.(*ContextError); !ok || !e.Timeout() {
+		t.Errorf("expected a context error, got %v", err)
+	}
+}
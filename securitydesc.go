@@ -0,0 +1,352 @@
+package smb2
+
+import (
+	"os"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// SecurityInformation selects the parts of a security descriptor to query or set. ([MS-DTYP] 2.4.7)
+type SecurityInformation uint32
+
+const (
+	OwnerSecurityInformation SecurityInformation = OWNER_SECURITY_INFORMATION
+	GroupSecurityInformation SecurityInformation = GROUP_SECUIRTY_INFORMATION
+	DACLSecurityInformation  SecurityInformation = DACL_SECUIRTY_INFORMATION
+	SACLSecurityInformation  SecurityInformation = SACL_SECUIRTY_INFORMATION // requires SeSecurityPrivilege on the server
+)
+
+// SecurityDescriptorControl holds the control flags of a security descriptor. ([MS-DTYP] 2.4.6)
+type SecurityDescriptorControl uint16
+
+const (
+	ControlOwnerDefaulted     SecurityDescriptorControl = SE_OWNER_DEFAULTED
+	ControlGroupDefaulted     SecurityDescriptorControl = SE_GROUP_DEFAULTED
+	ControlDACLPresent        SecurityDescriptorControl = SE_DACL_PRESENT
+	ControlDACLDefaulted      SecurityDescriptorControl = SE_DACL_DEFAULTED
+	ControlSACLPresent        SecurityDescriptorControl = SE_SACL_PRESENT
+	ControlSACLDefaulted      SecurityDescriptorControl = SE_SACL_DEFAULTED
+	ControlDACLAutoInheritReq SecurityDescriptorControl = SE_DACL_AUTO_INHERIT_REQ
+	ControlSACLAutoInheritReq SecurityDescriptorControl = SE_SACL_AUTO_INHERIT_REQ
+	ControlDACLAutoInherited  SecurityDescriptorControl = SE_DACL_AUTO_INHERITED
+	ControlSACLAutoInherited  SecurityDescriptorControl = SE_SACL_AUTO_INHERITED
+	ControlDACLProtected      SecurityDescriptorControl = SE_DACL_PROTECTED
+	ControlSACLProtected      SecurityDescriptorControl = SE_SACL_PROTECTED
+	ControlSelfRelative       SecurityDescriptorControl = SE_SELF_RELATIVE
+)
+
+// SecurityDescriptor represents the owner, the group and the access control lists of a file. ([MS-DTYP] 2.4.6)
+// Parts that weren't queried are nil.
+// A nil DACL with ControlDACLPresent set is a NULL DACL, which grants everyone full access,
+// while an empty DACL denies everyone.
+type SecurityDescriptor struct {
+	Control SecurityDescriptorControl
+	Owner   *SID
+	Group   *SID
+	DACL    *ACL
+	SACL    *ACL
+}
+
+// ACL represents an access control list. ([MS-DTYP] 2.4.5)
+type ACL struct {
+	Revision uint8 // if it's zero, ACL_REVISION (2) is used
+	ACEs     []ACE
+}
+
+// ACEType is the type of an access control entry.
+type ACEType uint8
+
+const (
+	AccessAllowedACE         ACEType = ACCESS_ALLOWED_ACE_TYPE
+	AccessDeniedACE          ACEType = ACCESS_DENIED_ACE_TYPE
+	SystemAuditACE           ACEType = SYSTEM_AUDIT_ACE_TYPE
+	SystemAlarmACE           ACEType = SYSTEM_ALARM_ACE_TYPE
+	AccessAllowedCallbackACE ACEType = ACCESS_ALLOWED_CALLBACK_ACE_TYPE
+	AccessDeniedCallbackACE  ACEType = ACCESS_DENIED_CALLBACK_ACE_TYPE
+	SystemMandatoryLabelACE  ACEType = SYSTEM_MANDATORY_LABEL_ACE_TYPE
+)
+
+// ACEFlags are the inheritance and audit flags of an access control entry.
+type ACEFlags uint8
+
+const (
+	ObjectInheritACE      ACEFlags = OBJECT_INHERIT_ACE
+	ContainerInheritACE   ACEFlags = CONTAINER_INHERIT_ACE
+	NoPropagateInheritACE ACEFlags = NO_PROPAGATE_INHERIT_ACE
+	InheritOnlyACE        ACEFlags = INHERIT_ONLY_ACE
+	InheritedACE          ACEFlags = INHERITED_ACE
+	SuccessfulAccessACE   ACEFlags = SUCCESSFUL_ACCESS_ACE_FLAG
+	FailedAccessACE       ACEFlags = FAILED_ACCESS_ACE_FLAG
+)
+
+// ACE represents an access control entry. ([MS-DTYP] 2.4.4)
+// Most types consist of an access mask ([MS-DTYP] 2.4.3) and a SID, which are decoded into Mask and SID;
+// anything that follows the SID, like the application data of callback ACEs, is kept in Data.
+// The body of compound and object ACEs is kept in Data as is, and their Mask and SID are unset.
+type ACE struct {
+	Type  ACEType
+	Flags ACEFlags
+	Mask  uint32
+	SID   *SID
+	Data  []byte
+}
+
+// hasSID reports whether ACEs of type t consist of an access mask and a SID.
+func hasSID(t uint8) bool {
+	switch t {
+	case ACCESS_ALLOWED_COMPOUND_ACE_TYPE,
+		ACCESS_ALLOWED_OBJECT_ACE_TYPE, ACCESS_DENIED_OBJECT_ACE_TYPE,
+		SYSTEM_AUDIT_OBJECT_ACE_TYPE, SYSTEM_ALARM_OBJECT_ACE_TYPE,
+		ACCESS_ALLOWED_CALLBACK_OBJECT_ACE_TYPE, ACCESS_DENIED_CALLBACK_OBJECT_ACE_TYPE,
+		SYSTEM_AUDIT_CALLBACK_OBJECT_ACE_TYPE, SYSTEM_ALARM_CALLBACK_OBJECT_ACE_TYPE:
+		return false
+	}
+	return true
+}
+
+func newSecurityDescriptor(d SecurityDescriptorDecoder) (*SecurityDescriptor, error) {
+	if d.IsInvalid() {
+		return nil, &InvalidResponseError{"broken security descriptor format"}
+	}
+
+	sd := &SecurityDescriptor{
+		Control: SecurityDescriptorControl(d.Control()),
+	}
+
+	if owner := d.Owner(); owner != nil {
+		sd.Owner = newSID(owner)
+	}
+	if group := d.Group(); group != nil {
+		sd.Group = newSID(group)
+	}
+
+	var err error
+
+	if dacl := d.Dacl(); dacl != nil {
+		sd.DACL, err = newACL(dacl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sacl := d.Sacl(); sacl != nil {
+		sd.SACL, err = newACL(sacl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sd, nil
+}
+
+func newACL(d AclDecoder) (*ACL, error) {
+	acl := &ACL{
+		Revision: d.AclRevision(),
+		ACEs:     make([]ACE, d.AceCount()),
+	}
+
+	aces := d.Aces()
+
+	for i := range acl.ACEs {
+		a := AceDecoder(aces)
+		aces = aces[a.AceSize():]
+
+		ace := ACE{
+			Type:  ACEType(a.AceType()),
+			Flags: ACEFlags(a.AceFlags()),
+			Data:  a.Body(),
+		}
+
+		if hasSID(a.AceType()) {
+			if a.AceSize() < 8 {
+				return nil, &InvalidResponseError{"broken ace format"}
+			}
+
+			sid := a.Sid()
+			if sid.IsInvalid() {
+				return nil, &InvalidResponseError{"broken sid format"}
+			}
+
+			ace.Mask = a.Mask()
+			ace.SID = newSID(sid)
+			ace.Data = sid[8+4*int(sid.SubAuthorityCount()):]
+		}
+
+		if len(ace.Data) == 0 {
+			ace.Data = nil
+		} else {
+			ace.Data = append([]byte(nil), ace.Data...)
+		}
+
+		acl.ACEs[i] = ace
+	}
+
+	return acl, nil
+}
+
+func (sd *SecurityDescriptor) encoder() *SecurityDescriptorEncoder {
+	e := &SecurityDescriptorEncoder{
+		Control: uint16(sd.Control) &^ (SE_SELF_RELATIVE | SE_DACL_PRESENT | SE_SACL_PRESENT),
+		Dacl:    sd.DACL.encoder(),
+		Sacl:    sd.SACL.encoder(),
+	}
+
+	// a NULL DACL or SACL is present without a list.
+	e.Control |= uint16(sd.Control) & (SE_DACL_PRESENT | SE_SACL_PRESENT)
+
+	if sd.Owner != nil {
+		e.Owner = sd.Owner.sid()
+	}
+	if sd.Group != nil {
+		e.Group = sd.Group.sid()
+	}
+
+	return e
+}
+
+func (acl *ACL) encoder() *AclEncoder {
+	if acl == nil {
+		return nil
+	}
+
+	e := &AclEncoder{
+		AclRevision: acl.Revision,
+		Aces:        make([]*AceEncoder, len(acl.ACEs)),
+	}
+
+	if e.AclRevision == 0 {
+		e.AclRevision = ACL_REVISION
+	}
+
+	for i, ace := range acl.ACEs {
+		e.Aces[i] = &AceEncoder{
+			AceType:  uint8(ace.Type),
+			AceFlags: uint8(ace.Flags),
+			Data:     ace.Data,
+		}
+		if ace.SID != nil {
+			e.Aces[i].Mask = ace.Mask
+			e.Aces[i].Sid = ace.SID.sid()
+		}
+	}
+
+	return e
+}
+
+// SecurityDescriptor returns the parts of the security descriptor of the file selected by info.
+// It requires READ_CONTROL access, which files opened for reading have,
+// and ACCESS_SYSTEM_SECURITY for the SACL; see Share.SecurityDescriptor.
+func (f *File) SecurityDescriptor(info SecurityInformation) (*SecurityDescriptor, error) {
+	sd, err := f.securityDescriptor(info)
+	if err != nil {
+		return nil, &os.PathError{Op: "getsecurity", Path: f.name, Err: err}
+	}
+	return sd, nil
+}
+
+func (f *File) securityDescriptor(info SecurityInformation) (*SecurityDescriptor, error) {
+	output, err := f.queryInfoGrow(SMB2_0_INFO_SECURITY, 0, uint32(info), 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSecurityDescriptor(SecurityDescriptorDecoder(output))
+}
+
+// SetSecurityDescriptor sets the parts of the security descriptor of the file selected by info.
+// The other parts of sd are ignored by the server.
+// It requires WRITE_DAC access for the DACL, WRITE_OWNER for the owner and the group
+// and ACCESS_SYSTEM_SECURITY for the SACL, which files opened by Share.OpenFile don't have;
+// see Share.SetSecurityDescriptor.
+func (f *File) SetSecurityDescriptor(info SecurityInformation, sd *SecurityDescriptor) error {
+	if sd == nil {
+		return &os.PathError{Op: "setsecurity", Path: f.name, Err: os.ErrInvalid}
+	}
+
+	req := &SetInfoRequest{
+		InfoType:              SMB2_0_INFO_SECURITY,
+		FileInfoClass:         0, // MUST be 0 for security requests
+		AdditionalInformation: uint32(info),
+		Input:                 sd.encoder(),
+	}
+
+	err := f.setInfo(req)
+	if err != nil {
+		return &os.PathError{Op: "setsecurity", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// SecurityDescriptor opens the file with the access needed to query the parts of its security descriptor
+// selected by info, and returns them.
+func (fs *Share) SecurityDescriptor(name string, info SecurityInformation) (*SecurityDescriptor, error) {
+	name, err := cleanPath("getsecurity", name)
+	if err != nil {
+		return nil, err
+	}
+
+	access := uint32(READ_CONTROL)
+	if info&SACLSecurityInformation != 0 {
+		access |= ACCESS_SYSTEM_SECURITY
+	}
+
+	f, err := fs.openSecurity(name, access)
+	if err != nil {
+		return nil, &os.PathError{Op: "getsecurity", Path: name, Err: err}
+	}
+
+	sd, err := f.securityDescriptor(info)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "getsecurity", Path: name, Err: err}
+	}
+	return sd, nil
+}
+
+// SetSecurityDescriptor opens the file with the access needed to set the parts of its security descriptor
+// selected by info, and sets them from sd.
+func (fs *Share) SetSecurityDescriptor(name string, info SecurityInformation, sd *SecurityDescriptor) error {
+	name, err := cleanPath("setsecurity", name)
+	if err != nil {
+		return err
+	}
+
+	var access uint32
+	if info&DACLSecurityInformation != 0 {
+		access |= WRITE_DAC
+	}
+	if info&(OwnerSecurityInformation|GroupSecurityInformation) != 0 {
+		access |= WRITE_OWNER
+	}
+	if info&SACLSecurityInformation != 0 {
+		access |= ACCESS_SYSTEM_SECURITY
+	}
+
+	f, err := fs.openSecurity(name, access)
+	if err != nil {
+		return &os.PathError{Op: "setsecurity", Path: name, Err: err}
+	}
+
+	err = f.SetSecurityDescriptor(info, sd)
+	if e := f.close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (fs *Share) openSecurity(name string, access uint32) (*File, error) {
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        access,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        0,
+	}
+
+	return fs.createFile(name, create, true)
+}
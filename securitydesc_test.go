@@ -0,0 +1,98 @@
+package smb2
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// O:BAG:SYD:AI(A;;FA;;;SY)(A;OICI;FA;;;BA) as returned by Windows, with the DACL before the owner and the group.
+var testSecurityDescriptor = []byte{
+	0x01, 0x00, 0x04, 0x84, // revision, control: self-relative, DACL present, DACL auto-inherited
+	0x48, 0x00, 0x00, 0x00, // owner
+	0x58, 0x00, 0x00, 0x00, // group
+	0x00, 0x00, 0x00, 0x00, // SACL
+	0x14, 0x00, 0x00, 0x00, // DACL
+
+	0x02, 0x00, 0x34, 0x00, 0x02, 0x00, 0x00, 0x00, // revision 2, 52 bytes, 2 ACEs
+
+	0x00, 0x00, 0x14, 0x00, 0xff, 0x01, 0x1f, 0x00, // allowed, FILE_ALL_ACCESS
+	0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x12, 0x00, 0x00, 0x00, // S-1-5-18
+
+	0x00, 0x03, 0x18, 0x00, 0xff, 0x01, 0x1f, 0x00, // allowed, object and container inherit, FILE_ALL_ACCESS
+	0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x20, 0x00, 0x00, 0x00, 0x20, 0x02, 0x00, 0x00, // S-1-5-32-544
+
+	0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x20, 0x00, 0x00, 0x00, 0x20, 0x02, 0x00, 0x00, // S-1-5-32-544
+	0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x12, 0x00, 0x00, 0x00, // S-1-5-18
+}
+
+func encodeSecurityDescriptor(sd *SecurityDescriptor) []byte {
+	e := sd.encoder()
+	p := make([]byte, e.Size())
+	e.Encode(p)
+	return p
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	sd, err := newSecurityDescriptor(SecurityDescriptorDecoder(testSecurityDescriptor))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sd.Control != ControlSelfRelative|ControlDACLPresent|ControlDACLAutoInherited {
+		t.Errorf("unexpected control: %#x", sd.Control)
+	}
+	if sd.Owner.String() != "S-1-5-32-544" {
+		t.Errorf("expected owner S-1-5-32-544, got %v", sd.Owner)
+	}
+	if sd.Group.String() != "S-1-5-18" {
+		t.Errorf("expected group S-1-5-18, got %v", sd.Group)
+	}
+	if sd.SACL != nil {
+		t.Errorf("expected no SACL, got %v", sd.SACL)
+	}
+	if sd.DACL == nil || sd.DACL.Revision != ACL_REVISION || len(sd.DACL.ACEs) != 2 {
+		t.Fatalf("unexpected DACL: %v", sd.DACL)
+	}
+
+	ace := sd.DACL.ACEs[1]
+	if ace.Type != AccessAllowedACE || ace.Flags != ObjectInheritACE|ContainerInheritACE || ace.Mask != 0x1f01ff || ace.SID.String() != "S-1-5-32-544" || ace.Data != nil {
+		t.Errorf("unexpected ACE: %+v", ace)
+	}
+
+	if p := encodeSecurityDescriptor(sd); !bytes.Equal(p, testSecurityDescriptor) {
+		t.Errorf("expected %x, got %x", testSecurityDescriptor, p)
+	}
+
+	// object ACEs and the data of callback ACEs are kept as is.
+	sd.DACL.ACEs = append(sd.DACL.ACEs,
+		ACE{Type: ACEType(ACCESS_ALLOWED_OBJECT_ACE_TYPE), Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		ACE{Type: AccessAllowedCallbackACE, Mask: 1, SID: sd.Group, Data: []byte{'a', 'r', 't', 'x', 0, 0, 0, 0}},
+	)
+
+	p := encodeSecurityDescriptor(sd)
+
+	sd2, err := newSecurityDescriptor(SecurityDescriptorDecoder(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2 := encodeSecurityDescriptor(sd2); !bytes.Equal(p, p2) {
+		t.Errorf("expected %x, got %x", p, p2)
+	}
+	if ace := sd2.DACL.ACEs[3]; ace.SID.String() != "S-1-5-18" || !bytes.Equal(ace.Data, []byte{'a', 'r', 't', 'x', 0, 0, 0, 0}) {
+		t.Errorf("unexpected ACE: %+v", ace)
+	}
+
+	// a NULL DACL is present without a list.
+	p = encodeSecurityDescriptor(&SecurityDescriptor{Control: ControlDACLPresent})
+	if sd, err := newSecurityDescriptor(SecurityDescriptorDecoder(p)); err != nil || sd.DACL != nil || sd.Control&ControlDACLPresent == 0 {
+		t.Errorf("unexpected NULL DACL: %v, %v", sd, err)
+	}
+
+	for _, n := range []int{19, 60, 80} {
+		if _, err := newSecurityDescriptor(SecurityDescriptorDecoder(testSecurityDescriptor[:n])); err == nil {
+			t.Errorf("expected an error for %d bytes", n)
+		}
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
-	"encoding/binary"
+	"crypto/subtle"
 	"fmt"
 	"hash"
+	"sync"
+	"time"
 
 	"github.com/hirochachacha/go-smb2/internal/crypto/ccm"
 	"github.com/hirochachacha/go-smb2/internal/crypto/cmac"
@@ -20,7 +22,9 @@ import (
 	. "github.com/hirochachacha/go-smb2/internal/smb2"
 )
 
-func sessionSetup(conn *conn, i Initiator, ctx context.Context) (*session, error) {
+// sessionSetup authenticates a new session on conn with i.
+// If bind isn't nil, conn is bound to the existing session bind as an additional channel instead. ([MS-SMB2] 3.2.4.1.7)
+func sessionSetup(conn *conn, i Initiator, bind *session, ctx context.Context) (*session, error) {
 	spnego := newSpnegoClient([]Initiator{i})
 
 	outputToken, err := spnego.initSecContext()
@@ -28,8 +32,24 @@ func sessionSetup(conn *conn, i Initiator, ctx context.Context) (*session, error
 		return nil, &InvalidResponseError{err.Error()}
 	}
 
+	var flags uint8
+
+	// binding requests carry the id of the session and are signed with its signing key.
+	var bindSigner hash.Hash
+
+	if bind != nil {
+		flags = SMB2_SESSION_FLAG_BINDING
+
+		bindSigner, err = bind.newSigningHash(bind.sessionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		conn.session = &session{conn: conn, sessionId: bind.sessionId, signer: bindSigner}
+	}
+
 	req := &SessionSetupRequest{
-		Flags:             0,
+		Flags:             flags,
 		Capabilities:      conn.capabilities & (SMB2_GLOBAL_CAP_DFS),
 		Channel:           0,
 		SecurityBuffer:    outputToken,
@@ -57,184 +77,67 @@ func sessionSetup(conn *conn, i Initiator, ctx context.Context) (*session, error
 
 	p := PacketCodec(pkt)
 
-	if NtStatus(p.Status()) != STATUS_MORE_PROCESSING_REQUIRED && NtStatus(p.Status()) != STATUS_SUCCESS {
-		return nil, &InvalidResponseError{fmt.Sprintf("expected status: %v, got %v", STATUS_MORE_PROCESSING_REQUIRED, NtStatus(p.Status()))}
-	}
-
 	res, err := accept(SMB2_SESSION_SETUP, pkt)
 	if err != nil {
 		return nil, err
 	}
 
+	if NtStatus(p.Status()) != STATUS_MORE_PROCESSING_REQUIRED && NtStatus(p.Status()) != STATUS_SUCCESS {
+		return nil, &InvalidResponseError{fmt.Sprintf("expected status: %v, got %v", STATUS_MORE_PROCESSING_REQUIRED, NtStatus(p.Status()))}
+	}
+
 	r := SessionSetupResponseDecoder(res)
 	if r.IsInvalid() {
 		return nil, &InvalidResponseError{"broken session setup response format"}
 	}
 
-	sessionFlags := r.SessionFlags()
-	if conn.requireSigning {
-		if sessionFlags&SMB2_SESSION_FLAG_IS_GUEST != 0 {
-			return nil, &InvalidResponseError{"guest account doesn't support signing"}
-		}
-		if sessionFlags&SMB2_SESSION_FLAG_IS_NULL != 0 {
-			return nil, &InvalidResponseError{"anonymous account doesn't support signing"}
-		}
-	}
-
 	s := &session{
-		conn:           conn,
-		treeConnTables: make(map[uint32]*treeConn),
-		sessionFlags:   sessionFlags,
-		sessionId:      p.SessionId(),
+		conn:                      conn,
+		treeConnTables:            make(map[uint32]*treeConn),
+		sessionFlags:              r.SessionFlags(),
+		sessionId:                 p.SessionId(),
+		preauthIntegrityHashValue: conn.preauthIntegrityHashValue,
+		signer:                    bindSigner,
+		initiator:                 i,
+		oplocks:                   newOplockTable(),
+		dfs:                       newDFSCache(),
 	}
 
-	switch conn.dialect {
-	case SMB311:
-		s.preauthIntegrityHashValue = conn.preauthIntegrityHashValue
-
-		switch conn.preauthIntegrityHashId {
-		case SHA512:
-			h := sha512.New()
-			h.Write(s.preauthIntegrityHashValue[:])
-			h.Write(rr.pkt)
-			h.Sum(s.preauthIntegrityHashValue[:0])
-
-			if NtStatus(p.Status()) == STATUS_MORE_PROCESSING_REQUIRED {
-				h.Reset()
-				h.Write(s.preauthIntegrityHashValue[:])
-				h.Write(pkt)
-				h.Sum(s.preauthIntegrityHashValue[:0])
-			}
+	if bind != nil {
+		if s.sessionId != bind.sessionId {
+			return nil, &InvalidResponseError{fmt.Sprintf("expected session id: %v, got %v", bind.sessionId, s.sessionId)}
 		}
 
-	}
-
-	outputToken, err = spnego.acceptSecContext(r.SecurityBuffer())
-	if err != nil {
-		return nil, &InvalidResponseError{err.Error()}
+		// the receiver of the channel checks tree ids against the tables of the session,
+		// and may receive oplock breaks for any file of the session.
+		s.treeConnTables = bind.treeConnTables
+		s.oplocks = bind.oplocks
 	}
 
 	// We set session before sending packet just for setting hdr.SessionId.
 	// But, we should not permit access from receiver until the session information is completed.
 	conn.session = s
 
-	if NtStatus(p.Status()) == STATUS_MORE_PROCESSING_REQUIRED {
-		req.SecurityBuffer = outputToken
-		req.CreditRequestResponse = 0
+	// The preauth integrity hash covers every session setup request,
+	// and every session setup response except the final one. See [MS-SMB2] 3.2.5.3.1.
+	s.updatePreauthIntegrityHashValue(rr.pkt)
 
-		rr, err = s.send(req, ctx)
+	if NtStatus(p.Status()) == STATUS_SUCCESS {
+		// single round trip; the response may carry a token for mutual authentication.
+		_, err = spnego.acceptSecContext(r.SecurityBuffer())
 		if err != nil {
-			return nil, err
+			return nil, &InvalidResponseError{err.Error()}
 		}
 	}
 
-	if s.sessionFlags&(SMB2_SESSION_FLAG_IS_GUEST|SMB2_SESSION_FLAG_IS_NULL) == 0 {
-		sessionKey := spnego.sessionKey()
-
-		switch conn.dialect {
-		case SMB202, SMB210:
-			s.signer = hmac.New(sha256.New, sessionKey)
-			s.verifier = hmac.New(sha256.New, sessionKey)
-		case SMB300, SMB302:
-			signingKey := kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
-			ciph, err := aes.NewCipher(signingKey)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-			s.signer = cmac.New(ciph)
-			s.verifier = cmac.New(ciph)
-
-			// s.applicationKey = kdf(sessionKey, []byte("SMB2APP\x00"), []byte("SmbRpc\x00"))
-
-			encryptionKey := kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerIn \x00"))
-			decryptionKey := kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerOut\x00"))
-
-			ciph, err = aes.NewCipher(encryptionKey)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-			s.encrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
+	for NtStatus(p.Status()) == STATUS_MORE_PROCESSING_REQUIRED {
+		s.updatePreauthIntegrityHashValue(pkt)
 
-			ciph, err = aes.NewCipher(decryptionKey)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-			s.decrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-		case SMB311:
-			if NtStatus(p.Status()) == STATUS_MORE_PROCESSING_REQUIRED {
-				switch conn.preauthIntegrityHashId {
-				case SHA512:
-					h := sha512.New()
-					h.Write(s.preauthIntegrityHashValue[:])
-					h.Write(rr.pkt)
-					h.Sum(s.preauthIntegrityHashValue[:0])
-				}
-			}
-
-			signingKey := kdf(sessionKey, []byte("SMBSigningKey\x00"), s.preauthIntegrityHashValue[:])
-			ciph, err := aes.NewCipher(signingKey)
-			if err != nil {
-				return nil, &InternalError{err.Error()}
-			}
-			s.signer = cmac.New(ciph)
-			s.verifier = cmac.New(ciph)
-
-			// s.applicationKey = kdf(sessionKey, []byte("SMBAppKey\x00"), preauthIntegrityHashValue)
-
-			encryptionKey := kdf(sessionKey, []byte("SMBC2SCipherKey\x00"), s.preauthIntegrityHashValue[:])
-			decryptionKey := kdf(sessionKey, []byte("SMBS2CCipherKey\x00"), s.preauthIntegrityHashValue[:])
-			sess := binary.LittleEndian.AppendUint64(nil, s.sessionId)
-			fmt.Printf("%x,%x\n", sess, sessionKey)
-
-			switch s.cipherId {
-			case AES128CCM:
-				ciph, err := aes.NewCipher(encryptionKey)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-				s.encrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-
-				ciph, err = aes.NewCipher(decryptionKey)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-				s.decrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-			case AES128GCM:
-				ciph, err := aes.NewCipher(encryptionKey)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-				s.encrypter, err = cipher.NewGCMWithNonceSize(ciph, 12)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-
-				ciph, err = aes.NewCipher(decryptionKey)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-				s.decrypter, err = cipher.NewGCMWithNonceSize(ciph, 12)
-				if err != nil {
-					return nil, &InternalError{err.Error()}
-				}
-			}
+		outputToken, err = spnego.acceptSecContext(r.SecurityBuffer())
+		if err != nil {
+			return nil, &InvalidResponseError{err.Error()}
 		}
-		s.sessionFlags |= SMB2_SESSION_FLAG_ENCRYPT_DATA
-	}
 
-	if NtStatus(p.Status()) == STATUS_MORE_PROCESSING_REQUIRED {
 		req.SecurityBuffer = outputToken
 		req.CreditRequestResponse = 0
 
@@ -242,26 +145,55 @@ func sessionSetup(conn *conn, i Initiator, ctx context.Context) (*session, error
 		if err != nil {
 			return nil, err
 		}
+
+		s.updatePreauthIntegrityHashValue(rr.pkt)
+
 		pkt, err = s.recv(rr)
 		if err != nil {
 			return nil, err
 		}
 
+		p = PacketCodec(pkt)
+
 		res, err = accept(SMB2_SESSION_SETUP, pkt)
 		if err != nil {
+			// forget the failed session, so that the connection can be used for another attempt.
+			conn.session = nil
+
 			return nil, err
 		}
 
+		if NtStatus(p.Status()) != STATUS_MORE_PROCESSING_REQUIRED && NtStatus(p.Status()) != STATUS_SUCCESS {
+			return nil, &InvalidResponseError{fmt.Sprintf("expected status: %v, got %v", STATUS_SUCCESS, NtStatus(p.Status()))}
+		}
+
 		r = SessionSetupResponseDecoder(res)
 		if r.IsInvalid() {
 			return nil, &InvalidResponseError{"broken session setup response format"}
 		}
 
-		if NtStatus(PacketCodec(pkt).Status()) != STATUS_SUCCESS {
-			return nil, &InvalidResponseError{"broken session setup response format"}
+		s.sessionFlags = r.SessionFlags()
+	}
+
+	if conn.requireSigning {
+		if s.sessionFlags&SMB2_SESSION_FLAG_IS_GUEST != 0 {
+			return nil, &InvalidResponseError{"guest account doesn't support signing"}
+		}
+		if s.sessionFlags&SMB2_SESSION_FLAG_IS_NULL != 0 {
+			return nil, &InvalidResponseError{"anonymous account doesn't support signing"}
 		}
+	}
 
-		s.sessionFlags = r.SessionFlags()
+	if bind != nil {
+		err = s.bindKeys(bind, spnego.sessionKey(), pkt)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.sessionFlags&(SMB2_SESSION_FLAG_IS_GUEST|SMB2_SESSION_FLAG_IS_NULL) == 0 {
+		err = s.deriveKeys(spnego.sessionKey())
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// now, allow access from receiver
@@ -270,6 +202,183 @@ func sessionSetup(conn *conn, i Initiator, ctx context.Context) (*session, error
 	return s, nil
 }
 
+// newAEAD returns the AEAD of the SMB 3.1.1 cipher with the given key.
+func newAEAD(cipherId uint16, key []byte) (cipher.AEAD, error) {
+	ciph, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cipherId {
+	case AES128CCM, AES256CCM:
+		return ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
+	case AES128GCM, AES256GCM:
+		return cipher.NewGCMWithNonceSize(ciph, 12)
+	}
+
+	return nil, fmt.Errorf("unknown cipher algorithm: %#x", cipherId)
+}
+
+func (s *session) updatePreauthIntegrityHashValue(pkt []byte) {
+	if s.dialect != SMB311 {
+		return
+	}
+
+	switch s.preauthIntegrityHashId {
+	case SHA512:
+		h := sha512.New()
+		h.Write(s.preauthIntegrityHashValue[:])
+		h.Write(pkt)
+		h.Sum(s.preauthIntegrityHashValue[:0])
+	}
+}
+
+func (s *session) deriveKeys(fullSessionKey []byte) (err error) {
+	s.sessionKey = truncateSessionKey(fullSessionKey)
+	s.fullSessionKey = fullSessionKey
+
+	s.signer, err = s.newSigningHash(s.sessionKey)
+	if err != nil {
+		return err
+	}
+	s.verifier, err = s.newSigningHash(s.sessionKey)
+	if err != nil {
+		return err
+	}
+
+	// s.applicationKey = kdf(sessionKey, []byte("SMB2APP\x00"), []byte("SmbRpc\x00"))
+	// s.applicationKey = kdf(sessionKey, []byte("SMBAppKey\x00"), preauthIntegrityHashValue)
+
+	return s.deriveEncryptionKeys(s.sessionKey, s.fullSessionKey, s.preauthIntegrityHashValue[:])
+}
+
+// truncateSessionKey returns the first 16 bytes of the key queried from the security context,
+// padded with zeros if it's shorter. ([MS-SMB2] 3.2.5.3.1)
+func truncateSessionKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+
+	sessionKey := make([]byte, 16)
+	copy(sessionKey, key)
+
+	return sessionKey
+}
+
+// bindKeys sets the keys of a channel bound to the session bind.
+// The channel has its own signing key, derived from the session key of the binding authentication,
+// and verifies the final response with it. The encryption keys are the ones of the session. ([MS-SMB2] 3.2.5.3.1)
+func (s *session) bindKeys(bind *session, fullSessionKey []byte, pkt []byte) (err error) {
+	sessionKey := truncateSessionKey(fullSessionKey)
+
+	s.signer, err = s.newSigningHash(sessionKey)
+	if err != nil {
+		return err
+	}
+	s.verifier, err = s.newSigningHash(sessionKey)
+	if err != nil {
+		return err
+	}
+
+	if PacketCodec(pkt).Flags()&SMB2_FLAGS_SIGNED == 0 || !s.verify(pkt) {
+		return &InvalidResponseError{"unverified session binding response"}
+	}
+
+	s.sessionKey = bind.sessionKey
+	s.fullSessionKey = bind.fullSessionKey
+	s.sessionFlags = bind.sessionFlags
+
+	return s.deriveEncryptionKeys(bind.sessionKey, bind.fullSessionKey, bind.preauthIntegrityHashValue[:])
+}
+
+// newSigningHash returns a hash computing signatures with the signing key derived from sessionKey.
+// On SMB 3.1.1, the key also depends on the preauth integrity hash value of s,
+// and the signatures are AES-GMAC if the server selected it at negotiation.
+func (s *session) newSigningHash(sessionKey []byte) (hash.Hash, error) {
+	var signingKey []byte
+
+	switch s.dialect {
+	case SMB202, SMB210:
+		return hmac.New(sha256.New, sessionKey), nil
+	case SMB300, SMB302:
+		signingKey = kdf(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"))
+	case SMB311:
+		signingKey = kdf(sessionKey, []byte("SMBSigningKey\x00"), s.preauthIntegrityHashValue[:])
+
+		if s.signingAlgorithmId == AES128GMAC {
+			h, err := newGMAC(signingKey)
+			if err != nil {
+				return nil, &InternalError{err.Error()}
+			}
+			return h, nil
+		}
+	default:
+		return nil, &InternalError{fmt.Sprintf("unknown dialect: %#x", s.dialect)}
+	}
+
+	ciph, err := aes.NewCipher(signingKey)
+	if err != nil {
+		return nil, &InternalError{err.Error()}
+	}
+
+	return cmac.New(ciph), nil
+}
+
+// deriveEncryptionKeys sets the encrypter and the decrypter of s.
+// On SMB 3.1.1, the keys depend on the preauth integrity hash value of the session.
+func (s *session) deriveEncryptionKeys(sessionKey, fullSessionKey, preauthIntegrityHashValue []byte) error {
+	switch s.dialect {
+	case SMB300, SMB302:
+		encryptionKey := kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerIn \x00"))
+		decryptionKey := kdf(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerOut\x00"))
+
+		ciph, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return &InternalError{err.Error()}
+		}
+		s.encrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
+		if err != nil {
+			return &InternalError{err.Error()}
+		}
+
+		ciph, err = aes.NewCipher(decryptionKey)
+		if err != nil {
+			return &InternalError{err.Error()}
+		}
+		s.decrypter, err = ccm.NewCCMWithNonceAndTagSizes(ciph, 11, 16)
+		if err != nil {
+			return &InternalError{err.Error()}
+		}
+	case SMB311:
+		// AES-256 ciphers use 256-bit keys derived from the full session key.
+		keySize := 16
+		switch s.cipherId {
+		case AES256CCM, AES256GCM:
+			keySize = 32
+			sessionKey = fullSessionKey
+		}
+
+		encryptionKey := kdfN(sessionKey, []byte("SMBC2SCipherKey\x00"), preauthIntegrityHashValue, keySize)
+		decryptionKey := kdfN(sessionKey, []byte("SMBS2CCipherKey\x00"), preauthIntegrityHashValue, keySize)
+
+		switch s.cipherId {
+		case AES128CCM, AES128GCM, AES256CCM, AES256GCM:
+			var err error
+
+			s.encrypter, err = newAEAD(s.cipherId, encryptionKey)
+			if err != nil {
+				return &InternalError{err.Error()}
+			}
+
+			s.decrypter, err = newAEAD(s.cipherId, decryptionKey)
+			if err != nil {
+				return &InternalError{err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
 type session struct {
 	*conn
 	treeConnTables            map[uint32]*treeConn
@@ -282,10 +391,39 @@ type session struct {
 	encrypter cipher.AEAD
 	decrypter cipher.AEAD
 
+	oplocks *oplockTable // files holding an oplock
+	dfs     *dfsCache    // DFS referrals and their targets
+
+	sessionKey     []byte    // first 16 bytes of the session key, for deriving the keys of channels
+	fullSessionKey []byte    // session key as queried from the security context, for AES-256 ciphers
+	initiator      Initiator // for authenticating channels
+	dialer         *Dialer   // for negotiating channels
+
+	// channels bound to the session in addition to its own connection, see Session.AddChannel.
+	channelsMu  sync.Mutex
+	channels    []*session
+	channelNext uint32
+
+	logoffMu  sync.Mutex
+	loggedOff bool
+
 	// applicationKey []byte
 }
 
+// logoff waits for the outstanding requests, sends LOGOFF and closes the connections of s.
+// It does nothing once it has succeeded.
 func (s *session) logoff(ctx context.Context) error {
+	s.logoffMu.Lock()
+	defer s.logoffMu.Unlock()
+
+	if s.loggedOff {
+		return nil
+	}
+
+	if err := s.drain(nil, ctx); err != nil {
+		return err
+	}
+
 	req := new(LogoffRequest)
 
 	req.CreditCharge = 1
@@ -295,8 +433,165 @@ func (s *session) logoff(ctx context.Context) error {
 		return err
 	}
 
-	s.conn.rdone <- struct{}{}
-	s.conn.t.Close()
+	s.loggedOff = true
+
+	s.teardown(ctx)
+
+	return nil
+}
+
+// teardown closes the connections of s and of its channels, and logs off the sessions set up for DFS targets.
+// It's called after LOGOFF, or instead of it when the connection is dead. (See SessionPool)
+func (s *session) teardown(ctx context.Context) {
+	s.conn.close()
+
+	s.dfs.close(ctx)
+
+	// the server closes the session on every channel.
+	s.channelsMu.Lock()
+	for _, ch := range s.channels {
+		ch.conn.close()
+	}
+	s.channels = nil
+	s.channelsMu.Unlock()
+}
+
+// drain drains the requests on the tree tc, or all the requests of s if tc is nil, on every channel of s.
+// (See conn.drain)
+func (s *session) drain(tc *treeConn, ctx context.Context) error {
+	s.channelsMu.Lock()
+	channels := append([]*session{s}, s.channels...)
+	s.channelsMu.Unlock()
+
+	for _, ch := range channels {
+		if err := ch.conn.drain(tc, ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *session) echo(ctx context.Context) error {
+	req := new(EchoRequest)
+
+	req.CreditCharge = 1
+
+	res, err := s.sendRecv(SMB2_ECHO, req, ctx)
+	if err != nil {
+		return err
+	}
+
+	r := EchoResponseDecoder(res)
+	if r.IsInvalid() {
+		return &InvalidResponseError{"broken echo response format"}
+	}
+
+	return nil
+}
+
+// keepAlive sends ECHO every interval until the connection is closed.
+// The connection is aborted if the server doesn't answer within the interval.
+func (s *session) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.conn.wdone:
+			return
+		case <-t.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := s.echo(ctx)
+		cancel()
+
+		switch err.(type) {
+		case nil:
+		case *ContextError:
+			s.conn.abort(&TransportError{ErrKeepAliveTimeout})
+
+			return
+		case *TransportError:
+			return
+		default:
+			s.log().Warn("keepalive failed", "err", err)
+		}
+	}
+}
+
+// validateNegotiateInfo sends FSCTL_VALIDATE_NEGOTIATE_INFO over IPC$ and verifies
+// that the server saw the same negotiate exchange as we did. (MS-SMB2 3.2.5.14.12)
+func (s *session) validateNegotiateInfo(addr string, ctx context.Context) error {
+	tc, err := treeConnect(s, fmt.Sprintf(`\\%s\IPC$`, addr), 0, ctx)
+	if err != nil {
+		return err
+	}
+	defer tc.disconnect(ctx)
+
+	req := &IoctlRequest{
+		CtlCode: FSCTL_VALIDATE_NEGOTIATE_INFO,
+		FileId: &FileId{
+			Persistent: [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			Volatile:   [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+		MaxOutputResponse: 24,
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input:             s.negotiateInfo,
+	}
+
+	req.CreditCharge = 1
+
+	rr, err := tc.send(req, ctx)
+	if err != nil {
+		return err
+	}
+
+	pkt, err := tc.recv(rr)
+	if err != nil {
+		return err
+	}
+
+	return s.checkNegotiateInfo(pkt)
+}
+
+// checkNegotiateInfo verifies the response to FSCTL_VALIDATE_NEGOTIATE_INFO against the negotiate exchange.
+func (s *session) checkNegotiateInfo(pkt []byte) error {
+	// an unsigned response can be forged; encrypted ones are verified by decryption.
+	if PacketCodec(pkt).Flags()&SMB2_FLAGS_SIGNED == 0 && s.sessionFlags&SMB2_SESSION_FLAG_ENCRYPT_DATA == 0 {
+		return &InvalidResponseError{"unsigned validate negotiate info response"}
+	}
+
+	res, err := accept(SMB2_IOCTL, pkt)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST:
+				// the server doesn't implement the request, but the signed response proves it's genuine.
+				// Any other error, like STATUS_FILE_CLOSED, fails the validation.
+				return nil
+			}
+		}
+		return err
+	}
+
+	r := IoctlResponseDecoder(res)
+	if r.IsInvalid() {
+		return &InvalidResponseError{"broken ioctl response format"}
+	}
+
+	info := ValidateNegotiateInfoResponseDecoder(r.Output())
+	if info.IsInvalid() {
+		return &InvalidResponseError{"broken validate negotiate info response format"}
+	}
+
+	if info.Capabilities() != s.serverCapabilities ||
+		!bytes.Equal(info.Guid(), s.serverGuid[:]) ||
+		info.SecurityMode() != s.serverSecurityMode ||
+		info.Dialect() != s.dialect {
+		return &InvalidResponseError{"validate negotiate info mismatch"}
+	}
 
 	return nil
 }
@@ -343,6 +638,12 @@ func (s *session) sign(pkt []byte) []byte {
 }
 
 func (s *session) verify(pkt []byte) (ok bool) {
+	return s.verifyParts(pkt, nil)
+}
+
+// verifyParts is like verify for the packet made of pkt followed by data,
+// like READ responses whose data is placed directly into the buffer of the caller.
+func (s *session) verifyParts(pkt, data []byte) (ok bool) {
 	p := PacketCodec(pkt)
 
 	signature := append([]byte{}, p.Signature()...)
@@ -354,10 +655,24 @@ func (s *session) verify(pkt []byte) (ok bool) {
 	h.Reset()
 
 	h.Write(pkt)
+	h.Write(data)
 
 	p.SetSignature(h.Sum(nil))
 
-	return bytes.Equal(signature, p.Signature())
+	return subtle.ConstantTimeCompare(signature, p.Signature()) == 1
+}
+
+// supportsEncryption reports whether the messages of s can be encrypted.
+// Guest and anonymous sessions have no keys, and SMB 3.0 servers tell whether they support encryption by a capability.
+func (s *session) supportsEncryption() bool {
+	if s.encrypter == nil {
+		return false
+	}
+	switch s.dialect {
+	case SMB300, SMB302:
+		return s.capabilities&SMB2_GLOBAL_CAP_ENCRYPTION != 0
+	}
+	return true
 }
 
 func (s *session) encrypt(pkt []byte) ([]byte, error) {
@@ -368,7 +683,7 @@ func (s *session) encrypt(pkt []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	c := make([]byte, 52+len(pkt)+16)
+	c := getBuffer(52 + len(pkt) + 16)
 
 	t := TransformCodec(c)
 
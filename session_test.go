@@ -0,0 +1,454 @@
+package smb2
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hirochachacha/go-smb2/internal/spnego"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	s := &session{
+		signer:   hmac.New(sha256.New, key),
+		verifier: hmac.New(sha256.New, key),
+	}
+
+	pkt := make([]byte, 64+16)
+	for i := 64; i < len(pkt); i++ {
+		pkt[i] = byte(i)
+	}
+
+	s.sign(pkt)
+
+	if !s.verify(append([]byte{}, pkt...)) {
+		t.Fatal("expected signature to be accepted")
+	}
+
+	// the data may follow the packet elsewhere.
+	if !s.verifyParts(append([]byte{}, pkt[:72]...), pkt[72:]) {
+		t.Fatal("expected signature to be accepted for the parts")
+	}
+
+	// signature is at [48:64]
+	for _, i := range []int{48, 63, 64, len(pkt) - 1} {
+		bad := append([]byte{}, pkt...)
+		bad[i] ^= 0xff
+		if s.verify(bad) {
+			t.Errorf("expected signature to be rejected when byte %d is modified", i)
+		}
+	}
+
+	s.verifier = hmac.New(sha256.New, []byte("fedcba9876543210"))
+
+	if s.verify(append([]byte{}, pkt...)) {
+		t.Error("expected signature to be rejected with a different key")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	sessionKey := []byte("0123456789abcdef0123456789abcdef")
+
+	pkt := make([]byte, 64+16)
+	for i := range pkt {
+		pkt[i] = byte(i)
+	}
+
+	var sealed [][]byte
+
+	for _, cipherId := range []uint16{AES128CCM, AES128GCM, AES256CCM, AES256GCM} {
+		s := &session{
+			conn:      &conn{dialect: SMB311, cipherId: cipherId},
+			sessionId: 1,
+		}
+
+		err := s.deriveKeys(sessionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the server encrypts with the client's decryption key and vice versa.
+		peer := &session{
+			conn:      s.conn,
+			sessionId: 1,
+			encrypter: s.decrypter,
+			decrypter: s.encrypter,
+		}
+
+		c, err := s.encrypt(append([]byte{}, pkt...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := peer.decrypt(c)
+		if err != nil {
+			t.Fatalf("cipher %#x: %v", cipherId, err)
+		}
+		if !bytes.Equal(p, pkt) {
+			t.Errorf("cipher %#x: unexpected decrypted packet", cipherId)
+		}
+
+		c, err = peer.encrypt(append([]byte{}, pkt...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err = s.decrypt(c)
+		if err != nil {
+			t.Fatalf("cipher %#x: %v", cipherId, err)
+		}
+		if !bytes.Equal(p, pkt) {
+			t.Errorf("cipher %#x: unexpected decrypted packet", cipherId)
+		}
+
+		sealed = append(sealed, c)
+	}
+
+	// AES-128 and AES-256 variants of the same mode derive different keys.
+	for i, cipherId := range []uint16{AES128CCM, AES128GCM} {
+		s := &session{
+			conn:      &conn{dialect: SMB311, cipherId: cipherId},
+			sessionId: 1,
+		}
+
+		err := s.deriveKeys(sessionKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.decrypt(sealed[i+2])
+		if err == nil {
+			t.Errorf("cipher %#x: expected decryption of AES-256 data to fail", cipherId)
+		}
+	}
+}
+
+func TestDeriveKeysFullSessionKey(t *testing.T) {
+	// e.g. the session key of an aes256-cts-hmac-sha1-96 Kerberos ticket.
+	fullSessionKey := []byte("0123456789abcdef0123456789abcdef")
+
+	pkt := make([]byte, 64+16)
+	for i := range pkt {
+		pkt[i] = byte(i)
+	}
+
+	for _, cipherId := range []uint16{AES128GCM, AES256GCM} {
+		s := &session{conn: &conn{dialect: SMB311, cipherId: cipherId}, sessionId: 1}
+		if err := s.deriveKeys(fullSessionKey); err != nil {
+			t.Fatal(err)
+		}
+
+		// signing and AES-128 keys are derived from the first 16 bytes only.
+		s16 := &session{conn: &conn{dialect: SMB311, cipherId: cipherId}, sessionId: 1}
+		if err := s16.deriveKeys(fullSessionKey[:16]); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(s.sessionKey, fullSessionKey[:16]) {
+			t.Errorf("cipher %#x: unexpected session key %x", cipherId, s.sessionKey)
+		}
+
+		p1 := append([]byte{}, pkt...)
+		p2 := append([]byte{}, pkt...)
+		s.sign(p1)
+		s16.sign(p2)
+		if !bytes.Equal(p1, p2) {
+			t.Errorf("cipher %#x: expected the same signature", cipherId)
+		}
+
+		c, err := s.encrypt(append([]byte{}, pkt...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		peer := &session{conn: s16.conn, sessionId: 1, decrypter: s16.encrypter}
+		_, err = peer.decrypt(c)
+		if cipherId == AES128GCM && err != nil {
+			t.Errorf("cipher %#x: expected the same encryption key, got %v", cipherId, err)
+		}
+		if cipherId == AES256GCM && err == nil {
+			t.Errorf("cipher %#x: expected an encryption key derived from the full session key", cipherId)
+		}
+	}
+
+	// short keys are padded with zeros.
+	if k := truncateSessionKey([]byte{1, 2, 3}); !bytes.Equal(k, []byte{1, 2, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("unexpected session key %x", k)
+	}
+}
+
+func TestChannel(t *testing.T) {
+	newChannel := func() *session {
+		return &session{
+			conn: &conn{
+				outstandingRequests: newOutstandingRequests(),
+				wdone:               make(chan struct{}),
+			},
+		}
+	}
+
+	s := newChannel()
+	if s.channel() != s {
+		t.Error("expected the session itself without channels")
+	}
+
+	ch1, ch2 := newChannel(), newChannel()
+	s.channels = []*session{ch1, ch2}
+
+	// sequential requests are spread across the channels.
+	seen := make(map[*session]int)
+	for i := 0; i < 6; i++ {
+		seen[s.channel()]++
+	}
+	for _, ch := range []*session{s, ch1, ch2} {
+		if seen[ch] != 2 {
+			t.Errorf("unexpected distribution: %v", seen)
+		}
+	}
+
+	// the channel with the fewest outstanding requests is preferred.
+	s.outstandingRequests.set(1, &requestResponse{})
+	ch1.outstandingRequests.set(1, &requestResponse{})
+	for i := 0; i < 3; i++ {
+		if ch := s.channel(); ch != ch2 {
+			t.Error("expected the idle channel")
+		}
+	}
+
+	// closed channels are skipped.
+	close(ch2.wdone)
+	for i := 0; i < 3; i++ {
+		if ch := s.channel(); ch == ch2 {
+			t.Error("unexpected closed channel")
+		}
+	}
+
+	tc := &treeConn{session: s, treeId: 5, shareFlags: SMB2_SHAREFLAG_ENCRYPT_DATA}
+	s.channels = []*session{ch1}
+	s.outstandingRequests.set(2, &requestResponse{})
+	if ctc := tc.channel(); ctc.session != ch1 || ctc.treeId != 5 || ctc.shareFlags != SMB2_SHAREFLAG_ENCRYPT_DATA {
+		t.Errorf("unexpected tree connection on channel: %+v", ctc)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// the server reads the requests but never answers.
+	go io.Copy(ioutil.Discard, server)
+
+	c := &conn{
+		t:                   direct(client),
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		recvBufferSize:      1024,
+		rdone:               make(chan struct{}, 1),
+		wdone:               make(chan struct{}, 1),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+	}
+
+	s := &session{
+		conn:         c,
+		sessionId:    1,
+		sessionFlags: SMB2_SESSION_FLAG_IS_GUEST,
+	}
+
+	c.session = s
+	c.enableSession()
+
+	go c.runSender()
+	go c.runReciever()
+
+	s.keepAlive(10 * time.Millisecond)
+
+	<-c.wdone
+
+	if err, ok := c.err.(*TransportError); !ok || err.Err != ErrKeepAliveTimeout {
+		t.Errorf("expected keepalive timeout, got %v", c.err)
+	}
+	if err := s.echo(context.Background()); err != c.err {
+		t.Errorf("expected %v, got %v", c.err, err)
+	}
+}
+
+func TestCheckNegotiateInfo(t *testing.T) {
+	s := &session{
+		conn: &conn{
+			dialect:            SMB302,
+			serverCapabilities: SMB2_GLOBAL_CAP_LEASING | SMB2_GLOBAL_CAP_LARGE_MTU,
+			serverSecurityMode: SMB2_NEGOTIATE_SIGNING_ENABLED,
+		},
+	}
+	copy(s.serverGuid[:], "0123456789abcdef")
+
+	response := func(status NtStatus, capabilities uint32, flags uint32) []byte {
+		info := make([]byte, 24)
+		le := binary.LittleEndian
+		le.PutUint32(info[:4], capabilities)
+		copy(info[4:20], s.serverGuid[:])
+		le.PutUint16(info[20:22], s.serverSecurityMode)
+		le.PutUint16(info[22:24], s.dialect)
+
+		var res Packet = &IoctlResponse{
+			CtlCode: FSCTL_VALIDATE_NEGOTIATE_INFO,
+			FileId:  &FileId{},
+			Input:   Bytes(nil),
+			Output:  Bytes(info),
+		}
+		if status != STATUS_SUCCESS {
+			res = new(ErrorResponse)
+		}
+		hdr := res.Header()
+		hdr.Command = SMB2_IOCTL
+		hdr.Status = uint32(status)
+		hdr.Flags = SMB2_FLAGS_SERVER_TO_REDIR | flags
+
+		pkt := make([]byte, res.Size())
+		res.Encode(pkt)
+		return pkt
+	}
+
+	// the server saw the same negotiate exchange.
+	if err := s.checkNegotiateInfo(response(STATUS_SUCCESS, s.serverCapabilities, SMB2_FLAGS_SIGNED)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the capabilities were tampered with.
+	if _, ok := s.checkNegotiateInfo(response(STATUS_SUCCESS, 0, SMB2_FLAGS_SIGNED)).(*InvalidResponseError); !ok {
+		t.Error("expected a mismatch")
+	}
+
+	// the server doesn't implement the request.
+	for _, status := range []NtStatus{STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST} {
+		if err := s.checkNegotiateInfo(response(status, 0, SMB2_FLAGS_SIGNED)); err != nil {
+			t.Errorf("%v: expected the validation to be skipped, got %v", status, err)
+		}
+
+		// but the response must be signed.
+		if err := s.checkNegotiateInfo(response(status, 0, 0)); err == nil {
+			t.Errorf("%v: expected an unsigned response to be rejected", status)
+		}
+	}
+
+	// other errors fail the validation.
+	for _, status := range []NtStatus{STATUS_FILE_CLOSED, STATUS_ACCESS_DENIED} {
+		if err := s.checkNegotiateInfo(response(status, 0, SMB2_FLAGS_SIGNED)); err == nil {
+			t.Errorf("%v: expected an error", status)
+		}
+	}
+}
+
+// testInitiator is a security mechanism exchanging fixed tokens.
+type testInitiator struct{}
+
+func (i *testInitiator) oid() asn1.ObjectIdentifier      { return asn1.ObjectIdentifier{1, 2, 3, 4} }
+func (i *testInitiator) initSecContext() ([]byte, error) { return []byte("negotiate"), nil }
+func (i *testInitiator) acceptSecContext(sc []byte) ([]byte, error) {
+	return []byte("authenticate"), nil
+}
+func (i *testInitiator) sum(bs []byte) []byte { return nil }
+func (i *testInitiator) sessionKey() []byte   { return []byte("0123456789abcdef") }
+
+func TestSessionSetupPreauthIntegrity(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	a := openAccount(8)
+	a.balance <- struct{}{} // as granted by NEGOTIATE
+
+	c := &conn{
+		t:                      direct(client),
+		outstandingRequests:    newOutstandingRequests(),
+		account:                a,
+		recvBufferSize:         4096,
+		dialect:                SMB311,
+		preauthIntegrityHashId: SHA512,
+		rdone:                  make(chan struct{}, 1),
+		wdone:                  make(chan struct{}, 1),
+		write:                  make(chan []byte, 1),
+		werr:                   make(chan error, 1),
+	}
+	copy(c.preauthIntegrityHashValue[:], "hash of the negotiate exchange")
+
+	go c.runSender()
+	go c.runReciever()
+
+	defer c.close()
+
+	// the server needs three rounds, and computes the hash over what is actually exchanged.
+	expected := c.preauthIntegrityHashValue
+	update := func(pkt []byte) {
+		h := sha512.New()
+		h.Write(expected[:])
+		h.Write(pkt)
+		h.Sum(expected[:0])
+	}
+
+	go func() {
+		for round := 0; round < 3; round++ {
+			var size [4]byte
+			if _, err := io.ReadFull(server, size[:]); err != nil {
+				return
+			}
+			pkt := make([]byte, binary.BigEndian.Uint32(size[:]))
+			if _, err := io.ReadFull(server, pkt); err != nil {
+				return
+			}
+			update(pkt)
+
+			token, _ := spnego.EncodeNegTokenResp(1, asn1.ObjectIdentifier{1, 2, 3, 4}, []byte("challenge"), nil)
+
+			res := &SessionSetupResponse{SecurityBuffer: token}
+			res.Command = SMB2_SESSION_SETUP
+			res.Flags = SMB2_FLAGS_SERVER_TO_REDIR
+			res.CreditRequestResponse = 1
+			res.MessageId = PacketCodec(pkt).MessageId()
+			res.SessionId = 7
+			res.Status = uint32(STATUS_MORE_PROCESSING_REQUIRED)
+			if round == 2 {
+				res.Status = uint32(STATUS_SUCCESS)
+			}
+
+			out := make([]byte, 4+res.Size())
+			binary.BigEndian.PutUint32(out[:4], uint32(res.Size()))
+			res.Encode(out[4:])
+
+			// the final response isn't hashed.
+			if round < 2 {
+				update(out[4:])
+			}
+
+			if _, err := server.Write(out); err != nil {
+				return
+			}
+		}
+	}()
+
+	s, err := sessionSetup(c, &testInitiator{}, nil, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.preauthIntegrityHashValue != expected {
+		t.Error("unexpected preauth integrity hash value")
+	}
+	if s.preauthIntegrityHashValue == c.preauthIntegrityHashValue {
+		t.Error("expected the hash of the session to differ from the one of the connection")
+	}
+}
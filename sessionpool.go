@@ -0,0 +1,289 @@
+package smb2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SessionPool keeps sessions to a server for reuse, so that short operations don't pay for
+// a connection, NEGOTIATE and SESSION_SETUP each.
+// Get hands out an idle session or sets up a new one with Dial, and Put gives it back once the caller is done.
+// A session is used by a single caller between Get and Put; shares mounted on it should be unmounted before Put.
+// Sessions whose connection was closed, or that are older than MaxLifetime, are logged off rather than reused.
+//
+// The zero value is not usable; Dial must be set. The other fields must not be changed once the pool is in use.
+type SessionPool struct {
+	// Dial sets up a new session, typically with Dialer.DialContext on a new connection to the server.
+	Dial func(ctx context.Context) (*Session, error)
+
+	// MaxIdle is the maximum number of idle sessions kept. Sessions put back beyond it are logged off.
+	// If it's zero, clientPoolMaxIdle is used. (See feature.go for more details)
+	MaxIdle int
+
+	// MaxLifetime is the maximum time a session is reused after it was set up,
+	// e.g. to pick up new credentials or to spread the sessions on servers behind a load balancer.
+	// Sessions in use aren't interrupted; they are logged off when they are put back.
+	// If it's zero, sessions are reused until their connection is closed.
+	MaxLifetime time.Duration
+
+	// HealthCheckInterval is the interval of the ECHO requests sent to idle sessions in the background.
+	// The sessions that don't answer within the interval are dropped, so that Get doesn't hand out
+	// sessions silently dropped by the server or by the network.
+	// If it's zero, idle sessions aren't checked; Get still skips the ones whose connection was closed.
+	HealthCheckInterval time.Duration
+
+	m       sync.Mutex
+	idle    []*Session             // most recently used last
+	lent    map[*session]*Session  // handles returned by Get, by session
+	created map[*session]time.Time // setup time of the sessions of the pool, idle or in use
+	closed  bool
+	done    chan struct{} // closed by Close to stop the health checks, if they were started
+}
+
+// Get returns an idle session of the pool, or sets up a new one if there is none.
+// The session is returned with a background context, like the ones returned by Dial.
+func (p *SessionPool) Get(ctx context.Context) (*Session, error) {
+	for {
+		p.m.Lock()
+		if p.closed {
+			p.m.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.m.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		expired := p.expired(c, time.Now())
+		p.m.Unlock()
+
+		if expired || c.s.isClosed() {
+			p.discard(c, false)
+			continue
+		}
+
+		p.lend(c)
+
+		return c, nil
+	}
+
+	c, err := p.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c = &Session{s: c.s, ctx: context.Background(), addr: c.addr}
+
+	p.m.Lock()
+	if p.created == nil {
+		p.created = make(map[*session]time.Time)
+	}
+	p.created[c.s] = time.Now()
+	p.m.Unlock()
+
+	p.lend(c)
+
+	return c, nil
+}
+
+// lend records that c is handed out by Get, so that Put can tell it from a stale handle.
+func (p *SessionPool) lend(c *Session) {
+	p.m.Lock()
+	if p.lent == nil {
+		p.lent = make(map[*session]*Session)
+	}
+	p.lent[c.s] = c
+	p.m.Unlock()
+}
+
+// Put gives c back to the pool for reuse.
+// Sessions that are closed, too old, or in excess of MaxIdle are logged off instead,
+// as well as all sessions once the pool is closed.
+// A session put back twice is ignored the second time, even if Get handed it out again meanwhile.
+func (p *SessionPool) Put(c *Session) {
+	if p.putBack(c) {
+		return
+	}
+
+	c = &Session{s: c.s, ctx: context.Background(), addr: c.addr}
+
+	p.m.Lock()
+	if p.closed || len(p.idle) >= p.maxIdle() || p.expired(c, time.Now()) || c.s.isClosed() {
+		p.m.Unlock()
+		p.discard(c, false)
+		return
+	}
+	p.idle = append(p.idle, c)
+	if p.HealthCheckInterval > 0 && p.done == nil {
+		p.done = make(chan struct{})
+		go p.healthCheck(p.HealthCheckInterval, p.done)
+	}
+	p.m.Unlock()
+}
+
+// Close logs off the idle sessions and closes the pool.
+// Sessions in use are logged off when they are put back, and Get fails with ErrPoolClosed.
+// It returns the first error of LOGOFF, if any.
+func (p *SessionPool) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	if p.done != nil {
+		close(p.done)
+	}
+	p.m.Unlock()
+
+	var err error
+
+	for _, c := range idle {
+		if e := p.discard(c, false); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func (p *SessionPool) maxIdle() int {
+	if p.MaxIdle > 0 {
+		return p.MaxIdle
+	}
+	return clientPoolMaxIdle
+}
+
+// expired reports whether c has outlived MaxLifetime. It's called with p.m held.
+func (p *SessionPool) expired(c *Session, now time.Time) bool {
+	if p.MaxLifetime <= 0 {
+		return false
+	}
+	created, ok := p.created[c.s]
+	if !ok {
+		// a session set up outside the pool starts its life when it's put back.
+		if p.created == nil {
+			p.created = make(map[*session]time.Time)
+		}
+		p.created[c.s] = now
+		return false
+	}
+	return now.Sub(created) > p.MaxLifetime
+}
+
+// discard forgets c and logs it off. Dead sessions, and the ones whose LOGOFF fails, are torn down without it,
+// which fails their outstanding requests.
+func (p *SessionPool) discard(c *Session, dead bool) error {
+	p.m.Lock()
+	delete(p.created, c.s)
+	p.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientPoolLogoffTimeout)
+	defer cancel()
+
+	if dead || c.s.isClosed() {
+		c.s.teardown(ctx)
+		return nil
+	}
+
+	err := c.s.logoff(ctx)
+	if err != nil {
+		c.s.teardown(ctx)
+	}
+
+	return err
+}
+
+// putBack reports whether c was already put back, and records that it is otherwise.
+func (p *SessionPool) putBack(c *Session) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if l, ok := p.lent[c.s]; ok {
+		if l != c {
+			return true // stale handle, the session was handed out again
+		}
+		delete(p.lent, c.s)
+		return false
+	}
+
+	for _, idle := range p.idle {
+		if idle.s == c.s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// healthCheck sends ECHO to the idle sessions every interval until done is closed,
+// and drops the ones that don't answer in time or have outlived MaxLifetime.
+// The sessions stay in the pool while they are checked, and are checked concurrently,
+// so that Get isn't held up by a slow session. A session handed out by Get meanwhile is left to its caller.
+func (p *SessionPool) healthCheck(interval time.Duration, done chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		p.m.Lock()
+		now := time.Now()
+		var expired, checked []*Session
+		for i := 0; i < len(p.idle); i++ {
+			if p.expired(p.idle[i], now) {
+				expired = append(expired, p.idle[i])
+				p.idle = append(p.idle[:i], p.idle[i+1:]...)
+				i--
+			}
+		}
+		checked = append(checked, p.idle...)
+		p.m.Unlock()
+
+		for _, c := range expired {
+			p.discard(c, false)
+		}
+
+		var wg sync.WaitGroup
+
+		for _, c := range checked {
+			wg.Add(1)
+			go func(c *Session) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				err := c.s.echo(ctx)
+				cancel()
+
+				if err != nil && p.remove(c) {
+					p.discard(c, true)
+				}
+			}(c)
+		}
+
+		wg.Wait()
+	}
+}
+
+// remove takes c out of the idle sessions, and reports whether it was still there.
+func (p *SessionPool) remove(c *Session) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for i, idle := range p.idle {
+		if idle == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,242 @@
+package smb2
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// newTestPoolSession returns a session on a connection to a testFileServer, which answers ECHO and LOGOFF,
+// or to a server that never answers if dead is set.
+func newTestPoolSession(dead bool) *Session {
+	if !dead {
+		f, _ := newTestFile(1, -1)
+		f.fs.session.dfs = newDFSCache()
+		return &Session{s: f.fs.session, ctx: context.Background()}
+	}
+
+	client, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+
+	c := &conn{
+		t:                   direct(client),
+		outstandingRequests: newOutstandingRequests(),
+		account:             openAccount(8),
+		recvBufferSize:      1024,
+		rdone:               make(chan struct{}, 1),
+		wdone:               make(chan struct{}, 1),
+		write:               make(chan []byte, 1),
+		werr:                make(chan error, 1),
+	}
+
+	s := &session{
+		conn:         c,
+		sessionId:    1,
+		sessionFlags: SMB2_SESSION_FLAG_IS_GUEST,
+		dfs:          newDFSCache(),
+	}
+
+	c.session = s
+	c.enableSession()
+
+	go c.runSender()
+	go c.runReciever()
+
+	return &Session{s: s, ctx: context.Background()}
+}
+
+func waitClosed(t *testing.T, c *Session) {
+	select {
+	case <-c.s.wdone:
+	case <-time.After(time.Second):
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestSessionPool(t *testing.T) {
+	var dialed []*Session
+
+	p := &SessionPool{
+		Dial: func(ctx context.Context) (*Session, error) {
+			c := newTestPoolSession(false)
+			dialed = append(dialed, c)
+			return c, nil
+		},
+		MaxIdle: 1,
+	}
+
+	c1, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dialed) != 2 || c1.s == c2.s {
+		t.Fatalf("expected two sessions, got %d", len(dialed))
+	}
+
+	p.Put(c1)
+	p.Put(c2) // in excess of MaxIdle
+
+	waitClosed(t, c2)
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.s != c1.s || len(dialed) != 2 {
+		t.Error("expected the idle session to be reused")
+	}
+
+	// sessions whose connection is closed aren't reused.
+	p.Put(c)
+	c.s.conn.close()
+	waitClosed(t, c)
+
+	c, err = p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.s == c1.s || len(dialed) != 3 {
+		t.Error("expected a new session")
+	}
+
+	// sessions older than MaxLifetime aren't reused.
+	p.MaxLifetime = time.Minute
+	p.Put(c)
+	p.created[c.s] = time.Now().Add(-2 * time.Minute)
+
+	c3, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c3.s == c.s || len(dialed) != 4 {
+		t.Error("expected a new session")
+	}
+	waitClosed(t, c)
+
+	p.Put(c3)
+
+	if err := p.Close(); err != nil {
+		t.Error(err)
+	}
+	waitClosed(t, c3)
+
+	if _, err := p.Get(context.Background()); err != ErrPoolClosed {
+		t.Errorf("expected %v, got %v", ErrPoolClosed, err)
+	}
+}
+
+func TestSessionPoolHealthCheck(t *testing.T) {
+	p := &SessionPool{
+		Dial: func(ctx context.Context) (*Session, error) {
+			return newTestPoolSession(true), nil
+		},
+		HealthCheckInterval: 10 * time.Millisecond,
+	}
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Put(c)
+
+	// the session doesn't answer ECHO, so that it's dropped.
+	waitClosed(t, c)
+
+	p.m.Lock()
+	n := len(p.idle)
+	p.m.Unlock()
+
+	if n != 0 {
+		t.Errorf("expected no idle session, got %d", n)
+	}
+}
+
+func TestSessionPoolPutTwice(t *testing.T) {
+	p := &SessionPool{
+		Dial: func(ctx context.Context) (*Session, error) {
+			return newTestPoolSession(false), nil
+		},
+	}
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Put(c)
+	p.Put(c)
+
+	if len(p.idle) != 1 {
+		t.Fatalf("expected 1 idle session, got %d", len(p.idle))
+	}
+
+	// a stale handle doesn't give back the session handed out again meanwhile.
+	c2, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.s != c.s {
+		t.Fatal("expected the idle session to be reused")
+	}
+
+	p.Put(c)
+
+	if len(p.idle) != 0 {
+		t.Errorf("expected no idle session, got %d", len(p.idle))
+	}
+
+	p.Put(c2)
+
+	if len(p.idle) != 1 {
+		t.Errorf("expected 1 idle session, got %d", len(p.idle))
+	}
+}
+
+func TestSessionPoolHealthCheckGet(t *testing.T) {
+	dialed := 0
+
+	p := &SessionPool{
+		Dial: func(ctx context.Context) (*Session, error) {
+			dialed++
+			return newTestPoolSession(true), nil
+		},
+		HealthCheckInterval: 50 * time.Millisecond,
+	}
+	defer p.Close()
+
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Put(c)
+
+	// the session is still idle while its ECHO is outstanding, and is left to Get.
+	time.Sleep(75 * time.Millisecond)
+
+	c2, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.s != c.s || dialed != 1 {
+		t.Fatal("expected the idle session to be reused")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if c2.s.isClosed() {
+		t.Error("expected the session in use to be left open")
+	}
+}
@@ -0,0 +1,77 @@
+package smb2
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// SID represents a Windows security identifier. ([MS-DTYP] 2.4.2)
+type SID struct {
+	Revision            uint8
+	IdentifierAuthority uint64 // 48-bit value
+	SubAuthority        []uint32
+}
+
+// String returns the string representation of the SID, e.g. "S-1-5-32-544".
+func (sid *SID) String() string {
+	return sid.sid().String()
+}
+
+func (sid *SID) sid() *Sid {
+	return &Sid{
+		Revision:            sid.Revision,
+		IdentifierAuthority: sid.IdentifierAuthority,
+		SubAuthority:        sid.SubAuthority,
+	}
+}
+
+func newSID(d SidDecoder) *SID {
+	return &SID{
+		Revision:            d.Revision(),
+		IdentifierAuthority: d.IdentifierAuthority(),
+		SubAuthority:        d.SubAuthority(),
+	}
+}
+
+// ParseSID parses the string representation of a SID, e.g. "S-1-5-32-544".
+// The identifier authority may be written in hexadecimal with the "0x" prefix.
+func ParseSID(s string) (*SID, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || len(parts) > 3+15 || (parts[0] != "S" && parts[0] != "s") {
+		return nil, errors.New("invalid SID format: " + s)
+	}
+
+	rev, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, errors.New("invalid SID revision: " + s)
+	}
+
+	var auth uint64
+	if strings.HasPrefix(parts[2], "0x") || strings.HasPrefix(parts[2], "0X") {
+		auth, err = strconv.ParseUint(parts[2][2:], 16, 48)
+	} else {
+		auth, err = strconv.ParseUint(parts[2], 10, 48)
+	}
+	if err != nil {
+		return nil, errors.New("invalid SID identifier authority: " + s)
+	}
+
+	sid := &SID{
+		Revision:            uint8(rev),
+		IdentifierAuthority: auth,
+		SubAuthority:        make([]uint32, len(parts)-3),
+	}
+
+	for i, part := range parts[3:] {
+		a, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.New("invalid SID sub authority: " + s)
+		}
+		sid.SubAuthority[i] = uint32(a)
+	}
+
+	return sid, nil
+}
@@ -0,0 +1,44 @@
+package smb2
+
+import (
+	"testing"
+)
+
+func TestParseSID(t *testing.T) {
+	for _, s := range []string{
+		"S-1-0-0",
+		"S-1-5-18",
+		"S-1-5-32-544",
+		"S-1-5-21-3623811015-3361044348-30300820-1013",
+	} {
+		sid, err := ParseSID(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sid.String() != s {
+			t.Errorf("expected %s, got %s", s, sid.String())
+		}
+	}
+
+	sid, err := ParseSID("S-1-0x000000000005-18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid.String() != "S-1-5-18" {
+		t.Errorf("expected S-1-5-18, got %s", sid.String())
+	}
+
+	for _, s := range []string{
+		"",
+		"S-1",
+		"X-1-5-18",
+		"S-256-5-18",
+		"S-1-5-4294967296",
+		"S-1-5-a",
+	} {
+		_, err := ParseSID(s)
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
@@ -50,3 +50,85 @@ func TestSign(t *testing.T) {
 		t.Error("fail")
 	}
 }
+
+func TestSignGMAC(t *testing.T) {
+	signingKey, err := hex.DecodeString("726d4c454e63516446695457664e5042")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the response of TestSign, with the signature zeroed.
+	pkt, err := hex.DecodeString("fe534d42400001000000000001007f00090000000000000003000000000000000000000000000000020000007bfba3f4041393e756a048c9092c4e52dc7037190900000048000900a1073005a0030a0100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	PacketCodec(pkt).SetSignature(zero[:])
+
+	// the same message as a CANCEL request.
+	cancel := append([]byte{}, pkt...)
+	PacketCodec(cancel).SetCommand(SMB2_CANCEL)
+	PacketCodec(cancel).SetFlags(SMB2_FLAGS_SIGNED)
+
+	for _, tc := range []struct {
+		pkt       []byte
+		signature string
+	}{
+		{pkt, "f3f83d37ded845fd1759036f8cb7b936"},
+		{cancel, "933dad6fa2824e693756fc925c388c69"},
+	} {
+		signature, err := hex.DecodeString(tc.signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := newGMAC(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer.Write(tc.pkt[:32])
+		signer.Write(tc.pkt[32:])
+		if sum := signer.Sum(nil); !bytes.Equal(sum, signature) {
+			t.Errorf("expected %x, got %x", signature, sum)
+		}
+
+		s := &session{signer: signer, verifier: signer}
+
+		signed := s.sign(append([]byte{}, tc.pkt...))
+		if !bytes.Equal(PacketCodec(signed).Signature(), signature) {
+			t.Errorf("expected %x, got %x", signature, PacketCodec(signed).Signature())
+		}
+		if !s.verify(signed) {
+			t.Error("expected signature to be accepted")
+		}
+
+		signed[len(signed)-1] ^= 0xff
+		if s.verify(signed) {
+			t.Error("expected signature to be rejected")
+		}
+	}
+}
+
+func TestNewSigningHash(t *testing.T) {
+	sessionKey := []byte("0123456789abcdef")
+
+	s := &session{conn: &conn{dialect: SMB311}}
+
+	h, err := s.newSigningHash(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.(*gmac); ok {
+		t.Error("expected AES-CMAC if the server didn't negotiate a signing algorithm")
+	}
+
+	s.signingAlgorithmId = AES128GMAC
+
+	h, err = s.newSigningHash(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.(*gmac); !ok {
+		t.Error("expected AES-GMAC")
+	}
+}
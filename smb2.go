@@ -13,8 +13,6 @@ package smb2
 
 import (
 	"encoding/binary"
-	"io/ioutil"
-	"log"
 	"os"
 )
 
@@ -23,13 +21,3 @@ var debug = os.Getenv("DEBUG") != ""
 var zero [16]byte
 
 var be = binary.BigEndian
-
-var logger *log.Logger
-
-func init() {
-	if debug {
-		logger = log.New(os.Stderr, "smb2: ", log.LstdFlags)
-	} else {
-		logger = log.New(ioutil.Discard, "smb2: ", log.LstdFlags)
-	}
-}
@@ -71,6 +71,49 @@ func TestDirFS(t *testing.T) {
 			t.Error("unexpected result")
 		}
 	}
+
+	{
+		dirents, err := iofs.ReadDir(fs.DirFS(testDir), ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(dirents) != 2 || dirents[0].Name() != "hello" || !dirents[0].IsDir() || dirents[0].Type() != iofs.ModeDir || dirents[1].Name() != "hello.txt" || dirents[1].Type() != 0 {
+			t.Errorf("unexpected result: %v", dirents)
+		}
+	}
+
+	{
+		fi, err := iofs.Stat(fs.FS(), path.Join(testDir, "hello/hello2.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Name() != "hello2.txt" || fi.Mode().IsDir() || fi.Size() != 12 {
+			t.Errorf("unexpected result: %v", fi)
+		}
+
+		bs, err := iofs.ReadFile(fs.DirFS(testDir), "hello/hello2.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != "hello world!" {
+			t.Errorf("unexpected content: %q", bs)
+		}
+	}
+
+	for _, name := range []string{"", "/hello", "hello/", "../hello", `hello\hello2.txt`} {
+		_, err := iofs.Stat(fs.DirFS(testDir), name)
+		if perr, ok := err.(*iofs.PathError); !ok || perr.Path != name || perr.Err != iofs.ErrInvalid {
+			t.Errorf("Stat(%q): expected an invalid path error, got %v", name, err)
+		}
+	}
+
+	{
+		_, err := iofs.Stat(fs.DirFS(testDir), "missing.txt")
+		if perr, ok := err.(*iofs.PathError); !ok || perr.Path != "missing.txt" || !os.IsNotExist(err) {
+			t.Errorf("expected a not exist error, got %v", err)
+		}
+	}
 }
 
 func TestGlobFS(t *testing.T) {
@@ -116,7 +159,7 @@ func TestGlobFS(t *testing.T) {
 		},
 		{
 			pattern:  "*/*",
-			expected: []string{`hello\hello2.txt`},
+			expected: []string{"hello/hello2.txt"},
 		},
 	}
 
@@ -13,10 +13,13 @@ import (
 	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hirochachacha/go-smb2"
+	"github.com/hirochachacha/go-smb2/internal/msrpc"
 
 	"testing"
 )
@@ -301,6 +304,60 @@ func TestFile(t *testing.T) {
 	}
 }
 
+func TestLargeWrite(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestLargeWrite", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\large`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// larger than the maximum write size of common servers (1 MiB - 8 MiB)
+	bs := make([]byte, 20*1024*1024+123)
+	for i := range bs {
+		bs[i] = byte(i * 7 / 5)
+	}
+
+	_, err = f.Write([]byte("head"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Write(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bs) {
+		t.Error("unexpected size:", n)
+	}
+
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(4+len(bs)) {
+		t.Error("unexpected offset:", off)
+	}
+
+	rs := make([]byte, len(bs))
+	_, err = f.ReadAt(rs, 4)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, rs) {
+		t.Error("content mismatch")
+	}
+}
+
 func TestSymlink(t *testing.T) {
 	if fs == nil {
 		t.Skip()
@@ -354,6 +411,28 @@ func TestSymlink(t *testing.T) {
 			t.Error("unexpected target:", target)
 		}
 
+		lf, err := fs.OpenFileWith(testDir+`\linkToTestFile`, os.O_RDONLY, 0, &smb2.OpenOptions{NoFollow: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lf.Close()
+
+		rp, err := lf.ReadReparsePoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rp.Tag != smb2.ReparseTagSymlink {
+			t.Errorf("unexpected reparse tag: %#x", rp.Tag)
+		}
+
+		target, err = lf.Readlink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != testDir+`\testFile` {
+			t.Error("unexpected target:", target)
+		}
+
 		f, err = fs.Open(testDir + `\linkToTestFile`)
 		if err == nil { // if it supports follow-symlink
 			bs, err := ioutil.ReadAll(f)
@@ -442,236 +521,2440 @@ func TestIsXXX(t *testing.T) {
 	}
 }
 
-func TestRename(t *testing.T) {
+func TestOpenFileWith(t *testing.T) {
 	if fs == nil {
 		t.Skip()
 	}
-	testDir := fmt.Sprintf("testDir-%d-TestRename", os.Getpid())
+	testDir := fmt.Sprintf("testDir-%d-TestOpenFileWith", os.Getpid())
 	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer fs.RemoveAll(testDir)
 
-	f, err := fs.Create(testDir + `\old`)
+	err = fs.WriteFile(testDir+`\file`, []byte("aaa"), 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = f.Write([]byte("testContent"))
+
+	_, err = fs.OpenFileWith(testDir+`\file`, os.O_RDONLY, 0, &smb2.OpenOptions{Directory: true})
+	if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrNotDirectory {
+		t.Error("unexpected error:", err)
+	}
+
+	_, err = fs.OpenFileWith(testDir, os.O_RDONLY, 0, &smb2.OpenOptions{NonDirectory: true})
+	if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrIsDirectory {
+		t.Error("unexpected error:", err)
+	}
+
+	f, err := fs.OpenFileWith(testDir, os.O_RDONLY, 0, &smb2.OpenOptions{Directory: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = f.Close()
-	if err != nil {
-		fs.Remove(testDir + `\old`)
+	f.Close()
 
+	f, err = fs.OpenFileWith(testDir+`\file`, os.O_RDONLY, 0, &smb2.OpenOptions{NonDirectory: true})
+	if err != nil {
 		t.Fatal(err)
 	}
+	f.Close()
+}
 
-	err = fs.Rename(testDir+`\old`, testDir+`\new`)
+func TestExists(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestExists", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
-		fs.Remove(testDir + `\old`)
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
 
+	err = fs.WriteFile(testDir+`\file`, []byte("aaa"), 0666)
+	if err != nil {
 		t.Fatal(err)
 	}
-	defer fs.Remove(testDir + `\new`)
 
-	_, err = fs.Stat(testDir + `\old`)
-	if os.IsExist(err) {
-		t.Error("unexpected error:", err)
+	for _, name := range []string{testDir, testDir + `\file`} {
+		ok, err := fs.Exists(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("%s should exist", name)
+		}
 	}
-	f, err = fs.Open(testDir + `\new`)
+
+	for _, name := range []string{testDir + `\missing`, testDir + `\missing\file`} {
+		ok, err := fs.Exists(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("%s should not exist", name)
+		}
+	}
+
+	isDir, err := fs.IsDir(testDir)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer f.Close()
-	bs, err := ioutil.ReadAll(f)
+	if !isDir {
+		t.Error("directory is not reported as directory")
+	}
+
+	isDir, err = fs.IsDir(testDir + `\file`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if string(bs) != "testContent" {
-		t.Error("unexpected content:", string(bs))
+	if isDir {
+		t.Error("file is reported as directory")
+	}
+
+	_, err = fs.IsDir(testDir + `\missing`)
+	if !os.IsNotExist(err) {
+		t.Error("unexpected error:", err)
 	}
 }
 
-func TestChtimes(t *testing.T) {
+func TestOpenUnbuffered(t *testing.T) {
 	if fs == nil {
 		t.Skip()
 	}
-	testDir := fmt.Sprintf("testDir-%d-TestChtimes", os.Getpid())
+	testDir := fmt.Sprintf("testDir-%d-TestOpenUnbuffered", os.Getpid())
 	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer fs.RemoveAll(testDir)
 
-	f, err := fs.Create(testDir + `\testFile`)
+	data := make([]byte, 8192)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	err = fs.WriteFile(testDir+`\file`, data, 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = f.Close()
+
+	f, err := fs.OpenFileWith(testDir+`\file`, os.O_RDONLY, 0, &smb2.OpenOptions{Unbuffered: true})
 	if err != nil {
-		fs.Remove(testDir + `\testFile`)
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("unbuffered read is not supported")
+		}
+		t.Fatal(err)
+	}
+	defer f.Close()
 
+	bs := make([]byte, 4096)
+	n, err := f.ReadAt(bs, 4096)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if !bytes.Equal(bs[:n], data[4096:]) {
+		t.Error("unexpected content")
+	}
+}
 
-	atime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+func TestOpenTwice(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestOpenTwice", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
-	mtime, err := time.Parse(time.RFC3339, "2006-03-08T19:32:05Z")
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("0123456789"), 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = fs.Chtimes(testDir+`\testFile`, atime, mtime)
+	f1, err := fs.Open(testDir + `\file`)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer f1.Close()
 
-	stat, err := fs.Stat(testDir + `\testFile`)
+	f2, err := fs.Open(testDir + `\file`)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer f2.Close()
+
+	bs := make([]byte, 3)
+
+	for _, tc := range []struct {
+		f      *smb2.File
+		expect string
+	}{
+		{f1, "012"},
+		{f2, "012"},
+		{f1, "345"},
+		{f1, "678"},
+		{f2, "345"},
+	} {
+		_, err = io.ReadFull(tc.f, bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != tc.expect {
+			t.Errorf("expected %q, got %q", tc.expect, bs)
+		}
+	}
 
-	if !stat.ModTime().Equal(mtime) {
-		t.Error("unexpected mtime:", stat.ModTime())
+	// closing one handle must not invalidate the other.
+	err = f1.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = io.ReadFull(f2, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "678" {
+		t.Errorf("expected %q, got %q", "678", bs)
 	}
 }
 
-func TestChmod(t *testing.T) {
+func TestCopySparse(t *testing.T) {
 	if fs == nil {
 		t.Skip()
 	}
-	testDir := fmt.Sprintf("testDir-%d-TestChmod", os.Getpid())
+	testDir := fmt.Sprintf("testDir-%d-TestCopySparse", os.Getpid())
 	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer fs.RemoveAll(testDir)
 
-	f, err := fs.Create(testDir + `\testFile`)
+	f, err := fs.Create(testDir + `\src`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer fs.Remove(testDir + `\testFile`)
-	defer f.Close()
 
-	stat, err := f.Stat()
+	sparse := true
+
+	err = f.SetSparse(true)
 	if err != nil {
-		t.Fatal(err)
+		if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrNotSupported {
+			t.Fatal(err)
+		}
+		sparse = false
 	}
-	if stat.Mode() != 0666 {
-		t.Error("unexpected mode:", stat.Mode())
+
+	_, err = f.WriteAt([]byte("head"), 0)
+	if err != nil {
+		t.Fatal(err)
 	}
-	err = f.Chmod(0444)
+
+	_, err = f.WriteAt([]byte("tail"), 4*1024*1024)
 	if err != nil {
 		t.Fatal(err)
 	}
-	stat, err = f.Stat()
+
+	err = f.Close()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if stat.Mode() != 0444 {
-		t.Error("unexpected mode:", stat.Mode())
+
+	err = fs.CopySparse(testDir+`\src`, testDir+`\dst`)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestListSharenames(t *testing.T) {
-	if session == nil {
-		t.Skip()
+	src, err := fs.ReadFile(testDir + `\src`)
+	if err != nil {
+		t.Fatal(err)
 	}
-	names, err := session.ListSharenames()
+
+	dst, err := fs.ReadFile(testDir + `\dst`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sort.Strings(names)
-	for _, expected := range []string{"IPC$", "tmp", "tmp2"} {
-		found := false
-		for _, name := range names {
-			if name == expected {
-				found = true
-				break
-			}
+
+	if !bytes.Equal(src, dst) {
+		t.Error("copied content differs")
+	}
+
+	// the holes of src are holes of dst.
+	allocatedRanges := func(name string) []smb2.FileRange {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if !found {
-			t.Errorf("couldn't find share name %s in %v", expected, names)
+		defer f.Close()
+
+		ranges, err := f.QueryAllocatedRanges()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ranges
+	}
+
+	srcRanges := allocatedRanges(testDir + `\src`)
+	dstRanges := allocatedRanges(testDir + `\dst`)
+
+	if !reflect.DeepEqual(srcRanges, dstRanges) {
+		t.Errorf("allocated ranges differ: %v and %v", srcRanges, dstRanges)
+	}
+
+	if sparse {
+		var allocated int64
+		for _, r := range dstRanges {
+			allocated += r.Length
+		}
+		if allocated >= int64(len(dst)) {
+			t.Errorf("expected a hole in the copy, got allocated ranges %v", dstRanges)
 		}
 	}
 }
 
-func TestServerSideCopy(t *testing.T) {
+func TestExtendedAttributes(t *testing.T) {
 	if fs == nil {
 		t.Skip()
 	}
-
-	testDir := fmt.Sprintf("testDir-%d-TestServerSideCopy", os.Getpid())
+	testDir := fmt.Sprintf("testDir-%d-TestExtendedAttributes", os.Getpid())
 	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer fs.RemoveAll(testDir)
 
-	err = fs.WriteFile(join(testDir, "src.txt"), []byte("hello world!"), 0666)
+	f, err := fs.Create(testDir + `\file`)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sf, err := fs.Open(join(testDir, "src.txt"))
+	defer f.Close()
+
+	err = f.SetEA("comment", []byte("hello\x00world"))
 	if err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err == smb2.ErrNotSupported {
+			t.Skip("extended attributes are not supported")
+		}
 		t.Fatal(err)
 	}
-	defer sf.Close()
 
-	df, err := fs.Create(join(testDir, "dst.txt"))
+	value, err := f.GetEA("comment")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer df.Close()
+	if string(value) != "hello\x00world" {
+		t.Errorf("unexpected value: %q", value)
+	}
 
-	_, err = io.Copy(df, sf)
+	eas, err := f.ListEA()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if len(eas) != 1 || !strings.EqualFold(eas[0].Name, "comment") || string(eas[0].Value) != "hello\x00world" {
+		t.Errorf("unexpected EAs: %v", eas)
 	}
 
-	bs, err := fs.ReadFile(join(testDir, "dst.txt"))
+	err = f.SetEA("comment", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if string(bs) != "hello world!" {
-		t.Error("unexpected content")
+	_, err = f.GetEA("comment")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+
+	err = f.SetEA("a=b", []byte("x"))
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != os.ErrInvalid {
+		t.Errorf("expected an invalid argument error, got %v", err)
 	}
 }
 
-func TestRemoveAll(t *testing.T) {
+func TestWatch(t *testing.T) {
 	if fs == nil {
 		t.Skip()
 	}
-
-	testDir := fmt.Sprintf("testDir-%d-TestRemoveAll", os.Getpid())
+	testDir := fmt.Sprintf("testDir-%d-TestWatch", os.Getpid())
 	err := fs.Mkdir(testDir, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = fs.WriteFile(join(testDir, "hello.txt"), []byte("hello world!"), 0666)
+	defer fs.RemoveAll(testDir)
+
+	err = fs.Mkdir(testDir+`\sub`, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = fs.Mkdir(join(testDir, "hello"), 0755)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := fs.Watch(ctx, testDir, true, smb2.NotifyFileName|smb2.NotifyDirName)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = fs.WriteFile(join(testDir, "hello", "hello.txt"), []byte("hello world!"), 0444)
+
+	err = fs.WriteFile(testDir+`\sub\testFile`, []byte("test"), 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = fs.RemoveAll(testDir)
+
+	timeout := time.After(10 * time.Second)
+
+	for found := false; !found; {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				t.Fatal("watch ended unexpectedly")
+			}
+			if ev.Err != nil {
+				t.Fatal(ev.Err)
+			}
+			found = ev.Action == smb2.NotifyAdded && ev.Name == `sub\testFile` || ev.Action == smb2.NotifyResync
+		case <-timeout:
+			t.Fatal("no event")
+		}
+	}
+
+	cancel()
+
+	for ev := range events {
+		if ev.Err != nil {
+			t.Error(ev.Err)
+		}
+	}
+
+	_, err = fs.Watch(ctx, testDir+`\sub\testFile`, false, smb2.NotifyFileName)
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != smb2.ErrNotDirectory {
+		t.Errorf("expected ErrNotDirectory, got %v", err)
+	}
+}
+
+func TestSparse(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestSparse", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.SetSparse(true)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err == smb2.ErrNotSupported {
+			t.Skip("sparse files are not supported")
+		}
+		t.Fatal(err)
+	}
+
+	const size = 64 * 1024 * 1024
+
+	err = f.Truncate(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.WriteAt([]byte("tail"), size-4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := f.QueryAllocatedRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) == 0 {
+		t.Fatal("expected an allocated range")
+	}
+
+	var allocated int64
+	for _, r := range ranges {
+		if r.Offset < 0 || r.Length <= 0 || r.Offset+r.Length > size {
+			t.Errorf("unexpected range: %+v", r)
+		}
+		allocated += r.Length
+	}
+	if last := ranges[len(ranges)-1]; last.Offset+last.Length != size {
+		t.Errorf("expected the tail to be allocated, got %+v", ranges)
+	}
+	if allocated == size {
+		t.Errorf("expected a hole, got %+v", ranges)
+	}
+}
+
+func TestGrantedAccess(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestGrantedAccess", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("aaa"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		fileReadData  = 0x1
+		fileWriteData = 0x2
+	)
+
+	f, err := fs.Open(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	access, err := f.GrantedAccess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access&fileReadData == 0 || access&fileWriteData != 0 {
+		t.Errorf("unexpected access mask for read-only handle: %#x", access)
+	}
+
+	wf, err := fs.OpenFile(testDir+`\file`, os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wf.Close()
+
+	access, err = wf.GrantedAccess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access&fileWriteData == 0 {
+		t.Errorf("unexpected access mask for writable handle: %#x", access)
+	}
+}
+
+func TestCrossServerCopy(t *testing.T) {
+	if fs == nil || rfs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestCrossServerCopy", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = rfs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rfs.RemoveAll(testDir)
+
+	data := make([]byte, 5*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	err = fs.WriteFile(testDir+`\src`, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := fs.Open(testDir + `\src`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst, err := rfs.Create(testDir + `\dst`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	_, err = dst.Write([]byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := smb2.CrossServerCopy(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes copied, got %d", len(data), n)
+	}
+
+	off, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(len("header")+len(data)) {
+		t.Errorf("unexpected offset after copy: %d", off)
+	}
+
+	bs, err := rfs.ReadFile(testDir + `\dst`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, append([]byte("header"), data...)) {
+		t.Error("copied content differs")
+	}
+}
+
+func TestListHardlinks(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestListHardlinks", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("aaa"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	names, err := f.ListHardlinks()
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("hard link information is not supported")
+		}
+		t.Fatal(err)
+	}
+
+	if len(names) != 1 || !strings.EqualFold(names[0], testDir+`\file`) {
+		t.Errorf("unexpected hard links: %q", names)
+	}
+}
+
+func TestOpenSection(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestOpenSection", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("0123456789"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		off, n int64
+		expect string
+	}{
+		{2, 3, "234"},
+		{0, 10, "0123456789"},
+		{7, 10, "789"},
+		{12, 3, ""},
+	} {
+		r, err := fs.OpenSection(testDir+`\file`, tc.off, tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != tc.expect {
+			t.Errorf("section (%d, %d): expected %q, got %q", tc.off, tc.n, tc.expect, bs)
+		}
+
+		err = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := fs.Open(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bs := make([]byte, 5)
+	n, err := f.ReadAt(bs, 8)
+	if n != 2 || err != io.EOF {
+		t.Errorf("unexpected short ReadAt result: %d, %v", n, err)
+	}
+}
+
+func TestSectorSizeInfo(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	info, err := fs.SectorSizeInfo()
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("sector size information is not supported")
+		}
+		t.Fatal(err)
+	}
+
+	if info.LogicalBytesPerSector == 0 || info.PhysicalBytesPerSectorForPerformance < info.LogicalBytesPerSector {
+		t.Errorf("unexpected sector size information: %+v", info)
+	}
+}
+
+func TestVolumeInfo(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	info, err := fs.VolumeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.FileSystemName == "" || info.MaxComponentLength <= 0 {
+		t.Errorf("unexpected volume information: %+v", info)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	snapshots, err := fs.Snapshots("")
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("snapshots are not supported")
+		}
+		t.Fatal(err)
+	}
+	if len(snapshots) == 0 {
+		t.Skip("no snapshots")
+	}
+
+	f, err := fs.OpenFileWith("", os.O_RDONLY, 0, &smb2.OpenOptions{Snapshot: snapshots[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Readdir(-1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShareSync(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestShareSync", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte("aaa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	entries, err := fs.GetQuota(nil)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err == smb2.ErrNotSupported {
+			t.Skip("quotas are not supported")
+		}
+		t.Fatal(err)
+	}
+
+	for _, e := range entries {
+		if e.SID == nil {
+			t.Fatal("unexpected nil SID")
+		}
+		if e.QuotaUsed < 0 {
+			t.Errorf("unexpected quota used for %s: %d", e.SID, e.QuotaUsed)
+		}
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	sids := []smb2.SID{*entries[0].SID}
+
+	entries2, err := fs.GetQuota(sids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries2) != 1 || entries2[0].SID.String() != sids[0].String() {
+		t.Errorf("unexpected quota entries: %v", entries2)
+	}
+}
+
+func TestOpenImpersonationLevel(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestOpenImpersonationLevel", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []smb2.ImpersonationLevel{0, smb2.SecurityImpersonation, smb2.SecurityDelegation} {
+		f, err := fs.OpenFileWith(testDir+`\file`, os.O_RDONLY, 0, &smb2.OpenOptions{ImpersonationLevel: level})
+		if err != nil {
+			t.Fatalf("impersonation level %d: %v", level, err)
+		}
+		f.Close()
+	}
+
+	_, err = fs.OpenFileWith(testDir+`\file`, os.O_RDONLY, 0, &smb2.OpenOptions{ImpersonationLevel: 5})
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != os.ErrInvalid {
+		t.Errorf("expected os.ErrInvalid, got %v", err)
+	}
+}
+
+func TestAlignmentInfo(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestAlignmentInfo", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\file`, []byte("aaa"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mask, err := f.AlignmentInfo()
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("alignment information is not supported")
+		}
+		t.Fatal(err)
+	}
+
+	// the alignment is a power of two.
+	if mask&(mask+1) != 0 {
+		t.Errorf("unexpected alignment requirement: %#x", mask)
+	}
+}
+
+func TestNegotiatedDialect(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	switch d := session.NegotiatedDialect(); d {
+	case smb2.DialectSMB202, smb2.DialectSMB210, smb2.DialectSMB300, smb2.DialectSMB302, smb2.DialectSMB311:
+	default:
+		t.Errorf("unexpected dialect: %#x", d)
+	}
+
+	if session.NegotiatedDialect() < smb2.DialectSMB300 && session.Capabilities()&smb2.CapabilityEncryption != 0 {
+		t.Error("unexpected encryption capability for SMB 2.x")
+	}
+
+	if session.MaxReadSize() < 64*1024 || session.MaxWriteSize() < 64*1024 || session.MaxTransactSize() < 64*1024 {
+		t.Errorf("unexpected max sizes: %d, %d, %d", session.MaxReadSize(), session.MaxWriteSize(), session.MaxTransactSize())
+	}
+}
+
+func TestEcho(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	if err := session.Echo(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRejectGuest(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := *dialer
+	d.RejectGuest = true
+
+	c, err := d.Dial(conn)
+	if session.IsGuest() {
+		if err != smb2.ErrGuestSession {
+			t.Errorf("expected %v, got %v", smb2.ErrGuestSession, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logoff()
+
+	if c.IsGuest() {
+		t.Error("expected a non-guest session")
+	}
+}
+
+func TestDialRequireEncryption(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := *dialer
+	d.RequireEncryption = true
+
+	c, err := d.Dial(conn)
+	if err == smb2.ErrEncryptionNotSupported {
+		t.Skip("encryption not supported by server")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logoff()
+
+	fs, err := c.Mount(cfg.TreeConn.Share1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Umount()
+
+	if _, err := fs.Stat(""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadAheadWindow(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	d := *dialer
+	d.ReadAheadWindow = 4
+
+	c, err := d.Dial(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logoff()
+
+	share, err := c.Mount(cfg.TreeConn.Share1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer share.Umount()
+
+	testFile := fmt.Sprintf("testFile-%d-TestReadAheadWindow", os.Getpid())
+
+	// larger than the max read size of Windows (1 MiB), so that the reads are split into several chunks.
+	data := make([]byte, 3*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	err = share.WriteFile(testFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer share.Remove(testFile)
+
+	f, err := share.Open(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the read stops at EOF in the middle of the chunks.
+	bs := make([]byte, len(data)+2*1024*1024)
+	n, err := f.ReadAt(bs, 0)
+	if err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+	if n != len(data) || !bytes.Equal(bs[:n], data) {
+		t.Errorf("unexpected content: %d bytes", n)
+	}
+
+	var buf bytes.Buffer
+
+	_, err = io.Copy(&buf, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("unexpected content")
+	}
+}
+
+func TestUmountTwice(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	fs, err := session.Mount(cfg.TreeConn.Share1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Umount(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Umount(); err != nil {
+		t.Errorf("expected the second Umount to do nothing, got %v", err)
+	}
+}
+
+func TestAddChannel(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c, err := dialer.Dial(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logoff()
+
+	conn2, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	err = c.AddChannel(conn2)
+	if err == smb2.ErrNotSupported {
+		t.Skip("multichannel is not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.NumChannels(); n != 2 {
+		t.Errorf("expected 2 channels, got %d", n)
+	}
+
+	fs1, err := c.Mount(cfg.TreeConn.Share1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs1.Umount()
+
+	testDir := fmt.Sprintf("testDir-%d-TestAddChannel", os.Getpid())
+	err = fs1.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs1.RemoveAll(testDir)
+
+	data := make([]byte, 4*1024*1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	err = fs1.WriteFile(testDir+`\testFile`, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := fs1.ReadFile(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, data) {
+		t.Error("unexpected content")
+	}
+}
+
+func TestDurableHandle(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	// the handle can only be reclaimed by a client with the same client GUID.
+	d := *dialer
+	d.Negotiator.StableClientGuid = true
+
+	dial := func() (net.Conn, *smb2.Share) {
+		conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := d.Dial(conn)
+		if err != nil {
+			conn.Close()
+			t.Fatal(err)
+		}
+
+		fs, err := c.Mount(cfg.TreeConn.Share1)
+		if err != nil {
+			conn.Close()
+			t.Fatal(err)
+		}
+
+		return conn, fs
+	}
+
+	conn1, fs1 := dial()
+	defer conn1.Close()
+
+	testDir := fmt.Sprintf("testDir-%d-TestDurableHandle", os.Getpid())
+	err := fs1.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs1.OpenFileWith(testDir+`\testFile`, os.O_RDWR|os.O_CREATE, 0666, &smb2.OpenOptions{Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.IsDurable() {
+		f.Close()
+		t.Skip("durable handles are not supported")
+	}
+
+	_, err = f.Write([]byte("durable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lose the connection without closing the handle.
+	conn1.Close()
+
+	conn2, fs2 := dial()
+	defer conn2.Close()
+
+	err = f.Reconnect(fs2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	bs := make([]byte, 7)
+	_, err = f.ReadAt(bs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "durable" {
+		t.Errorf("unexpected content: %q", bs)
+	}
+
+	// a handle which isn't durable can't be reclaimed.
+	g, err := fs2.Open(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	err = g.Reconnect(fs2)
+	if err == nil {
+		t.Error("expected error for a handle which isn't durable")
+	}
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	testDir := fmt.Sprintf("testDir-%d-TestSecurityDescriptor", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	name := testDir + `\testFile`
+
+	err = fs.WriteFile(name, []byte("test"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := smb2.OwnerSecurityInformation | smb2.GroupSecurityInformation | smb2.DACLSecurityInformation
+
+	sd, err := fs.SecurityDescriptor(name, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Owner == nil || sd.Group == nil {
+		t.Fatalf("expected owner and group, got %+v", sd)
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sd2, err := f.SecurityDescriptor(smb2.OwnerSecurityInformation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd2.Owner.String() != sd.Owner.String() || sd2.Group != nil || sd2.DACL != nil {
+		t.Errorf("expected only owner %s, got %+v", sd.Owner, sd2)
+	}
+
+	// add an ACE denying the builtin guests, and set the DACL back.
+	guests, err := smb2.ParseSID("S-1-5-32-546")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sd.DACL == nil {
+		sd.DACL = new(smb2.ACL)
+	}
+	sd.DACL.ACEs = append([]smb2.ACE{{Type: smb2.AccessDeniedACE, Mask: 0x10000, SID: guests}}, sd.DACL.ACEs...) // DELETE
+
+	err = fs.SetSecurityDescriptor(name, smb2.DACLSecurityInformation, sd)
+	if err != nil {
+		if os.IsPermission(err) {
+			t.Skip("permission denied")
+		}
+		t.Fatal(err)
+	}
+
+	sd3, err := fs.SecurityDescriptor(name, smb2.DACLSecurityInformation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, ace := range sd3.DACL.ACEs {
+		if ace.Type == smb2.AccessDeniedACE && ace.SID.String() == guests.String() {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected an ACE denying %s, got %+v", guests, sd3.DACL.ACEs)
+	}
+}
+
+func TestSessionServerSideCopy(t *testing.T) {
+	if session == nil || fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestSessionServerSideCopy", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	data := make([]byte, 17*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	err = fs.WriteFile(testDir+`\src`, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := fs.Open(testDir + `\src`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	_, err = src.Seek(100, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := fs.Create(testDir + `\dst`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	_, err = dst.Write([]byte("header"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := session.ServerSideCopy(context.Background(), src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)-100) {
+		t.Errorf("expected %d bytes copied, got %d", len(data)-100, n)
+	}
+
+	off, err := dst.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != int64(len("header")+len(data)-100) {
+		t.Errorf("unexpected offset after copy: %d", off)
+	}
+
+	bs, err := fs.ReadFile(testDir + `\dst`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, append([]byte("header"), data[100:]...)) {
+		t.Error("unexpected content")
+	}
+}
+
+func TestNormalizedName(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestNormalizedName", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\MixedCase`, []byte("aaa"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open(testDir + `\MixedCase`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	name, err := f.NormalizedName()
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("FileNormalizedNameInformation is not supported")
+		}
+		t.Fatal(err)
+	}
+	if name != testDir+`\MixedCase` {
+		t.Error("unexpected name:", name)
+	}
+}
+
+func TestRequestResiliency(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestRequestResiliency", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.RequestResiliency(10 * time.Second)
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok && e.Err == smb2.ErrNotSupported {
+			t.Skip("resilient handles are not supported")
+		}
+		t.Fatal(err)
+	}
+	if !f.IsResilient() {
+		t.Error("handle should be resilient")
+	}
+}
+
+func TestLock(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestLock", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f1, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+
+	f2, err := fs.OpenFile(testDir+`\file`, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	err = f1.Lock(0, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f2.Lock(5, 10, true)
+	if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrLockNotGranted {
+		t.Error("unexpected error:", err)
+	}
+
+	err = f2.LockWait(5, 10, true, 100*time.Millisecond)
+	if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrLockTimeout {
+		t.Error("unexpected error:", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f1.Unlock(0, 10)
+	}()
+
+	err = f2.LockWait(5, 10, true, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f2.Unlock(5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStatSys(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestFileStatSys", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, ok := fi.Sys().(*smb2.FileStat)
+	if !ok {
+		t.Fatalf("unexpected type: %T", fi.Sys())
+	}
+	if stat.NumberOfLinks != 1 {
+		t.Error("unexpected number of links:", stat.NumberOfLinks)
+	}
+	if stat.Directory || stat.DeletePending {
+		t.Error("unexpected flags:", stat.Directory, stat.DeletePending)
+	}
+}
+
+func TestStatAll(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestStatAll", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\file`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.StatAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.EndOfFile != 5 || fi.NumberOfLinks != 1 || fi.Directory {
+		t.Errorf("unexpected info: %+v", fi)
+	}
+	if !strings.HasSuffix(fi.FileName, testDir+`\file`) {
+		t.Errorf("unexpected name: %s", fi.FileName)
+	}
+}
+
+func TestFileRenameReplace(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestFileRenameReplace", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\target`, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.OpenFileWith(testDir+`\tmp`, os.O_RDWR|os.O_CREATE, 0644, &smb2.OpenOptions{DeleteAccess: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Rename(testDir+`\target`, false)
+	if !os.IsExist(err) {
+		t.Errorf("expected an existing file error, got %v", err)
+	}
+
+	err = f.Rename(testDir+`\target`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := fs.ReadFile(testDir + `\target`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "new" {
+		t.Errorf("unexpected content: %s", bs)
+	}
+
+	err = fs.Link(testDir+`\target`, testDir+`\link`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Link(testDir+`\target`, testDir+`\link`)
+	if !os.IsExist(err) {
+		t.Errorf("expected an existing file error, got %v", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fi.Sys().(*smb2.FileStat).NumberOfLinks; n != 2 {
+		t.Errorf("expected 2 links, got %d", n)
+	}
+}
+
+func TestSetAllocationSize(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestSetAllocationSize", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.OpenFileWith(testDir+`\log`, os.O_RDWR|os.O_CREATE, 0644, &smb2.OpenOptions{AllocationSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := f.StatAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.EndOfFile != 0 || info.AllocationSize < 1<<20 {
+		t.Errorf("unexpected sizes: %d, %d", info.EndOfFile, info.AllocationSize)
+	}
+
+	err = f.SetAllocationSize(4 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = f.StatAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.EndOfFile != 0 || info.AllocationSize < 4<<20 {
+		t.Errorf("unexpected sizes: %d, %d", info.EndOfFile, info.AllocationSize)
+	}
+
+	err = f.SetAllocationSize(-1)
+	if err != os.ErrInvalid {
+		t.Errorf("expected %v, got %v", os.ErrInvalid, err)
+	}
+}
+
+func TestOpenWith(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestOpenWith", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	opts := smb2.CreateOptions{
+		DesiredAccess:     smb2.AccessGenericRead | smb2.AccessGenericWrite,
+		ShareAccess:       smb2.ShareAccessRead,
+		CreateDisposition: smb2.DispositionCreate,
+		CreateOptions:     smb2.CreateNonDirectoryFile,
+		FileAttributes:    smb2.AttributeHidden,
+	}
+
+	f, err := fs.OpenWith(testDir+`\file`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = fs.OpenWith(testDir+`\file`, opts)
+	if !os.IsExist(err) {
+		t.Errorf("expected an existing file error, got %v", err)
+	}
+
+	// the file isn't shared for writing.
+	_, err = fs.OpenWith(testDir+`\file`, smb2.CreateOptions{
+		DesiredAccess: smb2.AccessGenericWrite,
+		ShareAccess:   smb2.ShareAccessRead | smb2.ShareAccessWrite,
+	})
+	if err == nil {
+		t.Error("expected a sharing violation")
+	}
+
+	info, err := f.StatAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.FileAttributes&uint32(smb2.AttributeHidden) == 0 {
+		t.Errorf("expected a hidden file, got attributes %#x", info.FileAttributes)
+	}
+
+	tmp, err := fs.OpenWith(testDir+`\tmp`, smb2.CreateOptions{
+		DesiredAccess:     smb2.AccessGenericWrite | smb2.AccessDelete,
+		CreateDisposition: smb2.DispositionOpenIf,
+		CreateOptions:     smb2.CreateDeleteOnClose,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat(testDir + `\tmp`)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	_, err = fs.OpenWith(testDir+`\file`, smb2.CreateOptions{CreateDisposition: -1})
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != os.ErrInvalid {
+		t.Errorf("expected an invalid argument error, got %v", err)
+	}
+}
+
+func TestDeleteOnClose(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestDeleteOnClose", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.OpenFileWith(testDir+`\tmp`, os.O_RDWR|os.O_CREATE, 0644, &smb2.OpenOptions{DeleteOnClose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = f.Write([]byte("scratch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat(testDir + `\tmp`)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	for _, del := range []bool{true, false} {
+		f, err := fs.OpenFileWith(testDir+`\file`, os.O_RDWR|os.O_CREATE, 0644, &smb2.OpenOptions{DeleteAccess: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = f.SetDeleteOnClose(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !del {
+			err = f.SetDeleteOnClose(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = fs.Stat(testDir + `\file`)
+		if del && !os.IsNotExist(err) {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+		if !del && err != nil {
+			t.Errorf("expected the file to be kept, got %v", err)
+		}
+	}
+}
+
+func TestReaddirPattern(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestReaddirPattern", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.log"} {
+		err = fs.WriteFile(testDir+`\`+name, []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := fs.Open(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var names []string
+	for {
+		fi, err := d.ReaddirPattern("*.txt", 2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fi) > 2 {
+			t.Errorf("expected up to 2 entries, got %d", len(fi))
+		}
+		for _, st := range fi {
+			names = append(names, st.Name())
+		}
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	// another pattern restarts the enumeration.
+	fi, err := d.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fi) != 4 {
+		t.Errorf("expected 4 entries, got %d", len(fi))
+	}
+
+	fi, err = d.ReaddirPattern("*.go", 1)
+	if err != io.EOF || len(fi) != 0 {
+		t.Errorf("expected io.EOF, got %v, %v", fi, err)
+	}
+}
+
+func TestReaddirInfoClass(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestReaddirInfoClass", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\a long file name.txt`, []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open(testDir + `\a long file name.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := f.Stat()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, class := range []smb2.DirectoryInfoClass{0, smb2.DirectoryInfo, smb2.FullDirectoryInfo, smb2.BothDirectoryInfo, smb2.IdFullDirectoryInfo, smb2.IdBothDirectoryInfo} {
+		d, err := fs.OpenFileWith(testDir, os.O_RDONLY, 0, &smb2.OpenOptions{DirectoryInfo: class})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := d.Readdir(-1)
+		d.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fi) != 1 || fi[0].Name() != "a long file name.txt" || fi[0].Size() != 4 {
+			t.Fatalf("unexpected entries: %v", fi)
+		}
+
+		id := fi[0].Sys().(*smb2.FileStat).IndexNumber
+		switch class {
+		case 0, smb2.IdFullDirectoryInfo, smb2.IdBothDirectoryInfo:
+			if id != st.Sys().(*smb2.FileStat).IndexNumber {
+				t.Errorf("expected file id %d, got %d", st.Sys().(*smb2.FileStat).IndexNumber, id)
+			}
+		default:
+			if id != 0 {
+				t.Errorf("expected no file id, got %d", id)
+			}
+		}
+	}
+
+	_, err = fs.OpenFileWith(testDir, os.O_RDONLY, 0, &smb2.OpenOptions{DirectoryInfo: -1})
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != os.ErrInvalid {
+		t.Errorf("expected an invalid argument error, got %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestRename", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\old`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte("testContent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		fs.Remove(testDir + `\old`)
+
+		t.Fatal(err)
+	}
+
+	err = fs.Rename(testDir+`\old`, testDir+`\new`)
+	if err != nil {
+		fs.Remove(testDir + `\old`)
+
+		t.Fatal(err)
+	}
+	defer fs.Remove(testDir + `\new`)
+
+	_, err = fs.Stat(testDir + `\old`)
+	if os.IsExist(err) {
+		t.Error("unexpected error:", err)
+	}
+	f, err = fs.Open(testDir + `\new`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	bs, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "testContent" {
+		t.Error("unexpected content:", string(bs))
+	}
+}
+
+func TestSetTimes(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestSetTimes", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	before, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	mtime := time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	err = f.SetTimes(ctime, time.Time{}, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := after.Sys().(*smb2.FileStat)
+	if !st.CreationTime.Equal(ctime) {
+		t.Errorf("expected creation time %v, got %v", ctime, st.CreationTime)
+	}
+	if !st.LastWriteTime.Equal(mtime) {
+		t.Errorf("expected last write time %v, got %v", mtime, st.LastWriteTime)
+	}
+	if atime := before.Sys().(*smb2.FileStat).LastAccessTime; !st.LastAccessTime.Equal(atime) {
+		t.Errorf("expected last access time %v, got %v", atime, st.LastAccessTime)
+	}
+}
+
+func TestChtimes(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestChtimes", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Close()
+	if err != nil {
+		fs.Remove(testDir + `\testFile`)
+
+		t.Fatal(err)
+	}
+
+	atime, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime, err := time.Parse(time.RFC3339, "2006-03-08T19:32:05Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fs.Chtimes(testDir+`\testFile`, atime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := fs.Stat(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stat.ModTime().Equal(mtime) {
+		t.Error("unexpected mtime:", stat.ModTime())
+	}
+}
+
+func TestSetAttributes(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestSetAttributes", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = f.SetAttributes(smb2.AttributeHidden | smb2.AttributeReadonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := f.Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs&(smb2.AttributeHidden|smb2.AttributeReadonly) != smb2.AttributeHidden|smb2.AttributeReadonly {
+		t.Errorf("expected hidden and read-only attributes, got %#x", attrs)
+	}
+
+	// zero clears the attributes rather than leaving them unchanged.
+	err = f.SetAttributes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err = f.Attributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs&(smb2.AttributeHidden|smb2.AttributeReadonly) != 0 {
+		t.Errorf("expected no hidden and read-only attributes, got %#x", attrs)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestChmod", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	f, err := fs.Create(testDir + `\testFile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Remove(testDir + `\testFile`)
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode() != 0666 {
+		t.Error("unexpected mode:", stat.Mode())
+	}
+	err = f.Chmod(0444)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err = f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode() != 0444 {
+		t.Error("unexpected mode:", stat.Mode())
+	}
+}
+
+func TestListSharenames(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+	names, err := session.ListSharenames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	for _, expected := range []string{"IPC$", "tmp", "tmp2"} {
+		found := false
+		for _, name := range names {
+			if name == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("couldn't find share name %s in %v", expected, names)
+		}
+	}
+}
+
+func TestNamedPipe(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	ipc, err := session.Mount("IPC$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipc.Umount()
+
+	if fs != nil {
+		if _, err := fs.OpenPipe("srvsvc"); err == nil {
+			t.Error("expected an error for a disk share")
+		}
+	}
+
+	p, err := ipc.OpenPipe(`\PIPE\srvsvc`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	bind := &msrpc.Bind{CallId: 1}
+	in := make([]byte, bind.Size())
+	bind.Encode(in)
+
+	out, err := p.Call(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := msrpc.BindAckDecoder(out); r.IsInvalid() || r.CallId() != 1 {
+		t.Errorf("unexpected bind ack: %x", out)
+	}
+}
+
+func TestServerSideCopy(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	testDir := fmt.Sprintf("testDir-%d-TestServerSideCopy", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(join(testDir, "src.txt"), []byte("hello world!"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := fs.Open(join(testDir, "src.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sf.Close()
+
+	df, err := fs.Create(join(testDir, "dst.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+
+	_, err = io.Copy(df, sf)
+	if err != nil {
+		t.Error(err)
+	}
+
+	bs, err := fs.ReadFile(join(testDir, "dst.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bs) != "hello world!" {
+		t.Error("unexpected content")
+	}
+}
+
+func TestMountNotFound(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	_, err := session.Mount(fmt.Sprintf("noShare-%d", os.Getpid()))
+	if e, ok := err.(*os.PathError); !ok || e.Err != smb2.ErrShareNotFound {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestRawRequest(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	// SMB2 ECHO
+	res, err := session.RawRequest(&smb2.RawPacket{
+		Command: 0x000D,
+		Body:    []byte{4, 0, 0, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) < 4 || res[0] != 4 || res[1] != 0 {
+		t.Error("unexpected response:", res)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+
+	testDir := fmt.Sprintf("testDir-%d-TestRemoveAll", os.Getpid())
+	err := fs.Mkdir(testDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile(join(testDir, "hello.txt"), []byte("hello world!"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Mkdir(join(testDir, "hello"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile(join(testDir, "hello", "hello.txt"), []byte("hello world!"), 0444)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.RemoveAll(testDir)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestDownloadTree(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestDownloadTree", os.Getpid())
+	err := fs.MkdirAll(testDir+`\sub`, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	err = fs.WriteFile(testDir+`\a`, []byte("aaa"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile(testDir+`\sub\b`, []byte("bbbb"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = fs.Chtimes(testDir+`\a`, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localDir, err := ioutil.TempDir("", "TestDownloadTree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+
+	var total int64
+	err = fs.DownloadTree(testDir, localDir, &smb2.DownloadOptions{
+		Progress: func(remote, local string, n int64) {
+			atomic.AddInt64(&total, n)
+		},
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 7 {
+		t.Error("unexpected progress:", total)
+	}
+
+	bs, err := ioutil.ReadFile(localDir + "/sub/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "bbbb" {
+		t.Error("unexpected content:", string(bs))
+	}
+
+	fi, err := os.Stat(localDir + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Error("unexpected mtime:", fi.ModTime())
+	}
+}
+
+func TestRemoveAllWith(t *testing.T) {
+	if fs == nil {
+		t.Skip()
+	}
+	testDir := fmt.Sprintf("testDir-%d-TestRemoveAllWith", os.Getpid())
+	err := fs.MkdirAll(testDir+`\a\b`, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(testDir)
+
+	for _, name := range []string{`\x`, `\a\y`, `\a\b\z`} {
+		err = fs.WriteFile(testDir+name, []byte("data"), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = fs.RemoveAllWith(testDir, &smb2.RemoveAllOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fs.Stat(testDir)
+	if !os.IsNotExist(err) {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	if session == nil {
+		t.Skip()
+	}
+
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var challenges []*smb2.AuthChallenge
+
+	d := &smb2.Dialer{
+		MaxCreditBalance: dialer.MaxCreditBalance,
+		Negotiator:       dialer.Negotiator,
+		Authenticate: func(ctx context.Context, challenge *smb2.AuthChallenge) (smb2.Initiator, error) {
+			challenges = append(challenges, challenge)
+
+			i := *dialer.Initiator.(*smb2.NTLMInitiator)
+			if challenge.Attempt == 0 {
+				i.Password += "-wrong"
+			}
+			return &i, nil
+		},
+	}
+
+	c, err := d.Dial(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Logoff()
+
+	if len(challenges) != 2 {
+		t.Fatalf("expected 2 calls of Authenticate, got %d", len(challenges))
+	}
+	if challenges[0].Err != nil {
+		t.Error("unexpected error in first challenge:", challenges[0].Err)
+	}
+	if challenges[1].Err == nil || challenges[1].Attempt != 1 {
+		t.Error("unexpected second challenge:", challenges[1])
+	}
+}
+
 func TestContextError(t *testing.T) {
 	if session == nil {
 		t.Skip()
@@ -711,7 +2994,7 @@ func TestContextError(t *testing.T) {
 		}
 	}
 
-	conn, err := net.Dial(cfg.Transport.Type, fmt.Sprintf("%s:%d", cfg.Transport.Host, cfg.Transport.Port))
+	conn, err := net.Dial(cfg.Transport.Type, net.JoinHostPort(cfg.Transport.Host, strconv.Itoa(cfg.Transport.Port)))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,101 @@
+package smb2
+
+import (
+	"os"
+	"time"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// snapshotTokenFormat is the format of the tokens naming snapshots, which are in UTC. ([MS-SMB2] 2.2.32.2)
+const snapshotTokenFormat = "@GMT-2006.01.02-15.04.05"
+
+// Snapshots returns the times of the snapshots of the volume holding name, also known as shadow copies or
+// previous versions, in the order returned by the server. The file as of one of them can be opened with OpenOptions.Snapshot.
+// If the server doesn't support snapshots, it returns ErrNotSupported.
+func (fs *Share) Snapshots(name string) ([]time.Time, error) {
+	name, err := cleanPath("snapshots", name)
+	if err != nil {
+		return nil, err
+	}
+
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_READ_DATA | FILE_READ_ATTRIBUTES,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        0,
+	}
+
+	f, err := fs.createFile(name, create, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "snapshots", Path: name, Err: err}
+	}
+
+	snapshots, err := f.snapshots()
+	if e := f.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "snapshots", Path: name, Err: err}
+	}
+	return snapshots, nil
+}
+
+func (f *File) snapshots() ([]time.Time, error) {
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_SRV_ENUMERATE_SNAPSHOTS,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: uint32(f.maxTransactSize()),
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input:             nil,
+	}
+
+	output, err := f.ioctl(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST:
+				return nil, ErrNotSupported
+			}
+		}
+		return nil, err
+	}
+
+	return parseSnapshots(output)
+}
+
+// parseSnapshots decodes a SRV_SNAPSHOT_ARRAY.
+func parseSnapshots(output []byte) ([]time.Time, error) {
+	r := SrvSnapshotArrayDecoder(output)
+	if r.IsInvalid() {
+		return nil, &InvalidResponseError{"broken snapshot array format"}
+	}
+
+	var snapshots []time.Time
+
+	for _, token := range r.SnapShots() {
+		t, err := time.Parse(snapshotTokenFormat, token)
+		if err != nil {
+			return nil, &InvalidResponseError{"broken snapshot token: " + token}
+		}
+		snapshots = append(snapshots, t)
+	}
+
+	return snapshots, nil
+}
+
+// requestSnapshot adds SMB2_CREATE_TIMEWARP_TOKEN to req, so that the file is opened as of the snapshot taken at t.
+func requestSnapshot(req *CreateRequest, t time.Time) {
+	req.Contexts = append(req.Contexts, &CreateContext{
+		Name: SMB2_CREATE_TIMEWARP_TOKEN,
+		Data: NsecToFiletime(t.Truncate(time.Second).UnixNano()),
+	})
+}
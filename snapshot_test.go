@@ -0,0 +1,60 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
+)
+
+// encodeSnapshotArray encodes a SRV_SNAPSHOT_ARRAY with tokens.
+func encodeSnapshotArray(tokens ...string) []byte {
+	var arr []byte
+	for _, token := range tokens {
+		arr = append(arr, utf16le.EncodeStringToBytes(token)...)
+		arr = append(arr, 0, 0)
+	}
+	arr = append(arr, 0, 0)
+
+	p := make([]byte, 12)
+	binary.LittleEndian.PutUint32(p[:4], uint32(len(tokens)))
+	binary.LittleEndian.PutUint32(p[4:8], uint32(len(tokens)))
+	binary.LittleEndian.PutUint32(p[8:12], uint32(len(arr)))
+
+	return append(p, arr...)
+}
+
+func TestParseSnapshots(t *testing.T) {
+	snapshots, err := parseSnapshots(encodeSnapshotArray("@GMT-2021.03.04-05.06.07", "@GMT-2020.12.31-23.59.59"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []time.Time{
+		time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC),
+	}
+	if len(snapshots) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, snapshots)
+	}
+	for i := range snapshots {
+		if !snapshots[i].Equal(expected[i]) {
+			t.Errorf("expected %v, got %v", expected[i], snapshots[i])
+		}
+	}
+
+	// a volume without snapshots.
+	if snapshots, err := parseSnapshots(encodeSnapshotArray()); err != nil || len(snapshots) != 0 {
+		t.Errorf("unexpected snapshots: %v, %v", snapshots, err)
+	}
+
+	for _, output := range [][]byte{
+		encodeSnapshotArray("@GMT-2021.03.04-05.06.07")[:20], // truncated array
+		encodeSnapshotArray("2021-03-04T05:06:07Z"),          // not a token
+	} {
+		if _, err := parseSnapshots(output); err == nil {
+			t.Errorf("expected an error for %x", output)
+		}
+	}
+}
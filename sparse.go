@@ -0,0 +1,274 @@
+package smb2
+
+import (
+	"os"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// CopySparse copies src to dst, preserving the holes of sparse files.
+// It queries the allocated ranges of src, marks dst as sparse and copies only the allocated ranges,
+// so that holes in src remain unallocated in dst. dst is created or truncated.
+// If the server doesn't support sparse files, src is treated as fully allocated and
+// dst is written as a regular file of the same content.
+func (fs *Share) CopySparse(src, dst string) error {
+	src, err := cleanPath("copysparse", src)
+	if err != nil {
+		return err
+	}
+
+	dst, err = cleanPath("copysparse", dst)
+	if err != nil {
+		return err
+	}
+
+	sf, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	df, err := fs.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = sf.copySparse(df)
+	if e := df.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return &os.LinkError{Op: "copysparse", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
+
+// FileRange is a range of bytes of a file.
+type FileRange struct {
+	Offset int64
+	Length int64
+}
+
+// SetSparse sets or clears the sparse attribute of the file (FSCTL_SET_SPARSE).
+// Ranges of sparse files that have never been written, or that have been extended by Truncate,
+// don't consume storage and read as zeros.
+// If the server or the underlying file system doesn't support sparse files, it returns ErrNotSupported.
+func (f *File) SetSparse(sparse bool) error {
+	err := f.setSparse(sparse)
+	if err != nil {
+		return &os.PathError{Op: "setsparse", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// QueryAllocatedRanges returns the ranges of the file backed by storage (FSCTL_QUERY_ALLOCATED_RANGES),
+// in ascending order. Only those need to be read to copy a sparse file; the rest reads as zeros.
+// If the server or the underlying file system doesn't support sparse files, the whole file is reported as allocated.
+func (f *File) QueryAllocatedRanges() ([]FileRange, error) {
+	ranges, err := f.queryAllocatedRanges()
+	if err != nil {
+		return nil, &os.PathError{Op: "queryallocatedranges", Path: f.name, Err: err}
+	}
+	return ranges, nil
+}
+
+func (f *File) queryAllocatedRanges() ([]FileRange, error) {
+	fi, err := f.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := f.allocatedRanges(fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]FileRange, len(rs))
+	for i, r := range rs {
+		ranges[i] = FileRange{Offset: r.FileOffset, Length: r.Length}
+	}
+	return ranges, nil
+}
+
+func (f *File) copySparse(wf *File) error {
+	fi, err := f.stat()
+	if err != nil {
+		return err
+	}
+
+	size := fi.Size()
+
+	ranges, err := f.allocatedRanges(size)
+	if err != nil {
+		return err
+	}
+
+	err = wf.setSparse(true)
+	if err != nil && err != ErrNotSupported {
+		return err
+	}
+
+	// extending the file leaves a hole in sparse files.
+	err = wf.truncate(size)
+	if err != nil {
+		return err
+	}
+
+	bufSize := f.maxReadSize()
+	if maxWriteSize := wf.maxWriteSize(); maxWriteSize < bufSize {
+		bufSize = maxWriteSize
+	}
+
+	buf := make([]byte, bufSize)
+
+	for _, r := range ranges {
+		for off, end := r.FileOffset, r.FileOffset+r.Length; off < end; {
+			bs := buf
+			if end-off < int64(len(bs)) {
+				bs = bs[:end-off]
+			}
+
+			n, err := f.readAt(bs, off)
+			if err != nil {
+				if rerr, ok := err.(*ResponseError); ok && NtStatus(rerr.Code) == STATUS_END_OF_FILE {
+					// the file has been shrunk concurrently.
+					return nil
+				}
+				return err
+			}
+			if n == 0 {
+				return nil
+			}
+
+			_, err = wf.writeAt(bs[:n], off)
+			if err != nil {
+				return err
+			}
+
+			if n < len(bs) {
+				return nil
+			}
+
+			off += int64(n)
+		}
+	}
+
+	return nil
+}
+
+// allocatedRanges returns the allocated ranges of the file within [0, size) using FSCTL_QUERY_ALLOCATED_RANGES.
+// If the server doesn't support the request, the whole file is reported as allocated.
+func (f *File) allocatedRanges(size int64) ([]*FileAllocatedRangeBuffer, error) {
+	if size == 0 {
+		return nil, nil
+	}
+
+	maxOutputResponse := f.maxTransactSize()
+	if maxOutputResponse > 64*1024 {
+		maxOutputResponse = 64 * 1024
+	}
+
+	var ranges []*FileAllocatedRangeBuffer
+
+	for off := int64(0); off < size; {
+		req := &IoctlRequest{
+			CtlCode:           FSCTL_QUERY_ALLOCATED_RANGES,
+			OutputOffset:      0,
+			OutputCount:       0,
+			MaxInputResponse:  0,
+			MaxOutputResponse: uint32(maxOutputResponse),
+			Flags:             SMB2_0_IOCTL_IS_FSCTL,
+			Input: &FileAllocatedRangeBuffer{
+				FileOffset: off,
+				Length:     size - off,
+			},
+		}
+
+		output, err := f.ioctl(req)
+
+		more := false
+
+		if err != nil {
+			rerr, ok := err.(*ResponseError)
+			if !ok {
+				return nil, err
+			}
+
+			switch NtStatus(rerr.Code) {
+			case STATUS_BUFFER_OVERFLOW:
+				more = true
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST, STATUS_INVALID_PARAMETER:
+				if off == 0 {
+					return []*FileAllocatedRangeBuffer{{FileOffset: 0, Length: size}}, nil
+				}
+				return nil, err
+			default:
+				return nil, err
+			}
+		}
+
+		next := off
+
+		for ; len(output) >= 16; output = output[16:] {
+			r := FileAllocatedRangeBufferDecoder(output)
+
+			start, end := r.FileOffset(), r.FileOffset()+r.Length()
+			if start < off || end <= start {
+				return nil, &InvalidResponseError{"broken allocated range format"}
+			}
+			if end > size {
+				end = size
+			}
+			if start >= end {
+				continue
+			}
+
+			ranges = append(ranges, &FileAllocatedRangeBuffer{FileOffset: start, Length: end - start})
+
+			next = end
+		}
+
+		if !more {
+			break
+		}
+
+		if next == off {
+			return nil, &InvalidResponseError{"no progress in allocated range query"}
+		}
+
+		off = next
+	}
+
+	return ranges, nil
+}
+
+// setSparse sets or clears the sparse attribute of the file using FSCTL_SET_SPARSE.
+// If the server or the underlying file system doesn't support sparse files, it returns ErrNotSupported.
+// File systems without sparse files, like FAT, reject the request with STATUS_INVALID_PARAMETER.
+func (f *File) setSparse(on bool) error {
+	req := &IoctlRequest{
+		CtlCode:           FSCTL_SET_SPARSE,
+		OutputOffset:      0,
+		OutputCount:       0,
+		MaxInputResponse:  0,
+		MaxOutputResponse: 0,
+		Flags:             SMB2_0_IOCTL_IS_FSCTL,
+		Input: &FileSparseBuffer{
+			SetSparse: on,
+		},
+	}
+
+	_, err := f.ioctl(req)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOT_SUPPORTED, STATUS_INVALID_DEVICE_REQUEST, STATUS_INVALID_PARAMETER:
+				return ErrNotSupported
+			}
+		}
+		return err
+	}
+
+	return nil
+}
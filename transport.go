@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"time"
 )
 
 const (
@@ -28,6 +29,47 @@ func direct(tcpConn net.Conn) transport {
 	return &directTCP{conn: tcpConn}
 }
 
+// deadlineConn extends the read deadline of the underlying connection before every read,
+// and the write deadline before every write.
+// As a result, the read deadline is reset each time some data arrives.
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func newDeadlineConn(c net.Conn, readTimeout, writeTimeout time.Duration) net.Conn {
+	if readTimeout <= 0 && writeTimeout <= 0 {
+		return c
+	}
+	return &deadlineConn{Conn: c, readTimeout: readTimeout, writeTimeout: writeTimeout}
+}
+
+func (c *deadlineConn) Read(p []byte) (n int, err error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (n int, err error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func isTimeout(err error) bool {
+	if e, ok := err.(net.Error); ok {
+		return e.Timeout()
+	}
+	return false
+}
+
 func (t *directTCP) Write(p []byte) (n int, err error) {
 	if len(p) > maxDirectTCPSize {
 		return -1, errors.New("max transport size exceeds")
@@ -51,9 +93,7 @@ func (t *directTCP) Write(p []byte) (n int, err error) {
 }
 
 func (t *directTCP) ReadSize() (size int, err error) {
-	bs := t.rb[:]
-
-	_, err = io.ReadFull(t.conn, bs)
+	bs, err := t.readHeader()
 	if err != nil {
 		return -1, err
 	}
@@ -65,6 +105,22 @@ func (t *directTCP) ReadSize() (size int, err error) {
 	return int(be.Uint32(bs)), nil
 }
 
+// readHeader reads the 4 bytes preceding each message.
+func (t *directTCP) readHeader() ([]byte, error) {
+	bs := t.rb[:]
+
+	n, err := io.ReadFull(t.conn, bs)
+	if err != nil {
+		if n != 0 && isTimeout(err) {
+			// the frame is half-read, so we can't resume reading from here.
+			return nil, errors.New("read timeout in the middle of a frame")
+		}
+		return nil, err
+	}
+
+	return bs, nil
+}
+
 func (t *directTCP) Read(p []byte) (n int, err error) {
 	n, err = io.ReadFull(t.conn, p)
 	if err != nil {
@@ -3,6 +3,8 @@ package smb2
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	. "github.com/hirochachacha/go-smb2/internal/smb2"
 )
@@ -12,9 +14,19 @@ type treeConn struct {
 	treeId     uint32
 	shareFlags uint32
 
-	// path string
-	// shareType  uint8
-	// capabilities uint32
+	// handles opened for writing, for Share.Sync
+	handlesMu sync.Mutex
+	handles   map[*FileId]trackedHandle
+
+	path      string // `\\<server>\<share>`
+	shareType uint8
+
+	shareCapabilities uint32 // SMB2_SHARE_CAP_*
+
+	forceEncryption int32 // set by Share.SetEncryption, accessed atomically
+
+	disconnectMu sync.Mutex
+	disconnected bool
 	// maximalAccess uint32
 }
 
@@ -47,19 +59,33 @@ func treeConnect(s *session, path string, flags uint16, ctx context.Context) (*t
 	}
 
 	tc := &treeConn{
-		session:    s,
-		treeId:     PacketCodec(pkt).TreeId(),
-		shareFlags: r.ShareFlags(),
-		// path:    path,
-		// shareType:  r.ShareType(),
-		// capabilities: r.Capabilities(),
+		session:           s,
+		treeId:            PacketCodec(pkt).TreeId(),
+		shareFlags:        r.ShareFlags(),
+		handles:           make(map[*FileId]trackedHandle),
+		path:              path,
+		shareType:         r.ShareType(),
+		shareCapabilities: r.Capabilities(),
 		// maximalAccess: r.MaximalAccess(),
 	}
 
 	return tc, nil
 }
 
+// disconnect waits for the outstanding requests on tc and sends TREE_DISCONNECT.
+// It does nothing once it has succeeded.
 func (tc *treeConn) disconnect(ctx context.Context) error {
+	tc.disconnectMu.Lock()
+	defer tc.disconnectMu.Unlock()
+
+	if tc.disconnected {
+		return nil
+	}
+
+	if err := tc.session.drain(tc, ctx); err != nil {
+		return err
+	}
+
 	req := new(TreeDisconnectRequest)
 
 	req.CreditCharge = 1
@@ -74,9 +100,50 @@ func (tc *treeConn) disconnect(ctx context.Context) error {
 		return &InvalidResponseError{"broken tree disconnect response format"}
 	}
 
+	tc.disconnected = true
+
 	return nil
 }
 
+// encryptData reports whether the requests on tc are encrypted,
+// either because the server requires it for the share or because Share.SetEncryption forces it.
+func (tc *treeConn) encryptData() bool {
+	return tc.shareFlags&SMB2_SHAREFLAG_ENCRYPT_DATA != 0 || atomic.LoadInt32(&tc.forceEncryption) != 0
+}
+
+// trackedHandle is a handle flushed by Share.Sync.
+type trackedHandle struct {
+	name string
+
+	// maximumAllowed is set if the handle was opened with MAXIMUM_ALLOWED rather than write access,
+	// so that it may turn out not to be writable.
+	maximumAllowed bool
+}
+
+func (tc *treeConn) trackHandle(fd *FileId, name string, access uint32) {
+	tc.handlesMu.Lock()
+	tc.handles[fd] = trackedHandle{name: name, maximumAllowed: !requestsWrite(access)}
+	tc.handlesMu.Unlock()
+}
+
+func (tc *treeConn) untrackHandle(fd *FileId) {
+	tc.handlesMu.Lock()
+	delete(tc.handles, fd)
+	tc.handlesMu.Unlock()
+}
+
+// writableHandles returns a snapshot of the handles opened for writing.
+func (tc *treeConn) writableHandles() map[*FileId]trackedHandle {
+	tc.handlesMu.Lock()
+	defer tc.handlesMu.Unlock()
+
+	handles := make(map[*FileId]trackedHandle, len(tc.handles))
+	for fd, h := range tc.handles {
+		handles[fd] = h
+	}
+	return handles
+}
+
 func (tc *treeConn) sendRecv(cmd uint16, req Packet, ctx context.Context) (res []byte, err error) {
 	rr, err := tc.send(req, ctx)
 	if err != nil {
@@ -92,7 +159,12 @@ func (tc *treeConn) sendRecv(cmd uint16, req Packet, ctx context.Context) (res [
 }
 
 func (tc *treeConn) send(req Packet, ctx context.Context) (rr *requestResponse, err error) {
-	return tc.sendWith(req, tc, ctx)
+	return tc.sendWith(req, tc, nil, ctx)
+}
+
+// sendInto is like send, but the data of the response of READ may be placed directly into dst.
+func (tc *treeConn) sendInto(req Packet, dst []byte, ctx context.Context) (rr *requestResponse, err error) {
+	return tc.sendWith(req, tc, dst, ctx)
 }
 
 func (tc *treeConn) recv(rr *requestResponse) (pkt []byte, err error) {
@@ -100,6 +172,23 @@ func (tc *treeConn) recv(rr *requestResponse) (pkt []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return tc.checkResponse(rr, pkt)
+}
+
+// recvWithCancel is like recv but sends CANCEL for the request when cancel is closed.
+// See conn.recvWithCancel for more details.
+func (tc *treeConn) recvWithCancel(rr *requestResponse, cancel <-chan struct{}) (pkt []byte, err error) {
+	pkt, err = tc.session.conn.recvWithCancel(rr, cancel)
+	if err != nil {
+		return nil, err
+	}
+	if sessionId := PacketCodec(pkt).SessionId(); sessionId != tc.sessionId {
+		return nil, &InvalidResponseError{fmt.Sprintf("expected session id: %v, got %v", tc.sessionId, sessionId)}
+	}
+	return tc.checkResponse(rr, pkt)
+}
+
+func (tc *treeConn) checkResponse(rr *requestResponse, pkt []byte) ([]byte, error) {
 	if rr.asyncId != 0 {
 		if asyncId := PacketCodec(pkt).AsyncId(); asyncId != rr.asyncId {
 			return nil, &InvalidResponseError{fmt.Sprintf("expected async id: %v, got %v", rr.asyncId, asyncId)}
@@ -109,5 +198,5 @@ func (tc *treeConn) recv(rr *requestResponse) (pkt []byte, err error) {
 			return nil, &InvalidResponseError{fmt.Sprintf("expected tree id: %v, got %v", tc.treeId, treeId)}
 		}
 	}
-	return pkt, err
+	return pkt, nil
 }
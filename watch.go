@@ -0,0 +1,283 @@
+package smb2
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	. "github.com/hirochachacha/go-smb2/internal/erref"
+	. "github.com/hirochachacha/go-smb2/internal/smb2"
+)
+
+// NotifyFilter selects the changes reported by Watch. ([MS-SMB2] 2.2.35)
+type NotifyFilter uint32
+
+const (
+	NotifyFileName    NotifyFilter = FILE_NOTIFY_CHANGE_FILE_NAME // files are created, deleted or renamed
+	NotifyDirName     NotifyFilter = FILE_NOTIFY_CHANGE_DIR_NAME  // directories are created, deleted or renamed
+	NotifyAttributes  NotifyFilter = FILE_NOTIFY_CHANGE_ATTRIBUTES
+	NotifySize        NotifyFilter = FILE_NOTIFY_CHANGE_SIZE
+	NotifyLastWrite   NotifyFilter = FILE_NOTIFY_CHANGE_LAST_WRITE
+	NotifyLastAccess  NotifyFilter = FILE_NOTIFY_CHANGE_LAST_ACCESS
+	NotifyCreation    NotifyFilter = FILE_NOTIFY_CHANGE_CREATION
+	NotifyEA          NotifyFilter = FILE_NOTIFY_CHANGE_EA
+	NotifySecurity    NotifyFilter = FILE_NOTIFY_CHANGE_SECURITY
+	NotifyStreamName  NotifyFilter = FILE_NOTIFY_CHANGE_STREAM_NAME
+	NotifyStreamSize  NotifyFilter = FILE_NOTIFY_CHANGE_STREAM_SIZE
+	NotifyStreamWrite NotifyFilter = FILE_NOTIFY_CHANGE_STREAM_WRITE
+)
+
+// NotifyAction tells how a file reported by Watch has changed. ([MS-FSCC] 2.4.42)
+type NotifyAction uint32
+
+const (
+	NotifyAdded          NotifyAction = FILE_ACTION_ADDED
+	NotifyRemoved        NotifyAction = FILE_ACTION_REMOVED
+	NotifyModified       NotifyAction = FILE_ACTION_MODIFIED
+	NotifyRenamedOldName NotifyAction = FILE_ACTION_RENAMED_OLD_NAME // followed by NotifyRenamedNewName
+	NotifyRenamedNewName NotifyAction = FILE_ACTION_RENAMED_NEW_NAME
+	NotifyAddedStream    NotifyAction = FILE_ACTION_ADDED_STREAM
+	NotifyRemovedStream  NotifyAction = FILE_ACTION_REMOVED_STREAM
+	NotifyModifiedStream NotifyAction = FILE_ACTION_MODIFIED_STREAM
+
+	// NotifyResync reports that changes were lost because they didn't fit in the buffer of the server
+	// (STATUS_NOTIFY_ENUM_DIR). The directory must be listed again to find out its current state.
+	NotifyResync NotifyAction = 0xffffffff
+)
+
+func (a NotifyAction) String() string {
+	switch a {
+	case NotifyAdded:
+		return "added"
+	case NotifyRemoved:
+		return "removed"
+	case NotifyModified:
+		return "modified"
+	case NotifyRenamedOldName:
+		return "renamed from"
+	case NotifyRenamedNewName:
+		return "renamed to"
+	case NotifyAddedStream:
+		return "added stream"
+	case NotifyRemovedStream:
+		return "removed stream"
+	case NotifyModifiedStream:
+		return "modified stream"
+	case NotifyResync:
+		return "resync"
+	}
+	return "NotifyAction(" + strconv.FormatUint(uint64(a), 10) + ")"
+}
+
+// NotifyEvent is a change reported by Watch.
+type NotifyEvent struct {
+	Action NotifyAction
+	Name   string // path relative to the watched directory, separated by backslashes; empty for NotifyResync
+
+	// Err is set on the last event if the watch failed, e.g. because the directory was deleted
+	// or the connection was lost. Then Action and Name are zero.
+	Err error
+}
+
+// Watch reports the changes selected by filter in the directory f, or in its whole tree if recursive is set,
+// using CHANGE_NOTIFY. The request is re-armed after each response, and changes happening meanwhile are
+// buffered by the server. If they overflow its buffer, a NotifyResync event is sent instead.
+// The channel is closed after ctx is done, which cancels the outstanding request, or after the watch fails.
+// The caller must receive from the channel until it's closed.
+// If f isn't a directory, it returns ErrNotDirectory.
+func (f *File) Watch(ctx context.Context, recursive bool, filter NotifyFilter) (<-chan NotifyEvent, error) {
+	events, err := f.watch(ctx, recursive, filter, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: f.name, Err: err}
+	}
+	return events, nil
+}
+
+// Watch is like File.Watch, but opens the directory dirname for the duration of the watch.
+func (fs *Share) Watch(ctx context.Context, dirname string, recursive bool, filter NotifyFilter) (<-chan NotifyEvent, error) {
+	dirname, err := cleanPath("watch", dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	create := &CreateRequest{
+		SecurityFlags:        0,
+		RequestedOplockLevel: SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel:   Impersonation,
+		SmbCreateFlags:       0,
+		DesiredAccess:        FILE_LIST_DIRECTORY | FILE_READ_ATTRIBUTES | SYNCHRONIZE,
+		FileAttributes:       FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:          FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:    FILE_OPEN,
+		CreateOptions:        FILE_DIRECTORY_FILE,
+	}
+
+	f, err := fs.createFile(dirname, create, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: dirname, Err: err}
+	}
+
+	events, err := f.watch(ctx, recursive, filter, true)
+	if err != nil {
+		f.close()
+
+		return nil, &os.PathError{Op: "watch", Path: dirname, Err: err}
+	}
+	return events, nil
+}
+
+func (f *File) watch(ctx context.Context, recursive bool, filter NotifyFilter, closeFile bool) (<-chan NotifyEvent, error) {
+	if filter == 0 {
+		return nil, os.ErrInvalid
+	}
+
+	if f.fileStat.FileAttributes&FILE_ATTRIBUTE_DIRECTORY == 0 {
+		return nil, ErrNotDirectory
+	}
+
+	var flags uint16
+	if recursive {
+		flags = SMB2_WATCH_TREE
+	}
+
+	rr, err := f.sendChangeNotify(flags, uint32(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan NotifyEvent)
+
+	go func() {
+		defer close(events)
+
+		if closeFile {
+			defer f.close()
+		}
+
+		for {
+			evs, err := f.recvChangeNotify(rr, ctx.Done())
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if err != errNotifyCleanup {
+					select {
+					case events <- NotifyEvent{Err: err}:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+
+			for _, ev := range evs {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			rr, err = f.sendChangeNotify(flags, uint32(filter))
+			if err != nil {
+				select {
+				case events <- NotifyEvent{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// errNotifyCleanup ends a watch quietly, since the handle was closed.
+var errNotifyCleanup = &InternalError{"notify cleanup"}
+
+func (f *File) sendChangeNotify(flags uint16, filter uint32) (rr *requestResponse, err error) {
+	req := &ChangeNotifyRequest{
+		Flags:              flags,
+		OutputBufferLength: clientChangeNotifyBufferSize,
+		CompletionFilter:   filter,
+	}
+
+	if f.maxTransactSize() < int(req.OutputBufferLength) {
+		req.OutputBufferLength = uint32(f.maxTransactSize())
+	}
+
+	req.CreditCharge, _, err = f.fs.loanCredit(int(req.OutputBufferLength))
+	defer func() {
+		if err != nil {
+			f.fs.chargeCredit(req.CreditCharge)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	req.FileId = f.fd
+
+	return f.fs.send(req, f.fs.ctx)
+}
+
+// recvChangeNotify waits for the response to a CHANGE_NOTIFY request, which is cancelled when cancel is closed.
+func (f *File) recvChangeNotify(rr *requestResponse, cancel <-chan struct{}) ([]NotifyEvent, error) {
+	pkt, err := f.fs.recvWithCancel(rr, cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := accept(SMB2_CHANGE_NOTIFY, pkt)
+	if err != nil {
+		if rerr, ok := err.(*ResponseError); ok {
+			switch NtStatus(rerr.Code) {
+			case STATUS_NOTIFY_ENUM_DIR:
+				return []NotifyEvent{{Action: NotifyResync}}, nil
+			case STATUS_NOTIFY_CLEANUP:
+				return nil, errNotifyCleanup
+			}
+		}
+		return nil, err
+	}
+
+	r := ChangeNotifyResponseDecoder(res)
+	if r.IsInvalid() {
+		return nil, &InvalidResponseError{"broken change notify response format"}
+	}
+
+	return parseNotifyEvents(r.OutputBuffer())
+}
+
+// parseNotifyEvents decodes a list of FILE_NOTIFY_INFORMATION.
+// An empty list means that the changes didn't fit in the buffer.
+func parseNotifyEvents(output []byte) ([]NotifyEvent, error) {
+	if len(output) == 0 {
+		return []NotifyEvent{{Action: NotifyResync}}, nil
+	}
+
+	var evs []NotifyEvent
+
+	for {
+		info := FileNotifyInformationDecoder(output)
+		if info.IsInvalid() {
+			return nil, &InvalidResponseError{"broken file notify information format"}
+		}
+
+		evs = append(evs, NotifyEvent{
+			Action: NotifyAction(info.Action()),
+			Name:   info.FileName(),
+		})
+
+		next := info.NextEntryOffset()
+		if next == 0 {
+			break
+		}
+		if int(next) > len(output) {
+			return nil, &InvalidResponseError{"broken file notify information format"}
+		}
+
+		output = output[next:]
+	}
+
+	return evs, nil
+}
@@ -0,0 +1,65 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/hirochachacha/go-smb2/internal/utf16le"
+)
+
+func encodeNotifyInformation(action uint32, name string, last bool) []byte {
+	le := binary.LittleEndian
+
+	u := utf16le.EncodeStringToBytes(name)
+
+	p := make([]byte, (12+len(u)+3)&^3)
+	if !last {
+		le.PutUint32(p[:4], uint32(len(p)))
+	}
+	le.PutUint32(p[4:8], action)
+	le.PutUint32(p[8:12], uint32(len(u)))
+	copy(p[12:], u)
+	return p
+}
+
+func TestParseNotifyEvents(t *testing.T) {
+	var output []byte
+	output = append(output, encodeNotifyInformation(uint32(NotifyRenamedOldName), `dir\old.txt`, false)...)
+	output = append(output, encodeNotifyInformation(uint32(NotifyRenamedNewName), `dir\new.txt`, false)...)
+	output = append(output, encodeNotifyInformation(uint32(NotifyAdded), "a", true)...)
+
+	evs, err := parseNotifyEvents(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []NotifyEvent{
+		{Action: NotifyRenamedOldName, Name: `dir\old.txt`},
+		{Action: NotifyRenamedNewName, Name: `dir\new.txt`},
+		{Action: NotifyAdded, Name: "a"},
+	}
+	if len(evs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, evs)
+	}
+	for i := range evs {
+		if evs[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], evs[i])
+		}
+	}
+
+	// the changes didn't fit in the buffer.
+	evs, err = parseNotifyEvents(nil)
+	if err != nil || len(evs) != 1 || evs[0].Action != NotifyResync {
+		t.Errorf("expected a resync event, got %v, %v", evs, err)
+	}
+
+	for _, n := range []int{8, 20, 36} {
+		if _, err := parseNotifyEvents(output[:n]); err == nil {
+			t.Errorf("expected an error for %d bytes", n)
+		}
+	}
+
+	if NotifyAdded.String() != "added" || NotifyResync.String() != "resync" || NotifyAction(42).String() != "NotifyAction(42)" {
+		t.Error("unexpected action names")
+	}
+}